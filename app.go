@@ -2,10 +2,11 @@ package tasmotahomekit
 
 import (
 	"context"
-	"errors"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -14,11 +15,19 @@ import (
 
 	homekitqr "github.com/kradalby/homekit-qr"
 	"github.com/kradalby/kra/web"
+	webauth "github.com/kradalby/tasmota-homekit/auth"
 	appconfig "github.com/kradalby/tasmota-homekit/config"
+	"github.com/kradalby/tasmota-homekit/energy"
 	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/kradalby/tasmota-homekit/homeassistant"
 	"github.com/kradalby/tasmota-homekit/logging"
 	"github.com/kradalby/tasmota-homekit/metrics"
+	"github.com/kradalby/tasmota-homekit/notify"
 	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/kradalby/tasmota-homekit/safety"
+	"github.com/kradalby/tasmota-homekit/schedule"
+	"github.com/kradalby/tasmota-homekit/store"
+	"github.com/kradalby/tasmota-homekit/webhook"
 
 	mqtt "github.com/mochi-mqtt/server/v2"
 	"github.com/mochi-mqtt/server/v2/hooks/auth"
@@ -60,9 +69,10 @@ func Main() {
 		"web_addr", cfg.WebAddrPort().String(),
 		"mqtt_addr", cfg.MQTTAddrPort().String(),
 		"plugs_config", cfg.PlugsConfigPath,
+		"mqtt_auth", cfg.MQTTAuth,
 	)
 
-	plugCfg, err := plugs.LoadConfig(cfg.PlugsConfigPath)
+	plugCfg, err := plugs.LoadConfigWithSecrets(cfg.PlugsConfigPath, cfg.MQTTSecretsPath)
 	if err != nil {
 		slog.Error("Failed to load plugs configuration", "error", err)
 		os.Exit(1)
@@ -117,7 +127,15 @@ func Main() {
 		InlineClient: true,
 	})
 
-	if err := mqttServer.AddHook(new(auth.AllowHook), nil); err != nil {
+	var authHook *MQTTAuthHook
+	if cfg.MQTTAuth {
+		authHook = NewMQTTAuthHook(plugCfg)
+		err = mqttServer.AddHook(authHook, nil)
+	} else {
+		slog.Warn("MQTT authentication disabled, any client can connect to the broker")
+		err = mqttServer.AddHook(new(auth.AllowHook), nil)
+	}
+	if err != nil {
 		slog.Error("Failed to add MQTT auth hook", "error", err)
 		os.Exit(1)
 	}
@@ -128,13 +146,97 @@ func Main() {
 		os.Exit(1)
 	}
 
+	// Seed the last known state before the broker starts and plugs are
+	// polled, so HomeKit and the dashboard do not start out all off
+	var stateStore *store.Store
+	var savedState *store.Snapshot
+	if cfg.StatePath != "" {
+		stateStore = store.New(cfg.StatePath)
+		savedState, err = stateStore.Load()
+		if err != nil {
+			slog.Warn("Failed to load saved state, starting fresh", "path", cfg.StatePath, "error", err)
+			savedState = nil
+		} else {
+			plugManager.Restore(savedState.States, savedState.Connections)
+		}
+	}
+
+	var energyHistory *energy.History
+	if cfg.EnergyHistoryPath != "" {
+		energyHistory = energy.New(cfg.EnergyHistoryPath)
+		if err := energyHistory.Load(); err != nil {
+			slog.Warn("Failed to load energy history, starting fresh", "path", cfg.EnergyHistoryPath, "error", err)
+			energyHistory = energy.New(cfg.EnergyHistoryPath)
+		}
+		energyHistory.SetTariff(plugCfg.Tariff)
+		if err := energyHistory.SetEventBus(eventBus); err != nil {
+			slog.Error("Failed to connect energy history to eventbus", "error", err)
+			os.Exit(1)
+		}
+		plugManager.SetEnergyRecorder(energyHistory)
+		go energyHistory.Run(ctx, cfg.StateSavePeriod())
+	}
+
+	safetyMonitor := safety.New(commands)
+	if err := safetyMonitor.SetEventBus(eventBus); err != nil {
+		slog.Error("Failed to connect safety monitor to eventbus", "error", err)
+		os.Exit(1)
+	}
+	plugManager.SetSafetyMonitor(safetyMonitor)
+	go safetyMonitor.Run(ctx)
+
+	var haBridge *homeassistant.Bridge
+	if addr, useTLS := cfg.HABrokerAddr(); addr != "" {
+		opts := homeassistant.Options{
+			Addr:            addr,
+			Username:        cfg.HAUsername,
+			Password:        cfg.HAPassword,
+			ClientID:        cfg.BridgeName,
+			TopicPrefix:     cfg.HATopicPrefix,
+			DiscoveryPrefix: cfg.HADiscoveryPrefix,
+		}
+		if useTLS {
+			host, _, _ := net.SplitHostPort(addr)
+			opts.TLS = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+		}
+		haBridge, err = homeassistant.New(opts, plugCfg, commands, eventBus)
+		if err != nil {
+			slog.Error("Failed to initialize Home Assistant bridge", "error", err)
+			os.Exit(1)
+		}
+		go haBridge.Run(ctx)
+		slog.Info("Home Assistant bridge enabled", "broker", addr, "topic_prefix", cfg.HATopicPrefix)
+	}
+
+	webhooks := webhook.New(cfg.WebhookDeadLetterPath)
+	if err := webhooks.Load(); err != nil {
+		slog.Warn("Failed to load webhook dead letters, starting fresh", "path", cfg.WebhookDeadLetterPath, "error", err)
+		webhooks = webhook.New(cfg.WebhookDeadLetterPath)
+	}
+	if err := webhooks.SetEventBus(eventBus); err != nil {
+		slog.Error("Failed to connect webhooks to eventbus", "error", err)
+		os.Exit(1)
+	}
+	webhooks.Update(plugCfg)
+	go webhooks.Run(ctx)
+
+	notifier := notify.New()
+	if err := notifier.SetEventBus(eventBus); err != nil {
+		slog.Error("Failed to connect notifications to eventbus", "error", err)
+		os.Exit(1)
+	}
+	notifier.Update(plugCfg)
+	go notifier.Run(ctx)
+
 	mqttClient, err := eventBus.Client(events.ClientMQTT)
 	if err != nil {
 		slog.Error("Failed to get MQTT client", "error", err)
 		os.Exit(1)
 	}
 	mqttHook := &MQTTHook{
-		statePublisher: eventbus.Publish[plugs.StateChangedEvent](mqttClient),
+		statePublisher:  eventbus.Publish[plugs.StateChangedEvent](mqttClient),
+		buttonPublisher: eventbus.Publish[plugs.ButtonEvent](mqttClient),
+		topics:          plugManager,
 	}
 	if err := mqttServer.AddHook(mqttHook, nil); err != nil {
 		slog.Error("Failed to add MQTT message hook", "error", err)
@@ -150,6 +252,37 @@ func Main() {
 		os.Exit(1)
 	}
 
+	if cfg.MQTTTLS {
+		hosts := []string{localIP, "localhost", "127.0.0.1"}
+		if hostname, err := os.Hostname(); err == nil {
+			hosts = append(hosts, hostname)
+		}
+		tlsConfig, fingerprint, err := LoadMQTTTLS(cfg.MQTTTLSCert, cfg.MQTTTLSKey, cfg.MQTTTLSDir, hosts)
+		if err != nil {
+			slog.Error("Failed to load MQTT TLS certificate", "error", err)
+			os.Exit(1)
+		}
+		tlsListener := listeners.NewTCP(listeners.Config{
+			ID:        "tls",
+			Address:   cfg.MQTTTLSAddrPort().String(),
+			TLSConfig: tlsConfig,
+		})
+		if err := mqttServer.AddListener(tlsListener); err != nil {
+			slog.Error("Failed to add MQTT TLS listener", "error", err)
+			os.Exit(1)
+		}
+		plugManager.SetMQTTTLS(int(cfg.MQTTTLSAddrPort().Port()), fingerprint)
+		slog.Info("MQTT TLS listener enabled", "addr", cfg.MQTTTLSAddrPort().String(), "fingerprint", fingerprint)
+	}
+
+	// Subscribe before the broker starts so announcements from devices
+	// connecting right away are not missed
+	deviceDiscovery := NewDiscovery(cfg.PlugsConfigPath, cfg.DiscoveryScanPrefix(), plugManager)
+	if err := deviceDiscovery.Subscribe(mqttServer); err != nil {
+		slog.Error("Failed to subscribe to discovery topics", "error", err)
+		os.Exit(1)
+	}
+
 	mqttComponent := string(events.ClientMQTT)
 	eventBus.PublishConnectionStatus(mqttClient, events.ConnectionStatusEvent{
 		Timestamp: time.Now(),
@@ -157,37 +290,37 @@ func Main() {
 		Status:    events.ConnectionStatusConnecting,
 	})
 
-	go func() {
-		slog.Info("Starting MQTT broker", "addr", cfg.MQTTAddrPort().String())
+	// Serve starts the listeners in the background and returns right away;
+	// the broker is disconnected only by Close on shutdown
+	slog.Info("Starting MQTT broker", "addr", cfg.MQTTAddrPort().String())
+	if err := mqttServer.Serve(); err != nil {
 		eventBus.PublishConnectionStatus(mqttClient, events.ConnectionStatusEvent{
 			Timestamp: time.Now(),
 			Component: mqttComponent,
-			Status:    events.ConnectionStatusConnected,
+			Status:    events.ConnectionStatusFailed,
+			Error:     err.Error(),
 		})
-		if err := mqttServer.Serve(); err != nil {
-			eventBus.PublishConnectionStatus(mqttClient, events.ConnectionStatusEvent{
-				Timestamp: time.Now(),
-				Component: mqttComponent,
-				Status:    events.ConnectionStatusFailed,
-				Error:     err.Error(),
-			})
-			slog.Error("MQTT server error", "error", err)
-			return
-		}
+		slog.Error("MQTT server error", "error", err)
+	} else {
 		eventBus.PublishConnectionStatus(mqttClient, events.ConnectionStatusEvent{
 			Timestamp: time.Now(),
 			Component: mqttComponent,
-			Status:    events.ConnectionStatusDisconnected,
+			Status:    events.ConnectionStatusConnected,
 		})
-	}()
+		slog.Info("MQTT broker started", "addr", cfg.MQTTAddrPort().String())
+	}
 
-	slog.Info("MQTT broker started", "addr", cfg.MQTTAddrPort().String())
+	// The broker's inline client publishes commands to connected plugs
+	plugManager.SetMQTTPublisher(mqttServer, cfg.MQTTCommandWait())
 
 	go plugManager.ProcessCommands(ctx)
 	go plugManager.ProcessStateEvents(ctx)
 
-	for _, plug := range plugCfg.Plugs {
-		go func(plugID string) {
+	// startPlug fetches the initial state of a plug and points it at the
+	// embedded broker. It runs for every configured plug at startup and for
+	// plugs added or re-addressed by a config reload.
+	startPlug := func(ctx context.Context, plugID string) {
+		go func() {
 			state, err := plugManager.GetStatus(ctx, plugID)
 			if err != nil {
 				slog.Warn(
@@ -202,11 +335,9 @@ func Main() {
 				"plug_id", plugID,
 				"on", state.On,
 			)
-		}(plug.ID)
-	}
+		}()
 
-	for _, plug := range plugCfg.Plugs {
-		go func(plugID string) {
+		go func() {
 			time.Sleep(time.Second)
 
 			if err := plugManager.ConfigureMQTT(ctx, plugID, localIP, int(cfg.MQTTAddrPort().Port())); err != nil {
@@ -223,13 +354,47 @@ func Main() {
 			}
 
 			slog.Info("Plug configured for MQTT", "plug_id", plugID)
-		}(plug.ID)
+		}()
+
+		go func() {
+			if err := plugManager.ConfigureSafety(ctx, plugID); err != nil {
+				slog.Error(
+					"Failed to configure safety limits on plug",
+					"plug_id", plugID,
+					"error", err,
+				)
+				errorPublisher.Publish(plugs.ErrorEvent{
+					PlugID: plugID,
+					Error:  fmt.Errorf("safety configuration failed: %w", err),
+				})
+			}
+		}()
+
+		go func() {
+			if err := plugManager.ConfigureButtons(ctx, plugID); err != nil {
+				slog.Error(
+					"Failed to configure buttons on plug",
+					"plug_id", plugID,
+					"error", err,
+				)
+				errorPublisher.Publish(plugs.ErrorEvent{
+					PlugID: plugID,
+					Error:  fmt.Errorf("button configuration failed: %w", err),
+				})
+			}
+		}()
+	}
+
+	for _, plug := range plugCfg.Plugs {
+		startPlug(ctx, plug.ID)
 	}
 
 	go plugManager.MonitorConnections(ctx, localIP, int(cfg.MQTTAddrPort().Port()))
 	slog.Info("Connection monitoring started")
 
+	plugManager.SetGroups(plugCfg)
 	hapManager := NewHAPManager(plugCfg.Plugs, cfg.BridgeName, commands, plugManager, eventBus)
+	hapManager.SetGroups(plugCfg)
 	hapManager.Start(ctx)
 	defer hapManager.Close()
 
@@ -240,70 +405,51 @@ func Main() {
 	}
 
 	fsStore := hap.NewFsStore(cfg.HAPStoragePath)
-	hapServer, err := hap.NewServer(
-		fsStore,
-		accessories[0],
-		accessories[1:]...,
-	)
-	if err != nil {
-		slog.Error("Failed to create HAP server", "error", err)
-		os.Exit(1)
-	}
-
-	hapServer.Pin = cfg.HAPPin
-	hapServer.Addr = cfg.HAPAddrPort().String()
-
-	hapManager.SetServer(hapServer)
-	hapManager.SetStore(fsStore)
-
-	hapStatusClient, err := eventBus.Client(events.ClientHAP)
-	if err != nil {
-		slog.Error("Failed to get HAP client", "error", err)
-		os.Exit(1)
-	}
-	hapComponent := string(events.ClientHAP)
-	eventBus.PublishConnectionStatus(hapStatusClient, events.ConnectionStatusEvent{
-		Timestamp: time.Now(),
-		Component: hapComponent,
-		Status:    events.ConnectionStatusConnecting,
-	})
-
 	go func() {
 		slog.Info(
 			"Starting HomeKit server",
 			"addr", cfg.HAPAddrPort().String(),
 			"pin", cfg.HAPPin,
 		)
-		eventBus.PublishConnectionStatus(hapStatusClient, events.ConnectionStatusEvent{
-			Timestamp: time.Now(),
-			Component: hapComponent,
-			Status:    events.ConnectionStatusConnected,
-		})
-		if err := hapServer.ListenAndServe(ctx); err != nil {
-			if errors.Is(err, context.Canceled) {
-				eventBus.PublishConnectionStatus(hapStatusClient, events.ConnectionStatusEvent{
-					Timestamp: time.Now(),
-					Component: hapComponent,
-					Status:    events.ConnectionStatusDisconnected,
-				})
-			} else {
-				eventBus.PublishConnectionStatus(hapStatusClient, events.ConnectionStatusEvent{
-					Timestamp: time.Now(),
-					Component: hapComponent,
-					Status:    events.ConnectionStatusFailed,
-					Error:     err.Error(),
-				})
-				slog.Error("HAP server error", "error", err)
-			}
-			return
+		if err := hapManager.Serve(ctx, fsStore, cfg.HAPPin, cfg.HAPAddrPort().String()); err != nil {
+			slog.Error("HAP server error", "error", err)
 		}
-		eventBus.PublishConnectionStatus(hapStatusClient, events.ConnectionStatusEvent{
-			Timestamp: time.Now(),
-			Component: hapComponent,
-			Status:    events.ConnectionStatusDisconnected,
-		})
 	}()
 
+	reloader, err := NewPlugsReloader(cfg.PlugsConfigPath, cfg.MQTTSecretsPath, plugManager, hapManager, eventBus, startPlug)
+	if err != nil {
+		slog.Error("Failed to initialize plugs config reloader", "error", err)
+		os.Exit(1)
+	}
+	if authHook != nil {
+		reloader.OnReload(authHook.Update)
+	}
+	if energyHistory != nil {
+		reloader.OnReload(func(cfg *plugs.Config) {
+			energyHistory.SetTariff(cfg.Tariff)
+		})
+	}
+	reloader.OnReload(safetyMonitor.Update)
+	if haBridge != nil {
+		reloader.OnReload(haBridge.SetPlugs)
+	}
+	reloader.OnReload(webhooks.Update)
+	reloader.OnReload(notifier.Update)
+	reloader.OnReload(plugManager.SetGroups)
+	reloader.OnReload(hapManager.SetGroups)
+	scheduler := schedule.New(commands)
+	scheduler.Update(plugCfg)
+	reloader.OnReload(scheduler.Update)
+	go scheduler.Run(ctx)
+	go reloader.Run(ctx, cfg.PlugsReloadPeriod())
+	deviceDiscovery.SetReloader(reloader)
+	deviceDiscovery.Start(ctx, cfg.DiscoveryScanPeriod())
+	slog.Info(
+		"Plugs config reload enabled",
+		"path", cfg.PlugsConfigPath,
+		"interval", cfg.PlugsReloadPeriod(),
+	)
+
 	fmt.Printf("HomeKit bridge ready - pair with PIN: %s\n\n", cfg.HAPPin)
 
 	qrConfig := homekitqr.QRCodeConfig{
@@ -350,19 +496,86 @@ func Main() {
 	}
 
 	webServer := NewWebServer(logger, plugManager, plugManager, eventBus, kraWeb, cfg.HAPPin, qrCode, hapManager)
+	if cfg.AuthConfigPath != "" {
+		authCfg, err := webauth.LoadConfig(cfg.AuthConfigPath)
+		if err != nil {
+			slog.Error("Failed to load auth configuration", "path", cfg.AuthConfigPath, "error", err)
+			os.Exit(1)
+		}
+		authenticator := webauth.New(authCfg)
+		if enableTailscale {
+			if ts, ok := any(kraWeb).(tsnetServer); ok {
+				lc, err := ts.LocalClient()
+				if err != nil {
+					slog.Error("Failed to get Tailscale local client", "error", err)
+					os.Exit(1)
+				}
+				authenticator.SetWhoIs(tailscaleWhoIs(lc))
+			} else {
+				slog.Warn("Tailscale identities are not available from the web server, tailnet users need a token or password")
+			}
+		}
+		webServer.SetAuthenticator(authenticator)
+		slog.Info("Web authentication enabled", "path", cfg.AuthConfigPath)
+	} else {
+		slog.Warn("Web authentication disabled, anyone who can reach the web UI can control plugs", "hint", "set TASMOTA_HOMEKIT_AUTH_CONFIG")
+	}
+	webServer.SetDiscovery(deviceDiscovery)
+	webServer.SetSchedule(scheduler)
+	webServer.SetGroups(plugManager)
+	webServer.SetWebhooks(webhooks)
+	webServer.SetReadiness(ReadinessCriteria{
+		PlugsSeenPercent: cfg.ReadyPlugsSeenPercent,
+		PlugsSeenWindow:  cfg.ReadyPlugsSeenPeriod(),
+		RequirePaired:    cfg.ReadyRequirePaired,
+		MQTTSilence:      cfg.ReadyMQTTSilencePeriod(),
+	}, mqttHook)
+	if energyHistory != nil {
+		webServer.SetEnergyHistory(energyHistory)
+	}
+	if savedState != nil {
+		webServer.RestoreEventLog(savedState.EventLog)
+	}
 	webServer.LogEvent("Server starting...")
 	webServer.Start(ctx)
 	defer webServer.Close()
 
-	kraWeb.Handle("/", http.HandlerFunc(webServer.HandleIndex))
-	kraWeb.Handle("/toggle/", http.HandlerFunc(webServer.HandleToggle))
-	kraWeb.Handle("/events", http.HandlerFunc(webServer.HandleSSE))
+	var persister *statePersister
+	if stateStore != nil {
+		persister = &statePersister{store: stateStore, plugs: plugManager, web: webServer}
+		go persister.Run(ctx, cfg.StateSavePeriod())
+		slog.Info("State store enabled", "path", cfg.StatePath, "interval", cfg.StateSavePeriod())
+	}
+
+	viewer := func(h http.HandlerFunc) http.Handler { return webServer.Require(webauth.RoleViewer, h) }
+	operator := func(h http.HandlerFunc) http.Handler { return webServer.Require(webauth.RoleOperator, h) }
+	admin := func(h http.HandlerFunc) http.Handler { return webServer.Require(webauth.RoleAdmin, h) }
+
+	kraWeb.Handle("/", viewer(webServer.HandleIndex))
+	kraWeb.Handle("/toggle/", operator(webServer.HandleToggle))
+	kraWeb.Handle("/light/", operator(webServer.HandleLight))
+	kraWeb.Handle("/energy/", viewer(webServer.HandleEnergy))
+	kraWeb.Handle("/energy/export.csv", viewer(webServer.HandleEnergyExport))
+	kraWeb.Handle("/alerts/dismiss", operator(webServer.HandleDismissAlert))
+	kraWeb.Handle("/groups/", operator(webServer.HandleGroup))
+	kraWeb.Handle("/scenes/", operator(webServer.HandleScene))
+	kraWeb.Handle("/schedules", viewer(webServer.HandleSchedules))
+	kraWeb.Handle("/schedules/", operator(webServer.HandleScheduleAction))
+	kraWeb.Handle("/api/v1/", webServer.RequireFunc(apiRole, http.HandlerFunc(webServer.HandleAPI)))
+	kraWeb.Handle("/discovery", admin(webServer.HandleDiscovery))
+	kraWeb.Handle("/discovery/adopt", admin(webServer.HandleDiscoveryAdopt))
+	kraWeb.Handle("/discovery/scan", admin(webServer.HandleDiscoveryScan))
+	kraWeb.Handle("/events", viewer(webServer.HandleSSE))
 	kraWeb.Handle("/health", http.HandlerFunc(webServer.HandleHealth))
-	kraWeb.Handle("/qrcode", http.HandlerFunc(webServer.HandleQRCode))
-	kraWeb.Handle("/debug/eventbus", http.HandlerFunc(webServer.HandleEventBusDebug))
+	kraWeb.Handle("/health/live", http.HandlerFunc(webServer.HandleHealthLive))
+	kraWeb.Handle("/health/ready", http.HandlerFunc(webServer.HandleHealthReady))
+	kraWeb.Handle("/qrcode", admin(webServer.HandleQRCode))
+	kraWeb.Handle("/debug/eventbus", admin(webServer.HandleEventBusDebug))
+	kraWeb.Handle("/debug/webhooks", admin(webServer.HandleWebhooksDebug))
+	kraWeb.Handle("/debug/webhooks/retry", admin(webServer.HandleWebhookRetry))
 
 	// Setup debug handlers with tsweb.Debugger
-	SetupDebugHandlers(kraWeb, hapManager)
+	SetupDebugHandlers(guardedMux{ws: webServer, mux: kraWeb, role: webauth.RoleAdmin}, hapManager)
 
 	webURL := fmt.Sprintf("http://%s", cfg.WebAddrPort().String())
 	if enableTailscale {
@@ -371,8 +584,24 @@ func Main() {
 	slog.Info("Web UI available", "url", webURL)
 
 	slog.Info("Server running, press Ctrl+C to stop")
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Warn("Failed to notify systemd", "error", err)
+	}
+	go runWatchdog(ctx, webServer.Live)
 	<-ctx.Done()
 	slog.Info("Shutting down...")
+	_ = sdNotify("STOPPING=1")
+
+	if persister != nil {
+		if err := persister.Save(); err != nil {
+			slog.Error("Failed to save state", "path", cfg.StatePath, "error", err)
+		}
+	}
+	if energyHistory != nil {
+		if err := energyHistory.Save(); err != nil {
+			slog.Error("Failed to save energy history", "path", cfg.EnergyHistoryPath, "error", err)
+		}
+	}
 
 	slog.Info("Stopping web server...")
 	slog.Info("Stopping MQTT broker...")
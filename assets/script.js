@@ -17,14 +17,40 @@
       return;
     }
 
-    card.classList.toggle('on', data.on);
-    card.classList.toggle('off', !data.on);
+    const relays = Array.isArray(data.relays) ? data.relays : [];
+    const anyOn = data.on || relays.some(Boolean);
+
+    card.classList.toggle('on', anyOn);
+    card.classList.toggle('off', !anyOn);
 
     const statusLabel = card.querySelector('[data-role="status-label"]');
     if (statusLabel) {
-      statusLabel.textContent = 'Status: ' + (data.on ? 'ON' : 'OFF');
+      statusLabel.textContent = 'Status: ' + (anyOn ? 'ON' : 'OFF');
     }
 
+    relays.forEach(function (on, index) {
+      const row = card.querySelector('[data-relay="' + (index + 1) + '"]');
+      if (!row) {
+        return;
+      }
+      row.classList.toggle('on', on);
+      row.classList.toggle('off', !on);
+
+      const relayStatus = row.querySelector('[data-role="relay-status"]');
+      if (relayStatus) {
+        relayStatus.textContent = on ? 'ON' : 'OFF';
+      }
+
+      const relayInput = row.querySelector('[data-role="relay-action-input"]');
+      const relayButton = row.querySelector('[data-role="relay-toggle-button"]');
+      if (relayInput && relayButton) {
+        relayInput.value = on ? 'off' : 'on';
+        relayButton.textContent = on ? 'Turn Off' : 'Turn On';
+        relayButton.classList.toggle('off', on);
+        relayButton.classList.toggle('on', !on);
+      }
+    });
+
     const lastUpdated = card.querySelector('[data-role="last-updated"]');
     if (lastUpdated) {
       lastUpdated.textContent = 'Last updated: ' + formatTime(data.last_updated);
@@ -62,6 +88,35 @@
       energyEl.textContent = data.energy.toFixed(3) + ' kWh';
     }
 
+    // Update sensor readings, formatted like web_sensor.go
+    const sensorUnits = {
+      temperature: [1, ' °C'],
+      humidity: [0, ' %'],
+      pressure: [1, ' hPa'],
+      illuminance: [0, ' lx'],
+    };
+    card.querySelectorAll('[data-role="sensor-value"]').forEach(function (el) {
+      const readings = (data.sensors || {})[el.dataset.sensor] || {};
+      const value = readings[el.dataset.kind];
+      const unit = sensorUnits[el.dataset.kind];
+      if (value !== undefined && unit) {
+        el.textContent = value.toFixed(unit[0]) + unit[1];
+      }
+    });
+
+    // Update bulb sliders unless the user is dragging one
+    [
+      ['brightness', data.brightness],
+      ['hue', data.hue],
+      ['saturation', data.saturation],
+      ['color_temperature', data.color_temperature],
+    ].forEach(function (entry) {
+      const input = card.querySelector('[data-role="' + entry[0] + '-input"]');
+      if (input && entry[1] !== undefined && document.activeElement !== input) {
+        input.value = Math.round(entry[1]);
+      }
+    });
+
     const actionInput = card.querySelector('[data-role="action-input"]');
     const button = card.querySelector('[data-role="toggle-button"]');
     if (actionInput && button) {
@@ -89,5 +144,13 @@
         console.error('invalid SSE payload', err);
       }
     };
+    // Plugs were added, removed or renamed; re-render the page
+    source.addEventListener('config', function () {
+      window.location.reload();
+    });
+    // A safety rule switched a plug off; show the alert banner
+    source.addEventListener('alert', function () {
+      window.location.reload();
+    });
   });
 })();
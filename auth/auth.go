@@ -0,0 +1,301 @@
+// Package auth identifies web and API clients and maps them to roles.
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/tailscale/hujson"
+)
+
+// Role is what an identity may do. Each role includes the ones below it.
+type Role int
+
+const (
+	// RoleNone grants nothing.
+	RoleNone Role = iota
+	// RoleViewer may look at the dashboard, state and history.
+	RoleViewer
+	// RoleOperator may also switch plugs.
+	RoleOperator
+	// RoleAdmin may also see the HomeKit PIN, adopt devices and use the
+	// debug endpoints.
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// ParseRole parses a role name. The empty string is RoleNone.
+func ParseRole(s string) (Role, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return RoleNone, nil
+	case "viewer":
+		return RoleViewer, nil
+	case "operator":
+		return RoleOperator, nil
+	case "admin":
+		return RoleAdmin, nil
+	default:
+		return RoleNone, fmt.Errorf("unknown role %q, want viewer, operator or admin", s)
+	}
+}
+
+// Methods an identity was established with.
+const (
+	MethodDisabled  = "disabled"
+	MethodToken     = "token"
+	MethodPassword  = "password"
+	MethodTailscale = "tailscale"
+	MethodAnonymous = "anonymous"
+)
+
+// Identity is the client behind a request.
+type Identity struct {
+	Name   string
+	Method string
+	Role   Role
+}
+
+// Source returns the command source for commands the identity sends through
+// channel, e.g. "web:alice@example.com". Without authentication it is just
+// the channel.
+func (id Identity) Source(channel string) string {
+	if id.Method == MethodDisabled || id.Method == MethodAnonymous || id.Name == "" {
+		return channel
+	}
+	return channel + ":" + id.Name
+}
+
+// Config is the HuJSON auth file. Tokens and passwords are kept in clear,
+// so the file should be readable by the service only.
+type Config struct {
+	// Anonymous is the role of requests without credentials, none by
+	// default.
+	Anonymous string `json:"anonymous,omitempty"`
+
+	Tailscale TailscaleConfig `json:"tailscale"`
+	Tokens    []Token         `json:"tokens,omitempty"`
+	Users     []User          `json:"users,omitempty"`
+}
+
+// TailscaleConfig maps Tailscale login names to roles. Default applies to
+// every other user of the tailnet.
+type TailscaleConfig struct {
+	Default string            `json:"default,omitempty"`
+	Users   map[string]string `json:"users,omitempty"`
+}
+
+// Token is a static API token, sent as "Authorization: Bearer <token>".
+type Token struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	Role  string `json:"role"`
+}
+
+// User is a local login, sent with HTTP basic auth.
+type User struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// LoadConfig reads and validates the HuJSON auth file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth file: %w", err)
+	}
+
+	standardized, err := hujson.Standardize(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to standardize auth HuJSON: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(standardized, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal auth file: %w", err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (c *Config) validate() error {
+	if _, err := ParseRole(c.Anonymous); err != nil {
+		return fmt.Errorf("anonymous: %w", err)
+	}
+	if _, err := ParseRole(c.Tailscale.Default); err != nil {
+		return fmt.Errorf("tailscale default: %w", err)
+	}
+	for login, role := range c.Tailscale.Users {
+		if _, err := ParseRole(role); err != nil {
+			return fmt.Errorf("tailscale user %s: %w", login, err)
+		}
+	}
+
+	names := make(map[string]bool, len(c.Tokens))
+	for i, token := range c.Tokens {
+		if token.Name == "" {
+			return fmt.Errorf("token %d has no name", i)
+		}
+		if names[token.Name] {
+			return fmt.Errorf("duplicate token name %q", token.Name)
+		}
+		names[token.Name] = true
+		if len(token.Token) < 16 {
+			return fmt.Errorf("token %s must be at least 16 characters", token.Name)
+		}
+		if _, err := ParseRole(token.Role); err != nil {
+			return fmt.Errorf("token %s: %w", token.Name, err)
+		}
+	}
+
+	usernames := make(map[string]bool, len(c.Users))
+	for i, user := range c.Users {
+		if user.Username == "" {
+			return fmt.Errorf("user %d has no username", i)
+		}
+		if usernames[user.Username] {
+			return fmt.Errorf("duplicate username %q", user.Username)
+		}
+		usernames[user.Username] = true
+		if user.Password == "" {
+			return fmt.Errorf("user %s has no password", user.Username)
+		}
+		if _, err := ParseRole(user.Role); err != nil {
+			return fmt.Errorf("user %s: %w", user.Username, err)
+		}
+	}
+	return nil
+}
+
+// WhoIsFunc returns the Tailscale login name of the peer at remoteAddr.
+type WhoIsFunc func(ctx context.Context, remoteAddr string) (string, error)
+
+// ErrInvalidCredentials is returned for a token or password that does not
+// match.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticator identifies requests. A nil or disabled Authenticator gives
+// every request the admin role.
+type Authenticator struct {
+	cfg   *Config
+	whois WhoIsFunc
+}
+
+// New returns an Authenticator for cfg. A nil cfg disables authentication.
+func New(cfg *Config) *Authenticator {
+	return &Authenticator{cfg: cfg}
+}
+
+// Enabled reports whether requests are authenticated.
+func (a *Authenticator) Enabled() bool {
+	return a != nil && a.cfg != nil
+}
+
+// SetWhoIs resolves Tailscale identities of requests from the tailnet.
+func (a *Authenticator) SetWhoIs(whois WhoIsFunc) {
+	a.whois = whois
+}
+
+// Authenticate returns the identity of r. Credentials are checked in order:
+// an API token, a username and password, the Tailscale identity of the
+// peer, and finally the anonymous role. Credentials that are given but do
+// not match are an error rather than falling through to the next method.
+func (a *Authenticator) Authenticate(r *http.Request) (Identity, error) {
+	if !a.Enabled() {
+		return Identity{Name: MethodDisabled, Method: MethodDisabled, Role: RoleAdmin}, nil
+	}
+
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		secret := strings.TrimPrefix(header, "Bearer ")
+		for _, token := range a.cfg.Tokens {
+			if subtle.ConstantTimeCompare([]byte(secret), []byte(token.Token)) == 1 {
+				role, _ := ParseRole(token.Role)
+				return Identity{Name: "token:" + token.Name, Method: MethodToken, Role: role}, nil
+			}
+		}
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	if username, password, ok := r.BasicAuth(); ok {
+		for _, user := range a.cfg.Users {
+			if user.Username == username && subtle.ConstantTimeCompare([]byte(password), []byte(user.Password)) == 1 {
+				role, _ := ParseRole(user.Role)
+				return Identity{Name: user.Username, Method: MethodPassword, Role: role}, nil
+			}
+		}
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	if a.whois != nil && fromTailnet(r.RemoteAddr) {
+		if login, err := a.whois(r.Context(), r.RemoteAddr); err == nil && login != "" {
+			roleName, ok := a.cfg.Tailscale.Users[login]
+			if !ok {
+				roleName = a.cfg.Tailscale.Default
+			}
+			role, _ := ParseRole(roleName)
+			if role > RoleNone {
+				return Identity{Name: login, Method: MethodTailscale, Role: role}, nil
+			}
+		}
+	}
+
+	role, _ := ParseRole(a.cfg.Anonymous)
+	return Identity{Method: MethodAnonymous, Role: role}, nil
+}
+
+// PasswordLogin reports whether local users are configured, so clients
+// without credentials can be asked for them.
+func (a *Authenticator) PasswordLogin() bool {
+	return a.Enabled() && len(a.cfg.Users) > 0
+}
+
+var (
+	tailnetIPv4 = netip.MustParsePrefix("100.64.0.0/10")
+	tailnetIPv6 = netip.MustParsePrefix("fd7a:115c:a1e0::/48")
+)
+
+// fromTailnet reports whether remoteAddr is a Tailscale address, so the
+// local listener does not ask the tailnet about LAN clients.
+func fromTailnet(remoteAddr string) bool {
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	return tailnetIPv4.Contains(addr) || tailnetIPv6.Contains(addr)
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a context carrying id.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the identity stored by WithIdentity.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
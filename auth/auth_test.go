@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "0123456789abcdef0123"
+
+func testConfig() *Config {
+	return &Config{
+		Anonymous: "viewer",
+		Tailscale: TailscaleConfig{
+			Default: "viewer",
+			Users:   map[string]string{"alice@example.com": "admin"},
+		},
+		Tokens: []Token{{Name: "shortcuts", Token: testToken, Role: "operator"}},
+		Users:  []User{{Username: "bob", Password: "hunter2", Role: "operator"}},
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.hujson")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		// Everyone on the LAN may look
+		"anonymous": "viewer",
+		"tailscale": {"users": {"alice@example.com": "admin"}},
+		"tokens": [{"name": "shortcuts", "token": "`+testToken+`", "role": "operator"}],
+	}`), 0o600))
+
+	cfg, err := LoadConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, "viewer", cfg.Anonymous)
+	require.Len(t, cfg.Tokens, 1)
+
+	for _, tt := range []struct {
+		name   string
+		cfg    Config
+		errMsg string
+	}{
+		{"unknown role", Config{Anonymous: "root"}, `unknown role "root"`},
+		{"short token", Config{Tokens: []Token{{Name: "a", Token: "short", Role: "viewer"}}}, "at least 16"},
+		{"duplicate token", Config{Tokens: []Token{
+			{Name: "a", Token: testToken, Role: "viewer"},
+			{Name: "a", Token: testToken + "x", Role: "viewer"},
+		}}, "duplicate token"},
+		{"no password", Config{Users: []User{{Username: "bob", Role: "admin"}}}, "no password"},
+		{"tailscale role", Config{Tailscale: TailscaleConfig{Users: map[string]string{"a": "owner"}}}, "tailscale user a"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			require.ErrorContains(t, tt.cfg.validate(), tt.errMsg)
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := New(testConfig())
+	a.SetWhoIs(func(_ context.Context, remoteAddr string) (string, error) {
+		switch remoteAddr {
+		case "100.64.0.1:1234":
+			return "alice@example.com", nil
+		case "100.64.0.2:1234":
+			return "carol@example.com", nil
+		}
+		return "", errors.New("unknown peer")
+	})
+
+	request := func(remoteAddr string, modify func(*http.Request)) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		if modify != nil {
+			modify(r)
+		}
+		return r
+	}
+
+	for _, tt := range []struct {
+		name string
+		req  *http.Request
+		want Identity
+		err  error
+	}{
+		{"token", request("192.168.1.5:1234", func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+testToken)
+		}), Identity{Name: "token:shortcuts", Method: MethodToken, Role: RoleOperator}, nil},
+		{"bad token", request("100.64.0.1:1234", func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer nope")
+		}), Identity{}, ErrInvalidCredentials},
+		{"password", request("192.168.1.5:1234", func(r *http.Request) {
+			r.SetBasicAuth("bob", "hunter2")
+		}), Identity{Name: "bob", Method: MethodPassword, Role: RoleOperator}, nil},
+		{"bad password", request("192.168.1.5:1234", func(r *http.Request) {
+			r.SetBasicAuth("bob", "wrong")
+		}), Identity{}, ErrInvalidCredentials},
+		{"tailscale user", request("100.64.0.1:1234", nil), Identity{Name: "alice@example.com", Method: MethodTailscale, Role: RoleAdmin}, nil},
+		{"tailscale default", request("100.64.0.2:1234", nil), Identity{Name: "carol@example.com", Method: MethodTailscale, Role: RoleViewer}, nil},
+		{"unknown peer", request("100.64.0.3:1234", nil), Identity{Method: MethodAnonymous, Role: RoleViewer}, nil},
+		// LAN clients are not looked up on the tailnet
+		{"lan", request("192.168.1.5:1234", nil), Identity{Method: MethodAnonymous, Role: RoleViewer}, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := a.Authenticate(tt.req)
+			require.ErrorIs(t, err, tt.err)
+			require.Equal(t, tt.want, id)
+		})
+	}
+}
+
+func TestAuthenticateDisabled(t *testing.T) {
+	var a *Authenticator
+	id, err := a.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil))
+	require.NoError(t, err)
+	require.Equal(t, RoleAdmin, id.Role)
+	require.Equal(t, "web", id.Source("web"))
+	require.False(t, a.PasswordLogin())
+
+	require.Equal(t, "api:token:shortcuts", Identity{Name: "token:shortcuts", Method: MethodToken}.Source("api"))
+}
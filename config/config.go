@@ -2,10 +2,15 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"net/netip"
+	"net/url"
 	"os"
+	"strconv"
+	"time"
 
 	env "github.com/Netflix/go-env"
+	"github.com/kradalby/tasmota-homekit/discovery"
 )
 
 const (
@@ -13,6 +18,7 @@ const (
 	defaultHAPPort       = 8080
 	defaultWebPort       = 8081
 	defaultMQTTPort      = 1883
+	defaultMQTTTLSPort   = 8883
 	defaultMQTTBindLocal = "0.0.0.0"
 	defaultBridgeName    = "tasmota-homekit"
 )
@@ -26,15 +32,48 @@ type Config struct {
 	HAPBindAddress string `env:"TASMOTA_HOMEKIT_HAP_BIND_ADDRESS,default=0.0.0.0"`
 	HAPPort        int    `env:"TASMOTA_HOMEKIT_HAP_PORT,default=8080"`
 
+	// Plug state, connection history and event log saved across restarts;
+	// an empty path disables the store. Seconds between periodic saves,
+	// 0 saves only on shutdown.
+	StatePath         string `env:"TASMOTA_HOMEKIT_STATE_PATH,default=./data/state.json"`
+	StateSaveInterval int    `env:"TASMOTA_HOMEKIT_STATE_SAVE_INTERVAL,default=60"`
+	// Energy readings of power-monitored plugs with hourly, daily and monthly
+	// rollups, saved at the same interval; an empty path disables it
+	EnergyHistoryPath string `env:"TASMOTA_HOMEKIT_ENERGY_HISTORY_PATH,default=./data/energy.json"`
+	// Webhook deliveries given up on, kept for retrying from the debug
+	// page; an empty path keeps them in memory only
+	WebhookDeadLetterPath string `env:"TASMOTA_HOMEKIT_WEBHOOK_DEAD_LETTER_PATH,default=./data/webhooks.json"`
+
 	// Web listener configuration
 	WebAddr        string `env:"TASMOTA_HOMEKIT_WEB_ADDR"`
 	WebBindAddress string `env:"TASMOTA_HOMEKIT_WEB_BIND_ADDRESS,default=0.0.0.0"`
 	WebPort        int    `env:"TASMOTA_HOMEKIT_WEB_PORT,default=8081"`
+	// Optional HuJSON file with API tokens, local users and Tailscale roles;
+	// without it the web UI and API are open to anyone who can reach them
+	AuthConfigPath string `env:"TASMOTA_HOMEKIT_AUTH_CONFIG"`
 
 	// Embedded MQTT listener configuration
 	MQTTAddr        string `env:"TASMOTA_HOMEKIT_MQTT_ADDR"`
 	MQTTBindAddress string `env:"TASMOTA_HOMEKIT_MQTT_BIND_ADDRESS,default=0.0.0.0"`
 	MQTTPort        int    `env:"TASMOTA_HOMEKIT_MQTT_PORT,default=1883"`
+	// Require plug credentials and restrict each plug to its own topics
+	MQTTAuth bool `env:"TASMOTA_HOMEKIT_MQTT_AUTH,default=false"`
+	// Optional HuJSON file with plug credentials and extra broker users
+	MQTTSecretsPath string `env:"TASMOTA_HOMEKIT_MQTT_SECRETS"`
+
+	// Seconds to wait for a plug to confirm a command sent over MQTT before
+	// retrying it over HTTP; 0 sends all commands over HTTP
+	MQTTCommandTimeout int `env:"TASMOTA_HOMEKIT_MQTT_COMMAND_TIMEOUT,default=2"`
+
+	// Optional TLS listener of the embedded broker. Without a certificate
+	// and key, a self-signed CA and server certificate are generated in
+	// MQTTTLSDir on first start.
+	MQTTTLS     bool   `env:"TASMOTA_HOMEKIT_MQTT_TLS,default=false"`
+	MQTTTLSAddr string `env:"TASMOTA_HOMEKIT_MQTT_TLS_ADDR"`
+	MQTTTLSPort int    `env:"TASMOTA_HOMEKIT_MQTT_TLS_PORT,default=8883"`
+	MQTTTLSCert string `env:"TASMOTA_HOMEKIT_MQTT_TLS_CERT"`
+	MQTTTLSKey  string `env:"TASMOTA_HOMEKIT_MQTT_TLS_KEY"`
+	MQTTTLSDir  string `env:"TASMOTA_HOMEKIT_MQTT_TLS_DIR,default=./data/mqtt-tls"`
 
 	// Tailscale configuration
 	BridgeName        string `env:"TASMOTA_HOMEKIT_BRIDGE_NAME"`
@@ -48,10 +87,41 @@ type Config struct {
 
 	// Plugs configuration file
 	PlugsConfigPath string `env:"TASMOTA_HOMEKIT_PLUGS_CONFIG,default=./plugs.hujson"`
+	// Seconds between checks of the plugs file for changes; 0 disables
+	// polling (SIGHUP still triggers a reload)
+	PlugsReloadInterval int `env:"TASMOTA_HOMEKIT_PLUGS_RELOAD_INTERVAL,default=5"`
+
+	// Device discovery: optional IPv4 range to sweep for Tasmota devices,
+	// and seconds between sweeps (0 sweeps only at startup and on demand)
+	DiscoveryScanCIDR     string `env:"TASMOTA_HOMEKIT_DISCOVERY_SCAN_CIDR"`
+	DiscoveryScanInterval int    `env:"TASMOTA_HOMEKIT_DISCOVERY_SCAN_INTERVAL,default=0"`
+
+	// Optional external MQTT broker to mirror the plugs to for Home
+	// Assistant, e.g. mqtt://broker:1883 or mqtts://broker:8883; empty
+	// disables the bridge
+	HABroker          string `env:"TASMOTA_HOMEKIT_HA_BROKER"`
+	HAUsername        string `env:"TASMOTA_HOMEKIT_HA_USERNAME"`
+	HAPassword        string `env:"TASMOTA_HOMEKIT_HA_PASSWORD"`
+	HATopicPrefix     string `env:"TASMOTA_HOMEKIT_HA_TOPIC_PREFIX,default=tasmota-homekit"`
+	HADiscoveryPrefix string `env:"TASMOTA_HOMEKIT_HA_DISCOVERY_PREFIX,default=homeassistant"`
 
-	hapAddr  netip.AddrPort
-	webAddr  netip.AddrPort
-	mqttAddr netip.AddrPort
+	// Readiness criteria of /health/ready on top of the MQTT broker, HAP
+	// server and web interface running: the percentage of plugs that must
+	// have been heard from within the window (seconds), whether HomeKit
+	// must be paired, and seconds without any MQTT message from a plug
+	// after which the bridge is not ready (0 disables the last two checks)
+	ReadyPlugsSeenPercent int  `env:"TASMOTA_HOMEKIT_READY_PLUGS_SEEN_PERCENT,default=0"`
+	ReadyPlugsSeenWindow  int  `env:"TASMOTA_HOMEKIT_READY_PLUGS_SEEN_WINDOW,default=120"`
+	ReadyRequirePaired    bool `env:"TASMOTA_HOMEKIT_READY_REQUIRE_PAIRED,default=false"`
+	ReadyMQTTSilence      int  `env:"TASMOTA_HOMEKIT_READY_MQTT_SILENCE,default=0"`
+
+	hapAddr             netip.AddrPort
+	webAddr             netip.AddrPort
+	mqttAddr            netip.AddrPort
+	mqttTLSAddr         netip.AddrPort
+	discoveryScanPrefix netip.Prefix
+	haBrokerAddr        string
+	haBrokerTLS         bool
 }
 
 // Load reads configuration from the environment.
@@ -84,6 +154,33 @@ func (c *Config) Validate() error {
 	if c.PlugsConfigPath == "" {
 		return fmt.Errorf("PlugsConfigPath cannot be empty")
 	}
+	if c.PlugsReloadInterval < 0 {
+		return fmt.Errorf("plugs reload interval cannot be negative, got %d", c.PlugsReloadInterval)
+	}
+	if c.StateSaveInterval < 0 {
+		return fmt.Errorf("state save interval cannot be negative, got %d", c.StateSaveInterval)
+	}
+	if c.MQTTCommandTimeout < 0 {
+		return fmt.Errorf("MQTT command timeout cannot be negative, got %d", c.MQTTCommandTimeout)
+	}
+	if c.ReadyPlugsSeenPercent < 0 || c.ReadyPlugsSeenPercent > 100 {
+		return fmt.Errorf("ready plugs seen percent must be between 0 and 100, got %d", c.ReadyPlugsSeenPercent)
+	}
+	if c.ReadyPlugsSeenWindow <= 0 {
+		return fmt.Errorf("ready plugs seen window must be positive, got %d", c.ReadyPlugsSeenWindow)
+	}
+	if c.ReadyMQTTSilence < 0 {
+		return fmt.Errorf("ready MQTT silence cannot be negative, got %d", c.ReadyMQTTSilence)
+	}
+	if err := c.parseDiscovery(); err != nil {
+		return err
+	}
+	if err := c.parseMQTTTLS(); err != nil {
+		return err
+	}
+	if err := c.parseHABroker(); err != nil {
+		return err
+	}
 	if err := validateLogLevel(c.LogLevel); err != nil {
 		return err
 	}
@@ -175,6 +272,149 @@ func (c *Config) MQTTAddrPort() netip.AddrPort {
 	return c.mqttAddr
 }
 
+func (c *Config) parseMQTTTLS() error {
+	if !c.MQTTTLS {
+		return nil
+	}
+	if (c.MQTTTLSCert == "") != (c.MQTTTLSKey == "") {
+		return fmt.Errorf("MQTT TLS needs both a certificate and a key, or neither for a self-signed certificate")
+	}
+	if c.MQTTTLSCert == "" && c.MQTTTLSDir == "" {
+		return fmt.Errorf("MQTTTLSDir cannot be empty without a TLS certificate")
+	}
+
+	if c.MQTTTLSPort == 0 && !envVarSet("TASMOTA_HOMEKIT_MQTT_TLS_PORT") {
+		c.MQTTTLSPort = defaultMQTTTLSPort
+	}
+	if err := validatePortRange("MQTT TLS", c.MQTTTLSPort); err != nil {
+		return err
+	}
+	addr := c.MQTTTLSAddr
+	if addr == "" {
+		addr = fmt.Sprintf("%s:%d", c.MQTTBindAddress, c.MQTTTLSPort)
+	}
+	parsed, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid MQTT TLS addr %q: %w", addr, err)
+	}
+	if parsed == c.mqttAddr {
+		return fmt.Errorf("MQTT TLS listener cannot share the plaintext listener address %s", parsed)
+	}
+	c.mqttTLSAddr = parsed
+	return nil
+}
+
+// MQTTTLSAddrPort returns the parsed MQTT TLS listener address, invalid when
+// the TLS listener is disabled.
+func (c *Config) MQTTTLSAddrPort() netip.AddrPort {
+	return c.mqttTLSAddr
+}
+
+func (c *Config) parseHABroker() error {
+	c.haBrokerAddr, c.haBrokerTLS = "", false
+	if c.HABroker == "" {
+		return nil
+	}
+	u, err := url.Parse(c.HABroker)
+	if err != nil {
+		return fmt.Errorf("invalid Home Assistant broker %q: %w", c.HABroker, err)
+	}
+	port := defaultMQTTPort
+	switch u.Scheme {
+	case "mqtt", "tcp":
+	case "mqtts", "ssl":
+		c.haBrokerTLS = true
+		port = defaultMQTTTLSPort
+	default:
+		return fmt.Errorf("invalid Home Assistant broker %q: scheme must be mqtt or mqtts", c.HABroker)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("invalid Home Assistant broker %q: missing host", c.HABroker)
+	}
+	if u.Port() != "" {
+		port, err = strconv.Atoi(u.Port())
+		if err != nil {
+			return fmt.Errorf("invalid Home Assistant broker %q: %w", c.HABroker, err)
+		}
+		if err := validatePortRange("Home Assistant broker", port); err != nil {
+			return err
+		}
+	}
+	if c.HATopicPrefix == "" || c.HADiscoveryPrefix == "" {
+		return fmt.Errorf("Home Assistant topic and discovery prefixes cannot be empty")
+	}
+	c.haBrokerAddr = net.JoinHostPort(u.Hostname(), strconv.Itoa(port))
+	return nil
+}
+
+// HABrokerAddr returns the host:port of the Home Assistant broker and
+// whether to connect with TLS. The address is empty when the bridge is
+// disabled.
+func (c *Config) HABrokerAddr() (string, bool) {
+	return c.haBrokerAddr, c.haBrokerTLS
+}
+
+func (c *Config) parseDiscovery() error {
+	if c.DiscoveryScanInterval < 0 {
+		return fmt.Errorf("discovery scan interval cannot be negative, got %d", c.DiscoveryScanInterval)
+	}
+	if c.DiscoveryScanCIDR == "" {
+		c.discoveryScanPrefix = netip.Prefix{}
+		return nil
+	}
+	prefix, err := netip.ParsePrefix(c.DiscoveryScanCIDR)
+	if err != nil {
+		return fmt.Errorf("invalid discovery scan CIDR %q: %w", c.DiscoveryScanCIDR, err)
+	}
+	if err := discovery.ValidatePrefix(prefix); err != nil {
+		return err
+	}
+	c.discoveryScanPrefix = prefix.Masked()
+	return nil
+}
+
+// DiscoveryScanPrefix returns the network range to sweep for Tasmota devices.
+// The prefix is invalid when sweeping is disabled.
+func (c *Config) DiscoveryScanPrefix() netip.Prefix {
+	return c.discoveryScanPrefix
+}
+
+// DiscoveryScanPeriod returns how often the scan range is swept.
+func (c *Config) DiscoveryScanPeriod() time.Duration {
+	return time.Duration(c.DiscoveryScanInterval) * time.Second
+}
+
+// PlugsReloadPeriod returns how often the plugs file is checked for changes.
+// Zero means the file is only reloaded on SIGHUP.
+func (c *Config) PlugsReloadPeriod() time.Duration {
+	return time.Duration(c.PlugsReloadInterval) * time.Second
+}
+
+// StateSavePeriod returns how often the state store is written. Zero means
+// it is only written on shutdown.
+func (c *Config) StateSavePeriod() time.Duration {
+	return time.Duration(c.StateSaveInterval) * time.Second
+}
+
+// MQTTCommandWait returns how long a command sent over MQTT may take to be
+// confirmed. Zero means commands are only sent over HTTP.
+func (c *Config) MQTTCommandWait() time.Duration {
+	return time.Duration(c.MQTTCommandTimeout) * time.Second
+}
+
+// ReadyPlugsSeenPeriod returns how recently a plug must have been heard from
+// to count towards readiness.
+func (c *Config) ReadyPlugsSeenPeriod() time.Duration {
+	return time.Duration(c.ReadyPlugsSeenWindow) * time.Second
+}
+
+// ReadyMQTTSilencePeriod returns how long the broker may go without a
+// message from a plug before the bridge is not ready. Zero disables the
+// check.
+func (c *Config) ReadyMQTTSilencePeriod() time.Duration {
+	return time.Duration(c.ReadyMQTTSilence) * time.Second
+}
+
 func (c *Config) ensureParsed() {
 	if !c.hapAddr.IsValid() || !c.webAddr.IsValid() || !c.mqttAddr.IsValid() {
 		if err := c.parseListenerAddrs(); err != nil {
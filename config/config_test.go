@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -53,6 +54,34 @@ func TestLoadConfig(t *testing.T) {
 			},
 			errMsg: "invalid log format",
 		},
+		{
+			name: "negative plugs reload interval",
+			env: map[string]string{
+				"TASMOTA_HOMEKIT_PLUGS_RELOAD_INTERVAL": "-1",
+			},
+			errMsg: "plugs reload interval cannot be negative",
+		},
+		{
+			name: "negative state save interval",
+			env: map[string]string{
+				"TASMOTA_HOMEKIT_STATE_SAVE_INTERVAL": "-1",
+			},
+			errMsg: "state save interval cannot be negative",
+		},
+		{
+			name: "invalid discovery scan CIDR",
+			env: map[string]string{
+				"TASMOTA_HOMEKIT_DISCOVERY_SCAN_CIDR": "192.168.1.0",
+			},
+			errMsg: "invalid discovery scan CIDR",
+		},
+		{
+			name: "discovery scan range too large",
+			env: map[string]string{
+				"TASMOTA_HOMEKIT_DISCOVERY_SCAN_CIDR": "10.0.0.0/8",
+			},
+			errMsg: "too large",
+		},
 	}
 
 	for _, tt := range tests {
@@ -110,6 +139,69 @@ func TestConfigDefaults(t *testing.T) {
 	if cfg.PlugsConfigPath != "./plugs.hujson" {
 		t.Errorf("PlugsConfigPath = %s, want ./plugs.hujson", cfg.PlugsConfigPath)
 	}
+	if got := cfg.PlugsReloadPeriod(); got != 5*time.Second {
+		t.Errorf("PlugsReloadPeriod = %s, want 5s", got)
+	}
+	if cfg.DiscoveryScanPrefix().IsValid() {
+		t.Errorf("DiscoveryScanPrefix = %s, want disabled", cfg.DiscoveryScanPrefix())
+	}
+	if got := cfg.MQTTCommandWait(); got != 2*time.Second {
+		t.Errorf("MQTTCommandWait = %s, want 2s", got)
+	}
+	if cfg.StatePath != "./data/state.json" {
+		t.Errorf("StatePath = %s, want ./data/state.json", cfg.StatePath)
+	}
+	if got := cfg.StateSavePeriod(); got != time.Minute {
+		t.Errorf("StateSavePeriod = %s, want 1m", got)
+	}
+	if cfg.EnergyHistoryPath != "./data/energy.json" {
+		t.Errorf("EnergyHistoryPath = %s, want ./data/energy.json", cfg.EnergyHistoryPath)
+	}
+	if cfg.WebhookDeadLetterPath != "./data/webhooks.json" {
+		t.Errorf("WebhookDeadLetterPath = %s, want ./data/webhooks.json", cfg.WebhookDeadLetterPath)
+	}
+	if cfg.ReadyPlugsSeenPercent != 0 || cfg.ReadyRequirePaired {
+		t.Errorf("readiness requires plugs seen %d%%, paired %v, want neither", cfg.ReadyPlugsSeenPercent, cfg.ReadyRequirePaired)
+	}
+	if got := cfg.ReadyPlugsSeenPeriod(); got != 2*time.Minute {
+		t.Errorf("ReadyPlugsSeenPeriod = %s, want 2m", got)
+	}
+	if got := cfg.ReadyMQTTSilencePeriod(); got != 0 {
+		t.Errorf("ReadyMQTTSilencePeriod = %s, want disabled", got)
+	}
+}
+
+func TestReadinessCriteria(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("TASMOTA_HOMEKIT_READY_PLUGS_SEEN_PERCENT", "80")
+	t.Setenv("TASMOTA_HOMEKIT_READY_PLUGS_SEEN_WINDOW", "300")
+	t.Setenv("TASMOTA_HOMEKIT_READY_MQTT_SILENCE", "600")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ReadyPlugsSeenPercent != 80 {
+		t.Errorf("ReadyPlugsSeenPercent = %d, want 80", cfg.ReadyPlugsSeenPercent)
+	}
+	if got := cfg.ReadyPlugsSeenPeriod(); got != 5*time.Minute {
+		t.Errorf("ReadyPlugsSeenPeriod = %s, want 5m", got)
+	}
+	if got := cfg.ReadyMQTTSilencePeriod(); got != 10*time.Minute {
+		t.Errorf("ReadyMQTTSilencePeriod = %s, want 10m", got)
+	}
+
+	for key, value := range map[string]string{
+		"TASMOTA_HOMEKIT_READY_PLUGS_SEEN_PERCENT": "101",
+		"TASMOTA_HOMEKIT_READY_PLUGS_SEEN_WINDOW":  "0",
+		"TASMOTA_HOMEKIT_READY_MQTT_SILENCE":       "-1",
+	} {
+		clearEnv(t)
+		t.Setenv(key, value)
+		if _, err := Load(); err == nil {
+			t.Errorf("Load() accepted %s=%s", key, value)
+		}
+	}
 }
 
 func TestBridgeNameFollowsTailscaleOverride(t *testing.T) {
@@ -187,6 +279,71 @@ func TestAddressOverrides(t *testing.T) {
 	}
 }
 
+func TestMQTTTLS(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("TASMOTA_HOMEKIT_MQTT_TLS", "true")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.MQTTTLSAddrPort().String(); got != "0.0.0.0:8883" {
+		t.Errorf("MQTT TLS addr = %s, want 0.0.0.0:8883", got)
+	}
+
+	t.Setenv("TASMOTA_HOMEKIT_MQTT_TLS_CERT", "/etc/ssl/broker.pem")
+	if _, err := Load(); err == nil {
+		t.Error("Load() accepted a TLS certificate without a key")
+	}
+
+	clearEnv(t)
+	t.Setenv("TASMOTA_HOMEKIT_MQTT_TLS", "true")
+	t.Setenv("TASMOTA_HOMEKIT_MQTT_TLS_PORT", "1883")
+	if _, err := Load(); err == nil {
+		t.Error("Load() accepted a TLS listener on the plaintext port")
+	}
+}
+
+func TestHABroker(t *testing.T) {
+	tests := []struct {
+		broker  string
+		addr    string
+		tls     bool
+		wantErr bool
+	}{
+		{broker: "", addr: ""},
+		{broker: "mqtt://broker.lan", addr: "broker.lan:1883"},
+		{broker: "mqtts://broker.lan", addr: "broker.lan:8883", tls: true},
+		{broker: "tcp://10.0.0.2:1884", addr: "10.0.0.2:1884"},
+		{broker: "mqtt://[fd00::2]", addr: "[fd00::2]:1883"},
+		{broker: "http://broker.lan", wantErr: true},
+		{broker: "mqtt://", wantErr: true},
+		{broker: "mqtt://broker.lan:70000", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.broker, func(t *testing.T) {
+			clearEnv(t)
+			t.Setenv("TASMOTA_HOMEKIT_HA_BROKER", tt.broker)
+
+			cfg, err := Load()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Load() accepted broker %q", tt.broker)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			addr, tls := cfg.HABrokerAddr()
+			if addr != tt.addr || tls != tt.tls {
+				t.Errorf("HABrokerAddr() = %q, %v, want %q, %v", addr, tls, tt.addr, tt.tls)
+			}
+		})
+	}
+}
+
 func TestSetListenerAddrsForTesting(t *testing.T) {
 	cfg := &Config{}
 	cfg.SetListenerAddrsForTesting("1.2.3.4:1234", "5.6.7.8:5678", "9.9.9.9:9999")
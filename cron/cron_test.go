@@ -0,0 +1,66 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNext(t *testing.T) {
+	// 2025-03-03 is a Monday
+	from := time.Date(2025, 3, 3, 10, 30, 0, 0, time.UTC)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2025, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	for _, tt := range []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", at(3, 3, 10, 31)},
+		{"30 10 * * *", at(3, 4, 10, 30)},
+		{"0 7 * * mon-fri", at(3, 4, 7, 0)},
+		{"0 9 * * sat,sun", at(3, 8, 9, 0)},
+		{"0 9 * * 7", at(3, 9, 9, 0)},
+		{"*/15 * * * *", at(3, 3, 10, 45)},
+		{"0 0 1 * *", at(4, 1, 0, 0)},
+		{"0 12 1 jan *", time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 8-18/5 * * *", at(3, 3, 13, 0)},
+		// Restricted day of month and week match either
+		{"0 0 15 * fri", at(3, 7, 0, 0)},
+	} {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := Parse(tt.expr)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, expr.Next(from))
+		})
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	expr, err := Parse("0 0 30 feb *")
+	require.NoError(t, err)
+	require.True(t, expr.Next(time.Now()).IsZero())
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tt := range []struct {
+		expr   string
+		errMsg string
+	}{
+		{"* * * *", "needs 5 fields"},
+		{"60 * * * *", "invalid minute"},
+		{"* 24 * * *", "invalid hour"},
+		{"* * 0 * *", "invalid day of month"},
+		{"* * * foo *", "invalid month"},
+		{"* * * * 8", "invalid day of week"},
+		{"*/0 * * * *", "invalid minute step"},
+		{"* 10-5 * * *", "invalid hour range"},
+	} {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			require.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/brutella/hap"
 	"github.com/brutella/hap/accessory"
+	"github.com/kradalby/tasmota-homekit/plugs"
 )
 
 // SetupDebugHandlers registers the HAP debug handler without using tsweb.Debugger to avoid pattern conflicts
@@ -38,6 +39,8 @@ type HAPDebugInfo struct {
 	Pairings    []PairingInfo   `json:"pairings,omitempty"`
 	Stats       StatsInfo       `json:"stats"`
 	Accessories []AccessoryInfo `json:"accessories"`
+	Groups      []GroupInfo     `json:"groups,omitempty"`
+	Scenes      []SceneInfo     `json:"scenes,omitempty"`
 }
 
 // ServerInfo contains HAP server information
@@ -71,6 +74,25 @@ type AccessoryInfo struct {
 	Firmware     string `json:"firmware"`
 }
 
+// GroupInfo describes the switch of a plug group
+type GroupInfo struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	AccessoryID uint64   `json:"accessory_id"`
+	Aggregate   string   `json:"aggregate"`
+	Plugs       []string `json:"plugs"`
+	On          bool     `json:"on"`
+	Reachable   bool     `json:"reachable"`
+}
+
+// SceneInfo describes the switch of a scene
+type SceneInfo struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	AccessoryID uint64 `json:"accessory_id"`
+	Plugs       int    `json:"plugs"`
+}
+
 // DebugInfo returns debug information about the HAP manager
 func (hm *HAPManager) DebugInfo() HAPDebugInfo {
 	info := HAPDebugInfo{
@@ -78,20 +100,23 @@ func (hm *HAPManager) DebugInfo() HAPDebugInfo {
 	}
 
 	// Server info
-	if hm.server != nil {
+	if server := hm.Server(); server != nil {
 		info.Server = &ServerInfo{
-			Address: hm.server.Addr,
-			PIN:     hm.server.Pin,
-			Paired:  hm.server.IsPaired(),
+			Address: server.Addr,
+			PIN:     server.Pin,
+			Paired:  server.IsPaired(),
 		}
 	}
 
 	// Pairings
-	if hm.store != nil {
+	hm.mu.RLock()
+	store := hm.store
+	hm.mu.RUnlock()
+	if store != nil {
 		type pairingStore interface {
 			Pairings() ([]hap.Pairing, error)
 		}
-		if ps, ok := hm.store.(pairingStore); ok {
+		if ps, ok := store.(pairingStore); ok {
 			pairings, err := ps.Pairings()
 			if err == nil {
 				for _, p := range pairings {
@@ -131,6 +156,12 @@ func (hm *HAPManager) DebugInfo() HAPDebugInfo {
 			accType = "Outlet"
 		case accessory.TypeLightbulb:
 			accType = "Lightbulb"
+		case accessory.TypeSwitch:
+			accType = "Switch"
+		case accessory.TypeProgrammableSwitch:
+			accType = "Programmable Switch"
+		case accessory.TypeSensor:
+			accType = "Sensor"
 		}
 
 		info.Accessories = append(info.Accessories, AccessoryInfo{
@@ -154,8 +185,14 @@ func (hm *HAPManager) DebugInfo() HAPDebugInfo {
 				return 1
 			case "Lightbulb":
 				return 2
-			default:
+			case "Switch":
 				return 3
+			case "Programmable Switch":
+				return 4
+			case "Sensor":
+				return 5
+			default:
+				return 6
 			}
 		}
 
@@ -168,5 +205,32 @@ func (hm *HAPManager) DebugInfo() HAPDebugInfo {
 		return info.Accessories[i].Name < info.Accessories[j].Name
 	})
 
+	// Groups and scenes
+	hm.mu.RLock()
+	for _, acc := range hm.groups {
+		aggregate := acc.group.Aggregate
+		if aggregate == "" {
+			aggregate = plugs.GroupAny
+		}
+		info.Groups = append(info.Groups, GroupInfo{
+			ID:          acc.group.ID,
+			Name:        acc.group.Name,
+			AccessoryID: acc.sw.Id,
+			Aggregate:   aggregate,
+			Plugs:       acc.group.Plugs,
+			On:          acc.sw.OnValue(),
+			Reachable:   acc.sw.Reachable(),
+		})
+	}
+	for _, acc := range hm.scenes {
+		info.Scenes = append(info.Scenes, SceneInfo{
+			ID:          acc.scene.ID,
+			Name:        acc.scene.Name,
+			AccessoryID: acc.sw.Id,
+			Plugs:       len(acc.scene.Plugs),
+		})
+	}
+	hm.mu.RUnlock()
+
 	return info
 }
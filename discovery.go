@@ -0,0 +1,251 @@
+package tasmotahomekit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/discovery"
+	"github.com/kradalby/tasmota-homekit/plugs"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// Topics Tasmota announces itself on when SetOption19 is 0, after every
+// connect to the broker.
+const (
+	discoveryConfigFilter  = "tasmota/discovery/+/config"
+	discoverySensorsFilter = "tasmota/discovery/+/sensors"
+)
+
+// Inline subscription IDs for the discovery topics.
+const (
+	discoveryConfigSubID = iota + 1
+	discoverySensorsSubID
+)
+
+// discoveryScanTimeout bounds each address probe of a network sweep.
+const discoveryScanTimeout = 2 * time.Second
+
+// ErrScanDisabled is returned when a sweep is requested without a scan range.
+var ErrScanDisabled = errors.New("network scan is not configured")
+
+// Discovery collects Tasmota devices that are not in the plugs configuration
+// yet and adopts them into it on request.
+type Discovery struct {
+	registry     *discovery.Registry
+	scanner      *discovery.Scanner
+	scanPrefix   netip.Prefix // invalid when sweeping is disabled
+	configPath   string
+	plugProvider plugStateProvider
+	reloader     *PlugsReloader
+
+	ctx      context.Context
+	adoptMu  sync.Mutex
+	scanning atomic.Bool
+}
+
+// NewDiscovery creates a discovery service. Adopted devices are written to
+// configPath. scanPrefix may be the zero prefix to disable network sweeps.
+func NewDiscovery(configPath string, scanPrefix netip.Prefix, plugProvider plugStateProvider) *Discovery {
+	return &Discovery{
+		registry:     discovery.NewRegistry(),
+		scanner:      discovery.NewScanner(discoveryScanTimeout),
+		scanPrefix:   scanPrefix,
+		configPath:   configPath,
+		plugProvider: plugProvider,
+		ctx:          context.Background(),
+	}
+}
+
+// SetReloader applies adopted devices right away instead of waiting for the
+// reloader to notice the file change.
+func (d *Discovery) SetReloader(r *PlugsReloader) {
+	d.reloader = r
+}
+
+// Subscribe listens for discovery announcements through the broker's inline client.
+func (d *Discovery) Subscribe(server *mqtt.Server) error {
+	if err := server.Subscribe(discoveryConfigFilter, discoveryConfigSubID, func(_ *mqtt.Client, _ packets.Subscription, pk packets.Packet) {
+		d.HandleAnnouncement(pk.TopicName, pk.Payload)
+	}); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", discoveryConfigFilter, err)
+	}
+	if err := server.Subscribe(discoverySensorsFilter, discoverySensorsSubID, func(_ *mqtt.Client, _ packets.Subscription, pk packets.Packet) {
+		d.HandleAnnouncement(pk.TopicName, pk.Payload)
+	}); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", discoverySensorsFilter, err)
+	}
+	return nil
+}
+
+// HandleAnnouncement processes a message on a tasmota/discovery topic.
+func (d *Discovery) HandleAnnouncement(topic string, payload []byte) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 4 || parts[0] != "tasmota" || parts[1] != "discovery" {
+		return
+	}
+	mac := discovery.NormalizeMAC(parts[2])
+
+	switch parts[3] {
+	case "config":
+		// An empty retained message removes the announcement
+		if len(payload) == 0 {
+			d.registry.Forget(mac)
+			return
+		}
+		device, err := discovery.ParseAnnouncement(payload)
+		if err != nil {
+			slog.Debug("Ignoring discovery announcement", "topic", topic, "error", err)
+			return
+		}
+		slog.Debug("Tasmota device announced", "mac", device.DisplayMAC(), "ip", device.IP, "hostname", device.Hostname)
+		d.registry.Observe(device)
+	case "sensors":
+		energy, err := discovery.ParseSensors(payload)
+		if err != nil {
+			slog.Debug("Ignoring discovery sensors", "topic", topic, "error", err)
+			return
+		}
+		d.registry.SetEnergy(mac, energy)
+	}
+}
+
+// Start runs a sweep at startup and then every interval, when a scan range
+// is configured. A zero interval only sweeps at startup and on demand.
+func (d *Discovery) Start(ctx context.Context, interval time.Duration) {
+	d.ctx = ctx
+	if !d.ScanEnabled() {
+		return
+	}
+
+	go func() {
+		_ = d.StartScan()
+
+		if interval <= 0 {
+			return
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = d.StartScan()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// ScanEnabled reports whether a scan range is configured.
+func (d *Discovery) ScanEnabled() bool {
+	return d.scanPrefix.IsValid()
+}
+
+// Scanning reports whether a sweep is in progress.
+func (d *Discovery) Scanning() bool {
+	return d.scanning.Load()
+}
+
+// StartScan sweeps the configured range in the background.
+func (d *Discovery) StartScan() error {
+	if !d.ScanEnabled() {
+		return ErrScanDisabled
+	}
+	if !d.scanning.CompareAndSwap(false, true) {
+		return errors.New("a network scan is already running")
+	}
+
+	go func() {
+		defer d.scanning.Store(false)
+
+		slog.Info("Scanning network for Tasmota devices", "range", d.scanPrefix.String())
+		devices, err := d.scanner.Scan(d.ctx, d.scanPrefix)
+		if err != nil {
+			slog.Warn("Network scan failed", "range", d.scanPrefix.String(), "error", err)
+		}
+		for _, device := range devices {
+			d.registry.Observe(device)
+		}
+		slog.Info("Network scan complete", "range", d.scanPrefix.String(), "found", len(devices))
+	}()
+
+	return nil
+}
+
+// Unconfigured returns discovered devices whose address does not match any
+// configured plug.
+func (d *Discovery) Unconfigured() []discovery.Device {
+	configured := make(map[string]bool)
+	for _, item := range d.plugProvider.Snapshot() {
+		configured[strings.ToLower(item.Plug.Address)] = true
+	}
+
+	var devices []discovery.Device
+	for _, device := range d.registry.Devices() {
+		if configured[device.IP] || (device.Hostname != "" && configured[strings.ToLower(device.Hostname)]) {
+			continue
+		}
+		devices = append(devices, device)
+	}
+	return devices
+}
+
+// Adopt appends the discovered device to the plugs configuration file and
+// reloads it, so the plug shows up in HomeKit and the web UI right away. If
+// the reload fails, the file is restored and the device stays unconfigured.
+// The reload runs under the service context rather than a request's, as it
+// starts MQTT setup of the new plug in the background.
+func (d *Discovery) Adopt(mac string) (plugs.Plug, error) {
+	d.adoptMu.Lock()
+	defer d.adoptMu.Unlock()
+
+	device, ok := d.registry.Device(mac)
+	if !ok {
+		return plugs.Plug{}, fmt.Errorf("device %s not found", mac)
+	}
+
+	taken := make(map[string]bool)
+	for id, item := range d.plugProvider.Snapshot() {
+		taken[id] = true
+		if strings.EqualFold(item.Plug.Address, device.IP) {
+			return plugs.Plug{}, fmt.Errorf("device %s is already configured as %s", device.IP, id)
+		}
+	}
+
+	previous, err := os.ReadFile(d.configPath)
+	if err != nil {
+		return plugs.Plug{}, fmt.Errorf("failed to read plugs config file: %w", err)
+	}
+
+	plug := device.Plug(taken)
+	if err := plugs.AddPlugToConfig(d.configPath, plug); err != nil {
+		return plugs.Plug{}, err
+	}
+
+	if d.reloader != nil {
+		if err := d.reloader.Reload(d.ctx); err != nil {
+			// Put the file back so a failed adoption is not picked up by a
+			// later reload, and reload again to drop anything the failed
+			// attempt applied
+			if restoreErr := plugs.RestoreConfig(d.configPath, previous); restoreErr != nil {
+				return plugs.Plug{}, fmt.Errorf("plug %s written but reload failed: %w (restoring the file failed: %v)", plug.ID, err, restoreErr)
+			}
+			if reloadErr := d.reloader.Reload(d.ctx); reloadErr != nil {
+				slog.Warn("Failed to reload restored plugs configuration", "error", reloadErr)
+			}
+			return plugs.Plug{}, fmt.Errorf("plug %s not adopted, reload failed: %w", plug.ID, err)
+		}
+	}
+	slog.Info("Adopted discovered device", "plug_id", plug.ID, "address", plug.Address, "mac", device.DisplayMAC())
+
+	return plug, nil
+}
@@ -0,0 +1,268 @@
+// Package discovery finds Tasmota devices that are not configured yet, from
+// their MQTT discovery announcements or by probing a network range.
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+)
+
+// Sources a device can be discovered from.
+const (
+	SourceMQTT = "mqtt"
+	SourceScan = "scan"
+)
+
+// Tasmota light subtypes ("lt_st" in the discovery announcement).
+const (
+	lightNone = iota
+	lightDimmer
+	lightCT
+	lightRGB
+	lightRGBW
+	lightRGBCW
+)
+
+// Device is a Tasmota device seen on the network.
+type Device struct {
+	MAC          string    `json:"mac"` // upper case hex without separators
+	IP           string    `json:"ip"`
+	Hostname     string    `json:"hostname,omitempty"`
+	DeviceName   string    `json:"device_name,omitempty"`
+	FriendlyName []string  `json:"friendly_name,omitempty"`
+	Topic        string    `json:"topic,omitempty"`
+	Firmware     string    `json:"firmware,omitempty"`
+	Module       string    `json:"module,omitempty"`
+	Relays       int       `json:"relays"`
+	LightType    int       `json:"light_type,omitempty"`
+	Energy       bool      `json:"energy,omitempty"` // reports ENERGY readings
+	Source       string    `json:"source"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// DisplayMAC returns the MAC address in colon separated form.
+func (d Device) DisplayMAC() string {
+	if len(d.MAC) != 12 {
+		return d.MAC
+	}
+	parts := make([]string, 0, 6)
+	for i := 0; i < 12; i += 2 {
+		parts = append(parts, d.MAC[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+// Name returns the most descriptive name the device reported.
+func (d Device) Name() string {
+	for _, name := range []string{d.firstFriendlyName(), d.DeviceName, d.Hostname, d.IP} {
+		if name != "" {
+			return name
+		}
+	}
+	return d.MAC
+}
+
+func (d Device) firstFriendlyName() string {
+	if len(d.FriendlyName) > 0 {
+		return d.FriendlyName[0]
+	}
+	return ""
+}
+
+// NormalizeMAC strips separators and upper cases a MAC address.
+func NormalizeMAC(mac string) string {
+	mac = strings.ToUpper(mac)
+	return strings.NewReplacer(":", "", "-", "", ".", "").Replace(mac)
+}
+
+// announcement is the payload Tasmota publishes on
+// tasmota/discovery/<mac>/config when SetOption19 is 0.
+type announcement struct {
+	IP           string    `json:"ip"`
+	DeviceName   string    `json:"dn"`
+	FriendlyName []*string `json:"fn"`
+	Hostname     string    `json:"hn"`
+	MAC          string    `json:"mac"`
+	Module       string    `json:"md"`
+	Firmware     string    `json:"sw"`
+	Topic        string    `json:"t"`
+	Relays       []int     `json:"rl"`
+	LightType    int       `json:"lt_st"`
+}
+
+// ParseAnnouncement parses a Tasmota MQTT discovery config payload.
+func ParseAnnouncement(payload []byte) (Device, error) {
+	var a announcement
+	if err := json.Unmarshal(payload, &a); err != nil {
+		return Device{}, fmt.Errorf("failed to parse discovery payload: %w", err)
+	}
+	if a.MAC == "" || a.IP == "" {
+		return Device{}, fmt.Errorf("discovery payload is missing mac or ip")
+	}
+
+	var names []string
+	for _, name := range a.FriendlyName {
+		if name != nil && *name != "" {
+			names = append(names, *name)
+		}
+	}
+
+	// rl lists the type of each output: 1 is a relay, 2 a light, 3 a shutter
+	relays := 0
+	for _, kind := range a.Relays {
+		if kind == 1 {
+			relays++
+		}
+	}
+
+	return Device{
+		MAC:          NormalizeMAC(a.MAC),
+		IP:           a.IP,
+		Hostname:     a.Hostname,
+		DeviceName:   a.DeviceName,
+		FriendlyName: names,
+		Topic:        a.Topic,
+		Firmware:     a.Firmware,
+		Module:       a.Module,
+		Relays:       relays,
+		LightType:    a.LightType,
+		Source:       SourceMQTT,
+		LastSeen:     time.Now(),
+	}, nil
+}
+
+// ParseSensors reports whether a tasmota/discovery/<mac>/sensors payload
+// includes energy readings.
+func ParseSensors(payload []byte) (bool, error) {
+	var sensors struct {
+		Sensors map[string]any `json:"sn"`
+	}
+	if err := json.Unmarshal(payload, &sensors); err != nil {
+		return false, fmt.Errorf("failed to parse discovery sensors payload: %w", err)
+	}
+	return sensors.Sensors["ENERGY"] != nil, nil
+}
+
+// status is the subset of the Status 0 response used for discovery.
+type status struct {
+	Status struct {
+		Module       int      `json:"Module"`
+		DeviceName   string   `json:"DeviceName"`
+		FriendlyName []string `json:"FriendlyName"`
+		Topic        string   `json:"Topic"`
+	} `json:"Status"`
+	StatusFWR struct {
+		Version string `json:"Version"`
+	} `json:"StatusFWR"`
+	StatusNET struct {
+		Hostname  string `json:"Hostname"`
+		IPAddress string `json:"IPAddress"`
+		Mac       string `json:"Mac"`
+	} `json:"StatusNET"`
+	StatusSTS map[string]any `json:"StatusSTS"`
+	StatusSNS map[string]any `json:"StatusSNS"`
+}
+
+// ParseStatus parses a Tasmota Status 0 response into a Device.
+func ParseStatus(payload []byte) (Device, error) {
+	var s status
+	if err := json.Unmarshal(payload, &s); err != nil {
+		return Device{}, fmt.Errorf("failed to parse status: %w", err)
+	}
+	if s.StatusNET.Mac == "" {
+		return Device{}, fmt.Errorf("status response is missing StatusNET.Mac")
+	}
+
+	lightType := lightNone
+	if _, ok := s.StatusSTS["Dimmer"]; ok {
+		lightType = lightDimmer
+		_, hasColor := s.StatusSTS["HSBColor"]
+		_, hasCT := s.StatusSTS["CT"]
+		switch {
+		case hasColor && hasCT:
+			lightType = lightRGBCW
+		case hasColor:
+			lightType = lightRGB
+		case hasCT:
+			lightType = lightCT
+		}
+	}
+
+	return Device{
+		MAC:          NormalizeMAC(s.StatusNET.Mac),
+		IP:           s.StatusNET.IPAddress,
+		Hostname:     s.StatusNET.Hostname,
+		DeviceName:   s.Status.DeviceName,
+		FriendlyName: s.Status.FriendlyName,
+		Topic:        s.Status.Topic,
+		Firmware:     s.StatusFWR.Version,
+		Module:       strconv.Itoa(s.Status.Module),
+		Relays:       len(plugs.ParsePowerStates(s.StatusSTS)),
+		LightType:    lightType,
+		Energy:       s.StatusSNS["ENERGY"] != nil,
+		Source:       SourceScan,
+		LastSeen:     time.Now(),
+	}, nil
+}
+
+// Plug returns a plug configuration for the device. The ID is derived from
+// the hostname and made unique against taken.
+func (d Device) Plug(taken map[string]bool) plugs.Plug {
+	base := slug(d.Hostname)
+	if base == "" {
+		base = "tasmota-" + strings.ToLower(d.MAC[max(0, len(d.MAC)-6):])
+	}
+	id := base
+	for i := 2; taken[id]; i++ {
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+
+	plug := plugs.Plug{
+		ID:      id,
+		Name:    d.Name(),
+		Address: d.IP,
+	}
+
+	if d.Relays > 1 {
+		plug.Relays = make([]plugs.Relay, d.Relays)
+		for i := range plug.Relays {
+			if i < len(d.FriendlyName) && d.FriendlyName[i] != plug.Name {
+				plug.Relays[i].Name = d.FriendlyName[i]
+			}
+		}
+	}
+
+	if d.Energy {
+		plug.Features = &plugs.PlugFeatures{PowerMonitoring: true, EnergyTracking: true}
+	}
+
+	if d.LightType != lightNone {
+		plug.Type = "bulb"
+		plug.Features = &plugs.PlugFeatures{
+			Dimmer:           true,
+			Color:            d.LightType >= lightRGB,
+			ColorTemperature: d.LightType == lightCT || d.LightType == lightRGBCW,
+		}
+	}
+
+	return plug
+}
+
+// slug lower cases s and keeps letters, digits and dashes.
+func slug(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '-' || r == '_' || r == '.' || r == ' ':
+			b.WriteRune('-')
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
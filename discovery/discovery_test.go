@@ -0,0 +1,105 @@
+package discovery
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const announcementPayload = `{"ip":"192.168.1.50","dn":"Desk Strip","fn":["Monitor","Lamp",null,null],` +
+	`"hn":"tasmota-ABCDEF-1234","mac":"A4CF12ABCDEF","md":"Sonoff Dual R2","ty":0,"sw":"13.1.0",` +
+	`"t":"tasmota_ABCDEF","rl":[1,1,0,0,0,0,0,0],"lt_st":0,"ver":1}`
+
+const statusPayload = `{"Status":{"Module":18,"DeviceName":"Hallway","FriendlyName":["Hallway"],"Topic":"tasmota_123456"},` +
+	`"StatusFWR":{"Version":"13.1.0(tasmota)"},` +
+	`"StatusNET":{"Hostname":"hallway-bulb","IPAddress":"192.168.1.60","Mac":"a4:cf:12:12:34:56"},` +
+	`"StatusSTS":{"POWER":"ON","Dimmer":40,"HSBColor":"0,0,40","CT":300}}`
+
+func TestParseAnnouncement(t *testing.T) {
+	device, err := ParseAnnouncement([]byte(announcementPayload))
+	require.NoError(t, err)
+	require.Equal(t, "A4CF12ABCDEF", device.MAC)
+	require.Equal(t, "A4:CF:12:AB:CD:EF", device.DisplayMAC())
+	require.Equal(t, "192.168.1.50", device.IP)
+	require.Equal(t, 2, device.Relays)
+	require.Equal(t, "Monitor", device.Name())
+	require.Equal(t, SourceMQTT, device.Source)
+
+	plug := device.Plug(map[string]bool{"tasmota-abcdef-1234": true})
+	require.Equal(t, "tasmota-abcdef-1234-2", plug.ID)
+	require.Equal(t, "192.168.1.50", plug.Address)
+	require.Len(t, plug.Relays, 2)
+	require.Equal(t, "Lamp", plug.Relays[1].Name)
+}
+
+func TestParseAnnouncementRequiresAddress(t *testing.T) {
+	_, err := ParseAnnouncement([]byte(`{"dn":"Tasmota"}`))
+	require.Error(t, err)
+}
+
+func TestParseStatusBulb(t *testing.T) {
+	device, err := ParseStatus([]byte(statusPayload))
+	require.NoError(t, err)
+	require.Equal(t, "A4CF12123456", device.MAC)
+	require.Equal(t, "18", device.Module)
+	require.Equal(t, 1, device.Relays)
+
+	plug := device.Plug(nil)
+	require.Equal(t, "hallway-bulb", plug.ID)
+	require.Equal(t, "bulb", plug.Type)
+	require.True(t, plug.HasColor())
+	require.True(t, plug.HasColorTemperature())
+}
+
+func TestRegistryKeepsEnergy(t *testing.T) {
+	r := NewRegistry()
+	device, err := ParseAnnouncement([]byte(announcementPayload))
+	require.NoError(t, err)
+
+	r.Observe(device)
+	r.SetEnergy(device.MAC, true)
+	r.Observe(device)
+
+	got, ok := r.Device("a4:cf:12:ab:cd:ef")
+	require.True(t, ok)
+	require.True(t, got.Energy)
+
+	r.Forget(device.MAC)
+	require.Empty(t, r.Devices())
+}
+
+func TestScannerFindsDevice(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cm" || r.URL.Query().Get("cmnd") != "Status 0" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(statusPayload))
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+
+	scanner := NewScanner(time.Second)
+	scanner.Port = port
+
+	devices, err := scanner.Scan(t.Context(), netip.MustParsePrefix("127.0.0.1/32"))
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	require.Equal(t, SourceScan, devices[0].Source)
+}
+
+func TestValidatePrefix(t *testing.T) {
+	require.NoError(t, ValidatePrefix(netip.MustParsePrefix("192.168.1.0/24")))
+	require.Error(t, ValidatePrefix(netip.MustParsePrefix("10.0.0.0/8")))
+	require.Error(t, ValidatePrefix(netip.MustParsePrefix("fd00::/120")))
+}
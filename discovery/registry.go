@@ -0,0 +1,78 @@
+package discovery
+
+import (
+	"net/netip"
+	"sort"
+	"sync"
+)
+
+// Registry holds the devices discovered so far, keyed by MAC address.
+type Registry struct {
+	mu      sync.RWMutex
+	devices map[string]Device
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{devices: make(map[string]Device)}
+}
+
+// Observe records a device, replacing earlier information about the same MAC.
+// Energy support is kept, as it is announced separately from the device.
+func (r *Registry) Observe(device Device) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.devices[device.MAC]; ok {
+		device.Energy = device.Energy || existing.Energy
+	}
+	r.devices[device.MAC] = device
+}
+
+// SetEnergy marks a known device as reporting energy readings.
+func (r *Registry) SetEnergy(mac string, energy bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if device, ok := r.devices[mac]; ok {
+		device.Energy = energy
+		r.devices[mac] = device
+	}
+}
+
+// Forget removes a device, e.g. when its retained announcement is cleared.
+func (r *Registry) Forget(mac string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.devices, mac)
+}
+
+// Device returns the device with the given MAC address.
+func (r *Registry) Device(mac string) (Device, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	device, ok := r.devices[NormalizeMAC(mac)]
+	return device, ok
+}
+
+// Devices returns all devices sorted by IP address.
+func (r *Registry) Devices() []Device {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	devices := make([]Device, 0, len(r.devices))
+	for _, device := range r.devices {
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		a, errA := netip.ParseAddr(devices[i].IP)
+		b, errB := netip.ParseAddr(devices[j].IP)
+		if errA == nil && errB == nil && a != b {
+			return a.Less(b)
+		}
+		return devices[i].MAC < devices[j].MAC
+	})
+	return devices
+}
@@ -0,0 +1,137 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// MinScanPrefixBits limits sweeps to at most 65536 addresses.
+const MinScanPrefixBits = 16
+
+// Scanner probes every address of a network range with a Tasmota Status 0
+// request over HTTP.
+type Scanner struct {
+	Client      *http.Client
+	Port        int // HTTP port, defaults to 80
+	Concurrency int // parallel probes, defaults to 32
+}
+
+// NewScanner returns a scanner with a per-address timeout.
+func NewScanner(timeout time.Duration) *Scanner {
+	return &Scanner{
+		Client:      &http.Client{Timeout: timeout},
+		Port:        80,
+		Concurrency: 32,
+	}
+}
+
+// ValidatePrefix checks that prefix is an IPv4 range small enough to sweep.
+func ValidatePrefix(prefix netip.Prefix) error {
+	if !prefix.Addr().Is4() {
+		return fmt.Errorf("scan range %s must be IPv4", prefix)
+	}
+	if prefix.Bits() < MinScanPrefixBits {
+		return fmt.Errorf("scan range %s is too large, use /%d or smaller", prefix, MinScanPrefixBits)
+	}
+	return nil
+}
+
+// Scan probes all addresses in prefix and returns the Tasmota devices that
+// answered. Addresses that do not answer or are not Tasmota are skipped.
+func (s *Scanner) Scan(ctx context.Context, prefix netip.Prefix) ([]Device, error) {
+	if err := ValidatePrefix(prefix); err != nil {
+		return nil, err
+	}
+	prefix = prefix.Masked()
+
+	concurrency := s.Concurrency
+	if concurrency <= 0 {
+		concurrency = 32
+	}
+
+	addrs := make(chan netip.Addr)
+	var (
+		mu      sync.Mutex
+		devices []Device
+		wg      sync.WaitGroup
+	)
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for addr := range addrs {
+				device, err := s.Probe(ctx, addr)
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				devices = append(devices, device)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+		if ctx.Err() != nil {
+			break
+		}
+		addrs <- addr
+	}
+	close(addrs)
+	wg.Wait()
+
+	return devices, ctx.Err()
+}
+
+// Probe asks a single address for its Tasmota status.
+func (s *Scanner) Probe(ctx context.Context, addr netip.Addr) (Device, error) {
+	port := s.Port
+	if port == 0 {
+		port = 80
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	target := url.URL{
+		Scheme:   "http",
+		Host:     netip.AddrPortFrom(addr, uint16(port)).String(),
+		Path:     "/cm",
+		RawQuery: url.Values{"cmnd": []string{"Status 0"}}.Encode(),
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return Device{}, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Device{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Device{}, fmt.Errorf("%s answered with status %d", addr, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return Device{}, err
+	}
+
+	device, err := ParseStatus(body)
+	if err != nil {
+		return Device{}, err
+	}
+	if device.IP == "" {
+		device.IP = addr.String()
+	}
+	return device, nil
+}
@@ -0,0 +1,82 @@
+package tasmotahomekit
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscoveryAdoptsAnnouncedDevice(t *testing.T) {
+	r, path, hm, _ := newTestReloader(t)
+	d := NewDiscovery(path, netip.Prefix{}, r.plugManager)
+	d.SetReloader(r)
+
+	// Already configured by address, so it is not offered
+	d.HandleAnnouncement("tasmota/discovery/A4CF12000001/config", []byte(
+		`{"ip":"192.168.1.10","hn":"desk","mac":"A4CF12000001","rl":[1]}`,
+	))
+	d.HandleAnnouncement("tasmota/discovery/A4CF12000002/config", []byte(
+		`{"ip":"192.168.1.20","hn":"heater","fn":["Heater"],"mac":"A4CF12000002","rl":[1]}`,
+	))
+	d.HandleAnnouncement("tasmota/discovery/A4CF12000002/sensors", []byte(
+		`{"sn":{"ENERGY":{"Power":0}},"ver":1}`,
+	))
+
+	devices := d.Unconfigured()
+	require.Len(t, devices, 1)
+	require.Equal(t, "A4CF12000002", devices[0].MAC)
+	require.True(t, devices[0].Energy)
+
+	plug, err := d.Adopt("a4:cf:12:00:00:02")
+	require.NoError(t, err)
+	require.Equal(t, "heater", plug.ID)
+	require.True(t, plug.HasPowerMonitoring())
+
+	require.Empty(t, d.Unconfigured())
+	require.Len(t, hm.GetAccessories(), 3)
+
+	_, err = d.Adopt("A4CF12000002")
+	require.Error(t, err)
+}
+
+func TestDiscoveryAdoptRestoresConfigOnReloadFailure(t *testing.T) {
+	r, path, hm, _ := newTestReloader(t)
+	d := NewDiscovery(path, netip.Prefix{}, r.plugManager)
+	d.SetReloader(r)
+
+	before, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	// A missing secrets file makes every reload fail
+	r.secretsPath = filepath.Join(t.TempDir(), "missing.hujson")
+
+	d.HandleAnnouncement("tasmota/discovery/A4CF12000002/config", []byte(
+		`{"ip":"192.168.1.20","hn":"heater","mac":"A4CF12000002","rl":[1]}`,
+	))
+
+	_, err = d.Adopt("A4CF12000002")
+	require.ErrorContains(t, err, "not adopted")
+
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, string(before), string(after))
+	require.Len(t, d.Unconfigured(), 1)
+	require.Len(t, hm.GetAccessories(), 2)
+}
+
+func TestDiscoveryForgetsClearedAnnouncement(t *testing.T) {
+	r, path, _, _ := newTestReloader(t)
+	d := NewDiscovery(path, netip.Prefix{}, r.plugManager)
+
+	d.HandleAnnouncement("tasmota/discovery/A4CF12000002/config", []byte(
+		`{"ip":"192.168.1.20","mac":"A4CF12000002","rl":[1]}`,
+	))
+	require.Len(t, d.Unconfigured(), 1)
+
+	d.HandleAnnouncement("tasmota/discovery/A4CF12000002/config", nil)
+	require.Empty(t, d.Unconfigured())
+	require.ErrorIs(t, d.StartScan(), ErrScanDisabled)
+}
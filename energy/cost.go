@@ -0,0 +1,130 @@
+package energy
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+)
+
+// maxPricedGap bounds how far back consumption is spread over tariff
+// windows. Consumption reported after a longer gap is priced at the rate
+// of the reading's time.
+const maxPricedGap = 48 * time.Hour
+
+// windowUsage is consumption attributed to a tariff window. Without a
+// tariff, window is empty and cost zero.
+type windowUsage struct {
+	window string
+	energy float64
+	cost   float64
+}
+
+// priceConsumption spreads used evenly over the time from the previous
+// reading to at and prices each minute at the tariff rate that applies.
+func priceConsumption(tariff *plugs.Tariff, from, at time.Time, used float64) []windowUsage {
+	if tariff == nil {
+		return []windowUsage{{energy: used}}
+	}
+
+	elapsed := at.Sub(from)
+	if elapsed <= 0 || elapsed > maxPricedGap {
+		window, rate := tariff.RateAt(at)
+		return []windowUsage{{window: window, energy: used, cost: used * rate}}
+	}
+
+	var usages []windowUsage
+	for t := from; t.Before(at); {
+		next := t.Truncate(time.Minute).Add(time.Minute)
+		if next.After(at) {
+			next = at
+		}
+
+		window, rate := tariff.RateAt(t)
+		part := used * float64(next.Sub(t)) / float64(elapsed)
+		i := slices.IndexFunc(usages, func(u windowUsage) bool { return u.window == window })
+		if i < 0 {
+			usages = append(usages, windowUsage{window: window})
+			i = len(usages) - 1
+		}
+		usages[i].energy += part
+		usages[i].cost += part * rate
+
+		t = next
+	}
+	return usages
+}
+
+// BillingPeriod returns the billing period of the current tariff that
+// contains at, calendar months without a tariff.
+func (h *History) BillingPeriod(at time.Time) (start, end time.Time) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.tariff.BillingPeriod(at)
+}
+
+// WriteCSV writes the daily consumption and cost of every plug from start
+// up to end, one row per plug, day and tariff window. Days without
+// consumption or outside the retained daily history are left out.
+func (h *History) WriteCSV(w io.Writer, start, end time.Time) error {
+	h.mu.RLock()
+	currency := ""
+	if h.tariff != nil {
+		currency = h.tariff.Currency
+	}
+	series := make(map[string]Series, len(h.series))
+	for id, s := range h.series {
+		series[id] = s.clone()
+	}
+	h.mu.RUnlock()
+
+	out := csv.NewWriter(w)
+	if err := out.Write([]string{"date", "plug_id", "window", "energy_kwh", "cost", "currency"}); err != nil {
+		return err
+	}
+
+	formatFloat := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', 4, 64)
+	}
+	for _, id := range slices.Sorted(maps.Keys(series)) {
+		for _, day := range series[id].Daily {
+			if day.Energy == 0 || day.Start.Before(start) || !day.Start.Before(end) {
+				continue
+			}
+			date := day.Start.Format(time.DateOnly)
+
+			if len(day.Windows) == 0 {
+				if err := out.Write([]string{date, id, "", formatFloat(day.Energy), formatFloat(day.Cost), currency}); err != nil {
+					return err
+				}
+				continue
+			}
+
+			// Consumption recorded before the tariff was set has no window
+			priced := 0.0
+			for _, window := range slices.Sorted(maps.Keys(day.Windows)) {
+				usage := day.Windows[window]
+				priced += usage.Energy
+				if err := out.Write([]string{date, id, window, formatFloat(usage.Energy), formatFloat(usage.Cost), currency}); err != nil {
+					return err
+				}
+			}
+			if unpriced := day.Energy - priced; unpriced > 1e-9 {
+				if err := out.Write([]string{date, id, "", formatFloat(unpriced), formatFloat(0), currency}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	out.Flush()
+	if err := out.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package energy
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/util/eventbus"
+)
+
+var testTariff = &plugs.Tariff{
+	Currency: "EUR",
+	Rate:     0.30,
+	Windows:  []plugs.TariffWindow{{Name: "night", Start: "23:00", End: "06:00", Rate: 0.10}},
+}
+
+func TestRecordPricesAcrossWindows(t *testing.T) {
+	h := New(filepath.Join(t.TempDir(), "energy.json"))
+	h.SetTariff(testTariff)
+
+	// start is 22:10; the next reading at 23:40 splits 0.9 kWh into
+	// 50 standard minutes and 40 night minutes
+	h.Record("desk", reading(0, 0, 10.0))
+	h.Record("desk", reading(90*time.Minute, 0, 10.9))
+
+	s, _ := h.Series("desk")
+	day := s.Day(start)
+	require.InDelta(t, 0.9, day.Energy, 1e-9)
+	require.InDelta(t, 0.5, day.Windows[plugs.StandardWindow].Energy, 1e-9)
+	require.InDelta(t, 0.4, day.Windows["night"].Energy, 1e-9)
+	require.InDelta(t, 0.5*0.30+0.4*0.10, day.Cost, 1e-9)
+	require.Equal(t, "EUR", h.Currency())
+
+	// Readings without a tariff only count energy
+	h.SetTariff(nil)
+	h.Record("desk", reading(100*time.Minute, 0, 11.0))
+	s, _ = h.Series("desk")
+	require.InDelta(t, 1.0, s.Day(start).Energy, 1e-9)
+	require.InDelta(t, 0.19, s.Day(start).Cost, 1e-9)
+}
+
+func TestRecordPublishesCosts(t *testing.T) {
+	bus, err := events.New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = bus.Close() })
+
+	client, err := bus.Client(events.ClientMetrics)
+	require.NoError(t, err)
+	sub := eventbus.Subscribe[events.CostEvent](client)
+	t.Cleanup(sub.Close)
+
+	h := New(filepath.Join(t.TempDir(), "energy.json"))
+	require.NoError(t, h.SetEventBus(bus))
+	h.SetTariff(testTariff)
+
+	h.Record("desk", reading(0, 0, 10.0))
+	h.Record("desk", reading(10*time.Minute, 0, 10.5))
+
+	select {
+	case evt := <-sub.Events():
+		require.Equal(t, "desk", evt.PlugID)
+		require.Equal(t, plugs.StandardWindow, evt.Window)
+		require.Equal(t, "EUR", evt.Currency)
+		require.InDelta(t, 0.15, evt.Cost, 1e-9)
+	case <-time.After(time.Second):
+		t.Fatal("no cost event published")
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	h := New(filepath.Join(t.TempDir(), "energy.json"))
+	h.SetTariff(testTariff)
+
+	h.Record("desk", reading(0, 0, 10.0))
+	h.Record("desk", reading(90*time.Minute, 0, 10.9))
+	h.Record("lamp", reading(0, 0, 1.0))
+	h.Record("lamp", reading(3*time.Hour, 0, 1.1))
+
+	var buf bytes.Buffer
+	periodStart, periodEnd := h.BillingPeriod(start)
+	require.NoError(t, h.WriteCSV(&buf, periodStart, periodEnd))
+	require.Equal(t, `date,plug_id,window,energy_kwh,cost,currency
+2025-03-31,desk,night,0.4000,0.0400,EUR
+2025-03-31,desk,standard,0.5000,0.1500,EUR
+`, buf.String())
+
+	// The lamp's reading falls in April
+	buf.Reset()
+	periodStart, periodEnd = h.BillingPeriod(start.Add(3 * time.Hour))
+	require.NoError(t, h.WriteCSV(&buf, periodStart, periodEnd))
+	require.Contains(t, buf.String(), "2025-04-01,lamp,night,")
+	require.NotContains(t, buf.String(), "desk")
+}
@@ -0,0 +1,453 @@
+// Package energy keeps a per-plug history of energy readings with hourly,
+// daily, weekly and monthly rollups.
+package energy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/kradalby/tasmota-homekit/store"
+	"tailscale.com/util/eventbus"
+)
+
+// Retention of each resolution.
+const (
+	maxHourly  = 48
+	maxDaily   = 62
+	maxMonthly = 24
+
+	// Raw power samples are kept for a day at one per minute
+	maxSamples     = 24 * 60
+	sampleInterval = time.Minute
+)
+
+// Bucket is the consumption of a plug in one hour, day or month.
+type Bucket struct {
+	Start    time.Time `json:"start"`
+	Energy   float64   `json:"energy"`    // kWh
+	AvgPower float64   `json:"avg_power"` // W
+	MaxPower float64   `json:"max_power"` // W
+	Samples  int       `json:"samples"`
+
+	// Cost of Energy in the tariff currency, and both split by tariff
+	// window. Only set when a tariff is configured.
+	Cost    float64          `json:"cost,omitempty"`
+	Windows map[string]Usage `json:"windows,omitempty"`
+}
+
+// Usage is the consumption within one tariff window.
+type Usage struct {
+	Energy float64 `json:"energy"` // kWh
+	Cost   float64 `json:"cost"`
+}
+
+// Sample is a single power reading.
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Power float64   `json:"power"` // W
+}
+
+// Series is the energy history of one plug.
+type Series struct {
+	Samples []Sample `json:"samples"`
+	Hourly  []Bucket `json:"hourly"`
+	Daily   []Bucket `json:"daily"`
+	Monthly []Bucket `json:"monthly"`
+
+	// Counters of the last reading, as reported by the device
+	LastReading time.Time `json:"last_reading"`
+	Total       float64   `json:"total"`     // kWh
+	Today       float64   `json:"today"`     // kWh
+	Yesterday   float64   `json:"yesterday"` // kWh
+
+	// Resets counts the times the device's Total went backwards
+	Resets int `json:"resets"`
+}
+
+// clone returns a copy of s that shares no slices with it.
+func (s *Series) clone() Series {
+	c := *s
+	c.Samples = append([]Sample(nil), s.Samples...)
+	c.Hourly = cloneBuckets(s.Hourly)
+	c.Daily = cloneBuckets(s.Daily)
+	c.Monthly = cloneBuckets(s.Monthly)
+	return c
+}
+
+func cloneBuckets(buckets []Bucket) []Bucket {
+	c := append([]Bucket(nil), buckets...)
+	for i := range c {
+		c[i].Windows = maps.Clone(c[i].Windows)
+	}
+	return c
+}
+
+// LastHours returns the hourly buckets of the n hours up to and including
+// the one containing end, with empty buckets for hours without readings.
+func (s Series) LastHours(end time.Time, n int) []Bucket {
+	byStart := make(map[int64]Bucket, len(s.Hourly))
+	for _, bucket := range s.Hourly {
+		byStart[bucket.Start.Unix()] = bucket
+	}
+
+	last := hourStart(end)
+	buckets := make([]Bucket, n)
+	for i := range buckets {
+		start := last.Add(-time.Duration(n-1-i) * time.Hour)
+		bucket, ok := byStart[start.Unix()]
+		if !ok {
+			bucket = Bucket{Start: start}
+		}
+		buckets[i] = bucket
+	}
+	return buckets
+}
+
+// Day returns the bucket of the day containing t.
+func (s Series) Day(t time.Time) Bucket {
+	return findBucket(s.Daily, dayStart(t))
+}
+
+// Month returns the bucket of the month containing t.
+func (s Series) Month(t time.Time) Bucket {
+	return findBucket(s.Monthly, monthStart(t))
+}
+
+// Weekly rolls the daily buckets up into ISO weeks, starting on Monday.
+// The oldest week is partial once daily buckets start to expire.
+func (s Series) Weekly() []Bucket {
+	var weeks []Bucket
+	for _, day := range s.Daily {
+		start := weekStart(day.Start)
+		if n := len(weeks); n == 0 || !weeks[n-1].Start.Equal(start) {
+			weeks = append(weeks, Bucket{Start: start})
+		}
+		mergeBucket(&weeks[len(weeks)-1], day)
+	}
+	return weeks
+}
+
+// mergeBucket adds the consumption and power readings of b to into.
+func mergeBucket(into *Bucket, b Bucket) {
+	into.Energy += b.Energy
+	into.Cost += b.Cost
+	for window, usage := range b.Windows {
+		if into.Windows == nil {
+			into.Windows = make(map[string]Usage)
+		}
+		sum := into.Windows[window]
+		sum.Energy += usage.Energy
+		sum.Cost += usage.Cost
+		into.Windows[window] = sum
+	}
+	if samples := into.Samples + b.Samples; samples > 0 {
+		into.AvgPower = (into.AvgPower*float64(into.Samples) + b.AvgPower*float64(b.Samples)) / float64(samples)
+		into.Samples = samples
+	}
+	into.MaxPower = max(into.MaxPower, b.MaxPower)
+}
+
+func findBucket(buckets []Bucket, start time.Time) Bucket {
+	for _, bucket := range buckets {
+		if bucket.Start.Equal(start) {
+			return bucket
+		}
+	}
+	return Bucket{Start: start}
+}
+
+// History records energy readings of all plugs and keeps them in a JSON
+// file. It implements plugs.EnergyRecorder.
+type History struct {
+	path string
+
+	mu     sync.RWMutex
+	series map[string]*Series
+	tariff *plugs.Tariff
+	dirty  bool
+
+	// Cost events for the metrics collector, see SetEventBus
+	eventBus *events.Bus
+	client   *eventbus.Client
+}
+
+var _ plugs.EnergyRecorder = (*History)(nil)
+
+// New returns an empty history stored at path. Call Load to read earlier
+// readings.
+func New(path string) *History {
+	return &History{
+		path:   path,
+		series: make(map[string]*Series),
+	}
+}
+
+// Load reads the history file. A missing file leaves the history empty.
+func (h *History) Load() error {
+	data, err := os.ReadFile(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read energy history: %w", err)
+	}
+
+	var series map[string]*Series
+	if err := json.Unmarshal(data, &series); err != nil {
+		return fmt.Errorf("failed to parse energy history %s: %w", h.path, err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, s := range series {
+		if s != nil {
+			h.series[id] = s
+		}
+	}
+	return nil
+}
+
+// Save writes the history file if anything was recorded since the last save.
+func (h *History) Save() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(h.series)
+	if err != nil {
+		return fmt.Errorf("failed to marshal energy history: %w", err)
+	}
+	if err := store.WriteFile(h.path, data); err != nil {
+		return err
+	}
+	h.dirty = false
+	return nil
+}
+
+// Run saves the history every interval until ctx is done. A zero interval
+// disables periodic saves.
+func (h *History) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := h.Save(); err != nil {
+				slog.Warn("Failed to save energy history", "path", h.path, "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SetTariff sets the tariff that prices readings from now on; nil stops
+// pricing. Earlier costs are kept.
+func (h *History) SetTariff(tariff *plugs.Tariff) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tariff = tariff
+}
+
+// Currency returns the currency of the current tariff, empty without one.
+func (h *History) Currency() string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.tariff == nil {
+		return ""
+	}
+	return h.tariff.Currency
+}
+
+// SetEventBus publishes an events.CostEvent for every priced reading.
+func (h *History) SetEventBus(bus *events.Bus) error {
+	client, err := bus.Client(events.ClientEnergy)
+	if err != nil {
+		return fmt.Errorf("failed to get energy eventbus client: %w", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.eventBus = bus
+	h.client = client
+	return nil
+}
+
+// Series returns the history of a plug.
+func (h *History) Series(plugID string) (Series, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	s, ok := h.series[plugID]
+	if !ok {
+		return Series{}, false
+	}
+	return s.clone(), true
+}
+
+// Record adds a reading to the plug's history. Consumption is the increase
+// of the device's Total since the previous reading and is attributed to the
+// hour, day and month of the new reading. With a tariff, it is priced as if
+// spread evenly since the previous reading.
+func (h *History) Record(plugID string, reading plugs.EnergyReading) {
+	costs := h.record(plugID, reading)
+
+	for _, cost := range costs {
+		h.eventBus.PublishCost(h.client, cost)
+	}
+}
+
+// record updates the series and returns the cost events to publish.
+func (h *History) record(plugID string, reading plugs.EnergyReading) []events.CostEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[plugID]
+	if !ok {
+		s = &Series{}
+		h.series[plugID] = s
+	}
+
+	at := reading.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+	// Status polls and telemetry can arrive out of order
+	if at.Before(s.LastReading) {
+		return nil
+	}
+
+	var usages []windowUsage
+	if !s.LastReading.IsZero() {
+		used := reading.Total - s.Total
+		if used < 0 {
+			s.Resets++
+			used = resetConsumption(s.Total, reading.Total)
+			slog.Info(
+				"Energy counter reset detected",
+				"plug_id", plugID,
+				"previous_total", s.Total,
+				"total", reading.Total,
+			)
+		}
+		if used > 0 {
+			usages = priceConsumption(h.tariff, s.LastReading, at, used)
+		}
+	}
+
+	s.Hourly = addToBucket(s.Hourly, hourStart(at), reading.Power, usages, maxHourly)
+	s.Daily = addToBucket(s.Daily, dayStart(at), reading.Power, usages, maxDaily)
+	s.Monthly = addToBucket(s.Monthly, monthStart(at), reading.Power, usages, maxMonthly)
+
+	if len(s.Samples) == 0 || at.Sub(s.Samples[len(s.Samples)-1].Time) >= sampleInterval {
+		s.Samples = append(s.Samples, Sample{Time: at, Power: reading.Power})
+		if len(s.Samples) > maxSamples {
+			s.Samples = s.Samples[len(s.Samples)-maxSamples:]
+		}
+	}
+
+	s.LastReading = at
+	s.Total = reading.Total
+	s.Today = reading.Today
+	s.Yesterday = reading.Yesterday
+	h.dirty = true
+
+	if h.eventBus == nil || h.tariff == nil {
+		return nil
+	}
+	costs := make([]events.CostEvent, 0, len(usages))
+	for _, usage := range usages {
+		costs = append(costs, events.CostEvent{
+			Timestamp: at,
+			PlugID:    plugID,
+			Window:    usage.window,
+			Currency:  h.tariff.Currency,
+			Energy:    usage.energy,
+			Cost:      usage.cost,
+		})
+	}
+	return costs
+}
+
+// resetConsumption returns the consumption to count when a device's Total
+// drops from previous to total. EnergyReset zeroes the counter, so whatever
+// it shows now was used since. A reboot instead restores the last total
+// saved to flash, slightly below the previous reading; that is counted as
+// nothing rather than as the whole total.
+func resetConsumption(previous, total float64) float64 {
+	if total < previous-total {
+		return total
+	}
+	return 0
+}
+
+// addToBucket adds a reading to the bucket starting at start, appending a
+// new bucket when start is past the last one, and keeps at most limit.
+func addToBucket(buckets []Bucket, start time.Time, power float64, usages []windowUsage, limit int) []Bucket {
+	if n := len(buckets); n == 0 || buckets[n-1].Start.Before(start) {
+		buckets = append(buckets, Bucket{Start: start})
+		if len(buckets) > limit {
+			buckets = buckets[len(buckets)-limit:]
+		}
+	}
+
+	bucket := &buckets[len(buckets)-1]
+	if !bucket.Start.Equal(start) {
+		// Older than the newest bucket, which Record does not allow
+		return buckets
+	}
+	for _, usage := range usages {
+		bucket.Energy += usage.energy
+		if usage.window == "" {
+			continue
+		}
+		bucket.Cost += usage.cost
+		if bucket.Windows == nil {
+			bucket.Windows = make(map[string]Usage)
+		}
+		window := bucket.Windows[usage.window]
+		window.Energy += usage.energy
+		window.Cost += usage.cost
+		bucket.Windows[usage.window] = window
+	}
+	bucket.AvgPower = (bucket.AvgPower*float64(bucket.Samples) + power) / float64(bucket.Samples+1)
+	bucket.MaxPower = max(bucket.MaxPower, power)
+	bucket.Samples++
+	return buckets
+}
+
+func hourStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+}
+
+func dayStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func weekStart(t time.Time) time.Time {
+	day := dayStart(t)
+	// Weekday counts from Sunday, ISO weeks from Monday
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,148 @@
+package energy
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2025, 3, 31, 22, 10, 0, 0, time.UTC)
+
+func reading(at time.Duration, power, total float64) plugs.EnergyReading {
+	return plugs.EnergyReading{Time: start.Add(at), Power: power, Total: total}
+}
+
+func TestRecordRollups(t *testing.T) {
+	h := New(filepath.Join(t.TempDir(), "energy.json"))
+
+	h.Record("desk", reading(0, 100, 10.0))
+	h.Record("desk", reading(30*time.Minute, 200, 10.1))
+	h.Record("desk", reading(time.Hour, 300, 10.4))
+	// Past midnight, into a new day and month
+	h.Record("desk", reading(2*time.Hour, 100, 10.5))
+
+	s, ok := h.Series("desk")
+	require.True(t, ok)
+	require.Equal(t, 10.5, s.Total)
+
+	require.Len(t, s.Hourly, 3)
+	require.InDelta(t, 0.1, s.Hourly[0].Energy, 1e-9)
+	require.InDelta(t, 150, s.Hourly[0].AvgPower, 1e-9)
+	require.Equal(t, 200.0, s.Hourly[0].MaxPower)
+	require.InDelta(t, 0.3, s.Hourly[1].Energy, 1e-9)
+
+	require.Len(t, s.Daily, 2)
+	require.InDelta(t, 0.4, s.Daily[0].Energy, 1e-9)
+	require.InDelta(t, 0.1, s.Daily[1].Energy, 1e-9)
+
+	require.Len(t, s.Monthly, 2)
+	require.Equal(t, time.April, s.Monthly[1].Start.Month())
+	require.InDelta(t, 0.1, s.Month(start.Add(2*time.Hour)).Energy, 1e-9)
+
+	_, ok = h.Series("other")
+	require.False(t, ok)
+}
+
+func TestRecordCounterResets(t *testing.T) {
+	h := New(filepath.Join(t.TempDir(), "energy.json"))
+
+	h.Record("desk", reading(0, 0, 50.0))
+	// EnergyReset 0, then 0.2 kWh used
+	h.Record("desk", reading(10*time.Minute, 0, 0.2))
+	// A reboot restores the total last saved to flash
+	h.Record("desk", reading(20*time.Minute, 0, 0.15))
+	h.Record("desk", reading(30*time.Minute, 0, 0.25))
+
+	s, _ := h.Series("desk")
+	require.Equal(t, 2, s.Resets)
+	require.InDelta(t, 0.3, s.Hourly[0].Energy, 1e-9)
+}
+
+func TestRecordIgnoresOutOfOrderReadings(t *testing.T) {
+	h := New(filepath.Join(t.TempDir(), "energy.json"))
+
+	h.Record("desk", reading(time.Minute, 0, 1.0))
+	h.Record("desk", reading(0, 0, 0.5))
+
+	s, _ := h.Series("desk")
+	require.Equal(t, 1.0, s.Total)
+	require.Equal(t, 0, s.Resets)
+}
+
+func TestRecordThrottlesSamples(t *testing.T) {
+	h := New(filepath.Join(t.TempDir(), "energy.json"))
+
+	h.Record("desk", reading(0, 10, 1))
+	h.Record("desk", reading(10*time.Second, 20, 1))
+	h.Record("desk", reading(time.Minute, 30, 1))
+
+	s, _ := h.Series("desk")
+	require.Len(t, s.Samples, 2)
+	require.Equal(t, 30.0, s.Samples[1].Power)
+}
+
+func TestWeekly(t *testing.T) {
+	h := New(filepath.Join(t.TempDir(), "energy.json"))
+	h.SetTariff(&plugs.Tariff{Currency: "EUR", Rate: 0.5})
+
+	// start is Monday, March 31st; April 6th is the Sunday of that week
+	h.Record("desk", reading(-24*time.Hour, 100, 1.0))
+	h.Record("desk", reading(0, 300, 1.2))
+	h.Record("desk", reading(2*time.Hour, 200, 1.5))
+	h.Record("desk", reading(6*24*time.Hour, 100, 2.0))
+	h.Record("desk", reading(7*24*time.Hour, 100, 2.5))
+
+	s, _ := h.Series("desk")
+	require.Len(t, s.Daily, 5)
+	weeks := s.Weekly()
+	require.Len(t, weeks, 3)
+
+	require.Equal(t, time.Date(2025, 3, 24, 0, 0, 0, 0, time.UTC), weeks[0].Start)
+	require.Equal(t, 0.0, weeks[0].Energy)
+
+	require.Equal(t, time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC), weeks[1].Start)
+	require.InDelta(t, 1.0, weeks[1].Energy, 1e-9)
+	require.InDelta(t, 0.5, weeks[1].Cost, 1e-9)
+	require.InDelta(t, 1.0, weeks[1].Windows[plugs.StandardWindow].Energy, 1e-9)
+	require.Equal(t, 3, weeks[1].Samples)
+	require.InDelta(t, 200, weeks[1].AvgPower, 1e-9)
+	require.Equal(t, 300.0, weeks[1].MaxPower)
+
+	require.Equal(t, time.Date(2025, 4, 7, 0, 0, 0, 0, time.UTC), weeks[2].Start)
+	require.InDelta(t, 0.5, weeks[2].Energy, 1e-9)
+}
+
+func TestLastHoursFillsGaps(t *testing.T) {
+	h := New(filepath.Join(t.TempDir(), "energy.json"))
+	h.Record("desk", reading(0, 0, 1.0))
+	h.Record("desk", reading(2*time.Hour, 0, 1.5))
+
+	s, _ := h.Series("desk")
+	hours := s.LastHours(start.Add(2*time.Hour), 4)
+	require.Len(t, hours, 4)
+	require.Equal(t, start.Add(-time.Hour).Truncate(time.Hour), hours[0].Start)
+	require.Equal(t, 0.0, hours[2].Energy)
+	require.InDelta(t, 0.5, hours[3].Energy, 1e-9)
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "energy.json")
+	h := New(path)
+	h.Record("desk", reading(0, 0, 1.0))
+	h.Record("desk", reading(time.Minute, 0, 1.2))
+	require.NoError(t, h.Save())
+
+	loaded := New(path)
+	require.NoError(t, loaded.Load())
+	// Consumption continues from the saved total
+	loaded.Record("desk", reading(2*time.Minute, 0, 1.5))
+
+	s, ok := loaded.Series("desk")
+	require.True(t, ok)
+	require.InDelta(t, 0.5, s.Daily[0].Energy, 1e-9)
+
+	require.NoError(t, New(filepath.Join(t.TempDir(), "missing.json")).Load())
+}
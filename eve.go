@@ -0,0 +1,73 @@
+package tasmotahomekit
+
+import (
+	"github.com/brutella/hap/characteristic"
+	"github.com/brutella/hap/service"
+)
+
+// Eve (Elgato) custom characteristic UUIDs for power metering. The Eve app
+// shows these on outlet services; Apple's Home app ignores them.
+const (
+	TypeEveVoltage            = "E863F10A-079E-48FF-8F27-9C2605A29F52"
+	TypeEveTotalConsumption   = "E863F10C-079E-48FF-8F27-9C2605A29F52"
+	TypeEveCurrentConsumption = "E863F10D-079E-48FF-8F27-9C2605A29F52"
+	TypeEveElectricCurrent    = "E863F126-079E-48FF-8F27-9C2605A29F52"
+)
+
+// Eve air pressure sensor service and characteristic UUIDs, as used by Eve
+// Weather. Apple's Home app does not show air pressure.
+const (
+	TypeEveAirPressureSensor = "E863F00A-079E-48FF-8F27-9C2605A29F52"
+	TypeEveAirPressure       = "E863F10F-079E-48FF-8F27-9C2605A29F52"
+)
+
+// newEveMeter creates a read-only float characteristic for an Eve metering UUID.
+func newEveMeter(typ, description string, maxValue, step float64) *characteristic.Float {
+	c := characteristic.NewFloat(typ)
+	c.Permissions = []string{characteristic.PermissionRead, characteristic.PermissionEvents}
+	c.Description = description
+	c.SetMinValue(0)
+	c.SetMaxValue(maxValue)
+	c.SetStepValue(step)
+	return c
+}
+
+// EveMeters holds the Eve power metering characteristics of an outlet.
+type EveMeters struct {
+	Voltage *characteristic.Float // V
+	Current *characteristic.Float // A
+	Power   *characteristic.Float // W
+	Energy  *characteristic.Float // kWh
+}
+
+// NewEveMeters creates the Eve Volt, Ampere, Watt and kWh characteristics.
+func NewEveMeters() *EveMeters {
+	return &EveMeters{
+		Voltage: newEveMeter(TypeEveVoltage, "Volt", 1000, 0.1),
+		Current: newEveMeter(TypeEveElectricCurrent, "Ampere", 100, 0.01),
+		Power:   newEveMeter(TypeEveCurrentConsumption, "Watt", 100000, 0.1),
+		Energy:  newEveMeter(TypeEveTotalConsumption, "kWh", 1000000, 0.001),
+	}
+}
+
+// Characteristics returns the meters in the order they are added to a service.
+func (m *EveMeters) Characteristics() []*characteristic.C {
+	return []*characteristic.C{m.Voltage.C, m.Current.C, m.Power.C, m.Energy.C}
+}
+
+// Set updates all meters.
+func (m *EveMeters) Set(voltage, current, power, energy float64) {
+	m.Voltage.SetValue(voltage)
+	m.Current.SetValue(current)
+	m.Power.SetValue(power)
+	m.Energy.SetValue(energy)
+}
+
+// NewEveAirPressureSensor creates an Eve air pressure sensor service and its
+// hPa characteristic.
+func NewEveAirPressureSensor() (*service.S, *characteristic.Float) {
+	pressure := newEveMeter(TypeEveAirPressure, "Air Pressure", 1200, 0.1)
+	s := service.New(TypeEveAirPressureSensor)
+	s.AddC(pressure.C)
+	return s, pressure
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"maps"
 	"sync"
 
 	"tailscale.com/util/eventbus"
@@ -13,11 +14,17 @@ import (
 type ClientName string
 
 const (
-	ClientPlugManager ClientName = "plugmanager"
-	ClientHAP         ClientName = "hap"
-	ClientWeb         ClientName = "web"
-	ClientMQTT        ClientName = "mqtt"
-	ClientMetrics     ClientName = "metrics"
+	ClientPlugManager   ClientName = "plugmanager"
+	ClientHAP           ClientName = "hap"
+	ClientWeb           ClientName = "web"
+	ClientMQTT          ClientName = "mqtt"
+	ClientMetrics       ClientName = "metrics"
+	ClientConfig        ClientName = "config"
+	ClientEnergy        ClientName = "energy"
+	ClientSafety        ClientName = "safety"
+	ClientHomeAssistant ClientName = "homeassistant"
+	ClientWebhook       ClientName = "webhook"
+	ClientNotify        ClientName = "notify"
 )
 
 // Bus wraps tailscale's eventbus and provides helpers for publishing state updates.
@@ -31,6 +38,11 @@ type Bus struct {
 	lastStates map[string]StateUpdateEvent
 	stateMu    sync.Mutex
 	mu         sync.RWMutex
+
+	// Last connection status per component, for subscribers that start
+	// after a component reported in
+	lastStatuses map[string]ConnectionStatusEvent
+	statusMu     sync.Mutex
 }
 
 // New constructs a new bus with the known clients registered.
@@ -48,6 +60,8 @@ func New(logger *slog.Logger) (*Bus, error) {
 		ctx:        ctx,
 		cancel:     cancel,
 		lastStates: make(map[string]StateUpdateEvent),
+
+		lastStatuses: make(map[string]ConnectionStatusEvent),
 	}
 
 	for _, name := range []ClientName{
@@ -56,6 +70,12 @@ func New(logger *slog.Logger) (*Bus, error) {
 		ClientWeb,
 		ClientMQTT,
 		ClientMetrics,
+		ClientConfig,
+		ClientEnergy,
+		ClientSafety,
+		ClientHomeAssistant,
+		ClientWebhook,
+		ClientNotify,
 	} {
 		b.clients[name] = b.bus.Client(string(name))
 	}
@@ -110,6 +130,29 @@ func (b *Bus) PublishStateUpdate(client *eventbus.Client, event StateUpdateEvent
 	b.lastStates[event.PlugID] = event
 }
 
+// ForgetState drops the last published state of a plug, so a plug re-added
+// with identical state is announced again.
+func (b *Bus) ForgetState(plugID string) {
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+
+	delete(b.lastStates, plugID)
+}
+
+// PublishConfigChanged emits a plug configuration reload event.
+func (b *Bus) PublishConfigChanged(client *eventbus.Client, event ConfigChangedEvent) {
+	b.logger.Debug(
+		"publishing config changed",
+		slog.Int("added", len(event.Added)),
+		slog.Int("removed", len(event.Removed)),
+		slog.Int("updated", len(event.Updated)),
+	)
+
+	publisher := eventbus.Publish[ConfigChangedEvent](client)
+	defer publisher.Close()
+	publisher.Publish(event)
+}
+
 // PublishCommand emits a command event for metrics/debug consumers.
 func (b *Bus) PublishCommand(client *eventbus.Client, event CommandEvent) {
 	b.logger.Debug(
@@ -124,6 +167,33 @@ func (b *Bus) PublishCommand(client *eventbus.Client, event CommandEvent) {
 	publisher.Publish(event)
 }
 
+// PublishCost emits the cost of a plug's consumption.
+func (b *Bus) PublishCost(client *eventbus.Client, event CostEvent) {
+	b.logger.Debug(
+		"publishing cost",
+		slog.String("plug_id", event.PlugID),
+		slog.String("window", event.Window),
+		slog.Float64("cost", event.Cost),
+	)
+
+	publisher := eventbus.Publish[CostEvent](client)
+	defer publisher.Close()
+	publisher.Publish(event)
+}
+
+// PublishAlert emits a safety alert.
+func (b *Bus) PublishAlert(client *eventbus.Client, event AlertEvent) {
+	b.logger.Debug(
+		"publishing alert",
+		slog.String("plug_id", event.PlugID),
+		slog.String("rule", event.Rule),
+	)
+
+	publisher := eventbus.Publish[AlertEvent](client)
+	defer publisher.Close()
+	publisher.Publish(event)
+}
+
 // PublishConnectionStatus emits lifecycle updates for components (web, hap, mqtt, etc.).
 func (b *Bus) PublishConnectionStatus(client *eventbus.Client, event ConnectionStatusEvent) {
 	b.logger.Debug(
@@ -132,11 +202,24 @@ func (b *Bus) PublishConnectionStatus(client *eventbus.Client, event ConnectionS
 		slog.String("status", string(event.Status)),
 	)
 
+	b.statusMu.Lock()
+	b.lastStatuses[event.Component] = event
+	b.statusMu.Unlock()
+
 	publisher := eventbus.Publish[ConnectionStatusEvent](client)
 	defer publisher.Close()
 	publisher.Publish(event)
 }
 
+// ConnectionStatuses returns the last published status of every component.
+// The eventbus does not replay events, so subscribers that start late seed
+// their view from this.
+func (b *Bus) ConnectionStatuses() map[string]ConnectionStatusEvent {
+	b.statusMu.Lock()
+	defer b.statusMu.Unlock()
+	return maps.Clone(b.lastStatuses)
+}
+
 // Close shuts down the event bus and releases clients.
 func (b *Bus) Close() error {
 	b.cancel()
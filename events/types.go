@@ -1,42 +1,79 @@
 package events
 
 import (
+	"slices"
 	"time"
 )
 
 // StateUpdateEvent carries plug state for SSE subscribers.
 type StateUpdateEvent struct {
-	Timestamp       time.Time `json:"timestamp"`
-	Source          string    `json:"source"`
-	PlugID          string    `json:"plug_id"`
-	Name            string    `json:"name"`
-	On              bool      `json:"on"`
-	Power           float64   `json:"power"`
-	Voltage         float64   `json:"voltage"`
-	Current         float64   `json:"current"`
-	Energy          float64   `json:"energy"`
-	MQTTConnected   bool      `json:"mqtt_connected"`
-	LastSeen        time.Time `json:"last_seen"`
-	LastUpdated     time.Time `json:"last_updated"`
-	ConnectionState string    `json:"connection_state"`
-	ConnectionNote  string    `json:"connection_note"`
+	Timestamp        time.Time `json:"timestamp"`
+	Source           string    `json:"source"`
+	PlugID           string    `json:"plug_id"`
+	Name             string    `json:"name"`
+	On               bool      `json:"on"`
+	Relays           []bool    `json:"relays,omitempty"`
+	Brightness       int       `json:"brightness,omitempty"`
+	Hue              float64   `json:"hue,omitempty"`
+	Saturation       float64   `json:"saturation,omitempty"`
+	ColorTemperature int       `json:"color_temperature,omitempty"`
+	Power            float64   `json:"power"`
+	Voltage          float64   `json:"voltage"`
+	Current          float64   `json:"current"`
+	Energy           float64   `json:"energy"`
+	// Sensors holds readings by sensor name and kind, e.g.
+	// {"BME280": {"temperature": 21.3}}
+	Sensors         map[string]map[string]float64 `json:"sensors,omitempty"`
+	MQTTConnected   bool                          `json:"mqtt_connected"`
+	Offline         bool                          `json:"offline"`
+	Restored        bool                          `json:"restored,omitempty"` // loaded from the state store, not yet confirmed
+	LastSeen        time.Time                     `json:"last_seen"`
+	LastUpdated     time.Time                     `json:"last_updated"`
+	ConnectionState string                        `json:"connection_state"`
+	ConnectionNote  string                        `json:"connection_note"`
 }
 
 // CommandType represents supported plug commands.
 type CommandType string
 
 const (
-	// CommandTypeSetPower toggles plug state via HTTP fast path.
+	// CommandTypeSetPower toggles plug state.
 	CommandTypeSetPower CommandType = "set_power"
+	// CommandTypeSetBrightness changes a bulb's dimmer level.
+	CommandTypeSetBrightness CommandType = "set_brightness"
+	// CommandTypeSetColor changes a bulb's hue and/or saturation.
+	CommandTypeSetColor CommandType = "set_color"
+	// CommandTypeSetColorTemperature changes a bulb's white temperature.
+	CommandTypeSetColorTemperature CommandType = "set_color_temperature"
 )
 
-// CommandEvent captures requested control actions for a plug.
+// Transport is how a command reached the device.
+type Transport string
+
+const (
+	// TransportMQTT is a command published on the embedded broker and
+	// confirmed by the device.
+	TransportMQTT Transport = "mqtt"
+	// TransportHTTP is a command sent to the device's web server.
+	TransportHTTP Transport = "http"
+	// TransportHTTPFallback is a command sent over HTTP after the device
+	// did not confirm it over MQTT in time.
+	TransportHTTPFallback Transport = "http_fallback"
+)
+
+// CommandEvent captures control actions sent to a plug.
 type CommandEvent struct {
-	Timestamp   time.Time   `json:"timestamp"`
-	Source      string      `json:"source"`
-	PlugID      string      `json:"plug_id"`
-	CommandType CommandType `json:"command_type"`
-	On          *bool       `json:"on,omitempty"`
+	Timestamp        time.Time   `json:"timestamp"`
+	Source           string      `json:"source"`
+	PlugID           string      `json:"plug_id"`
+	CommandType      CommandType `json:"command_type"`
+	Relay            int         `json:"relay,omitempty"`
+	On               *bool       `json:"on,omitempty"`
+	Brightness       *int        `json:"brightness,omitempty"`
+	Hue              *float64    `json:"hue,omitempty"`
+	Saturation       *float64    `json:"saturation,omitempty"`
+	ColorTemperature *int        `json:"color_temperature,omitempty"`
+	Transport        Transport   `json:"transport,omitempty"`
 }
 
 // Equals determines whether two events carry the same logical state (ignoring timestamp/source).
@@ -44,17 +81,44 @@ func (e StateUpdateEvent) Equals(other StateUpdateEvent) bool {
 	return e.PlugID == other.PlugID &&
 		e.Name == other.Name &&
 		e.On == other.On &&
+		slices.Equal(e.Relays, other.Relays) &&
+		e.Brightness == other.Brightness &&
+		almostEqual(e.Hue, other.Hue) &&
+		almostEqual(e.Saturation, other.Saturation) &&
+		e.ColorTemperature == other.ColorTemperature &&
 		almostEqual(e.Power, other.Power) &&
 		almostEqual(e.Voltage, other.Voltage) &&
 		almostEqual(e.Current, other.Current) &&
 		almostEqual(e.Energy, other.Energy) &&
+		sensorsEqual(e.Sensors, other.Sensors) &&
 		e.MQTTConnected == other.MQTTConnected &&
+		e.Offline == other.Offline &&
+		e.Restored == other.Restored &&
 		e.LastSeen.Equal(other.LastSeen) &&
 		e.LastUpdated.Equal(other.LastUpdated) &&
 		e.ConnectionState == other.ConnectionState &&
 		e.ConnectionNote == other.ConnectionNote
 }
 
+func sensorsEqual(a, b map[string]map[string]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, readings := range a {
+		others, ok := b[name]
+		if !ok || len(readings) != len(others) {
+			return false
+		}
+		for kind, value := range readings {
+			other, ok := others[kind]
+			if !ok || !almostEqual(value, other) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func almostEqual(a, b float64) bool {
 	const eps = 0.001
 	if a > b {
@@ -63,6 +127,34 @@ func almostEqual(a, b float64) bool {
 	return b-a < eps
 }
 
+// ConfigChangedEvent announces a plug configuration reload that changed the plug set.
+type ConfigChangedEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Added     []string  `json:"added,omitempty"`
+	Removed   []string  `json:"removed,omitempty"`
+	Updated   []string  `json:"updated,omitempty"`
+}
+
+// CostEvent reports consumption of a plug priced by the tariff, one per
+// tariff window a reading spans.
+type CostEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	PlugID    string    `json:"plug_id"`
+	Window    string    `json:"window"`
+	Currency  string    `json:"currency"`
+	Energy    float64   `json:"energy"` // kWh
+	Cost      float64   `json:"cost"`
+}
+
+// AlertEvent reports a safety rule switching a plug off.
+type AlertEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	PlugID    string    `json:"plug_id"`
+	Relay     int       `json:"relay,omitempty"`
+	Rule      string    `json:"rule"`
+	Reason    string    `json:"reason"`
+}
+
 // ConnectionStatusEvent conveys component lifecycle information (web, HAP, MQTT, etc.).
 type ConnectionStatusEvent struct {
 	Timestamp  time.Time        `json:"timestamp"`
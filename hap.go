@@ -2,13 +2,19 @@ package tasmotahomekit
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"hash/fnv"
 	"log/slog"
+	"net/http"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/brutella/hap"
 	"github.com/brutella/hap/accessory"
+	"github.com/brutella/hap/characteristic"
+	"github.com/brutella/hap/service"
 	"github.com/kradalby/tasmota-homekit/events"
 	"github.com/kradalby/tasmota-homekit/plugs"
 	"tailscale.com/util/eventbus"
@@ -26,11 +32,65 @@ type Switchable interface {
 	OnValue() bool
 	OnValueRemoteUpdate(f func(on bool))
 	ID() uint64
+	SetReachable(reachable bool)
+	Reachable() bool
 }
 
-// OutletWrapper wraps an accessory.Outlet to implement Switchable
+// reachability fails HomeKit reads of an accessory's characteristics while
+// its device is offline, which the Home app shows as "No Response" rather
+// than the last known state.
+type reachability struct {
+	offline atomic.Bool
+}
+
+func (r *reachability) SetReachable(reachable bool) {
+	r.offline.Store(!reachable)
+}
+
+func (r *reachability) Reachable() bool {
+	return !r.offline.Load()
+}
+
+// guard installs the read handlers on every characteristic of acc, except
+// the accessory information.
+func (r *reachability) guard(acc *accessory.A) {
+	for _, svc := range acc.Ss {
+		if svc.Type == service.TypeAccessoryInformation {
+			continue
+		}
+		for _, c := range svc.Cs {
+			c.ValueRequestFunc = func(*http.Request) (interface{}, int) {
+				if r.offline.Load() {
+					return nil, hap.JsonStatusServiceCommunicationFailure
+				}
+				return c.Val, hap.JsonStatusSuccess
+			}
+		}
+	}
+}
+
+// OutletWrapper wraps an accessory.Outlet to implement Switchable.
+// Meters is nil unless the plug has power monitoring.
 type OutletWrapper struct {
 	*accessory.Outlet
+	reachability
+	Meters         *EveMeters
+	inUseThreshold float64
+}
+
+// NewOutletWrapper creates an outlet, adding Eve power metering
+// characteristics for plugs with power monitoring.
+func NewOutletWrapper(info accessory.Info, plug plugs.Plug) *OutletWrapper {
+	w := &OutletWrapper{Outlet: accessory.NewOutlet(info)}
+	if plug.HasPowerMonitoring() {
+		w.Meters = NewEveMeters()
+		for _, c := range w.Meters.Characteristics() {
+			w.Outlet.Outlet.AddC(c)
+		}
+		w.inUseThreshold = plug.InUseWatts()
+	}
+	w.guard(w.A)
+	return w
 }
 
 func (w *OutletWrapper) SetOn(on bool) {
@@ -49,9 +109,60 @@ func (w *OutletWrapper) ID() uint64 {
 	return w.Id
 }
 
-// LightbulbWrapper wraps an accessory.Lightbulb to implement Switchable
+// UpdateMetering updates the Eve meters and OutletInUse. Without power
+// monitoring the outlet is considered in use whenever it is on.
+func (w *OutletWrapper) UpdateMetering(event events.StateUpdateEvent) {
+	if w.Meters == nil {
+		w.Outlet.Outlet.OutletInUse.SetValue(event.On)
+		return
+	}
+
+	w.Meters.Set(event.Voltage, event.Current, event.Power, event.Energy)
+	w.Outlet.Outlet.OutletInUse.SetValue(event.On && event.Power >= w.inUseThreshold)
+}
+
+// Metered is implemented by accessories that report power consumption.
+type Metered interface {
+	UpdateMetering(event events.StateUpdateEvent)
+}
+
+// LightbulbWrapper wraps an accessory.Lightbulb to implement Switchable.
+// Brightness, Hue, Saturation and ColorTemperature are nil unless the plug
+// has the matching features.
 type LightbulbWrapper struct {
 	*accessory.Lightbulb
+	reachability
+	Brightness       *characteristic.Brightness
+	Hue              *characteristic.Hue
+	Saturation       *characteristic.Saturation
+	ColorTemperature *characteristic.ColorTemperature
+}
+
+// NewLightbulbWrapper creates a lightbulb with the characteristics the plug's
+// features call for.
+func NewLightbulbWrapper(info accessory.Info, plug plugs.Plug) *LightbulbWrapper {
+	w := &LightbulbWrapper{Lightbulb: accessory.NewLightbulb(info)}
+	svc := w.Lightbulb.Lightbulb
+
+	if plug.HasBrightness() {
+		w.Brightness = characteristic.NewBrightness()
+		svc.AddC(w.Brightness.C)
+	}
+	if plug.HasColor() {
+		w.Hue = characteristic.NewHue()
+		svc.AddC(w.Hue.C)
+		w.Saturation = characteristic.NewSaturation()
+		svc.AddC(w.Saturation.C)
+	}
+	if plug.HasColorTemperature() {
+		w.ColorTemperature = characteristic.NewColorTemperature()
+		w.ColorTemperature.SetMinValue(plugs.MinColorTemperature)
+		w.ColorTemperature.SetMaxValue(plugs.MaxColorTemperature)
+		svc.AddC(w.ColorTemperature.C)
+	}
+
+	w.guard(w.A)
+	return w
 }
 
 func (w *LightbulbWrapper) SetOn(on bool) {
@@ -70,16 +181,200 @@ func (w *LightbulbWrapper) ID() uint64 {
 	return w.Id
 }
 
+// SetLight updates the light characteristics from a state event.
+func (w *LightbulbWrapper) SetLight(event events.StateUpdateEvent) {
+	if w.Brightness != nil {
+		w.Brightness.SetValue(event.Brightness)
+	}
+	if w.Hue != nil {
+		w.Hue.SetValue(event.Hue)
+	}
+	if w.Saturation != nil {
+		w.Saturation.SetValue(event.Saturation)
+	}
+	if w.ColorTemperature != nil && event.ColorTemperature > 0 {
+		w.ColorTemperature.SetValue(event.ColorTemperature)
+	}
+}
+
+// OnLightRemoteUpdate registers f for HomeKit changes to any light characteristic.
+func (w *LightbulbWrapper) OnLightRemoteUpdate(f func(settings plugs.LightSettings)) {
+	if w.Brightness != nil {
+		w.Brightness.OnValueRemoteUpdate(func(v int) {
+			f(plugs.LightSettings{Brightness: &v})
+		})
+	}
+	if w.Hue != nil {
+		w.Hue.OnValueRemoteUpdate(func(v float64) {
+			f(plugs.LightSettings{Hue: &v})
+		})
+	}
+	if w.Saturation != nil {
+		w.Saturation.OnValueRemoteUpdate(func(v float64) {
+			f(plugs.LightSettings{Saturation: &v})
+		})
+	}
+	if w.ColorTemperature != nil {
+		w.ColorTemperature.OnValueRemoteUpdate(func(v int) {
+			f(plugs.LightSettings{ColorTemperature: &v})
+		})
+	}
+}
+
+// Dimmable is implemented by accessories with light controls beyond on/off.
+type Dimmable interface {
+	Switchable
+	SetLight(event events.StateUpdateEvent)
+	OnLightRemoteUpdate(f func(settings plugs.LightSettings))
+}
+
+// MultiSwitchable is implemented by accessories that expose one service per relay.
+type MultiSwitchable interface {
+	Switchable
+	RelayCount() int
+	SetRelayOn(relay int, on bool)
+	RelayOnValue(relay int) bool
+	OnRelayValueRemoteUpdate(f func(relay int, on bool))
+}
+
+// MultiRelayWrapper is a single accessory carrying one outlet or lightbulb
+// service per relay of a multi-relay Tasmota device. Meters is nil unless
+// the device is an outlet with power monitoring; the device reports one
+// reading for all relays, so they sit on the first outlet.
+type MultiRelayWrapper struct {
+	*accessory.A
+	reachability
+	relays         []*characteristic.On
+	inUse          []*characteristic.OutletInUse
+	Meters         *EveMeters
+	inUseThreshold float64
+}
+
+// NewMultiRelayWrapper creates an accessory with one service per configured relay.
+func NewMultiRelayWrapper(info accessory.Info, plug plugs.Plug) *MultiRelayWrapper {
+	typ := accessory.TypeOutlet
+	if plug.Type == "bulb" {
+		typ = accessory.TypeLightbulb
+	}
+
+	w := &MultiRelayWrapper{A: accessory.New(info, typ)}
+	for relay := 1; relay <= plug.RelayCount(); relay++ {
+		var svc *service.S
+		var on *characteristic.On
+		if plug.Type == "bulb" {
+			bulb := service.NewLightbulb()
+			svc, on = bulb.S, bulb.On
+		} else {
+			outlet := service.NewOutlet()
+			svc, on = outlet.S, outlet.On
+			w.inUse = append(w.inUse, outlet.OutletInUse)
+			if relay == 1 && plug.HasPowerMonitoring() {
+				w.Meters = NewEveMeters()
+				for _, c := range w.Meters.Characteristics() {
+					svc.AddC(c)
+				}
+				w.inUseThreshold = plug.InUseWatts()
+			}
+		}
+
+		name := characteristic.NewName()
+		name.SetValue(plug.RelayName(relay))
+		svc.AddC(name.C)
+		if relay == 1 {
+			svc.Primary = true
+		}
+
+		w.AddS(svc)
+		w.relays = append(w.relays, on)
+	}
+
+	w.guard(w.A)
+	return w
+}
+
+func (w *MultiRelayWrapper) SetOn(on bool) {
+	w.SetRelayOn(1, on)
+}
+
+func (w *MultiRelayWrapper) OnValue() bool {
+	return w.RelayOnValue(1)
+}
+
+func (w *MultiRelayWrapper) OnValueRemoteUpdate(f func(on bool)) {
+	w.relays[0].OnValueRemoteUpdate(f)
+}
+
+func (w *MultiRelayWrapper) ID() uint64 {
+	return w.Id
+}
+
+func (w *MultiRelayWrapper) RelayCount() int {
+	return len(w.relays)
+}
+
+func (w *MultiRelayWrapper) SetRelayOn(relay int, on bool) {
+	if relay < 1 || relay > len(w.relays) {
+		return
+	}
+	w.relays[relay-1].SetValue(on)
+}
+
+func (w *MultiRelayWrapper) RelayOnValue(relay int) bool {
+	if relay < 1 || relay > len(w.relays) {
+		return false
+	}
+	return w.relays[relay-1].Value()
+}
+
+// UpdateMetering updates the Eve meters and the OutletInUse of every relay,
+// after the relays took the event's state. A relay that is on is in use
+// while the device draws at least the threshold, or always without power
+// monitoring.
+func (w *MultiRelayWrapper) UpdateMetering(event events.StateUpdateEvent) {
+	drawing := true
+	if w.Meters != nil {
+		w.Meters.Set(event.Voltage, event.Current, event.Power, event.Energy)
+		drawing = event.Power >= w.inUseThreshold
+	}
+	for i, inUse := range w.inUse {
+		inUse.SetValue(w.RelayOnValue(i+1) && drawing)
+	}
+}
+
+func (w *MultiRelayWrapper) OnRelayValueRemoteUpdate(f func(relay int, on bool)) {
+	for i, on := range w.relays {
+		relay := i + 1
+		on.OnValueRemoteUpdate(func(v bool) {
+			f(relay, v)
+		})
+	}
+}
+
 // HAPManager manages HomeKit accessories and their state synchronization
 type HAPManager struct {
-	bridge          *accessory.Bridge
-	accessories     map[string]Switchable
-	accessoryOrder  []string
-	commands        chan plugs.CommandEvent
-	plugManager     *plugs.Manager
-	stateSubscriber *eventbus.Subscriber[events.StateUpdateEvent]
-	eventBus        *events.Bus
-	eventClient     *eventbus.Client
+	bridge         *accessory.Bridge
+	mu             sync.RWMutex
+	accessories    map[string]Switchable
+	accessoryOrder []string
+	restart        chan struct{}
+
+	// Group and scene switches, see SetGroups, and the last state of every
+	// plug for the group switches
+	groups     []*groupAccessory
+	scenes     []*sceneAccessory
+	plugStates map[string]events.StateUpdateEvent
+
+	// Programmable switches of plugs with buttons and accessories of
+	// their sensors, by plug ID
+	buttons map[string]*buttonAccessory
+	sensors map[string][]*sensorAccessory
+
+	commands         chan plugs.CommandEvent
+	plugManager      *plugs.Manager
+	stateSubscriber  *eventbus.Subscriber[events.StateUpdateEvent]
+	buttonSubscriber *eventbus.Subscriber[plugs.ButtonEvent]
+	eventBus         *events.Bus
+	eventClient      *eventbus.Client
 
 	// Runtime info
 	server *hap.Server
@@ -113,17 +408,30 @@ func NewHAPManager(
 	})
 
 	hm := &HAPManager{
-		bridge:          bridge,
-		accessories:     make(map[string]Switchable),
-		accessoryOrder:  make([]string, 0, len(plugConfigs)),
-		commands:        commands,
-		plugManager:     plugManager,
-		stateSubscriber: eventbus.Subscribe[events.StateUpdateEvent](client),
-		eventBus:        bus,
-		eventClient:     client,
+		bridge:           bridge,
+		commands:         commands,
+		plugManager:      plugManager,
+		stateSubscriber:  eventbus.Subscribe[events.StateUpdateEvent](client),
+		buttonSubscriber: eventbus.Subscribe[plugs.ButtonEvent](client),
+		eventBus:         bus,
+		eventClient:      client,
+		restart:          make(chan struct{}, 1),
 	}
 
-	// Create accessory for each plug
+	hm.accessories, hm.accessoryOrder = hm.buildAccessories(plugConfigs)
+	hm.buttons = hm.buildButtons(plugConfigs)
+	hm.sensors = hm.buildSensors(plugConfigs)
+
+	return hm
+}
+
+// buildAccessories creates an accessory for every plug enabled for HomeKit.
+// The accessories are not added to the bridge; GetAccessories hands them to
+// the HAP server next to it.
+func (hm *HAPManager) buildAccessories(plugConfigs []plugs.Plug) (map[string]Switchable, []string) {
+	accessories := make(map[string]Switchable, len(plugConfigs))
+	order := make([]string, 0, len(plugConfigs))
+
 	for _, plug := range plugConfigs {
 		// Skip plugs that are not enabled for HomeKit
 		if plug.HomeKit != nil && !*plug.HomeKit {
@@ -141,61 +449,85 @@ func NewHAPManager(
 		var switchable Switchable
 		var acc *accessory.A
 
-		if plug.Type == "bulb" {
-			lightbulb := accessory.NewLightbulb(info)
+		if plug.RelayCount() > 1 {
+			multi := NewMultiRelayWrapper(info, plug)
+			acc = multi.A
+			switchable = multi
+			slog.Info("Created HomeKit multi-relay accessory", "plug_id", plug.ID, "name", plug.Name, "relays", plug.RelayCount(), "id", hashString(plug.ID))
+		} else if plug.Type == "bulb" {
+			lightbulb := NewLightbulbWrapper(info, plug)
 			acc = lightbulb.A
-			switchable = &LightbulbWrapper{lightbulb}
+			switchable = lightbulb
 			slog.Info("Created HomeKit lightbulb", "plug_id", plug.ID, "name", plug.Name, "id", hashString(plug.ID))
 		} else {
 			// Default to outlet (plug)
-			outlet := accessory.NewOutlet(info)
+			outlet := NewOutletWrapper(info, plug)
 			acc = outlet.A
-			switchable = &OutletWrapper{outlet}
+			switchable = outlet
 			slog.Info("Created HomeKit outlet", "plug_id", plug.ID, "name", plug.Name, "id", hashString(plug.ID))
 		}
 
-		// Set explicit ID to avoid collisions
+		// Set explicit ID to avoid collisions; derived from the plug ID so
+		// it stays stable across restarts and reloads
 		acc.Id = hashString(plug.ID)
 
 		// Capture plug ID for closure
 		plugID := plug.ID
 
 		// Set up handler for when HomeKit changes the state
-		switchable.OnValueRemoteUpdate(func(on bool) {
-			slog.Info("HomeKit command received", "plug_id", plugID, "on", on)
-
-			hm.incomingCommands.Add(1)
-			hm.lastActivity.Store(time.Now().Unix())
+		if multi, ok := switchable.(MultiSwitchable); ok {
+			multi.OnRelayValueRemoteUpdate(func(relay int, on bool) {
+				hm.handleRemotePower(plugID, relay, on)
+			})
+		} else {
+			switchable.OnValueRemoteUpdate(func(on bool) {
+				hm.handleRemotePower(plugID, 0, on)
+			})
+		}
 
-			// Send command through event channel
-			commands <- plugs.CommandEvent{
-				PlugID: plugID,
-				On:     on,
+		if plug.HasBrightness() {
+			if dimmable, ok := switchable.(Dimmable); ok {
+				dimmable.OnLightRemoteUpdate(func(settings plugs.LightSettings) {
+					hm.handleRemoteLight(plugID, settings)
+				})
 			}
+		}
 
-			hm.publishCommand(plugID, on)
-		})
+		accessories[plug.ID] = switchable
+		order = append(order, plug.ID)
+	}
 
-		hm.accessories[plug.ID] = switchable
-		hm.accessoryOrder = append(hm.accessoryOrder, plug.ID)
+	return accessories, order
+}
 
-		// Add accessory to bridge
-		// Note: We need to access the underlying accessory.A to add it to the bridge
-		// Since we don't store it in the map, we do it here.
-		// However, HAP library usually requires adding accessories to the bridge or the server.
-		// The original code didn't explicitly add outlets to the bridge struct in NewHAPManager,
-		// but presumably they are added when the server starts or via `hm.bridge.AddA(outlet.A)`.
-		// Let's check how it was done. It seems they were just stored in `hm.outlets`.
-		// Ah, the `Start` method (which is not shown here but likely exists) probably iterates over the map.
-		// Wait, `accessory.NewBridge` creates a bridge, but we need to serve these accessories.
-		// Let's look at the `Start` method in `hap.go` later. For now, I'll just store them.
+// Reload replaces the accessory set after a plug configuration change and
+// asks Serve to restart the HAP server with it. Current plug state is
+// applied to the new accessories so HomeKit does not see them flip.
+func (hm *HAPManager) Reload(plugConfigs []plugs.Plug) {
+	accessories, order := hm.buildAccessories(plugConfigs)
+	buttons := hm.buildButtons(plugConfigs)
+	sensors := hm.buildSensors(plugConfigs)
+
+	hm.mu.Lock()
+	hm.accessories = accessories
+	hm.accessoryOrder = order
+	hm.buttons = buttons
+	hm.sensors = sensors
+	hm.mu.Unlock()
+
+	hm.applyPlugState("reload")
+
+	select {
+	case hm.restart <- struct{}{}:
+	default:
 	}
-
-	return hm
 }
 
 // GetAccessories returns all accessories for the HAP server
 func (hm *HAPManager) GetAccessories() []*accessory.A {
+	hm.mu.RLock()
+	defer hm.mu.RUnlock()
+
 	// Collect all accessories
 	var accessories []*accessory.A
 	accessories = append(accessories, hm.bridge.A) // Add the bridge itself
@@ -209,22 +541,61 @@ func (hm *HAPManager) GetAccessories() []*accessory.A {
 			accessories = append(accessories, a.A)
 		case *LightbulbWrapper:
 			accessories = append(accessories, a.A)
+		case *MultiRelayWrapper:
+			accessories = append(accessories, a.A)
+		}
+		if buttons, ok := hm.buttons[plugID]; ok {
+			accessories = append(accessories, buttons.a)
+		}
+		for _, sensor := range hm.sensors[plugID] {
+			accessories = append(accessories, sensor.a)
 		}
 	}
+	for _, group := range hm.groups {
+		accessories = append(accessories, group.sw.A)
+	}
+	for _, scene := range hm.scenes {
+		accessories = append(accessories, scene.sw.A)
+	}
 
 	return accessories
 }
 
 // UpdateState updates the HomeKit state for a plug
 func (hm *HAPManager) UpdateState(event events.StateUpdateEvent) {
+	hm.mu.Lock()
+	hm.recordPlugState(event)
 	acc, exists := hm.accessories[event.PlugID]
+	sensors := hm.sensors[event.PlugID]
+	hm.mu.Unlock()
+
+	for _, sensor := range sensors {
+		sensor.update(event)
+	}
 	if !exists {
 		slog.Warn("Accessory not found for plug", "plug_id", event.PlugID)
 		return
 	}
 
+	if acc.Reachable() == event.Offline {
+		slog.Info("Plug reachability changed", "plug_id", event.PlugID, "reachable", !event.Offline)
+	}
+	acc.SetReachable(!event.Offline)
+
 	// Update HomeKit state
-	acc.SetOn(event.On)
+	if multi, ok := acc.(MultiSwitchable); ok && len(event.Relays) > 0 {
+		for i, on := range event.Relays {
+			multi.SetRelayOn(i+1, on)
+		}
+	} else {
+		acc.SetOn(event.On)
+	}
+	if dimmable, ok := acc.(Dimmable); ok {
+		dimmable.SetLight(event)
+	}
+	if metered, ok := acc.(Metered); ok {
+		metered.UpdateMetering(event)
+	}
 
 	hm.outgoingUpdates.Add(1)
 	hm.lastActivity.Store(time.Now().Unix())
@@ -240,19 +611,117 @@ func (hm *HAPManager) UpdateState(event events.StateUpdateEvent) {
 // ProcessStateChanges listens for state changes and updates HomeKit
 // Start begins processing state changes.
 func (hm *HAPManager) Start(ctx context.Context) {
+	// Accessories start out off; begin from the manager's state, which may
+	// have been restored from the state store
+	hm.applyPlugState("initial")
 	go hm.ProcessStateChanges(ctx)
 }
 
+// applyPlugState sets every accessory to the plug manager's current state.
+func (hm *HAPManager) applyPlugState(source string) {
+	if hm.plugManager == nil {
+		return
+	}
+
+	hm.mu.RLock()
+	accessories := hm.accessories
+	hm.mu.RUnlock()
+
+	for id, item := range hm.plugManager.Snapshot() {
+		if _, ok := accessories[id]; ok {
+			hm.UpdateState(plugs.NewStateUpdateEvent(source, id, item.State))
+		}
+	}
+}
+
 // Close releases subscriptions.
 func (hm *HAPManager) Close() {
 	hm.stateSubscriber.Close()
+	hm.buttonSubscriber.Close()
 }
 
 func (hm *HAPManager) SetServer(s *hap.Server) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
 	hm.server = s
 }
 
+// Server returns the running HAP server, nil before Serve starts one.
+func (hm *HAPManager) Server() *hap.Server {
+	hm.mu.RLock()
+	defer hm.mu.RUnlock()
+	return hm.server
+}
+
+// Paired reports whether the HAP server is running and whether a HomeKit
+// controller is paired with it.
+func (hm *HAPManager) Paired() (running, paired bool) {
+	server := hm.Server()
+	if server == nil {
+		return false, false
+	}
+	return true, server.IsPaired()
+}
+
+// Serve runs the HAP server until ctx is done, recreating it with the current
+// accessories whenever Reload is called. The pairing store is kept, so paired
+// controllers reconnect on their own.
+func (hm *HAPManager) Serve(ctx context.Context, store hap.Store, pin, addr string) error {
+	hm.SetStore(store)
+	hm.publishStatus(events.ConnectionStatusConnecting, "")
+
+	for {
+		accessories := hm.GetAccessories()
+		server, err := hap.NewServer(store, accessories[0], accessories[1:]...)
+		if err != nil {
+			hm.publishStatus(events.ConnectionStatusFailed, err.Error())
+			return fmt.Errorf("failed to create HAP server: %w", err)
+		}
+		server.Pin = pin
+		server.Addr = addr
+		hm.SetServer(server)
+
+		serveCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() {
+			done <- server.ListenAndServe(serveCtx)
+		}()
+		hm.publishStatus(events.ConnectionStatusConnected, "")
+
+		select {
+		case <-hm.restart:
+			slog.Info("Restarting HomeKit server with updated accessories", "accessories", len(accessories)-1)
+			cancel()
+			<-done
+			hm.publishStatus(events.ConnectionStatusReconnecting, "")
+		case err := <-done:
+			cancel()
+			if err != nil && !errors.Is(err, context.Canceled) {
+				hm.publishStatus(events.ConnectionStatusFailed, err.Error())
+				return err
+			}
+			hm.publishStatus(events.ConnectionStatusDisconnected, "")
+			return nil
+		}
+	}
+}
+
+func (hm *HAPManager) publishStatus(status events.ConnectionStatus, errMsg string) {
+	if hm.eventBus == nil || hm.eventClient == nil {
+		return
+	}
+
+	hm.eventBus.PublishConnectionStatus(hm.eventClient, events.ConnectionStatusEvent{
+		Timestamp: time.Now(),
+		Component: string(events.ClientHAP),
+		Status:    status,
+		Error:     errMsg,
+	})
+}
+
 func (hm *HAPManager) SetStore(s hap.Store) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
 	hm.store = s
 }
 
@@ -262,24 +731,43 @@ func (hm *HAPManager) ProcessStateChanges(ctx context.Context) {
 		case event := <-hm.stateSubscriber.Events():
 			slog.Debug("Received state update event", "plug_id", event.PlugID, "on", event.On)
 			hm.UpdateState(event)
+		case event := <-hm.buttonSubscriber.Events():
+			hm.HandleButton(event)
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (hm *HAPManager) publishCommand(plugID string, on bool) {
-	if hm.eventBus == nil || hm.eventClient == nil {
-		return
+// handleRemotePower forwards a HomeKit power change for a plug (or one of its
+// relays) to the plug manager.
+func (hm *HAPManager) handleRemotePower(plugID string, relay int, on bool) {
+	slog.Info("HomeKit command received", "plug_id", plugID, "relay", relay, "on", on)
+
+	hm.incomingCommands.Add(1)
+	hm.lastActivity.Store(time.Now().Unix())
+
+	// Send command through event channel; the plug manager records it on
+	// the event bus once sent
+	hm.commands <- plugs.CommandEvent{
+		PlugID: plugID,
+		Relay:  relay,
+		On:     on,
+		Source: "homekit",
 	}
+}
 
-	desiredState := on
-	hm.eventBus.PublishCommand(hm.eventClient, events.CommandEvent{
-		Timestamp:   time.Now(),
-		Source:      "homekit",
-		PlugID:      plugID,
-		CommandType: events.CommandTypeSetPower,
-		On:          &desiredState,
-	})
-	slog.Debug("Published command to eventbus", "plug_id", plugID, "on", on)
+// handleRemoteLight forwards a HomeKit brightness, color or white temperature
+// change to the plug manager.
+func (hm *HAPManager) handleRemoteLight(plugID string, settings plugs.LightSettings) {
+	slog.Info("HomeKit light command received", "plug_id", plugID)
+
+	hm.incomingCommands.Add(1)
+	hm.lastActivity.Store(time.Now().Unix())
+
+	hm.commands <- plugs.CommandEvent{
+		PlugID: plugID,
+		Light:  &settings,
+		Source: "homekit",
+	}
 }
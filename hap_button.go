@@ -0,0 +1,102 @@
+package tasmotahomekit
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/brutella/hap/accessory"
+	"github.com/brutella/hap/characteristic"
+	"github.com/brutella/hap/service"
+	"github.com/kradalby/tasmota-homekit/plugs"
+)
+
+// buttonAccessory is the HomeKit programmable switch of a plug's buttons,
+// with one stateless switch service per button.
+type buttonAccessory struct {
+	plug     plugs.Plug
+	a        *accessory.A
+	switches []*service.StatelessProgrammableSwitch
+}
+
+// programmableSwitchEvent maps a button action to its
+// ProgrammableSwitchEvent value.
+var programmableSwitchEvent = map[plugs.ButtonAction]int{
+	plugs.ButtonSingle: characteristic.ProgrammableSwitchEventSinglePress,
+	plugs.ButtonDouble: characteristic.ProgrammableSwitchEventDoublePress,
+	plugs.ButtonLong:   characteristic.ProgrammableSwitchEventLongPress,
+}
+
+// buildButtons creates a programmable switch for every plug enabled for
+// HomeKit with buttons configured.
+func (hm *HAPManager) buildButtons(plugConfigs []plugs.Plug) map[string]*buttonAccessory {
+	buttons := make(map[string]*buttonAccessory)
+
+	for _, plug := range plugConfigs {
+		if len(plug.Buttons) == 0 || (plug.HomeKit != nil && !*plug.HomeKit) {
+			continue
+		}
+
+		name := plug.ButtonName(1)
+		if len(plug.Buttons) > 1 {
+			name = plug.Name + " Buttons"
+		}
+		acc := &buttonAccessory{
+			plug: plug,
+			a: accessory.New(accessory.Info{
+				Name:         name,
+				Manufacturer: "Tasmota",
+				Model:        plug.Model,
+				SerialNumber: plug.ID + "-buttons",
+			}, accessory.TypeProgrammableSwitch),
+		}
+		acc.a.Id = hashString("buttons:" + plug.ID)
+
+		// Several buttons need a label namespace and an index each, so
+		// HomeKit can tell them apart
+		if len(plug.Buttons) > 1 {
+			label := service.NewServiceLabel()
+			label.ServiceLabelNamespace.SetValue(characteristic.ServiceLabelNamespaceArabicNumerals)
+			acc.a.AddS(label.S)
+		}
+		for i := range plug.Buttons {
+			sw := service.NewStatelessProgrammableSwitch()
+			if len(plug.Buttons) > 1 {
+				index := characteristic.NewServiceLabelIndex()
+				index.SetValue(i + 1)
+				sw.AddC(index.C)
+
+				labelName := characteristic.NewName()
+				labelName.SetValue(plug.ButtonName(i + 1))
+				sw.AddC(labelName.C)
+			}
+			acc.a.AddS(sw.S)
+			acc.switches = append(acc.switches, sw)
+		}
+
+		buttons[plug.ID] = acc
+		slog.Info("Created HomeKit programmable switch", "plug_id", plug.ID, "buttons", len(plug.Buttons), "id", acc.a.Id)
+	}
+
+	return buttons
+}
+
+// HandleButton emits a button press to HomeKit, where it can trigger
+// automations. Presses of buttons that are not configured are dropped.
+func (hm *HAPManager) HandleButton(event plugs.ButtonEvent) {
+	hm.mu.RLock()
+	acc, ok := hm.buttons[event.PlugID]
+	hm.mu.RUnlock()
+	if !ok || event.Button < 1 || event.Button > len(acc.switches) {
+		slog.Debug("Ignoring press of unconfigured button", "plug_id", event.PlugID, "button", event.Button)
+		return
+	}
+	value, ok := programmableSwitchEvent[event.Action]
+	if !ok {
+		return
+	}
+
+	acc.switches[event.Button-1].ProgrammableSwitchEvent.SetValue(value)
+	hm.outgoingUpdates.Add(1)
+	hm.lastActivity.Store(time.Now().Unix())
+	slog.Debug("Sent button press to HomeKit", "plug_id", event.PlugID, "button", event.Button, "action", event.Action)
+}
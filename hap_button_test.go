@@ -0,0 +1,49 @@
+package tasmotahomekit
+
+import (
+	"testing"
+
+	"github.com/brutella/hap/characteristic"
+	"github.com/brutella/hap/service"
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHAPManagerButtons(t *testing.T) {
+	hidden := false
+	plugCfg := []plugs.Plug{
+		{ID: "hall", Name: "Hall", Address: "1", Buttons: []plugs.Button{{Name: "Up"}, {Name: "Down"}}},
+		{ID: "porch", Name: "Porch", Address: "2", Buttons: []plugs.Button{{}}},
+		{ID: "shed", Name: "Shed", Address: "3", Buttons: []plugs.Button{{}}, HomeKit: &hidden},
+	}
+
+	commands := make(chan plugs.CommandEvent, 1)
+	hm := NewHAPManager(plugCfg, "Test Bridge", commands, nil, newTestEventsBus(t))
+
+	// Bridge, two outlets and their programmable switches
+	require.Len(t, hm.GetAccessories(), 5)
+	require.NotContains(t, hm.buttons, "shed")
+
+	hall := hm.buttons["hall"]
+	require.Equal(t, hashString("buttons:hall"), hall.a.Id)
+	require.Equal(t, "Hall Buttons", hall.a.Info.Name.Value())
+	require.Len(t, hall.switches, 2)
+	require.Equal(t, service.TypeServiceLabel, hall.a.Ss[1].Type, "label service after the accessory information")
+	require.Equal(t, "Porch Button", hm.buttons["porch"].a.Info.Name.Value())
+	require.Len(t, hm.buttons["porch"].a.Ss, 2, "a single button needs no label")
+
+	hm.HandleButton(plugs.ButtonEvent{PlugID: "hall", Button: 2, Action: plugs.ButtonLong})
+	require.Equal(t, characteristic.ProgrammableSwitchEventLongPress, hall.switches[1].ProgrammableSwitchEvent.Value())
+	require.Equal(t, uint64(1), hm.outgoingUpdates.Load())
+
+	// Presses of buttons without a switch are dropped
+	hm.HandleButton(plugs.ButtonEvent{PlugID: "hall", Button: 3, Action: plugs.ButtonSingle})
+	hm.HandleButton(plugs.ButtonEvent{PlugID: "shed", Button: 1, Action: plugs.ButtonSingle})
+	require.Equal(t, uint64(1), hm.outgoingUpdates.Load())
+
+	require.Equal(t, "Programmable Switch", hm.DebugInfo().Accessories[3].Type)
+
+	hm.Reload(plugCfg[1:])
+	require.NotContains(t, hm.buttons, "hall")
+	require.Len(t, hm.GetAccessories(), 3)
+}
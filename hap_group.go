@@ -0,0 +1,194 @@
+package tasmotahomekit
+
+import (
+	"log/slog"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/brutella/hap/accessory"
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/kradalby/tasmota-homekit/plugs"
+)
+
+// sceneSwitchReset is how long a scene's switch stays on in HomeKit after it
+// was flipped to apply the scene.
+const sceneSwitchReset = time.Second
+
+// SwitchWrapper wraps an accessory.Switch to implement Switchable. Groups and
+// scenes are exposed as switches.
+type SwitchWrapper struct {
+	*accessory.Switch
+	reachability
+}
+
+// NewSwitchWrapper creates a switch accessory.
+func NewSwitchWrapper(info accessory.Info) *SwitchWrapper {
+	w := &SwitchWrapper{Switch: accessory.NewSwitch(info)}
+	w.guard(w.A)
+	return w
+}
+
+func (w *SwitchWrapper) SetOn(on bool) {
+	w.Switch.Switch.On.SetValue(on)
+}
+
+func (w *SwitchWrapper) OnValue() bool {
+	return w.Switch.Switch.On.Value()
+}
+
+func (w *SwitchWrapper) OnValueRemoteUpdate(f func(on bool)) {
+	w.Switch.Switch.On.OnValueRemoteUpdate(f)
+}
+
+func (w *SwitchWrapper) ID() uint64 {
+	return w.Id
+}
+
+// groupAccessory is the HomeKit switch of a group.
+type groupAccessory struct {
+	group plugs.Group
+	sw    *SwitchWrapper
+}
+
+// sceneAccessory is the HomeKit switch of a scene.
+type sceneAccessory struct {
+	scene plugs.Scene
+	sw    *SwitchWrapper
+}
+
+// SetGroups replaces the group and scene switches with the ones in cfg and
+// restarts the HAP server when they changed. Groups and scenes with homekit
+// set to false get no switch.
+func (hm *HAPManager) SetGroups(cfg *plugs.Config) {
+	groups := slices.DeleteFunc(slices.Clone(cfg.Groups), func(g plugs.Group) bool {
+		return g.HomeKit != nil && !*g.HomeKit
+	})
+	scenes := slices.DeleteFunc(slices.Clone(cfg.Scenes), func(s plugs.Scene) bool {
+		return s.HomeKit != nil && !*s.HomeKit
+	})
+
+	hm.mu.Lock()
+	current := make([]plugs.Group, 0, len(hm.groups))
+	for _, acc := range hm.groups {
+		current = append(current, acc.group)
+	}
+	currentScenes := make([]plugs.Scene, 0, len(hm.scenes))
+	for _, acc := range hm.scenes {
+		currentScenes = append(currentScenes, acc.scene)
+	}
+	if reflect.DeepEqual(current, groups) && reflect.DeepEqual(currentScenes, scenes) {
+		hm.mu.Unlock()
+		return
+	}
+
+	hm.groups = make([]*groupAccessory, 0, len(groups))
+	for _, group := range groups {
+		acc := &groupAccessory{group: group, sw: hm.newSwitch("group:"+group.ID, group.Name, "Group")}
+		acc.sw.OnValueRemoteUpdate(func(on bool) {
+			hm.handleRemoteGroup(group.ID, on)
+		})
+		hm.groups = append(hm.groups, acc)
+		slog.Info("Created HomeKit group switch", "group", group.ID, "name", group.Name, "plugs", len(group.Plugs))
+	}
+
+	hm.scenes = make([]*sceneAccessory, 0, len(scenes))
+	for _, scene := range scenes {
+		acc := &sceneAccessory{scene: scene, sw: hm.newSwitch("scene:"+scene.ID, scene.Name, "Scene")}
+		acc.sw.OnValueRemoteUpdate(func(on bool) {
+			hm.handleRemoteScene(acc, on)
+		})
+		hm.scenes = append(hm.scenes, acc)
+		slog.Info("Created HomeKit scene switch", "scene", scene.ID, "name", scene.Name, "plugs", len(scene.Plugs))
+	}
+	hm.updateGroups()
+	started := hm.server != nil
+	hm.mu.Unlock()
+
+	if started {
+		select {
+		case hm.restart <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// newSwitch creates a switch with an ID derived from serial, so it stays
+// stable across restarts and reloads.
+func (hm *HAPManager) newSwitch(serial, name, model string) *SwitchWrapper {
+	sw := NewSwitchWrapper(accessory.Info{
+		Name:         name,
+		Manufacturer: "Tasmota HomeKit",
+		Model:        model,
+		SerialNumber: serial,
+	})
+	sw.Id = hashString(serial)
+	return sw
+}
+
+// recordPlugState remembers the state of a plug for the groups it is in.
+// The caller holds hm.mu.
+func (hm *HAPManager) recordPlugState(event events.StateUpdateEvent) {
+	if hm.plugStates == nil {
+		hm.plugStates = make(map[string]events.StateUpdateEvent)
+	}
+	hm.plugStates[event.PlugID] = event
+	hm.updateGroups()
+}
+
+// updateGroups sets every group switch to the aggregated state of its
+// plugs. A group is unreachable while all its plugs are offline. The
+// caller holds hm.mu.
+func (hm *HAPManager) updateGroups() {
+	on := make(map[string]bool, len(hm.plugStates))
+	for id, event := range hm.plugStates {
+		on[id] = event.On
+	}
+
+	for _, acc := range hm.groups {
+		reachable := false
+		for _, id := range acc.group.Plugs {
+			if event, ok := hm.plugStates[id]; !ok || !event.Offline {
+				reachable = true
+				break
+			}
+		}
+		acc.sw.SetReachable(reachable)
+		acc.sw.SetOn(acc.group.On(on))
+	}
+}
+
+// handleRemoteGroup forwards a HomeKit change of a group switch to the plug
+// manager, which switches every plug of the group.
+func (hm *HAPManager) handleRemoteGroup(groupID string, on bool) {
+	slog.Info("HomeKit group command received", "group", groupID, "on", on)
+
+	hm.incomingCommands.Add(1)
+	hm.lastActivity.Store(time.Now().Unix())
+
+	hm.commands <- plugs.CommandEvent{
+		Group:  groupID,
+		On:     on,
+		Source: "homekit",
+	}
+}
+
+// handleRemoteScene applies a scene when its switch is turned on, and turns
+// the switch back off since a scene has no state of its own.
+func (hm *HAPManager) handleRemoteScene(acc *sceneAccessory, on bool) {
+	if !on {
+		return
+	}
+	slog.Info("HomeKit scene command received", "scene", acc.scene.ID)
+
+	hm.incomingCommands.Add(1)
+	hm.lastActivity.Store(time.Now().Unix())
+
+	hm.commands <- plugs.CommandEvent{
+		Scene:  acc.scene.ID,
+		Source: "homekit",
+	}
+	time.AfterFunc(sceneSwitchReset, func() {
+		acc.sw.SetOn(false)
+	})
+}
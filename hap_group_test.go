@@ -0,0 +1,67 @@
+package tasmotahomekit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHAPManagerGroups(t *testing.T) {
+	hidden := false
+	cfg := &plugs.Config{
+		Plugs: []plugs.Plug{
+			{ID: "lamp", Name: "Lamp", Address: "1"},
+			{ID: "tv", Name: "TV", Address: "2", HomeKit: &hidden},
+		},
+		Groups: []plugs.Group{
+			{ID: "downstairs", Name: "Downstairs", Plugs: []string{"lamp", "tv"}},
+			{ID: "everything", Name: "Everything", Plugs: []string{"lamp", "tv"}, Aggregate: plugs.GroupAll},
+			{ID: "hidden", Name: "Hidden", Plugs: []string{"lamp"}, HomeKit: &hidden},
+		},
+		Scenes: []plugs.Scene{
+			{ID: "movie", Name: "Movie", Plugs: []plugs.SceneTarget{{Plug: "lamp", Action: "off"}, {Plug: "tv", Action: "on"}}},
+		},
+	}
+
+	commands := make(chan plugs.CommandEvent, 1)
+	hm := NewHAPManager(cfg.Plugs, "Test Bridge", commands, nil, newTestEventsBus(t))
+	hm.SetGroups(cfg)
+
+	// Bridge, lamp, two groups and the scene
+	require.Len(t, hm.GetAccessories(), 5)
+	downstairs, everything := hm.groups[0].sw, hm.groups[1].sw
+
+	// Plugs hidden from HomeKit still count towards their groups
+	hm.UpdateState(events.StateUpdateEvent{PlugID: "tv", On: true})
+	require.True(t, downstairs.OnValue())
+	require.False(t, everything.OnValue())
+	hm.UpdateState(events.StateUpdateEvent{PlugID: "lamp", On: true})
+	require.True(t, everything.OnValue())
+
+	hm.UpdateState(events.StateUpdateEvent{PlugID: "lamp", Offline: true})
+	require.True(t, downstairs.Reachable())
+	hm.UpdateState(events.StateUpdateEvent{PlugID: "tv", Offline: true})
+	require.False(t, downstairs.Reachable())
+
+	hm.handleRemoteGroup("downstairs", false)
+	require.Equal(t, plugs.CommandEvent{Group: "downstairs", Source: "homekit"}, <-commands)
+
+	scene := hm.scenes[0]
+	scene.sw.SetOn(true)
+	hm.handleRemoteScene(scene, true)
+	require.Equal(t, plugs.CommandEvent{Scene: "movie", Source: "homekit"}, <-commands)
+	require.Eventually(t, func() bool { return !scene.sw.OnValue() }, 5*time.Second, 50*time.Millisecond)
+
+	info := hm.DebugInfo()
+	require.Len(t, info.Groups, 2)
+	require.Equal(t, plugs.GroupAny, info.Groups[0].Aggregate)
+	require.Len(t, info.Scenes, 1)
+	require.Equal(t, "Switch", info.Accessories[len(info.Accessories)-1].Type)
+
+	// Unchanged groups keep their accessories
+	hm.SetGroups(cfg)
+	require.True(t, downstairs == hm.groups[0].sw)
+}
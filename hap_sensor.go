@@ -0,0 +1,104 @@
+package tasmotahomekit
+
+import (
+	"log/slog"
+
+	"github.com/brutella/hap/accessory"
+	"github.com/brutella/hap/characteristic"
+	"github.com/brutella/hap/service"
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/kradalby/tasmota-homekit/plugs"
+)
+
+// sensorAccessory is the HomeKit accessory of a sensor attached to a plug,
+// with a service for each reading it exposes. Characteristics of readings
+// it does not expose are nil.
+type sensorAccessory struct {
+	sensor plugs.Sensor
+	a      *accessory.A
+	reachability
+
+	temperature *characteristic.CurrentTemperature
+	humidity    *characteristic.CurrentRelativeHumidity
+	pressure    *characteristic.Float
+	illuminance *characteristic.CurrentAmbientLightLevel
+}
+
+// buildSensors creates an accessory for every configured sensor of the
+// plugs enabled for HomeKit, by plug ID.
+func (hm *HAPManager) buildSensors(plugConfigs []plugs.Plug) map[string][]*sensorAccessory {
+	sensors := make(map[string][]*sensorAccessory)
+
+	for _, plug := range plugConfigs {
+		if plug.HomeKit != nil && !*plug.HomeKit {
+			continue
+		}
+
+		for _, sensor := range plug.Sensors {
+			acc := &sensorAccessory{
+				sensor: sensor,
+				a: accessory.New(accessory.Info{
+					Name:         plug.SensorName(sensor),
+					Manufacturer: "Tasmota",
+					Model:        sensor.Sensor,
+					SerialNumber: plug.ID + "-" + sensor.Sensor,
+				}, accessory.TypeSensor),
+			}
+			acc.a.Id = hashString("sensor:" + plug.ID + ":" + sensor.Sensor)
+
+			for _, kind := range sensor.Kinds() {
+				switch kind {
+				case plugs.SensorTemperature:
+					s := service.NewTemperatureSensor()
+					// Outdoor and freezer probes read below zero
+					s.CurrentTemperature.SetMinValue(-55)
+					s.CurrentTemperature.SetMaxValue(125)
+					acc.temperature = s.CurrentTemperature
+					acc.a.AddS(s.S)
+				case plugs.SensorHumidity:
+					s := service.NewHumiditySensor()
+					acc.humidity = s.CurrentRelativeHumidity
+					acc.a.AddS(s.S)
+				case plugs.SensorPressure:
+					s, pressure := NewEveAirPressureSensor()
+					acc.pressure = pressure
+					acc.a.AddS(s)
+				case plugs.SensorIlluminance:
+					s := service.NewLightSensor()
+					acc.illuminance = s.CurrentAmbientLightLevel
+					acc.a.AddS(s.S)
+				}
+			}
+			acc.guard(acc.a)
+
+			sensors[plug.ID] = append(sensors[plug.ID], acc)
+			slog.Info("Created HomeKit sensor", "plug_id", plug.ID, "sensor", sensor.Sensor, "readings", sensor.Kinds(), "id", acc.a.Id)
+		}
+	}
+
+	return sensors
+}
+
+// update sets the readings of the sensor from a plug state update. Readings
+// missing from the update keep their value.
+func (acc *sensorAccessory) update(event events.StateUpdateEvent) {
+	acc.SetReachable(!event.Offline)
+
+	readings, ok := event.Sensors[acc.sensor.Sensor]
+	if !ok {
+		return
+	}
+	if v, ok := readings[string(plugs.SensorTemperature)]; ok && acc.temperature != nil {
+		acc.temperature.SetValue(v)
+	}
+	if v, ok := readings[string(plugs.SensorHumidity)]; ok && acc.humidity != nil {
+		acc.humidity.SetValue(min(max(v, 0), 100))
+	}
+	if v, ok := readings[string(plugs.SensorPressure)]; ok && acc.pressure != nil {
+		acc.pressure.SetValue(v)
+	}
+	if v, ok := readings[string(plugs.SensorIlluminance)]; ok && acc.illuminance != nil {
+		// HomeKit's lowest light level is 0.0001 lux
+		acc.illuminance.SetValue(max(v, 0.0001))
+	}
+}
@@ -0,0 +1,55 @@
+package tasmotahomekit
+
+import (
+	"testing"
+
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHAPManagerSensors(t *testing.T) {
+	plugCfg := []plugs.Plug{{
+		ID:      "office",
+		Name:    "Office",
+		Address: "1",
+		Sensors: []plugs.Sensor{
+			{Sensor: "BME280", Name: "Office Climate"},
+			{Sensor: "BH1750"},
+			{Sensor: "DS18B20", Readings: []plugs.SensorKind{plugs.SensorTemperature}},
+		},
+	}}
+
+	commands := make(chan plugs.CommandEvent, 1)
+	hm := NewHAPManager(plugCfg, "Test Bridge", commands, nil, newTestEventsBus(t))
+
+	// Bridge, outlet and the three sensors
+	require.Len(t, hm.GetAccessories(), 5)
+	sensors := hm.sensors["office"]
+	require.Len(t, sensors, 3)
+	climate, light := sensors[0], sensors[1]
+	require.Equal(t, "Office Climate", climate.a.Info.Name.Value())
+	require.Equal(t, "Office BH1750", light.a.Info.Name.Value())
+	require.Equal(t, hashString("sensor:office:BME280"), climate.a.Id)
+	require.NotNil(t, climate.pressure)
+	require.Nil(t, climate.illuminance)
+	require.Len(t, climate.a.Ss, 4, "information, temperature, humidity and pressure")
+
+	hm.UpdateState(events.StateUpdateEvent{
+		PlugID: "office",
+		Sensors: map[string]map[string]float64{
+			"BME280": {"temperature": -3.5, "humidity": 41, "pressure": 1008.4},
+			"BH1750": {"illuminance": 0},
+		},
+	})
+	require.Equal(t, -3.5, climate.temperature.Value())
+	require.Equal(t, 41.0, climate.humidity.Value())
+	require.Equal(t, 1008.4, climate.pressure.Value())
+	require.Equal(t, 0.0001, light.illuminance.Value())
+
+	hm.UpdateState(events.StateUpdateEvent{PlugID: "office", Offline: true})
+	require.False(t, climate.Reachable())
+	require.Equal(t, -3.5, climate.temperature.Value(), "readings kept without an update")
+
+	require.Equal(t, "Sensor", hm.DebugInfo().Accessories[4].Type)
+}
@@ -7,12 +7,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/brutella/hap"
 	"github.com/brutella/hap/accessory"
 	"github.com/kradalby/tasmota-homekit/events"
 	"github.com/kradalby/tasmota-homekit/plugs"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"tailscale.com/util/eventbus"
 )
 
 func newTestEventsBus(t *testing.T) *events.Bus {
@@ -125,7 +125,7 @@ func TestHAPManagerAccessoryOrderStable(t *testing.T) {
 	}
 }
 
-func TestHAPManagerPublishesCommandEvents(t *testing.T) {
+func TestHAPManagerForwardsCommands(t *testing.T) {
 	plugCfg := []plugs.Plug{{
 		ID:      "plug-1",
 		Name:    "Desk Lamp",
@@ -135,24 +135,44 @@ func TestHAPManagerPublishesCommandEvents(t *testing.T) {
 	eventBus := newTestEventsBus(t)
 	hm := NewHAPManager(plugCfg, "Test Bridge", commands, nil, eventBus)
 
-	client, err := eventBus.Client(events.ClientHAP)
-	require.NoError(t, err)
-	sub := eventbus.Subscribe[events.CommandEvent](client)
-	t.Cleanup(sub.Close)
-
-	hm.publishCommand("plug-1", true)
+	hm.handleRemotePower("plug-1", 0, true)
 
 	select {
-	case evt := <-sub.Events():
-		require.Equal(t, "plug-1", evt.PlugID)
-		require.NotNil(t, evt.On)
-		require.True(t, *evt.On)
-		require.Equal(t, events.CommandTypeSetPower, evt.CommandType)
+	case cmd := <-commands:
+		require.Equal(t, "plug-1", cmd.PlugID)
+		require.True(t, cmd.On)
+		require.Equal(t, "homekit", cmd.Source)
 	case <-time.After(time.Second):
 		t.Fatal("expected command event")
 	}
 }
 
+func TestHAPManagerReportsOfflinePlugs(t *testing.T) {
+	plugCfg := []plugs.Plug{{
+		ID:      "plug-1",
+		Name:    "Desk Lamp",
+		Address: "1.2.3.4",
+	}}
+	commands := make(chan plugs.CommandEvent, 1)
+	eventBus := newTestEventsBus(t)
+	hm := NewHAPManager(plugCfg, "Test Bridge", commands, nil, eventBus)
+
+	outlet, ok := hm.accessories["plug-1"].(*OutletWrapper)
+	require.True(t, ok)
+	on := outlet.Outlet.Outlet.On.C
+
+	hm.UpdateState(events.StateUpdateEvent{PlugID: "plug-1", On: true, Offline: true})
+	require.False(t, outlet.Reachable())
+	_, status := on.ValueRequestFunc(nil)
+	require.Equal(t, hap.JsonStatusServiceCommunicationFailure, status)
+
+	hm.UpdateState(events.StateUpdateEvent{PlugID: "plug-1", On: true})
+	require.True(t, outlet.Reachable())
+	value, status := on.ValueRequestFunc(nil)
+	require.Equal(t, hap.JsonStatusSuccess, status)
+	require.Equal(t, true, value)
+}
+
 func TestHAPManagerCreatesBulb(t *testing.T) {
 	plugCfg := []plugs.Plug{{
 		ID:      "bulb-1",
@@ -227,3 +247,165 @@ func TestHAPManagerStats(t *testing.T) {
 		t.Error("expected lastActivity to be set")
 	}
 }
+
+func TestHAPManagerCreatesMultiRelayAccessory(t *testing.T) {
+	plugCfg := []plugs.Plug{{
+		ID:      "strip",
+		Name:    "Power Strip",
+		Address: "1.2.3.4",
+		Relays:  []plugs.Relay{{Name: "Desk"}, {Name: "Monitor"}, {}},
+	}}
+
+	commands := make(chan plugs.CommandEvent, 1)
+	eventBus := newTestEventsBus(t)
+	hm := NewHAPManager(plugCfg, "Test Bridge", commands, nil, eventBus)
+
+	multi, ok := hm.accessories["strip"].(*MultiRelayWrapper)
+	require.True(t, ok, "expected multi-relay accessory")
+	require.Equal(t, 3, multi.RelayCount())
+	require.Len(t, hm.GetAccessories(), 2)
+
+	hm.UpdateState(events.StateUpdateEvent{
+		PlugID: "strip",
+		On:     false,
+		Relays: []bool{false, true, true},
+	})
+
+	assert.False(t, multi.RelayOnValue(1))
+	assert.True(t, multi.RelayOnValue(2))
+	assert.True(t, multi.RelayOnValue(3))
+	require.Nil(t, multi.Meters)
+	assert.False(t, multi.inUse[0].Value())
+	assert.True(t, multi.inUse[1].Value(), "in use whenever on without power monitoring")
+
+	hm.handleRemotePower("strip", 2, false)
+
+	select {
+	case cmd := <-commands:
+		assert.Equal(t, "strip", cmd.PlugID)
+		assert.Equal(t, 2, cmd.Relay)
+		assert.False(t, cmd.On)
+	case <-time.After(time.Second):
+		t.Fatal("expected command event")
+	}
+}
+
+func TestHAPManagerColorBulb(t *testing.T) {
+	plugCfg := []plugs.Plug{{
+		ID:       "bulb-1",
+		Name:     "Hallway",
+		Address:  "1.2.3.4",
+		Type:     "bulb",
+		Features: &plugs.PlugFeatures{Color: true, ColorTemperature: true},
+	}}
+
+	commands := make(chan plugs.CommandEvent, 1)
+	eventBus := newTestEventsBus(t)
+	hm := NewHAPManager(plugCfg, "Test Bridge", commands, nil, eventBus)
+
+	bulb, ok := hm.accessories["bulb-1"].(*LightbulbWrapper)
+	require.True(t, ok, "expected lightbulb accessory")
+	require.NotNil(t, bulb.Brightness)
+	require.NotNil(t, bulb.Hue)
+	require.NotNil(t, bulb.Saturation)
+	require.NotNil(t, bulb.ColorTemperature)
+
+	hm.UpdateState(events.StateUpdateEvent{
+		PlugID:           "bulb-1",
+		On:               true,
+		Brightness:       70,
+		Hue:              120,
+		Saturation:       50,
+		ColorTemperature: 300,
+	})
+
+	assert.Equal(t, 70, bulb.Brightness.Value())
+	assert.InDelta(t, 120, bulb.Hue.Value(), 0.001)
+	assert.InDelta(t, 50, bulb.Saturation.Value(), 0.001)
+	assert.Equal(t, 300, bulb.ColorTemperature.Value())
+
+	hm.handleRemoteLight("bulb-1", plugs.LightSettings{Brightness: new(int)})
+
+	select {
+	case cmd := <-commands:
+		require.NotNil(t, cmd.Light)
+		require.NotNil(t, cmd.Light.Brightness)
+		assert.Equal(t, 0, *cmd.Light.Brightness)
+	case <-time.After(time.Second):
+		t.Fatal("expected light command")
+	}
+}
+
+func TestHAPManagerUpdatesEveMeters(t *testing.T) {
+	threshold := 5.0
+	plugCfg := []plugs.Plug{
+		{
+			ID:             "metered",
+			Name:           "Washer",
+			Address:        "1.2.3.4",
+			Features:       &plugs.PlugFeatures{PowerMonitoring: true},
+			InUseThreshold: &threshold,
+		},
+		{ID: "plain", Name: "Lamp", Address: "1.2.3.5"},
+	}
+
+	commands := make(chan plugs.CommandEvent, 1)
+	eventBus := newTestEventsBus(t)
+	hm := NewHAPManager(plugCfg, "Test Bridge", commands, nil, eventBus)
+
+	metered, ok := hm.accessories["metered"].(*OutletWrapper)
+	require.True(t, ok)
+	require.NotNil(t, metered.Meters)
+
+	plain, ok := hm.accessories["plain"].(*OutletWrapper)
+	require.True(t, ok)
+	require.Nil(t, plain.Meters)
+
+	hm.UpdateState(events.StateUpdateEvent{
+		PlugID:  "metered",
+		On:      true,
+		Power:   2.5,
+		Voltage: 231.4,
+		Current: 0.02,
+		Energy:  12.345,
+	})
+
+	assert.InDelta(t, 2.5, metered.Meters.Power.Value(), 0.001)
+	assert.InDelta(t, 231.4, metered.Meters.Voltage.Value(), 0.001)
+	assert.InDelta(t, 0.02, metered.Meters.Current.Value(), 0.001)
+	assert.InDelta(t, 12.345, metered.Meters.Energy.Value(), 0.001)
+	assert.False(t, metered.Outlet.Outlet.OutletInUse.Value(), "below threshold")
+
+	hm.UpdateState(events.StateUpdateEvent{PlugID: "metered", On: true, Power: 450})
+	assert.True(t, metered.Outlet.Outlet.OutletInUse.Value())
+
+	hm.UpdateState(events.StateUpdateEvent{PlugID: "plain", On: true})
+	assert.True(t, plain.Outlet.Outlet.OutletInUse.Value())
+}
+
+func TestHAPManagerMultiRelayMeters(t *testing.T) {
+	plugCfg := []plugs.Plug{{
+		ID:       "strip",
+		Name:     "Power Strip",
+		Address:  "1.2.3.4",
+		Features: &plugs.PlugFeatures{PowerMonitoring: true},
+		Relays:   []plugs.Relay{{Name: "Desk"}, {Name: "Monitor"}},
+	}}
+
+	commands := make(chan plugs.CommandEvent, 1)
+	eventBus := newTestEventsBus(t)
+	hm := NewHAPManager(plugCfg, "Test Bridge", commands, nil, eventBus)
+
+	multi, ok := hm.accessories["strip"].(*MultiRelayWrapper)
+	require.True(t, ok)
+	require.NotNil(t, multi.Meters)
+
+	hm.UpdateState(events.StateUpdateEvent{PlugID: "strip", Relays: []bool{false, true}, Power: 0.5})
+	assert.InDelta(t, 0.5, multi.Meters.Power.Value(), 0.001)
+	assert.False(t, multi.inUse[1].Value(), "below threshold")
+
+	hm.UpdateState(events.StateUpdateEvent{PlugID: "strip", Relays: []bool{false, true}, Power: 60, Energy: 1.5})
+	assert.InDelta(t, 1.5, multi.Meters.Energy.Value(), 0.001)
+	assert.False(t, multi.inUse[0].Value(), "relay 1 is off")
+	assert.True(t, multi.inUse[1].Value())
+}
@@ -0,0 +1,386 @@
+// Package homeassistant mirrors the plugs to Home Assistant through an
+// external MQTT broker: it republishes their state, announces them with
+// MQTT discovery and passes commands from Home Assistant to the plugs.
+package homeassistant
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"tailscale.com/util/eventbus"
+)
+
+// Source is the source of the commands the bridge sends.
+const Source = "homeassistant"
+
+// Component is the name the bridge reports its connection status under.
+const Component = "homeassistant"
+
+const (
+	keepalive    = 60 * time.Second
+	dialTimeout  = 10 * time.Second
+	minBackoff   = time.Second
+	maxBackoff   = time.Minute
+	payloadOn    = "ON"
+	payloadOff   = "OFF"
+	statusOnline = "online"
+	statusOff    = "offline"
+)
+
+// Options configure the connection to the external broker and its topics.
+type Options struct {
+	Addr     string      // host:port of the broker
+	TLS      *tls.Config // nil for a plaintext connection
+	Username string
+	Password string
+	ClientID string
+
+	// TopicPrefix is prepended to the state, availability and command
+	// topics; DiscoveryPrefix is the prefix Home Assistant watches for
+	// discovery configs.
+	TopicPrefix     string
+	DiscoveryPrefix string
+}
+
+// Bridge keeps an external broker up to date with the plugs. Topics, below
+// the topic prefix:
+//
+//	status                        online/offline of the bridge itself
+//	<plug>/state                  the plug's events.StateUpdateEvent as JSON
+//	<plug>/availability           online/offline of the plug
+//	<plug>/set                    ON/OFF from Home Assistant
+//	<plug>/relay/<n>/set          ON/OFF for a relay of a multi-relay plug
+//
+// All but the command topics are retained, so Home Assistant picks up the
+// current state when it restarts.
+type Bridge struct {
+	opts     Options
+	commands chan<- plugs.CommandEvent
+
+	eventBus *events.Bus
+	client   *eventbus.Client
+	stateSub *eventbus.Subscriber[events.StateUpdateEvent]
+
+	mu     sync.Mutex
+	plugs  map[string]plugs.Plug // by topic ID
+	states map[string]events.StateUpdateEvent
+	conn   *client
+	// announced holds the discovery topics published on the current
+	// connection, so those of removed plugs and outputs can be cleared
+	announced map[string]bool
+}
+
+// New returns a bridge for the plugs in cfg that sends commands from Home
+// Assistant to commands. Call Run to connect.
+func New(opts Options, cfg *plugs.Config, commands chan<- plugs.CommandEvent, bus *events.Bus) (*Bridge, error) {
+	if opts.Addr == "" {
+		return nil, fmt.Errorf("broker address is required")
+	}
+	if opts.TopicPrefix == "" || opts.DiscoveryPrefix == "" {
+		return nil, fmt.Errorf("topic and discovery prefixes are required")
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = "tasmota-homekit"
+	}
+
+	client, err := bus.Client(events.ClientHomeAssistant)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get homeassistant eventbus client: %w", err)
+	}
+
+	b := &Bridge{
+		opts:      opts,
+		commands:  commands,
+		eventBus:  bus,
+		client:    client,
+		stateSub:  eventbus.Subscribe[events.StateUpdateEvent](client),
+		plugs:     make(map[string]plugs.Plug),
+		states:    make(map[string]events.StateUpdateEvent),
+		announced: make(map[string]bool),
+	}
+	for _, plug := range cfg.Plugs {
+		b.plugs[topicID(plug.ID)] = plug
+	}
+	return b, nil
+}
+
+// SetPlugs announces added and changed plugs and withdraws removed ones.
+func (b *Bridge) SetPlugs(cfg *plugs.Config) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current := make(map[string]plugs.Plug, len(cfg.Plugs))
+	for _, plug := range cfg.Plugs {
+		current[topicID(plug.ID)] = plug
+	}
+	var removed []string
+	for id, plug := range b.plugs {
+		if _, ok := current[id]; !ok {
+			removed = append(removed, id)
+			delete(b.states, plug.ID)
+		}
+	}
+	b.plugs = current
+
+	if b.conn == nil {
+		return
+	}
+	for _, id := range removed {
+		// Empty retained messages delete the retained state
+		for _, topic := range []string{b.topic(id, "state"), b.topic(id, "availability")} {
+			b.publishLocked(message{Topic: topic, Retain: true})
+		}
+	}
+	b.announceLocked()
+}
+
+// Run connects to the broker and keeps the connection up until ctx is done,
+// reconnecting with backoff when it fails.
+func (b *Bridge) Run(ctx context.Context) {
+	defer b.stateSub.Close()
+
+	go b.consumeStates(ctx)
+
+	backoff := minBackoff
+	reconnects := 0
+	status := events.ConnectionStatusConnecting
+	for {
+		b.publishStatus(status, "", reconnects)
+
+		start := time.Now()
+		err := b.session(ctx, reconnects)
+		if ctx.Err() != nil {
+			b.publishStatus(events.ConnectionStatusDisconnected, "", reconnects)
+			return
+		}
+		slog.Warn("Home Assistant MQTT connection failed", "addr", b.opts.Addr, "error", err, "retry_in", backoff)
+		b.publishStatus(events.ConnectionStatusFailed, err.Error(), reconnects)
+
+		// A connection that held for a while starts over with a short wait
+		if time.Since(start) > maxBackoff {
+			backoff = minBackoff
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			b.publishStatus(events.ConnectionStatusDisconnected, "", reconnects)
+			return
+		}
+		backoff = min(backoff*2, maxBackoff)
+		reconnects++
+		status = events.ConnectionStatusReconnecting
+	}
+}
+
+// session runs one connection to the broker until it fails or ctx is done.
+func (b *Bridge) session(ctx context.Context, reconnects int) error {
+	dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	conn, err := dial(dialCtx, b.opts.Addr, b.opts.TLS, connectOptions{
+		ClientID:  b.opts.ClientID,
+		Username:  b.opts.Username,
+		Password:  b.opts.Password,
+		Keepalive: keepalive,
+		// Publishing holds b.mu, which a stalled broker must not block
+		WriteTimeout: dialTimeout,
+		Will:         &message{Topic: b.statusTopic(), Payload: []byte(statusOff), Retain: true},
+	})
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+
+	if err := conn.subscribe(b.topic("+", "set"), b.topic("+", "relay", "+", "set")); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.conn = conn
+	clear(b.announced)
+	b.publishLocked(message{Topic: b.statusTopic(), Payload: []byte(statusOnline), Retain: true})
+	b.announceLocked()
+	for _, id := range slices.Sorted(maps.Keys(b.plugs)) {
+		if state, ok := b.states[b.plugs[id].ID]; ok {
+			b.publishStateLocked(state)
+		}
+	}
+	b.mu.Unlock()
+
+	slog.Info("Connected to Home Assistant MQTT broker", "addr", b.opts.Addr, "topic_prefix", b.opts.TopicPrefix)
+	b.publishStatus(events.ConnectionStatusConnected, "", reconnects)
+
+	err = conn.run(ctx, func(msg message) {
+		b.handleCommand(ctx, msg)
+	})
+
+	b.mu.Lock()
+	b.conn = nil
+	b.mu.Unlock()
+
+	if err == nil && ctx.Err() == nil {
+		err = fmt.Errorf("connection closed by broker")
+	}
+	return err
+}
+
+func (b *Bridge) consumeStates(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.stateSub.Done():
+			return
+		case state := <-b.stateSub.Events():
+			b.mu.Lock()
+			if _, ok := b.plugs[topicID(state.PlugID)]; ok {
+				b.states[state.PlugID] = state
+				if b.conn != nil {
+					b.publishStateLocked(state)
+				}
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+// handleCommand passes an ON/OFF command from Home Assistant to the plug.
+func (b *Bridge) handleCommand(ctx context.Context, msg message) {
+	id, relay, ok := b.parseCommandTopic(msg.Topic)
+	if !ok {
+		slog.Debug("Ignoring Home Assistant message on unknown topic", "topic", msg.Topic)
+		return
+	}
+
+	b.mu.Lock()
+	plug, exists := b.plugs[id]
+	b.mu.Unlock()
+	if !exists {
+		slog.Warn("Ignoring Home Assistant command for unknown plug", "topic", msg.Topic)
+		return
+	}
+	if relay > plug.RelayCount() {
+		slog.Warn("Ignoring Home Assistant command for unknown relay", "plug_id", plug.ID, "relay", relay)
+		return
+	}
+
+	var on bool
+	switch strings.ToUpper(strings.TrimSpace(string(msg.Payload))) {
+	case payloadOn:
+		on = true
+	case payloadOff:
+		on = false
+	default:
+		slog.Warn("Ignoring Home Assistant command with unknown payload", "plug_id", plug.ID, "payload", string(msg.Payload))
+		return
+	}
+
+	slog.Info("Command from Home Assistant", "plug_id", plug.ID, "relay", relay, "on", on)
+	select {
+	case b.commands <- plugs.CommandEvent{PlugID: plug.ID, Relay: relay, On: on, Source: Source}:
+	case <-ctx.Done():
+	}
+}
+
+// parseCommandTopic returns the plug topic ID and relay, 0 for the whole
+// plug, of a command topic.
+func (b *Bridge) parseCommandTopic(topic string) (string, int, bool) {
+	rest, ok := strings.CutPrefix(topic, b.opts.TopicPrefix+"/")
+	if !ok {
+		return "", 0, false
+	}
+	parts := strings.Split(rest, "/")
+	switch {
+	case len(parts) == 2 && parts[1] == "set":
+		return parts[0], 0, true
+	case len(parts) == 4 && parts[1] == "relay" && parts[3] == "set":
+		relay, err := strconv.Atoi(parts[2])
+		if err != nil || relay < 1 {
+			return "", 0, false
+		}
+		return parts[0], relay, true
+	default:
+		return "", 0, false
+	}
+}
+
+func (b *Bridge) publishStateLocked(state events.StateUpdateEvent) {
+	id := topicID(state.PlugID)
+	payload, err := json.Marshal(state)
+	if err != nil {
+		slog.Warn("Failed to encode plug state for Home Assistant", "plug_id", state.PlugID, "error", err)
+		return
+	}
+	availability := statusOnline
+	if state.Offline {
+		availability = statusOff
+	}
+	b.publishLocked(message{Topic: b.topic(id, "state"), Payload: payload, Retain: true})
+	b.publishLocked(message{Topic: b.topic(id, "availability"), Payload: []byte(availability), Retain: true})
+}
+
+// announceLocked publishes the discovery configs of every plug and clears
+// the ones announced before that no longer apply.
+func (b *Bridge) announceLocked() {
+	current := make(map[string]bool)
+	for _, id := range slices.Sorted(maps.Keys(b.plugs)) {
+		for _, msg := range b.discoveryMessages(b.plugs[id]) {
+			current[msg.Topic] = true
+			b.publishLocked(msg)
+		}
+	}
+	for _, topic := range slices.Sorted(maps.Keys(b.announced)) {
+		if !current[topic] {
+			b.publishLocked(message{Topic: topic, Retain: true})
+		}
+	}
+	b.announced = current
+}
+
+// publishLocked sends msg on the current connection. Failures are logged;
+// the read loop notices a broken connection and reconnects.
+func (b *Bridge) publishLocked(msg message) {
+	if b.conn == nil {
+		return
+	}
+	if err := b.conn.publish(msg); err != nil {
+		slog.Debug("Failed to publish to Home Assistant MQTT broker", "topic", msg.Topic, "error", err)
+	}
+}
+
+func (b *Bridge) publishStatus(status events.ConnectionStatus, errMsg string, reconnects int) {
+	b.eventBus.PublishConnectionStatus(b.client, events.ConnectionStatusEvent{
+		Timestamp:  time.Now(),
+		Component:  Component,
+		Status:     status,
+		Error:      errMsg,
+		Reconnects: reconnects,
+	})
+}
+
+func (b *Bridge) topic(parts ...string) string {
+	return b.opts.TopicPrefix + "/" + strings.Join(parts, "/")
+}
+
+func (b *Bridge) statusTopic() string {
+	return b.topic("status")
+}
+
+var topicUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// topicID is the plug ID as used in topics and discovery object IDs, which
+// Home Assistant limits to letters, digits, underscores and hyphens.
+func topicID(plugID string) string {
+	return topicUnsafe.ReplaceAllString(plugID, "_")
+}
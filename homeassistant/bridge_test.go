@@ -0,0 +1,506 @@
+package homeassistant
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/kradalby/tasmota-homekit/plugs"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/util/eventbus"
+)
+
+// fakeBroker accepts one connection at a time and records what the client
+// publishes.
+type fakeBroker struct {
+	t        *testing.T
+	listener net.Listener
+	connects chan connectPacket
+	messages chan message
+	conns    chan net.Conn
+}
+
+type connectPacket struct {
+	ClientID, Username, Password string
+	WillTopic, WillPayload       string
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	b := &fakeBroker{
+		t:        t,
+		listener: listener,
+		connects: make(chan connectPacket, 10),
+		messages: make(chan message, 100),
+		conns:    make(chan net.Conn, 10),
+	}
+	go b.serve()
+	return b
+}
+
+func (b *fakeBroker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.t.Cleanup(func() { conn.Close() })
+		go b.handle(conn)
+	}
+}
+
+func (b *fakeBroker) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		header, body, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		switch header & 0xf0 {
+		case packetConnect:
+			b.connects <- decodeConnect(body)
+			_, _ = conn.Write([]byte{packetConnack, 2, 0, 0})
+			b.conns <- conn
+		case packetPublish:
+			msg, _, err := decodePublish(header, body)
+			if err != nil {
+				return
+			}
+			b.messages <- msg
+		case packetSubscribe:
+			_, _ = conn.Write([]byte{packetSuback, 4, body[0], body[1], 0, 0})
+		case packetPingreq:
+			_, _ = conn.Write([]byte{packetPingresp, 0})
+		case packetDisconnect:
+			return
+		}
+	}
+}
+
+func decodeConnect(body []byte) connectPacket {
+	// Protocol name, level, flags and keepalive come first
+	_, rest, _ := readString(body)
+	flags := rest[1]
+	rest = rest[4:]
+
+	var p connectPacket
+	p.ClientID, rest, _ = readString(rest)
+	if flags&0x04 != 0 {
+		p.WillTopic, rest, _ = readString(rest)
+		n := binary.BigEndian.Uint16(rest)
+		p.WillPayload, rest = string(rest[2:2+n]), rest[2+n:]
+	}
+	if flags&0x80 != 0 {
+		p.Username, rest, _ = readString(rest)
+	}
+	if flags&0x40 != 0 {
+		p.Password, _, _ = readString(rest)
+	}
+	return p
+}
+
+func publishPacket(t *testing.T, msg message) []byte {
+	t.Helper()
+	packet, err := encodePublish(msg)
+	require.NoError(t, err)
+	return packet
+}
+
+// collect reads messages until one arrives on topic, returning everything
+// received by then keyed by topic.
+func (b *fakeBroker) collect(topic string) map[string]message {
+	b.t.Helper()
+	received := make(map[string]message)
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-b.messages:
+			received[msg.Topic] = msg
+			if msg.Topic == topic {
+				return received
+			}
+		case <-timeout:
+			b.t.Fatalf("no message on %s, got %v", topic, received)
+		}
+	}
+}
+
+var (
+	desk = plugs.Plug{
+		ID:       "desk",
+		Name:     "Desk",
+		Model:    "Athom PG01",
+		Features: &plugs.PlugFeatures{PowerMonitoring: true},
+	}
+	strip = plugs.Plug{
+		ID:     "strip",
+		Name:   "Power Strip",
+		Relays: []plugs.Relay{{Name: "Monitor"}, {}},
+	}
+)
+
+func newTestBridge(t *testing.T, broker *fakeBroker) (*Bridge, *events.Bus, chan plugs.CommandEvent) {
+	t.Helper()
+	bus, err := events.New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	t.Cleanup(func() { bus.Close() })
+
+	commands := make(chan plugs.CommandEvent, 10)
+	bridge, err := New(Options{
+		Addr:            broker.listener.Addr().String(),
+		Username:        "homekit",
+		Password:        "secret",
+		ClientID:        "bridge-test",
+		TopicPrefix:     "tasmota-homekit",
+		DiscoveryPrefix: "homeassistant",
+	}, &plugs.Config{Plugs: []plugs.Plug{desk, strip}}, commands, bus)
+	require.NoError(t, err)
+	return bridge, bus, commands
+}
+
+func TestBridge(t *testing.T) {
+	broker := newFakeBroker(t)
+	bridge, bus, commands := newTestBridge(t, broker)
+
+	statusClient, err := bus.Client(events.ClientWeb)
+	require.NoError(t, err)
+	statusSub := eventbus.Subscribe[events.ConnectionStatusEvent](statusClient)
+	t.Cleanup(statusSub.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go bridge.Run(ctx)
+
+	select {
+	case connect := <-broker.connects:
+		require.Equal(t, connectPacket{
+			ClientID:    "bridge-test",
+			Username:    "homekit",
+			Password:    "secret",
+			WillTopic:   "tasmota-homekit/status",
+			WillPayload: "offline",
+		}, connect)
+	case <-time.After(5 * time.Second):
+		t.Fatal("bridge did not connect")
+	}
+
+	received := broker.collect("homeassistant/switch/strip_relay2/config")
+	require.Equal(t, "online", string(received["tasmota-homekit/status"].Payload))
+	for _, topic := range []string{
+		"homeassistant/switch/desk/config",
+		"homeassistant/sensor/desk_power/config",
+		"homeassistant/sensor/desk_energy/config",
+		"homeassistant/sensor/desk_voltage/config",
+		"homeassistant/sensor/desk_current/config",
+		"homeassistant/switch/strip_relay1/config",
+	} {
+		require.Contains(t, received, topic)
+		require.True(t, received[topic].Retain, topic)
+	}
+	require.NotContains(t, received, "homeassistant/sensor/strip_power/config")
+
+	var deskSwitch map[string]any
+	require.NoError(t, json.Unmarshal(received["homeassistant/switch/desk/config"].Payload, &deskSwitch))
+	require.Equal(t, "tasmota_homekit_desk", deskSwitch["unique_id"])
+	require.Nil(t, deskSwitch["name"])
+	require.Equal(t, "tasmota-homekit/desk/state", deskSwitch["state_topic"])
+	require.Equal(t, "tasmota-homekit/desk/set", deskSwitch["command_topic"])
+	require.Equal(t, "{{ 'ON' if value_json.on else 'OFF' }}", deskSwitch["value_template"])
+
+	var relay2 map[string]any
+	require.NoError(t, json.Unmarshal(received["homeassistant/switch/strip_relay2/config"].Payload, &relay2))
+	require.Equal(t, "Power Strip 2", relay2["name"])
+	require.Equal(t, "tasmota-homekit/strip/relay/2/set", relay2["command_topic"])
+	require.Equal(t, "{{ 'ON' if value_json.relays[1] else 'OFF' }}", relay2["value_template"])
+
+	var energy map[string]any
+	require.NoError(t, json.Unmarshal(received["homeassistant/sensor/desk_energy/config"].Payload, &energy))
+	require.Equal(t, "energy", energy["device_class"])
+	require.Equal(t, "total_increasing", energy["state_class"])
+	require.Equal(t, "kWh", energy["unit_of_measurement"])
+
+	require.Eventually(t, func() bool {
+		select {
+		case status := <-statusSub.Events():
+			return status.Component == Component && status.Status == events.ConnectionStatusConnected
+		default:
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// State updates are republished
+	plugClient, err := bus.Client(events.ClientPlugManager)
+	require.NoError(t, err)
+	bus.PublishStateUpdate(plugClient, events.StateUpdateEvent{PlugID: "desk", Name: "Desk", On: true, Power: 42.5})
+
+	received = broker.collect("tasmota-homekit/desk/availability")
+	state := received["tasmota-homekit/desk/state"]
+	require.True(t, state.Retain)
+	var event events.StateUpdateEvent
+	require.NoError(t, json.Unmarshal(state.Payload, &event))
+	require.True(t, event.On)
+	require.InDelta(t, 42.5, event.Power, 0.001)
+	require.Equal(t, "online", string(received["tasmota-homekit/desk/availability"].Payload))
+
+	// Commands from Home Assistant reach the plugs
+	var conn net.Conn
+	select {
+	case conn = <-broker.conns:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no broker connection")
+	}
+	_, err = conn.Write(publishPacket(t, message{Topic: "tasmota-homekit/strip/relay/2/set", Payload: []byte("ON")}))
+	require.NoError(t, err)
+	_, err = conn.Write(publishPacket(t, message{Topic: "tasmota-homekit/desk/set", Payload: []byte("off")}))
+	require.NoError(t, err)
+	// Unknown plugs, relays and payloads are dropped
+	for _, msg := range []message{
+		{Topic: "tasmota-homekit/lamp/set", Payload: []byte("ON")},
+		{Topic: "tasmota-homekit/strip/relay/3/set", Payload: []byte("ON")},
+		{Topic: "tasmota-homekit/desk/set", Payload: []byte("TOGGLE")},
+	} {
+		_, err = conn.Write(publishPacket(t, msg))
+		require.NoError(t, err)
+	}
+
+	for _, want := range []plugs.CommandEvent{
+		{PlugID: "strip", Relay: 2, On: true, Source: Source},
+		{PlugID: "desk", On: false, Source: Source},
+	} {
+		select {
+		case cmd := <-commands:
+			require.Equal(t, want, cmd)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no command %+v", want)
+		}
+	}
+	select {
+	case cmd := <-commands:
+		t.Fatalf("unexpected command %+v", cmd)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Removed plugs are withdrawn
+	bridge.SetPlugs(&plugs.Config{Plugs: []plugs.Plug{desk}})
+	received = broker.collect("homeassistant/switch/strip_relay2/config")
+	for _, topic := range []string{
+		"tasmota-homekit/strip/state",
+		"tasmota-homekit/strip/availability",
+		"homeassistant/switch/strip_relay1/config",
+		"homeassistant/switch/strip_relay2/config",
+	} {
+		require.Contains(t, received, topic)
+		require.Empty(t, received[topic].Payload, topic)
+		require.True(t, received[topic].Retain, topic)
+	}
+	require.NotEmpty(t, received["homeassistant/switch/desk/config"].Payload)
+
+	// Shutting down marks the bridge offline
+	cancel()
+	received = broker.collect("tasmota-homekit/status")
+	require.Equal(t, "offline", string(received["tasmota-homekit/status"].Payload))
+}
+
+func TestParseCommandTopic(t *testing.T) {
+	b := &Bridge{opts: Options{TopicPrefix: "home/tasmota"}}
+
+	tests := []struct {
+		topic string
+		id    string
+		relay int
+		ok    bool
+	}{
+		{topic: "home/tasmota/desk/set", id: "desk", ok: true},
+		{topic: "home/tasmota/strip/relay/3/set", id: "strip", relay: 3, ok: true},
+		{topic: "home/tasmota/strip/relay/0/set"},
+		{topic: "home/tasmota/strip/relay/x/set"},
+		{topic: "home/tasmota/desk/state"},
+		{topic: "other/desk/set"},
+	}
+	for _, tt := range tests {
+		id, relay, ok := b.parseCommandTopic(tt.topic)
+		require.Equal(t, tt.ok, ok, tt.topic)
+		require.Equal(t, tt.id, id, tt.topic)
+		require.Equal(t, tt.relay, relay, tt.topic)
+	}
+}
+
+func TestTopicID(t *testing.T) {
+	require.Equal(t, "living-room_lamp", topicID("living-room_lamp"))
+	require.Equal(t, "a_b_c", topicID("a/b+c"))
+	require.False(t, strings.ContainsAny(topicID("x#y z"), "#/+ "))
+}
+
+// TestClientAgainstBroker runs the client against the broker the bridge
+// embeds for the plugs.
+func TestClientAgainstBroker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	server := mqtt.New(&mqtt.Options{InlineClient: true})
+	require.NoError(t, server.AddHook(new(auth.AllowHook), nil))
+	require.NoError(t, server.AddListener(listeners.NewTCP(listeners.Config{ID: "tcp", Address: addr})))
+	require.NoError(t, server.Serve())
+	t.Cleanup(func() { _ = server.Close() })
+
+	published := make(chan message, 10)
+	require.NoError(t, server.Subscribe("ha/status", 1, func(_ *mqtt.Client, _ packets.Subscription, pk packets.Packet) {
+		published <- message{Topic: pk.TopicName, Payload: pk.Payload}
+	}))
+	// Retained before the client subscribes, so it arrives with the SUBACK
+	require.NoError(t, server.Publish("ha/desk/set", []byte("ON"), true, 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	dialCtx, dialCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer dialCancel()
+	c, err := dial(dialCtx, addr, nil, connectOptions{
+		ClientID:     "bridge-test",
+		Keepalive:    time.Minute,
+		WriteTimeout: 5 * time.Second,
+		Will:         &message{Topic: "ha/status", Payload: []byte("offline"), Retain: true},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.close() })
+	require.NoError(t, c.subscribe("ha/+/set"))
+
+	received := make(chan message, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.run(ctx, func(msg message) { received <- msg })
+	}()
+
+	next := func(ch chan message) message {
+		t.Helper()
+		select {
+		case msg := <-ch:
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message")
+			return message{}
+		}
+	}
+
+	msg := next(received)
+	require.Equal(t, "ha/desk/set", msg.Topic)
+	require.Equal(t, "ON", string(msg.Payload))
+
+	require.NoError(t, server.Publish("ha/strip/set", []byte("OFF"), false, 0))
+	msg = next(received)
+	require.Equal(t, "ha/strip/set", msg.Topic)
+	require.Equal(t, "OFF", string(msg.Payload))
+
+	require.NoError(t, c.publish(message{Topic: "ha/status", Payload: []byte("online"), Retain: true}))
+	require.Equal(t, "online", string(next(published).Payload))
+
+	// Shutting down publishes the will before disconnecting cleanly
+	cancel()
+	require.Equal(t, "offline", string(next(published).Payload))
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return")
+	}
+}
+
+func TestSubscribeWaitsForSuback(t *testing.T) {
+	conn, peer := net.Pipe()
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+	c := &client{conn: conn, r: bufio.NewReader(conn), writeTimeout: 5 * time.Second}
+
+	broker := func(codes ...byte) {
+		r := bufio.NewReader(peer)
+		header, body, err := readPacket(r)
+		if err != nil || header&0xf0 != packetSubscribe {
+			return
+		}
+		// A message and the SUBACK of another subscription come first
+		_, _ = peer.Write(publishPacket(t, message{Topic: "ha/desk/set", Payload: []byte("ON")}))
+		_, _ = peer.Write([]byte{packetSuback, 3, body[0], body[1] + 1, 0x80})
+		_, _ = peer.Write(append([]byte{packetSuback, byte(2 + len(codes)), body[0], body[1]}, codes...))
+	}
+
+	go broker(0)
+	require.NoError(t, c.subscribe("ha/+/set"))
+
+	go broker(0, 0x80)
+	require.ErrorContains(t, c.subscribe("ha/+/set", "ha/#"), "subscription refused")
+
+	// Messages that arrived while subscribing are passed on by run
+	ctx, cancel := context.WithCancel(context.Background())
+	var handled []message
+	go func() {
+		_, _ = io.Copy(io.Discard, peer)
+	}()
+	cancel()
+	_ = c.run(ctx, func(msg message) { handled = append(handled, msg) })
+	require.Len(t, handled, 2)
+	require.Equal(t, "ha/desk/set", handled[0].Topic)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	// Nothing reads the other end of the pipe, like a stalled broker
+	conn, peer := net.Pipe()
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+
+	c := &client{conn: conn, writeTimeout: 50 * time.Millisecond}
+	start := time.Now()
+	err := c.publish(message{Topic: "a/b", Payload: []byte("ON")})
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+
+	// The connection is closed rather than left with half a packet
+	require.ErrorIs(t, c.publish(message{Topic: "a/b"}), io.ErrClosedPipe)
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	payload := []byte(strings.Repeat("x", 300)) // needs a two-byte remaining length
+	packet := publishPacket(t, message{Topic: "a/b", Payload: payload, Retain: true})
+
+	header, body, err := readPacket(bufio.NewReader(strings.NewReader(string(packet))))
+	require.NoError(t, err)
+	msg, _, err := decodePublish(header, body)
+	require.NoError(t, err)
+	require.Equal(t, message{Topic: "a/b", Payload: payload, Retain: true}, msg)
+}
+
+func TestEncodeRejectsOversizedFields(t *testing.T) {
+	long := strings.Repeat("x", maxString+1)
+
+	_, err := encodePublish(message{Topic: long})
+	require.ErrorContains(t, err, "invalid topic")
+	_, err = encodeSubscribe(1, []string{"a/b", long})
+	require.ErrorContains(t, err, "invalid topic filter")
+	_, err = encodeConnect(connectOptions{ClientID: "bridge", Will: &message{Topic: "s", Payload: []byte(long)}})
+	require.ErrorContains(t, err, "invalid CONNECT")
+
+	// The largest string still fits
+	_, err = encodePublish(message{Topic: long[1:]})
+	require.NoError(t, err)
+}
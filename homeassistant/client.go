@@ -0,0 +1,441 @@
+package homeassistant
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types, shifted into the fixed header.
+const (
+	packetConnect    = 1 << 4
+	packetConnack    = 2 << 4
+	packetPublish    = 3 << 4
+	packetPuback     = 4 << 4
+	packetSubscribe  = 8 << 4
+	packetSuback     = 9 << 4
+	packetPingreq    = 12 << 4
+	packetPingresp   = 13 << 4
+	packetDisconnect = 14 << 4
+)
+
+// maxRemaining is the largest remaining length MQTT can encode, and
+// maxString the longest string or will payload.
+const (
+	maxRemaining = 268435455
+	maxString    = 65535
+)
+
+// message is a PUBLISH received from or sent to the broker.
+type message struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// connectOptions are the CONNECT fields the bridge uses.
+type connectOptions struct {
+	ClientID  string
+	Username  string
+	Password  string
+	Keepalive time.Duration
+	// WriteTimeout bounds every write and the wait for a SUBACK, so a
+	// stalled broker cannot block publishing; zero means no limit.
+	WriteTimeout time.Duration
+
+	// Will is published by the broker when the connection drops.
+	Will *message
+}
+
+// client is a minimal MQTT 3.1.1 client: QoS 0 publishing and subscribing,
+// which is all Home Assistant discovery needs.
+type client struct {
+	conn         net.Conn
+	r            *bufio.Reader
+	keepalive    time.Duration
+	writeTimeout time.Duration
+	will         *message
+
+	writeMu  sync.Mutex
+	packetID uint16
+
+	// Messages received by subscribe before its SUBACK, for run
+	early []message
+}
+
+// dial connects and sends CONNECT, returning once the broker accepted it.
+func dial(ctx context.Context, addr string, tlsConfig *tls.Config, opts connectOptions) (*client, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake failed: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	c := &client{conn: conn, r: bufio.NewReader(conn), keepalive: opts.Keepalive, writeTimeout: opts.WriteTimeout, will: opts.Will}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	connect, err := encodeConnect(opts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := c.write(connect); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	header, body, err := readPacket(c.r)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNACK: %w", err)
+	}
+	if header&0xf0 != packetConnack || len(body) != 2 {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected packet 0x%02x instead of CONNACK", header)
+	}
+	if code := body[1]; code != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("connection refused: %s", connackReason(code))
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	return c, nil
+}
+
+func connackReason(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad username or password"
+	case 5:
+		return "not authorized"
+	default:
+		return fmt.Sprintf("return code %d", code)
+	}
+}
+
+// publish sends a QoS 0 message.
+func (c *client) publish(msg message) error {
+	packet, err := encodePublish(msg)
+	if err != nil {
+		return err
+	}
+	return c.write(packet)
+}
+
+// subscribe subscribes to filters with QoS 0 and waits for the broker to
+// accept them. Call it before run; messages that arrive before the SUBACK
+// are passed on by run.
+func (c *client) subscribe(filters ...string) error {
+	c.writeMu.Lock()
+	c.packetID++
+	if c.packetID == 0 {
+		c.packetID = 1
+	}
+	id := c.packetID
+	c.writeMu.Unlock()
+
+	packet, err := encodeSubscribe(id, filters)
+	if err != nil {
+		return err
+	}
+	if err := c.write(packet); err != nil {
+		return err
+	}
+
+	if c.writeTimeout > 0 {
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.writeTimeout))
+		defer c.conn.SetReadDeadline(time.Time{})
+	}
+	for {
+		header, body, err := readPacket(c.r)
+		if err != nil {
+			return fmt.Errorf("failed to read SUBACK: %w", err)
+		}
+		switch header & 0xf0 {
+		case packetPublish:
+			msg, err := c.receive(header, body)
+			if err != nil {
+				return err
+			}
+			c.early = append(c.early, msg)
+		case packetSuback:
+			if len(body) < 2 || binary.BigEndian.Uint16(body) != id {
+				continue
+			}
+			for _, code := range body[2:] {
+				if code == 0x80 {
+					return errors.New("subscription refused by broker")
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// receive decodes a PUBLISH and acknowledges it if the broker sent it with
+// QoS 1.
+func (c *client) receive(header byte, body []byte) (message, error) {
+	msg, id, err := decodePublish(header, body)
+	if err != nil {
+		return message{}, err
+	}
+	if qos := (header >> 1) & 0x03; qos == 1 {
+		if err := c.write([]byte{packetPuback, 2, byte(id >> 8), byte(id)}); err != nil {
+			return message{}, err
+		}
+	}
+	return msg, nil
+}
+
+// run reads packets until the connection fails or ctx is done, passing
+// messages to handle and pinging the broker to keep the session alive.
+func (c *client) run(ctx context.Context, handle func(message)) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// A clean disconnect discards the will, so publish it first
+			if c.will != nil {
+				_ = c.publish(*c.will)
+			}
+			_ = c.write([]byte{packetDisconnect, 0})
+			c.conn.Close()
+		case <-done:
+		}
+	}()
+
+	if c.keepalive > 0 {
+		go func() {
+			ticker := time.NewTicker(c.keepalive / 2)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := c.write([]byte{packetPingreq, 0}); err != nil {
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	for _, msg := range c.early {
+		handle(msg)
+	}
+	c.early = nil
+
+	for {
+		if c.keepalive > 0 {
+			_ = c.conn.SetReadDeadline(time.Now().Add(c.keepalive * 3 / 2))
+		}
+		header, body, err := readPacket(c.r)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		switch header & 0xf0 {
+		case packetPublish:
+			msg, err := c.receive(header, body)
+			if err != nil {
+				return err
+			}
+			handle(msg)
+		case packetPingresp:
+		}
+	}
+}
+
+func (c *client) close() error {
+	return c.conn.Close()
+}
+
+func (c *client) write(packet []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.writeTimeout > 0 {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	if _, err := c.conn.Write(packet); err != nil {
+		// Part of the packet may have been sent; closing ends the session
+		// so it reconnects, and later writes fail right away
+		c.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// readPacket reads one control packet, returning its first header byte and
+// the bytes after the remaining length.
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// packet prefixes body with the fixed header.
+func packet(header byte, body []byte) ([]byte, error) {
+	if len(body) > maxRemaining {
+		return nil, fmt.Errorf("packet of %d bytes exceeds the MQTT maximum of %d", len(body), maxRemaining)
+	}
+	out := []byte{header}
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(out, body...), nil
+}
+
+func appendString(b []byte, s string) ([]byte, error) {
+	if len(s) > maxString {
+		return nil, fmt.Errorf("string of %d bytes exceeds the MQTT maximum of %d", len(s), maxString)
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...), nil
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errors.New("malformed string")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errors.New("malformed string")
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+func encodeConnect(opts connectOptions) ([]byte, error) {
+	flags := byte(0x02) // clean session
+	if opts.Will != nil {
+		flags |= 0x04
+		if opts.Will.Retain {
+			flags |= 0x20
+		}
+	}
+	if opts.Username != "" {
+		flags |= 0x80
+		if opts.Password != "" {
+			flags |= 0x40
+		}
+	}
+
+	body := []byte{0, 4, 'M', 'Q', 'T', 'T', 4, flags}
+	body = binary.BigEndian.AppendUint16(body, uint16(opts.Keepalive/time.Second))
+	fields := []string{opts.ClientID}
+	if opts.Will != nil {
+		// The will payload is encoded like a string
+		fields = append(fields, opts.Will.Topic, string(opts.Will.Payload))
+	}
+	if opts.Username != "" {
+		fields = append(fields, opts.Username)
+		if opts.Password != "" {
+			fields = append(fields, opts.Password)
+		}
+	}
+	for _, s := range fields {
+		var err error
+		if body, err = appendString(body, s); err != nil {
+			return nil, fmt.Errorf("invalid CONNECT: %w", err)
+		}
+	}
+	return packet(packetConnect, body)
+}
+
+func encodePublish(msg message) ([]byte, error) {
+	header := byte(packetPublish)
+	if msg.Retain {
+		header |= 0x01
+	}
+	body, err := appendString(nil, msg.Topic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid topic: %w", err)
+	}
+	return packet(header, append(body, msg.Payload...))
+}
+
+// decodePublish decodes a PUBLISH, returning its packet ID for QoS above 0.
+func decodePublish(header byte, body []byte) (message, uint16, error) {
+	topic, rest, err := readString(body)
+	if err != nil {
+		return message{}, 0, err
+	}
+	var id uint16
+	if (header>>1)&0x03 > 0 {
+		if len(rest) < 2 {
+			return message{}, 0, errors.New("malformed PUBLISH")
+		}
+		id = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+	return message{Topic: topic, Payload: rest, Retain: header&0x01 != 0}, id, nil
+}
+
+func encodeSubscribe(id uint16, filters []string) ([]byte, error) {
+	body := binary.BigEndian.AppendUint16(nil, id)
+	for _, filter := range filters {
+		var err error
+		if body, err = appendString(body, filter); err != nil {
+			return nil, fmt.Errorf("invalid topic filter: %w", err)
+		}
+		body = append(body, 0) // QoS 0
+	}
+	return packet(packetSubscribe|0x02, body)
+}
@@ -0,0 +1,126 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+)
+
+// device groups a plug's entities in Home Assistant.
+type device struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model,omitempty"`
+}
+
+type availability struct {
+	Topic string `json:"topic"`
+}
+
+// entityConfig is a Home Assistant MQTT discovery config for a switch or
+// sensor.
+type entityConfig struct {
+	Name              *string        `json:"name"` // null names the entity after its device
+	UniqueID          string         `json:"unique_id"`
+	ObjectID          string         `json:"object_id"`
+	StateTopic        string         `json:"state_topic"`
+	ValueTemplate     string         `json:"value_template"`
+	Availability      []availability `json:"availability"`
+	AvailabilityMode  string         `json:"availability_mode"`
+	Device            device         `json:"device"`
+	CommandTopic      string         `json:"command_topic,omitempty"`
+	PayloadOn         string         `json:"payload_on,omitempty"`
+	PayloadOff        string         `json:"payload_off,omitempty"`
+	StateOn           string         `json:"state_on,omitempty"`
+	StateOff          string         `json:"state_off,omitempty"`
+	DeviceClass       string         `json:"device_class,omitempty"`
+	StateClass        string         `json:"state_class,omitempty"`
+	UnitOfMeasurement string         `json:"unit_of_measurement,omitempty"`
+}
+
+// sensorEntities are the power readings announced for plugs with power
+// monitoring, by the StateUpdateEvent field they come from.
+var sensorEntities = []struct {
+	field, name, deviceClass, stateClass, unit string
+}{
+	{"power", "Power", "power", "measurement", "W"},
+	{"energy", "Energy", "energy", "total_increasing", "kWh"},
+	{"voltage", "Voltage", "voltage", "measurement", "V"},
+	{"current", "Current", "current", "measurement", "A"},
+}
+
+// discoveryMessages returns the retained discovery configs announcing a
+// plug: a switch per output and, with power monitoring, its readings.
+func (b *Bridge) discoveryMessages(plug plugs.Plug) []message {
+	id := topicID(plug.ID)
+	base := entityConfig{
+		StateTopic: b.topic(id, "state"),
+		Availability: []availability{
+			{Topic: b.statusTopic()},
+			{Topic: b.topic(id, "availability")},
+		},
+		AvailabilityMode: "all",
+		Device: device{
+			Identifiers:  []string{"tasmota_homekit_" + id},
+			Name:         plug.Name,
+			Manufacturer: "Tasmota",
+			Model:        plug.Model,
+		},
+	}
+
+	var msgs []message
+	add := func(component, objectID string, cfg entityConfig) {
+		cfg.UniqueID = "tasmota_homekit_" + objectID
+		cfg.ObjectID = objectID
+		payload, err := json.Marshal(cfg)
+		if err != nil {
+			slog.Warn("Failed to encode Home Assistant discovery config", "plug_id", plug.ID, "error", err)
+			return
+		}
+		msgs = append(msgs, message{
+			Topic:   fmt.Sprintf("%s/%s/%s/config", b.opts.DiscoveryPrefix, component, objectID),
+			Payload: payload,
+			Retain:  true,
+		})
+	}
+
+	switchConfig := func(commandTopic, valueTemplate string, name *string) entityConfig {
+		cfg := base
+		cfg.Name = name
+		cfg.CommandTopic = commandTopic
+		cfg.ValueTemplate = valueTemplate
+		cfg.PayloadOn, cfg.PayloadOff = payloadOn, payloadOff
+		cfg.StateOn, cfg.StateOff = payloadOn, payloadOff
+		return cfg
+	}
+	if plug.RelayCount() > 1 {
+		for relay := 1; relay <= plug.RelayCount(); relay++ {
+			name := plug.RelayName(relay)
+			add("switch", fmt.Sprintf("%s_relay%d", id, relay), switchConfig(
+				b.topic(id, "relay", fmt.Sprint(relay), "set"),
+				fmt.Sprintf("{{ 'ON' if value_json.relays[%d] else 'OFF' }}", relay-1),
+				&name,
+			))
+		}
+	} else {
+		add("switch", id, switchConfig(b.topic(id, "set"), "{{ 'ON' if value_json.on else 'OFF' }}", nil))
+	}
+
+	if plug.HasPowerMonitoring() {
+		for _, sensor := range sensorEntities {
+			cfg := base
+			name := sensor.name
+			cfg.Name = &name
+			cfg.ValueTemplate = fmt.Sprintf("{{ value_json.%s }}", sensor.field)
+			cfg.DeviceClass = sensor.deviceClass
+			cfg.StateClass = sensor.stateClass
+			cfg.UnitOfMeasurement = sensor.unit
+			add("sensor", id+"_"+sensor.field, cfg)
+		}
+	}
+
+	return msgs
+}
@@ -17,12 +17,33 @@ type Collector struct {
 	logger         *slog.Logger
 	statusSub      *eventbus.Subscriber[events.ConnectionStatusEvent]
 	commandSub     *eventbus.Subscriber[events.CommandEvent]
+	costSub        *eventbus.Subscriber[events.CostEvent]
+	stateSub       *eventbus.Subscriber[events.StateUpdateEvent]
+	configSub      *eventbus.Subscriber[events.ConfigChangedEvent]
 	statusGauge    *prometheus.GaugeVec
 	commandCounter *prometheus.CounterVec
-	ctx            context.Context
-	cancel         context.CancelFunc
-	shutdownOnce   sync.Once
-	workers        sync.WaitGroup
+	transportCount *prometheus.CounterVec
+	costCounter    *prometheus.CounterVec
+	sensorGauges   map[string]*prometheus.GaugeVec // by sensor kind
+	plugGauges     plugGauges
+	energyCounter  *prometheus.CounterVec
+	// lastEnergy is the last energy total each plug reported, only used by
+	// consumeStates
+	lastEnergy   map[string]float64
+	ctx          context.Context
+	cancel       context.CancelFunc
+	shutdownOnce sync.Once
+	workers      sync.WaitGroup
+}
+
+// plugGauges are the per-plug gauges set from every state update.
+type plugGauges struct {
+	power         *prometheus.GaugeVec
+	voltage       *prometheus.GaugeVec
+	current       *prometheus.GaugeVec
+	on            *prometheus.GaugeVec
+	lastSeen      *prometheus.GaugeVec
+	mqttConnected *prometheus.GaugeVec
 }
 
 // NewCollector wires eventbus subscribers into Prometheus metrics.
@@ -48,6 +69,9 @@ func NewCollector(ctx context.Context, logger *slog.Logger, bus *events.Bus, reg
 	collectorCtx, cancel := context.WithCancel(ctx)
 	statusSub := eventbus.Subscribe[events.ConnectionStatusEvent](client)
 	commandSub := eventbus.Subscribe[events.CommandEvent](client)
+	costSub := eventbus.Subscribe[events.CostEvent](client)
+	stateSub := eventbus.Subscribe[events.StateUpdateEvent](client)
+	configSub := eventbus.Subscribe[events.ConfigChangedEvent](client)
 
 	statusGauge := promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
 		Name: "tasmota_homekit_component_status",
@@ -59,19 +83,73 @@ func NewCollector(ctx context.Context, logger *slog.Logger, bus *events.Bus, reg
 		Help: "Total control commands by source and plug",
 	}, []string{"source", "plug_id", "command_type"})
 
+	transportCount := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "tasmota_homekit_command_transport_total",
+		Help: "Total control commands by plug and transport (mqtt, http, http_fallback)",
+	}, []string{"plug_id", "transport"})
+
+	costCounter := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "tasmota_homekit_plug_cost_total",
+		Help: "Total electricity cost by plug in the tariff currency",
+	}, []string{"plug_id", "currency"})
+
+	plugGauge := func(name, help string) *prometheus.GaugeVec {
+		return promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: name,
+			Help: help,
+		}, []string{"plug_id"})
+	}
+	gauges := plugGauges{
+		power:         plugGauge("tasmota_homekit_plug_power_watts", "Power drawn by a plug in watts"),
+		voltage:       plugGauge("tasmota_homekit_plug_voltage_volts", "Voltage measured by a plug"),
+		current:       plugGauge("tasmota_homekit_plug_current_amps", "Current drawn by a plug in amps"),
+		on:            plugGauge("tasmota_homekit_plug_on", "Whether a plug is on (1) or off (0), by its default output"),
+		lastSeen:      plugGauge("tasmota_homekit_plug_last_seen_timestamp", "Unix time in seconds a plug was last heard from"),
+		mqttConnected: plugGauge("tasmota_homekit_plug_mqtt_connected", "Whether a plug holds a session with the embedded broker (1) or not (0)"),
+	}
+
+	energyCounter := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "tasmota_homekit_plug_energy_kwh_total",
+		Help: "Total energy used by a plug in kWh, continuing across device counter resets",
+	}, []string{"plug_id"})
+
+	sensorGauges := make(map[string]*prometheus.GaugeVec)
+	for _, sensor := range []struct{ kind, name, help string }{
+		{"temperature", "tasmota_homekit_sensor_temperature_celsius", "Temperature reported by a sensor attached to a plug"},
+		{"humidity", "tasmota_homekit_sensor_humidity_percent", "Relative humidity reported by a sensor attached to a plug"},
+		{"pressure", "tasmota_homekit_sensor_pressure_hpa", "Air pressure reported by a sensor attached to a plug"},
+		{"illuminance", "tasmota_homekit_sensor_illuminance_lux", "Illuminance reported by a sensor attached to a plug"},
+	} {
+		sensorGauges[sensor.kind] = promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: sensor.name,
+			Help: sensor.help,
+		}, []string{"plug_id", "sensor"})
+	}
+
 	c := &Collector{
 		logger:         logger,
 		statusSub:      statusSub,
 		commandSub:     commandSub,
+		costSub:        costSub,
+		stateSub:       stateSub,
+		configSub:      configSub,
 		statusGauge:    statusGauge,
 		commandCounter: commandCounter,
+		transportCount: transportCount,
+		costCounter:    costCounter,
+		sensorGauges:   sensorGauges,
+		plugGauges:     gauges,
+		energyCounter:  energyCounter,
+		lastEnergy:     make(map[string]float64),
 		ctx:            collectorCtx,
 		cancel:         cancel,
 	}
 
-	c.workers.Add(2)
+	c.workers.Add(4)
 	go c.consumeStatuses()
 	go c.consumeCommands()
+	go c.consumeCosts()
+	go c.consumeStates()
 
 	logger.Info("metrics collector started")
 
@@ -88,6 +166,15 @@ func (c *Collector) Close() {
 		if c.commandSub != nil {
 			c.commandSub.Close()
 		}
+		if c.costSub != nil {
+			c.costSub.Close()
+		}
+		if c.stateSub != nil {
+			c.stateSub.Close()
+		}
+		if c.configSub != nil {
+			c.configSub.Close()
+		}
 		c.workers.Wait()
 		c.logger.Info("metrics collector stopped")
 	})
@@ -117,6 +204,112 @@ func (c *Collector) consumeCommands() {
 	}
 }
 
+func (c *Collector) consumeCosts() {
+	defer c.workers.Done()
+	for {
+		select {
+		case evt := <-c.costSub.Events():
+			c.costCounter.WithLabelValues(evt.PlugID, evt.Currency).Add(evt.Cost)
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// consumeStates handles plug state updates and configuration changes in one
+// goroutine, so series of removed plugs are not recreated by a late update.
+func (c *Collector) consumeStates() {
+	defer c.workers.Done()
+	for {
+		select {
+		case evt := <-c.stateSub.Events():
+			c.observeState(evt)
+		case evt := <-c.configSub.Events():
+			for _, plugID := range evt.Removed {
+				c.forgetPlug(plugID)
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// observeState sets the plug and sensor gauges from a plug state.
+func (c *Collector) observeState(evt events.StateUpdateEvent) {
+	c.plugGauges.power.WithLabelValues(evt.PlugID).Set(evt.Power)
+	c.plugGauges.voltage.WithLabelValues(evt.PlugID).Set(evt.Voltage)
+	c.plugGauges.current.WithLabelValues(evt.PlugID).Set(evt.Current)
+	c.plugGauges.on.WithLabelValues(evt.PlugID).Set(boolValue(evt.On))
+	c.plugGauges.mqttConnected.WithLabelValues(evt.PlugID).Set(boolValue(evt.MQTTConnected))
+	if !evt.LastSeen.IsZero() {
+		c.plugGauges.lastSeen.WithLabelValues(evt.PlugID).Set(float64(evt.LastSeen.Unix()))
+	}
+	c.observeEnergy(evt.PlugID, evt.Energy)
+
+	for sensor, readings := range evt.Sensors {
+		for kind, value := range readings {
+			if gauge, ok := c.sensorGauges[kind]; ok {
+				gauge.WithLabelValues(evt.PlugID, sensor).Set(value)
+			}
+		}
+	}
+}
+
+// observeEnergy adds what a plug used since its last reported energy total.
+// A total lower than the last means the device counter was reset, and all of
+// it was used since. Zero totals come from plugs without energy monitoring
+// and from status replies without readings, and are skipped.
+func (c *Collector) observeEnergy(plugID string, total float64) {
+	if total <= 0 {
+		return
+	}
+	counter := c.energyCounter.WithLabelValues(plugID)
+	last, seen := c.lastEnergy[plugID]
+	switch {
+	case !seen:
+		// Start from the device total, so restarting the bridge does not
+		// look like a reset
+		counter.Add(total)
+	case total >= last:
+		counter.Add(total - last)
+	default:
+		c.logger.Info("energy counter reset on plug", slog.String("plug_id", plugID), slog.Float64("last", last), slog.Float64("total", total))
+		counter.Add(total)
+	}
+	c.lastEnergy[plugID] = total
+}
+
+// forgetPlug removes every series of a plug that was removed from the
+// configuration.
+func (c *Collector) forgetPlug(plugID string) {
+	labels := prometheus.Labels{"plug_id": plugID}
+	for _, vec := range []*prometheus.GaugeVec{
+		c.plugGauges.power,
+		c.plugGauges.voltage,
+		c.plugGauges.current,
+		c.plugGauges.on,
+		c.plugGauges.lastSeen,
+		c.plugGauges.mqttConnected,
+	} {
+		vec.DeletePartialMatch(labels)
+	}
+	for _, vec := range c.sensorGauges {
+		vec.DeletePartialMatch(labels)
+	}
+	for _, vec := range []*prometheus.CounterVec{c.energyCounter, c.costCounter, c.commandCounter, c.transportCount} {
+		vec.DeletePartialMatch(labels)
+	}
+	delete(c.lastEnergy, plugID)
+	c.logger.Info("removed metrics of plug", slog.String("plug_id", plugID))
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 func (c *Collector) observeStatus(evt events.ConnectionStatusEvent) {
 	for _, status := range []events.ConnectionStatus{
 		events.ConnectionStatusDisconnected,
@@ -147,4 +340,8 @@ func (c *Collector) observeCommand(evt events.CommandEvent) {
 		plugID = "unknown"
 	}
 	c.commandCounter.WithLabelValues(source, plugID, commandType).Inc()
+
+	if evt.Transport != "" {
+		c.transportCount.WithLabelValues(plugID, string(evt.Transport)).Inc()
+	}
 }
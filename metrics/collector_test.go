@@ -55,6 +55,7 @@ func TestCollectorObservesEvents(t *testing.T) {
 		Source:      "web",
 		PlugID:      "plug-1",
 		CommandType: events.CommandTypeSetPower,
+		Transport:   events.TransportMQTT,
 	}
 	bus.PublishCommand(componentClient, cmd)
 
@@ -62,6 +63,98 @@ func TestCollectorObservesEvents(t *testing.T) {
 		value := counterValue(collector.commandCounter.WithLabelValues("web", "plug-1", string(events.CommandTypeSetPower)))
 		return value == 1.0
 	}, time.Second, 20*time.Millisecond, "expected command counter to increment")
+	require.Eventually(t, func() bool {
+		value := counterValue(collector.transportCount.WithLabelValues("plug-1", string(events.TransportMQTT)))
+		return value == 1.0
+	}, time.Second, 20*time.Millisecond, "expected transport counter to increment")
+
+	energyClient, err := bus.Client(events.ClientEnergy)
+	require.NoError(t, err)
+	for _, cost := range []float64{0.25, 0.5} {
+		bus.PublishCost(energyClient, events.CostEvent{
+			Timestamp: time.Now(),
+			PlugID:    "plug-1",
+			Window:    "peak",
+			Currency:  "EUR",
+			Energy:    1,
+			Cost:      cost,
+		})
+	}
+
+	require.Eventually(t, func() bool {
+		value := counterValue(collector.costCounter.WithLabelValues("plug-1", "EUR"))
+		return value == 0.75
+	}, time.Second, 20*time.Millisecond, "expected cost counter to increase")
+
+	plugClient, err := bus.Client(events.ClientPlugManager)
+	require.NoError(t, err)
+	bus.PublishStateUpdate(plugClient, events.StateUpdateEvent{
+		Timestamp: time.Now(),
+		PlugID:    "plug-1",
+		Sensors:   map[string]map[string]float64{"BME280": {"temperature": 21.5, "pressure": 1013.2}},
+	})
+
+	require.Eventually(t, func() bool {
+		return gaugeValue(collector.sensorGauges["temperature"].WithLabelValues("plug-1", "BME280")) == 21.5 &&
+			gaugeValue(collector.sensorGauges["pressure"].WithLabelValues("plug-1", "BME280")) == 1013.2
+	}, time.Second, 20*time.Millisecond, "expected sensor gauges to update")
+}
+
+func TestCollectorObservesPlugState(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bus := newTestBus(t)
+	reg := prometheus.NewRegistry()
+
+	collector, err := NewCollector(ctx, testLogger(), bus, reg)
+	require.NoError(t, err)
+	defer collector.Close()
+
+	plugClient, err := bus.Client(events.ClientPlugManager)
+	require.NoError(t, err)
+	lastSeen := time.Unix(1741025100, 0)
+	publish := func(energy float64) {
+		bus.PublishStateUpdate(plugClient, events.StateUpdateEvent{
+			Timestamp:     time.Now(),
+			PlugID:        "plug-1",
+			On:            true,
+			Power:         120.5,
+			Voltage:       230,
+			Current:       0.52,
+			Energy:        energy,
+			MQTTConnected: true,
+			LastSeen:      lastSeen,
+		})
+	}
+	energy := func() float64 {
+		return counterValue(collector.energyCounter.WithLabelValues("plug-1"))
+	}
+
+	publish(10)
+	require.Eventually(t, func() bool { return energy() == 10 }, time.Second, 20*time.Millisecond, "expected energy to start at the device total")
+	require.Equal(t, 120.5, gaugeValue(collector.plugGauges.power.WithLabelValues("plug-1")))
+	require.Equal(t, 230.0, gaugeValue(collector.plugGauges.voltage.WithLabelValues("plug-1")))
+	require.Equal(t, 0.52, gaugeValue(collector.plugGauges.current.WithLabelValues("plug-1")))
+	require.Equal(t, 1.0, gaugeValue(collector.plugGauges.on.WithLabelValues("plug-1")))
+	require.Equal(t, 1.0, gaugeValue(collector.plugGauges.mqttConnected.WithLabelValues("plug-1")))
+	require.Equal(t, 1741025100.0, gaugeValue(collector.plugGauges.lastSeen.WithLabelValues("plug-1")))
+
+	publish(12.5)
+	require.Eventually(t, func() bool { return energy() == 12.5 }, time.Second, 20*time.Millisecond, "expected energy to increase")
+
+	// Missing readings are skipped, and a reset device counter keeps counting
+	publish(0)
+	publish(0.5)
+	require.Eventually(t, func() bool { return energy() == 13 }, time.Second, 20*time.Millisecond, "expected energy to continue after a reset")
+
+	configClient, err := bus.Client(events.ClientConfig)
+	require.NoError(t, err)
+	bus.PublishConfigChanged(configClient, events.ConfigChangedEvent{Timestamp: time.Now(), Removed: []string{"plug-1"}})
+
+	// Reading a deleted series recreates it at zero
+	require.Eventually(t, func() bool { return energy() == 0 }, time.Second, 20*time.Millisecond, "expected removed plug series to be deleted")
+	require.Equal(t, 0.0, gaugeValue(collector.plugGauges.power.WithLabelValues("plug-1")))
 }
 
 func gaugeValue(g prometheus.Gauge) float64 {
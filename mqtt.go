@@ -5,8 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/kradalby/tasmota-homekit/plugs"
@@ -39,6 +43,51 @@ func getLocalIP() (string, error) {
 type MQTTHook struct {
 	mqtt.HookBase
 	statePublisher *eventbus.Publisher[plugs.StateChangedEvent]
+	// buttonPublisher announces presses of buttons decoupled from the relays
+	buttonPublisher *eventbus.Publisher[plugs.ButtonEvent]
+	// topics resolves topics to plugs by their FullTopic; without it the
+	// default %prefix%/tasmota/<id>/ layout is assumed
+	topics topicResolver
+
+	// Broker sessions of plugs by client ID, learned from the will topic
+	mu       sync.Mutex
+	sessions map[string]mqttSession
+
+	// lastMessage is when a plug last published, in Unix nanoseconds
+	lastMessage atomic.Int64
+}
+
+// mqttSession ties a broker client to the plug it belongs to.
+type mqttSession struct {
+	plugID string
+	client *mqtt.Client
+}
+
+// topicResolver maps MQTT topics to plugs, implemented by *plugs.Manager.
+type topicResolver interface {
+	ResolveTopic(topic string) (plugs.TopicMatch, bool)
+}
+
+// resolve returns the plug a tele/... or stat/... topic belongs to.
+func (h *MQTTHook) resolve(topic string) (plugs.TopicMatch, bool) {
+	var match plugs.TopicMatch
+	if h.topics != nil {
+		var ok bool
+		if match, ok = h.topics.ResolveTopic(topic); !ok {
+			return plugs.TopicMatch{}, false
+		}
+	} else {
+		parts := strings.SplitN(topic, "/", 4)
+		if len(parts) < 4 || parts[1] != "tasmota" {
+			return plugs.TopicMatch{}, false
+		}
+		match = plugs.TopicMatch{PlugID: parts[2], Prefix: parts[0], Suffix: parts[3]}
+	}
+
+	if match.Prefix != plugs.PrefixTelemetry && match.Prefix != plugs.PrefixStatus {
+		return plugs.TopicMatch{}, false
+	}
+	return match, true
 }
 
 // ID returns the hook identifier
@@ -56,10 +105,26 @@ func (h *MQTTHook) Provides(b byte) bool {
 	}, []byte{b})
 }
 
-// OnConnect is called when a client connects
+// OnConnect is called when a client connects. Tasmota sets its will to
+// tele/<topic>/LWT, which identifies the plug behind the client.
 func (h *MQTTHook) OnConnect(cl *mqtt.Client, pk packets.Packet) error {
 	clientID := cl.ID
-	slog.Info("MQTT client connected", "client_id", clientID)
+	match, ok := h.resolve(pk.Connect.WillTopic)
+	slog.Info("MQTT client connected", "client_id", clientID, "plug_id", match.PlugID)
+
+	if !ok || match.Suffix != "LWT" {
+		return nil
+	}
+	plugID := match.PlugID
+
+	h.mu.Lock()
+	if h.sessions == nil {
+		h.sessions = make(map[string]mqttSession)
+	}
+	h.sessions[clientID] = mqttSession{plugID: plugID, client: cl}
+	h.mu.Unlock()
+
+	h.publishAvailability(plugID, true)
 	return nil
 }
 
@@ -69,9 +134,49 @@ func (h *MQTTHook) OnDisconnect(cl *mqtt.Client, err error, expire bool) {
 
 	slog.Info("MQTT client disconnected", "client_id", clientID, "error", err, "expire", expire)
 
-	// Try to find which plug this was and mark as disconnected
-	// Since we can't easily map client ID to plug ID, we'll rely on LastSeen timeouts
-	// to determine connection status
+	// A device reconnecting with the same client ID takes over the session
+	// before the old one is closed; only the current client counts
+	h.mu.Lock()
+	session, ok := h.sessions[clientID]
+	if ok && session.client == cl {
+		delete(h.sessions, clientID)
+	}
+	h.mu.Unlock()
+
+	if ok && session.client == cl {
+		slog.Warn("Plug disconnected from MQTT", "plug_id", session.plugID)
+		h.publishAvailability(session.plugID, false)
+	}
+}
+
+// publishAvailability marks a plug online or offline.
+func (h *MQTTHook) publishAvailability(plugID string, online bool) {
+	state := plugs.State{
+		ID:            plugID,
+		MQTTConnected: online,
+		Offline:       !online,
+	}
+	fields := []string{"MQTTConnected", "Offline"}
+	if online {
+		state.LastSeen = time.Now()
+		fields = append(fields, "LastSeen")
+	}
+
+	h.statePublisher.Publish(plugs.StateChangedEvent{
+		PlugID:        plugID,
+		State:         state,
+		UpdatedFields: fields,
+	})
+}
+
+// LastMessage returns when a plug last published to the broker, zero if
+// none has yet.
+func (h *MQTTHook) LastMessage() time.Time {
+	nanos := h.lastMessage.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
 }
 
 // OnPublish is called when a message is received from a client
@@ -86,22 +191,28 @@ func (h *MQTTHook) OnPublish(cl *mqtt.Client, pk packets.Packet) (packets.Packet
 		"payload", string(payload),
 	)
 
-	// Parse topic to extract plug ID
-	// Topics are typically: tele/tasmota/<plug-id>/STATE or stat/tasmota/<plug-id>/RESULT
-	parts := strings.Split(topic, "/")
-	if len(parts) < 3 {
+	// Topics are typically: tele/tasmota/<plug-id>/STATE or stat/tasmota/<plug-id>/RESULT,
+	// other FullTopic layouts are resolved through the plug configuration
+	match, ok := h.resolve(topic)
+	if !ok {
 		return pk, nil
 	}
-
-	// Extract plug ID from topic
-	var plugID string
-	if parts[0] == "tele" || parts[0] == "stat" {
-		if len(parts) >= 3 {
-			plugID = parts[2]
-		}
+	plugID := match.PlugID
+	// The broker publishes the Offline will itself, it is not a message
+	// from the plug
+	if match.Suffix != "LWT" || string(payload) != "Offline" {
+		h.lastMessage.Store(time.Now().UnixNano())
 	}
 
-	if plugID == "" {
+	// Tasmota publishes a plain Online on connect; the broker publishes
+	// the Offline will when the connection drops
+	if match.Suffix == "LWT" {
+		switch string(payload) {
+		case "Online":
+			h.publishAvailability(plugID, true)
+		case "Offline":
+			h.publishAvailability(plugID, false)
+		}
 		return pk, nil
 	}
 
@@ -112,13 +223,24 @@ func (h *MQTTHook) OnPublish(cl *mqtt.Client, pk packets.Packet) (packets.Packet
 		return pk, nil
 	}
 
-	// Check for power state
-	var powerState string
-	if power, ok := msg["POWER"].(string); ok {
-		powerState = power
-	} else if result, ok := msg["StatusSTS"].(map[string]interface{}); ok {
-		if power, ok := result["POWER"].(string); ok {
-			powerState = power
+	// Button presses, reported once SetOption73 decouples the buttons
+	if actions := plugs.ParseButtonActions(msg); len(actions) > 0 && h.buttonPublisher != nil {
+		for _, button := range slices.Sorted(maps.Keys(actions)) {
+			slog.Info("Button pressed", "plug_id", plugID, "button", button, "action", actions[button])
+			h.buttonPublisher.Publish(plugs.ButtonEvent{
+				PlugID: plugID,
+				Button: button,
+				Action: actions[button],
+			})
+		}
+	}
+
+	// Check for power state, POWER for single relay devices and
+	// POWER1..POWERn for multi-relay devices
+	relays := plugs.ParsePowerStates(msg)
+	if len(relays) == 0 {
+		if result, ok := msg["StatusSTS"].(map[string]interface{}); ok {
+			relays = plugs.ParsePowerStates(result)
 		}
 	}
 
@@ -132,15 +254,25 @@ func (h *MQTTHook) OnPublish(cl *mqtt.Client, pk packets.Packet) (packets.Packet
 	}
 
 	// Update power state if present
-	if powerState != "" {
-		partialState.On = powerState == "ON"
+	for _, relay := range slices.Sorted(maps.Keys(relays)) {
+		on := relays[relay]
+		partialState.SetRelay(relay, on)
 		slog.Info(
 			"Plug state updated from MQTT",
 			"plug_id", plugID,
-			"on", partialState.On,
+			"relay", relay,
+			"on", on,
 		)
 	}
 
+	// Light attributes (Dimmer, HSBColor, CT) from bulbs and LED controllers
+	lightFields := plugs.ParseLightState(msg, &partialState)
+	if len(lightFields) == 0 {
+		if result, ok := msg["StatusSTS"].(map[string]interface{}); ok {
+			lightFields = plugs.ParseLightState(result, &partialState)
+		}
+	}
+
 	// Parse electrical stats from ENERGY field (from SENSOR telemetry)
 	var energy map[string]interface{}
 	if e, ok := msg["ENERGY"].(map[string]interface{}); ok {
@@ -164,6 +296,12 @@ func (h *MQTTHook) OnPublish(cl *mqtt.Client, pk packets.Packet) (packets.Packet
 		if total, ok := energy["Total"].(float64); ok {
 			partialState.Energy = total
 		}
+		if today, ok := energy["Today"].(float64); ok {
+			partialState.EnergyToday = today
+		}
+		if yesterday, ok := energy["Yesterday"].(float64); ok {
+			partialState.EnergyYesterday = yesterday
+		}
 
 		slog.Debug(
 			"Electrical stats updated from MQTT",
@@ -175,7 +313,20 @@ func (h *MQTTHook) OnPublish(cl *mqtt.Client, pk packets.Packet) (packets.Packet
 		)
 	}
 
-	if powerState == "" && partialState.Power == 0 && partialState.Voltage == 0 {
+	// Readings of other sensors, e.g. DS18B20 or BME280 blocks in SENSOR
+	// telemetry
+	sensors := plugs.ParseSensorReadings(msg)
+	if len(sensors) == 0 {
+		if sns, ok := msg["StatusSNS"].(map[string]interface{}); ok {
+			sensors = plugs.ParseSensorReadings(sns)
+		}
+	}
+	if len(sensors) > 0 {
+		partialState.Sensors = sensors
+		slog.Debug("Sensor readings updated from MQTT", "plug_id", plugID, "sensors", len(sensors))
+	}
+
+	if len(relays) == 0 && partialState.Power == 0 && partialState.Voltage == 0 {
 		slog.Debug(
 			"Plug connection tracked via MQTT",
 			"plug_id", plugID,
@@ -185,18 +336,25 @@ func (h *MQTTHook) OnPublish(cl *mqtt.Client, pk packets.Packet) (packets.Packet
 
 	// Publish to eventbus - PlugManager will merge with its state
 	var updatedFields []string
-	if powerState != "" {
+	if _, ok := relays[1]; ok {
 		updatedFields = append(updatedFields, "On")
 	}
+	for _, relay := range slices.Sorted(maps.Keys(relays)) {
+		updatedFields = append(updatedFields, plugs.RelayField(relay))
+	}
+	updatedFields = append(updatedFields, lightFields...)
 	if _, ok := msg["ENERGY"]; ok {
-		updatedFields = append(updatedFields, "Power", "Voltage", "Current", "Energy")
+		updatedFields = append(updatedFields, "Power", "Voltage", "Current", "Energy", "EnergyToday", "EnergyYesterday")
 	} else if sns, ok := msg["StatusSNS"].(map[string]interface{}); ok {
 		if _, ok := sns["ENERGY"]; ok {
-			updatedFields = append(updatedFields, "Power", "Voltage", "Current", "Energy")
+			updatedFields = append(updatedFields, "Power", "Voltage", "Current", "Energy", "EnergyToday", "EnergyYesterday")
 		}
 	}
-	// Always update connectivity fields
-	updatedFields = append(updatedFields, "MQTTConnected", "LastSeen", "LastUpdated")
+	if len(sensors) > 0 {
+		updatedFields = append(updatedFields, "Sensors")
+	}
+	// Always update connectivity fields; any message means the plug is online
+	updatedFields = append(updatedFields, "MQTTConnected", "Offline", "LastSeen", "LastUpdated")
 
 	h.statePublisher.Publish(plugs.StateChangedEvent{
 		PlugID:        plugID,
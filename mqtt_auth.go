@@ -0,0 +1,156 @@
+package tasmotahomekit
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// mqttAccount is a broker login with the topic filters it may use.
+type mqttAccount struct {
+	password string
+	read     []string
+	write    []string
+}
+
+// MQTTAuthHook authenticates broker clients against the plug credentials and
+// restricts each plug to its own topics. Clients without a matching account
+// are refused; the bridge's inline client is always allowed.
+type MQTTAuthHook struct {
+	mqtt.HookBase
+	accounts atomic.Pointer[map[string]mqttAccount]
+}
+
+// NewMQTTAuthHook creates the hook with the accounts of cfg.
+func NewMQTTAuthHook(cfg *plugs.Config) *MQTTAuthHook {
+	h := &MQTTAuthHook{}
+	h.Update(cfg)
+	return h
+}
+
+// Update replaces the accounts, e.g. after the plugs configuration was
+// reloaded. Connected clients keep their session but are checked against
+// the new ACLs from now on.
+func (h *MQTTAuthHook) Update(cfg *plugs.Config) {
+	accounts := make(map[string]mqttAccount, len(cfg.Plugs)+len(cfg.MQTTUsers))
+
+	for _, plug := range cfg.Plugs {
+		username, password, ok := plug.MQTTCredentials()
+		if !ok {
+			slog.Warn("Plug has no MQTT credentials and cannot connect to the broker", "plug_id", plug.ID)
+			continue
+		}
+		accounts[username] = mqttAccount{
+			password: password,
+			read:     []string{plug.TopicFor(plugs.PrefixCommand) + "#"},
+			write: []string{
+				plug.TopicFor(plugs.PrefixTelemetry) + "#",
+				plug.TopicFor(plugs.PrefixStatus) + "#",
+				// Discovery announcements are keyed by MAC, which is not
+				// known up front
+				"tasmota/discovery/#",
+			},
+		}
+	}
+
+	for _, account := range cfg.MQTTUsers {
+		accounts[account.Username] = mqttAccount{
+			password: account.Password,
+			read:     account.Read,
+			write:    account.Write,
+		}
+	}
+
+	h.accounts.Store(&accounts)
+}
+
+// ID returns the hook identifier
+func (h *MQTTAuthHook) ID() string {
+	return "tasmota-auth-hook"
+}
+
+// Provides returns the hook methods this hook provides
+func (h *MQTTAuthHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnConnectAuthenticate,
+		mqtt.OnACLCheck,
+	}, []byte{b})
+}
+
+// OnConnectAuthenticate accepts clients whose username and password match an account.
+func (h *MQTTAuthHook) OnConnectAuthenticate(cl *mqtt.Client, pk packets.Packet) bool {
+	username := string(pk.Connect.Username)
+	account, ok := h.account(username)
+	if !ok || subtle.ConstantTimeCompare(pk.Connect.Password, []byte(account.password)) != 1 {
+		slog.Warn("MQTT client rejected", "client_id", cl.ID, "username", username, "remote", cl.Net.Remote)
+		return false
+	}
+	return true
+}
+
+// OnACLCheck allows a client to use topics matching its account's filters.
+func (h *MQTTAuthHook) OnACLCheck(cl *mqtt.Client, topic string, write bool) bool {
+	if cl.Net.Inline {
+		return true
+	}
+
+	account, ok := h.account(string(cl.Properties.Username))
+	if !ok {
+		return false
+	}
+
+	filters := account.read
+	if write {
+		filters = account.write
+	}
+	for _, filter := range filters {
+		if topicMatches(filter, topic) {
+			return true
+		}
+	}
+
+	slog.Debug("MQTT topic denied", "client_id", cl.ID, "topic", topic, "write", write)
+	return false
+}
+
+func (h *MQTTAuthHook) account(username string) (mqttAccount, bool) {
+	accounts := h.accounts.Load()
+	if accounts == nil || username == "" {
+		return mqttAccount{}, false
+	}
+	account, ok := (*accounts)[username]
+	return account, ok
+}
+
+// topicMatches reports whether an MQTT topic, or a subscription filter, is
+// covered by filter. A subscription filter is only covered when every topic
+// it can match is.
+func topicMatches(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		switch {
+		case topicLevels[i] == "#":
+			return false
+		case level == "+":
+			continue
+		case topicLevels[i] == "+" || level != topicLevels[i]:
+			return false
+		}
+	}
+
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,96 @@
+package tasmotahomekit
+
+import (
+	"testing"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func testMQTTClient(username string) *mqtt.Client {
+	return &mqtt.Client{
+		ID:         "client-" + username,
+		Properties: mqtt.ClientProperties{Username: []byte(username)},
+	}
+}
+
+func connectPacket(username, password string) packets.Packet {
+	return packets.Packet{Connect: packets.ConnectParams{
+		Username: []byte(username),
+		Password: []byte(password),
+	}}
+}
+
+func TestMQTTAuthHookAuthenticates(t *testing.T) {
+	hook := NewMQTTAuthHook(&plugs.Config{
+		Plugs: []plugs.Plug{
+			{ID: "plug-1", MQTTPassword: "pw1"},
+			{ID: "plug-2"},
+		},
+		MQTTUsers: []plugs.MQTTAccount{{Username: "admin", Password: "root", Read: []string{"#"}}},
+	})
+
+	require.True(t, hook.OnConnectAuthenticate(testMQTTClient("plug-1"), connectPacket("plug-1", "pw1")))
+	require.False(t, hook.OnConnectAuthenticate(testMQTTClient("plug-1"), connectPacket("plug-1", "wrong")))
+	require.False(t, hook.OnConnectAuthenticate(testMQTTClient("plug-2"), connectPacket("plug-2", "")))
+	require.False(t, hook.OnConnectAuthenticate(testMQTTClient(""), connectPacket("", "")))
+	require.True(t, hook.OnConnectAuthenticate(testMQTTClient("admin"), connectPacket("admin", "root")))
+
+	// Credentials change on reload
+	hook.Update(&plugs.Config{Plugs: []plugs.Plug{{ID: "plug-1", MQTTPassword: "rotated"}}})
+	require.False(t, hook.OnConnectAuthenticate(testMQTTClient("plug-1"), connectPacket("plug-1", "pw1")))
+	require.True(t, hook.OnConnectAuthenticate(testMQTTClient("plug-1"), connectPacket("plug-1", "rotated")))
+}
+
+func TestMQTTAuthHookACL(t *testing.T) {
+	hook := NewMQTTAuthHook(&plugs.Config{
+		Plugs: []plugs.Plug{{ID: "plug-1", MQTTPassword: "pw1"}},
+		MQTTUsers: []plugs.MQTTAccount{
+			{Username: "viewer", Password: "pw", Read: []string{"tele/#", "stat/#"}},
+		},
+	})
+	plug := testMQTTClient("plug-1")
+
+	require.True(t, hook.OnACLCheck(plug, "stat/tasmota/plug-1/RESULT", true))
+	require.True(t, hook.OnACLCheck(plug, "tele/tasmota/plug-1/LWT", true))
+	require.True(t, hook.OnACLCheck(plug, "tasmota/discovery/A4CF12ABCDEF/config", true))
+	require.True(t, hook.OnACLCheck(plug, "cmnd/tasmota/plug-1/#", false))
+
+	// Spoofing another plug or listening to everything is refused
+	require.False(t, hook.OnACLCheck(plug, "stat/tasmota/plug-2/RESULT", true))
+	require.False(t, hook.OnACLCheck(plug, "cmnd/tasmota/plug-1/POWER", true))
+	require.False(t, hook.OnACLCheck(plug, "#", false))
+	require.False(t, hook.OnACLCheck(plug, "cmnd/tasmota/+/#", false))
+
+	viewer := testMQTTClient("viewer")
+	require.True(t, hook.OnACLCheck(viewer, "tele/#", false))
+	require.False(t, hook.OnACLCheck(viewer, "cmnd/tasmota/plug-1/POWER", true))
+
+	inline := &mqtt.Client{Net: mqtt.ClientConnection{Inline: true}}
+	require.True(t, hook.OnACLCheck(inline, "cmnd/tasmota/plug-1/POWER", true))
+}
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"cmnd/tasmota/a/#", "cmnd/tasmota/a/POWER", true},
+		{"cmnd/tasmota/a/#", "cmnd/tasmota/a", true},
+		{"cmnd/tasmota/a/#", "cmnd/tasmota/b/POWER", false},
+		{"tasmota/discovery/+/config", "tasmota/discovery/ABC/config", true},
+		{"tasmota/discovery/+/config", "tasmota/discovery/ABC/sensors", false},
+		{"tele/+/STATE", "tele/+/STATE", true},
+		{"tele/a/STATE", "tele/+/STATE", false},
+		{"tele/a/#", "tele/a/+", true},
+		{"tele/a/+", "tele/a/#", false},
+	}
+
+	for _, tt := range tests {
+		if got := topicMatches(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("topicMatches(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package tasmotahomekit
 
 import (
+	"slices"
 	"testing"
 	"time"
 
 	"github.com/kradalby/tasmota-homekit/plugs"
+	mqtt "github.com/mochi-mqtt/server/v2"
 	"github.com/mochi-mqtt/server/v2/packets"
 	"tailscale.com/util/eventbus"
 )
@@ -76,3 +78,223 @@ func TestMQTTHookParsesTelemetryState(t *testing.T) {
 		t.Fatal("expected event from telemetry topic")
 	}
 }
+
+func TestMQTTHookParsesMultiRelayState(t *testing.T) {
+	bus := eventbus.New()
+	pubClient := bus.Client("publisher")
+	subClient := bus.Client("subscriber")
+
+	hook := &MQTTHook{
+		statePublisher: eventbus.Publish[plugs.StateChangedEvent](pubClient),
+	}
+
+	sub := eventbus.Subscribe[plugs.StateChangedEvent](subClient)
+	t.Cleanup(sub.Close)
+
+	pk := packets.Packet{
+		TopicName: "stat/tasmota/strip/RESULT",
+		Payload:   []byte(`{"POWER2":"ON"}`),
+	}
+
+	if _, err := hook.OnPublish(nil, pk); err != nil {
+		t.Fatalf("OnPublish() error = %v", err)
+	}
+
+	select {
+	case evt := <-sub.Events():
+		if !evt.State.RelayOn(2) {
+			t.Fatalf("expected relay 2 on")
+		}
+		if evt.State.On {
+			t.Fatalf("expected relay 1 untouched")
+		}
+		if !slices.Contains(evt.UpdatedFields, plugs.RelayField(2)) || slices.Contains(evt.UpdatedFields, "On") {
+			t.Fatalf("unexpected updated fields: %v", evt.UpdatedFields)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected state event")
+	}
+}
+
+func TestMQTTHookTracksAvailability(t *testing.T) {
+	bus := eventbus.New()
+	pubClient := bus.Client("publisher")
+	subClient := bus.Client("subscriber")
+
+	hook := &MQTTHook{
+		statePublisher: eventbus.Publish[plugs.StateChangedEvent](pubClient),
+	}
+
+	sub := eventbus.Subscribe[plugs.StateChangedEvent](subClient)
+	t.Cleanup(sub.Close)
+
+	next := func() plugs.StateChangedEvent {
+		t.Helper()
+		select {
+		case evt := <-sub.Events():
+			return evt
+		case <-time.After(time.Second):
+			t.Fatal("expected state event")
+			return plugs.StateChangedEvent{}
+		}
+	}
+
+	if !hook.LastMessage().IsZero() {
+		t.Fatal("expected no message before any was published")
+	}
+
+	cl := &mqtt.Client{ID: "DVES_123456"}
+	connect := packets.Packet{}
+	connect.Connect.WillTopic = "tele/tasmota/plug-1/LWT"
+	if err := hook.OnConnect(cl, connect); err != nil {
+		t.Fatalf("OnConnect() error = %v", err)
+	}
+	if evt := next(); evt.PlugID != "plug-1" || evt.State.Offline || !evt.State.MQTTConnected {
+		t.Fatalf("expected plug-1 online on connect, got %+v", evt)
+	}
+
+	// A stale client with the same ID going away must not mark the plug offline
+	hook.OnDisconnect(&mqtt.Client{ID: "DVES_123456"}, nil, false)
+	hook.OnDisconnect(cl, nil, false)
+	if evt := next(); evt.PlugID != "plug-1" || !evt.State.Offline || evt.State.MQTTConnected {
+		t.Fatalf("expected plug-1 offline on disconnect, got %+v", evt)
+	}
+
+	if _, err := hook.OnPublish(nil, packets.Packet{
+		TopicName: "tele/tasmota/plug-1/LWT",
+		Payload:   []byte("Online"),
+	}); err != nil {
+		t.Fatalf("OnPublish() error = %v", err)
+	}
+	evt := next()
+	if evt.State.Offline || !slices.Contains(evt.UpdatedFields, "Offline") {
+		t.Fatalf("expected plug-1 online after LWT Online, got %+v", evt)
+	}
+	heard := hook.LastMessage()
+	if heard.IsZero() {
+		t.Fatal("expected LWT Online to count as a message from the plug")
+	}
+
+	if _, err := hook.OnPublish(nil, packets.Packet{
+		TopicName: "tele/tasmota/plug-1/LWT",
+		Payload:   []byte("Offline"),
+	}); err != nil {
+		t.Fatalf("OnPublish() error = %v", err)
+	}
+	if evt := next(); !evt.State.Offline {
+		t.Fatalf("expected plug-1 offline after LWT Offline, got %+v", evt)
+	}
+	if got := hook.LastMessage(); !got.Equal(heard) {
+		t.Fatalf("expected the broker's Offline will not to count as a message, last message %v, want %v", got, heard)
+	}
+}
+
+// staticTopics resolves topics against a fixed plug list.
+type staticTopics []plugs.Plug
+
+func (s staticTopics) ResolveTopic(topic string) (plugs.TopicMatch, bool) {
+	return plugs.ResolveTopic(s, topic)
+}
+
+func TestMQTTHookResolvesCustomFullTopic(t *testing.T) {
+	bus := eventbus.New()
+	pubClient := bus.Client("publisher")
+	subClient := bus.Client("subscriber")
+
+	hook := &MQTTHook{
+		statePublisher: eventbus.Publish[plugs.StateChangedEvent](pubClient),
+		topics:         staticTopics{{ID: "kitchen", Topic: "kitchen_plug", FullTopic: "%topic%/%prefix%/"}},
+	}
+
+	sub := eventbus.Subscribe[plugs.StateChangedEvent](subClient)
+	t.Cleanup(sub.Close)
+
+	// Unknown plugs and the default layout are ignored
+	for _, topic := range []string{"stat/tasmota/kitchen/RESULT", "kitchen_plug/cmnd/POWER"} {
+		if _, err := hook.OnPublish(nil, packets.Packet{TopicName: topic, Payload: []byte(`{"POWER":"ON"}`)}); err != nil {
+			t.Fatalf("OnPublish() error = %v", err)
+		}
+	}
+	if _, err := hook.OnPublish(nil, packets.Packet{
+		TopicName: "kitchen_plug/stat/RESULT",
+		Payload:   []byte(`{"POWER":"ON"}`),
+	}); err != nil {
+		t.Fatalf("OnPublish() error = %v", err)
+	}
+
+	select {
+	case evt := <-sub.Events():
+		if evt.PlugID != "kitchen" || !evt.State.On {
+			t.Fatalf("unexpected event %+v", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected state event")
+	}
+}
+
+func TestMQTTHookPublishesButtonPresses(t *testing.T) {
+	bus := eventbus.New()
+	pubClient := bus.Client("publisher")
+	subClient := bus.Client("subscriber")
+
+	hook := &MQTTHook{
+		statePublisher:  eventbus.Publish[plugs.StateChangedEvent](pubClient),
+		buttonPublisher: eventbus.Publish[plugs.ButtonEvent](pubClient),
+	}
+
+	sub := eventbus.Subscribe[plugs.ButtonEvent](subClient)
+	t.Cleanup(sub.Close)
+
+	pk := packets.Packet{
+		TopicName: "stat/tasmota/hall/RESULT",
+		Payload:   []byte(`{"Button2":{"Action":"DOUBLE"}}`),
+	}
+
+	if _, err := hook.OnPublish(nil, pk); err != nil {
+		t.Fatalf("OnPublish() error = %v", err)
+	}
+
+	select {
+	case evt := <-sub.Events():
+		want := plugs.ButtonEvent{PlugID: "hall", Button: 2, Action: plugs.ButtonDouble}
+		if evt != want {
+			t.Fatalf("unexpected button event: %+v", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected button event")
+	}
+}
+
+func TestMQTTHookParsesSensorTelemetry(t *testing.T) {
+	bus := eventbus.New()
+	pubClient := bus.Client("publisher")
+	subClient := bus.Client("subscriber")
+
+	hook := &MQTTHook{
+		statePublisher: eventbus.Publish[plugs.StateChangedEvent](pubClient),
+	}
+
+	sub := eventbus.Subscribe[plugs.StateChangedEvent](subClient)
+	t.Cleanup(sub.Close)
+
+	pk := packets.Packet{
+		TopicName: "tele/tasmota/office/SENSOR",
+		Payload:   []byte(`{"Time":"2025-03-03T18:05:00","AM2301":{"Temperature":22.1,"Humidity":40.2},"TempUnit":"C"}`),
+	}
+
+	if _, err := hook.OnPublish(nil, pk); err != nil {
+		t.Fatalf("OnPublish() error = %v", err)
+	}
+
+	select {
+	case evt := <-sub.Events():
+		if got := evt.State.Sensors["AM2301"][plugs.SensorHumidity]; got != 40.2 {
+			t.Fatalf("humidity = %v; want 40.2", got)
+		}
+		if !slices.Contains(evt.UpdatedFields, "Sensors") {
+			t.Fatalf("unexpected updated fields: %v", evt.UpdatedFields)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected state event")
+	}
+}
@@ -0,0 +1,201 @@
+package tasmotahomekit
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Files of the generated self-signed PKI, relative to the TLS directory.
+const (
+	mqttCACertFile     = "ca.pem"
+	mqttCAKeyFile      = "ca-key.pem"
+	mqttServerCertFile = "server.pem"
+	mqttServerKeyFile  = "server-key.pem"
+)
+
+// Tasmota's BearSSL only does RSA, and at most 2048 bit keys.
+const mqttTLSKeyBits = 2048
+
+// LoadMQTTTLS returns the TLS configuration for the broker's TLS listener and
+// the Tasmota fingerprint of its certificate. Without certPath and keyPath a
+// self-signed CA and server certificate for hosts are created in dir, or
+// reused if they exist.
+func LoadMQTTTLS(certPath, keyPath, dir string, hosts []string) (*tls.Config, string, error) {
+	if certPath == "" {
+		var err error
+		certPath, keyPath, err = ensureSelfSignedMQTTCert(dir, hosts)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load MQTT TLS certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse MQTT TLS certificate: %w", err)
+	}
+
+	fingerprint := ""
+	if pub, ok := leaf.PublicKey.(*rsa.PublicKey); ok {
+		fingerprint = TasmotaFingerprint(pub)
+	} else {
+		slog.Warn("MQTT TLS certificate is not RSA, Tasmota devices cannot connect to it", "cert", certPath)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		// Tasmota's TLS client only speaks TLS 1.2
+		MinVersion: tls.VersionTLS12,
+	}, fingerprint, nil
+}
+
+// TasmotaFingerprint returns the fingerprint Tasmota's MqttFingerprint
+// expects: SHA-1 of the RSA public key in SSH wire format, as 20 space
+// separated hex bytes.
+func TasmotaFingerprint(pub *rsa.PublicKey) string {
+	h := sha1.New()
+	writeSSHString(h, []byte("ssh-rsa"))
+	writeSSHMPInt(h, big.NewInt(int64(pub.E)))
+	writeSSHMPInt(h, pub.N)
+
+	sum := h.Sum(nil)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, " ")
+}
+
+func writeSSHString(w io.Writer, b []byte) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(b)))
+	w.Write(length[:])
+	w.Write(b)
+}
+
+// writeSSHMPInt writes a positive integer as an SSH mpint, with a leading
+// zero byte when the high bit is set.
+func writeSSHMPInt(w io.Writer, n *big.Int) {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	writeSSHString(w, b)
+}
+
+// ensureSelfSignedMQTTCert creates a CA and a server certificate signed by it
+// in dir, unless the server certificate already exists. Keeping them across
+// restarts keeps the fingerprint, and so the devices, stable.
+func ensureSelfSignedMQTTCert(dir string, hosts []string) (certPath, keyPath string, err error) {
+	certPath = filepath.Join(dir, mqttServerCertFile)
+	keyPath = filepath.Join(dir, mqttServerKeyFile)
+
+	if _, err := os.Stat(certPath); err == nil {
+		return certPath, keyPath, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", "", fmt.Errorf("failed to check MQTT TLS certificate: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", "", fmt.Errorf("failed to create MQTT TLS directory: %w", err)
+	}
+
+	now := time.Now()
+
+	caKey, err := rsa.GenerateKey(rand.Reader, mqttTLSKeyBits)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate MQTT CA key: %w", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "tasmota-homekit MQTT CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create MQTT CA certificate: %w", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse MQTT CA certificate: %w", err)
+	}
+
+	serverKey, err := rsa.GenerateKey(rand.Reader, mqttTLSKeyBits)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate MQTT server key: %w", err)
+	}
+	serverTemplate := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: "tasmota-homekit MQTT broker"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			serverTemplate.IPAddresses = append(serverTemplate.IPAddresses, ip)
+		} else if host != "" {
+			serverTemplate.DNSNames = append(serverTemplate.DNSNames, host)
+		}
+	}
+	serverDER, err := x509.CreateCertificate(rand.Reader, serverTemplate, caCert, &serverKey.PublicKey, caKey)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create MQTT server certificate: %w", err)
+	}
+
+	files := []struct {
+		name  string
+		block *pem.Block
+		mode  os.FileMode
+	}{
+		{mqttCACertFile, &pem.Block{Type: "CERTIFICATE", Bytes: caDER}, 0o644},
+		{mqttCAKeyFile, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(caKey)}, 0o600},
+		{mqttServerKeyFile, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(serverKey)}, 0o600},
+		// Written last, as its presence marks the PKI as complete
+		{mqttServerCertFile, &pem.Block{Type: "CERTIFICATE", Bytes: serverDER}, 0o644},
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f.name), pem.EncodeToMemory(f.block), f.mode); err != nil {
+			return "", "", fmt.Errorf("failed to write %s: %w", f.name, err)
+		}
+	}
+
+	slog.Info("Generated self-signed MQTT TLS certificate", "dir", dir, "hosts", hosts)
+	return certPath, keyPath, nil
+}
+
+func randomSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+	return serial
+}
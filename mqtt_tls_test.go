@@ -0,0 +1,73 @@
+package tasmotahomekit
+
+import (
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTasmotaFingerprint(t *testing.T) {
+	// A modulus with the high bit set needs a leading zero byte
+	pub := &rsa.PublicKey{N: big.NewInt(0xc1), E: 3}
+
+	wire := []byte("\x00\x00\x00\x07ssh-rsa" +
+		"\x00\x00\x00\x01\x03" +
+		"\x00\x00\x00\x02\x00\xc1")
+	sum := sha1.Sum(wire)
+	var want []string
+	for _, b := range sum {
+		want = append(want, fmt.Sprintf("%02X", b))
+	}
+
+	require.Equal(t, strings.Join(want, " "), TasmotaFingerprint(pub))
+}
+
+func TestLoadMQTTTLSSelfSigned(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "tls")
+
+	cfg, fingerprint, err := LoadMQTTTLS("", "", dir, []string{"192.168.1.10", "bridge.local"})
+	require.NoError(t, err)
+	require.Len(t, cfg.Certificates, 1)
+	require.Len(t, strings.Fields(fingerprint), 20)
+
+	for _, name := range []string{mqttCACertFile, mqttCAKeyFile, mqttServerCertFile, mqttServerKeyFile} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		require.NoError(t, err)
+	}
+
+	caPEM, err := os.ReadFile(filepath.Join(dir, mqttCACertFile))
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(caPEM))
+
+	serverPEM, err := os.ReadFile(filepath.Join(dir, mqttServerCertFile))
+	require.NoError(t, err)
+	block, _ := pem.Decode(serverPEM)
+	require.NotNil(t, block)
+	server, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+
+	_, err = server.Verify(x509.VerifyOptions{Roots: pool, DNSName: "bridge.local"})
+	require.NoError(t, err)
+	require.NoError(t, server.VerifyHostname("192.168.1.10"))
+
+	// A restart reuses the certificate, so devices keep their fingerprint
+	_, again, err := LoadMQTTTLS("", "", dir, []string{"192.168.1.10"})
+	require.NoError(t, err)
+	require.Equal(t, fingerprint, again)
+}
+
+func TestLoadMQTTTLSMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	_, _, err := LoadMQTTTLS(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), "", nil)
+	require.Error(t, err)
+}
@@ -100,7 +100,7 @@ in
     dataDir = mkOption {
       type = types.path;
       default = "/var/lib/tasmota-homekit";
-      description = "Base directory for persistent data (contains HAP + Tailscale state).";
+      description = "Base directory for persistent data (contains HAP, Tailscale and plug state).";
       example = "/var/lib/tasmota-homekit";
     };
 
@@ -151,6 +151,159 @@ in
       };
     };
 
+    auth = {
+      configFile = mkOption {
+        type = types.nullOr types.path;
+        default = null;
+        description = ''
+          HuJSON file with API tokens, local users and Tailscale roles for the
+          web UI and API. It is passed as a systemd credential, so changes need
+          a service restart. Without it, anyone who can reach the web listener
+          can control the plugs.
+        '';
+        example = "/run/secrets/tasmota-homekit-auth";
+      };
+    };
+
+    mqtt = {
+      auth = mkOption {
+        type = types.bool;
+        default = false;
+        description = ''
+          Require per-plug credentials on the embedded broker and restrict
+          each plug to its own topics.
+        '';
+      };
+
+      secretsFile = mkOption {
+        type = types.nullOr types.path;
+        default = null;
+        description = ''
+          HuJSON file with plug MQTT credentials and extra broker users. It is
+          passed as a systemd credential, so changes need a service restart.
+        '';
+        example = "/run/secrets/tasmota-homekit-mqtt";
+      };
+
+      tls = {
+        enable = mkEnableOption "a TLS listener on the embedded MQTT broker";
+
+        port = mkOption {
+          type = types.port;
+          default = 8883;
+          description = "Port of the MQTT TLS listener.";
+        };
+
+        certFile = mkOption {
+          type = types.nullOr types.path;
+          default = null;
+          description = ''
+            PEM certificate of the TLS listener, passed as a systemd credential.
+            When unset, a self-signed CA and certificate are generated in the
+            data directory on first start.
+          '';
+        };
+
+        keyFile = mkOption {
+          type = types.nullOr types.path;
+          default = null;
+          description = "PEM private key matching certFile, passed as a systemd credential.";
+        };
+      };
+    };
+
+    discovery = {
+      scanCidr = mkOption {
+        type = types.nullOr types.str;
+        default = null;
+        description = ''
+          IPv4 range (at most a /16) to sweep for Tasmota devices in addition
+          to MQTT discovery announcements.
+        '';
+        example = "192.168.1.0/24";
+      };
+
+      scanInterval = mkOption {
+        type = types.ints.unsigned;
+        default = 0;
+        description = "Seconds between network sweeps; 0 sweeps only at startup and on demand.";
+      };
+    };
+
+    homeAssistant = {
+      broker = mkOption {
+        type = types.nullOr types.str;
+        default = null;
+        description = ''
+          External MQTT broker to mirror the plugs to for Home Assistant,
+          as mqtt://host:port or mqtts://host:port. The bridge is disabled
+          when unset.
+        '';
+        example = "mqtt://homeassistant.lan:1883";
+      };
+
+      username = mkOption {
+        type = types.nullOr types.str;
+        default = null;
+        description = "Username on the Home Assistant broker.";
+      };
+
+      passwordFile = mkOption {
+        type = types.nullOr types.path;
+        default = null;
+        description = "File with the password on the Home Assistant broker, passed as a systemd credential.";
+        example = "/run/secrets/tasmota-homekit-ha-password";
+      };
+
+      topicPrefix = mkOption {
+        type = types.str;
+        default = "tasmota-homekit";
+        description = "Prefix of the state, availability and command topics.";
+      };
+
+      discoveryPrefix = mkOption {
+        type = types.str;
+        default = "homeassistant";
+        description = "MQTT discovery prefix Home Assistant listens on.";
+      };
+    };
+
+    health = {
+      plugsSeenPercent = mkOption {
+        type = types.ints.between 0 100;
+        default = 0;
+        description = "Percentage of plugs that must have been heard from recently for /health/ready.";
+      };
+
+      plugsSeenWindow = mkOption {
+        type = types.ints.positive;
+        default = 120;
+        description = "Seconds since a plug was heard from for it to count as seen.";
+      };
+
+      requirePaired = mkOption {
+        type = types.bool;
+        default = false;
+        description = "Whether /health/ready requires HomeKit to be paired.";
+      };
+
+      mqttSilence = mkOption {
+        type = types.ints.unsigned;
+        default = 0;
+        description = "Seconds without a message from any plug before /health/ready fails; 0 disables the check.";
+      };
+
+      watchdog = mkOption {
+        type = types.ints.unsigned;
+        default = 60;
+        description = ''
+          systemd WatchdogSec in seconds. The bridge feeds the watchdog while
+          the MQTT broker, HomeKit server and web interface have not failed,
+          so systemd restarts it when one does. 0 disables the watchdog.
+        '';
+      };
+    };
+
     openFirewall = mkOption {
       type = types.bool;
       default = false;
@@ -160,6 +313,13 @@ in
 
   config = mkIf cfg.enable (mkMerge [
     {
+      assertions = [
+        {
+          assertion = (cfg.mqtt.tls.certFile == null) == (cfg.mqtt.tls.keyFile == null);
+          message = "services.tasmota-homekit.mqtt.tls.certFile and keyFile must be set together.";
+        }
+      ];
+
       users.users.${cfg.user} = {
         isSystemUser = true;
         group = cfg.group;
@@ -183,7 +343,8 @@ in
           cfg.ports.hap
           cfg.ports.web
           cfg.ports.mqtt
-        ];
+        ]
+        ++ optional cfg.mqtt.tls.enable cfg.mqtt.tls.port;
         allowedUDPPorts = [
           5353
         ];
@@ -202,15 +363,40 @@ in
             TASMOTA_HOMEKIT_MQTT_PORT = toString cfg.ports.mqtt;
             TASMOTA_HOMEKIT_HAP_PIN = cfg.hap.pin;
             TASMOTA_HOMEKIT_HAP_STORAGE_PATH = hapDir;
+            TASMOTA_HOMEKIT_STATE_PATH = "${cfg.dataDir}/state.json";
+            TASMOTA_HOMEKIT_ENERGY_HISTORY_PATH = "${cfg.dataDir}/energy.json";
+            TASMOTA_HOMEKIT_WEBHOOK_DEAD_LETTER_PATH = "${cfg.dataDir}/webhooks.json";
             TASMOTA_HOMEKIT_PLUGS_CONFIG = toString cfg.plugsConfig;
+            TASMOTA_HOMEKIT_MQTT_AUTH = boolToString cfg.mqtt.auth;
             TASMOTA_HOMEKIT_LOG_LEVEL = cfg.log.level;
             TASMOTA_HOMEKIT_LOG_FORMAT = cfg.log.format;
             TASMOTA_HOMEKIT_TS_HOSTNAME = cfg.tailscale.hostname;
             TASMOTA_HOMEKIT_TS_STATE_DIR = tailscaleDir;
+            TASMOTA_HOMEKIT_READY_PLUGS_SEEN_PERCENT = toString cfg.health.plugsSeenPercent;
+            TASMOTA_HOMEKIT_READY_PLUGS_SEEN_WINDOW = toString cfg.health.plugsSeenWindow;
+            TASMOTA_HOMEKIT_READY_REQUIRE_PAIRED = boolToString cfg.health.requirePaired;
+            TASMOTA_HOMEKIT_READY_MQTT_SILENCE = toString cfg.health.mqttSilence;
           }
           // (optionalAttrs (cfg.bridgeName != null) {
             TASMOTA_HOMEKIT_BRIDGE_NAME = cfg.bridgeName;
           })
+          // (optionalAttrs cfg.mqtt.tls.enable {
+            TASMOTA_HOMEKIT_MQTT_TLS = "true";
+            TASMOTA_HOMEKIT_MQTT_TLS_ADDR = "${cfg.bindAddresses.mqtt}:${toString cfg.mqtt.tls.port}";
+            TASMOTA_HOMEKIT_MQTT_TLS_DIR = "${cfg.dataDir}/mqtt-tls";
+          })
+          // (optionalAttrs (cfg.discovery.scanCidr != null) {
+            TASMOTA_HOMEKIT_DISCOVERY_SCAN_CIDR = cfg.discovery.scanCidr;
+            TASMOTA_HOMEKIT_DISCOVERY_SCAN_INTERVAL = toString cfg.discovery.scanInterval;
+          })
+          // (optionalAttrs (cfg.homeAssistant.broker != null) {
+            TASMOTA_HOMEKIT_HA_BROKER = cfg.homeAssistant.broker;
+            TASMOTA_HOMEKIT_HA_TOPIC_PREFIX = cfg.homeAssistant.topicPrefix;
+            TASMOTA_HOMEKIT_HA_DISCOVERY_PREFIX = cfg.homeAssistant.discoveryPrefix;
+          })
+          // (optionalAttrs (cfg.homeAssistant.username != null) {
+            TASMOTA_HOMEKIT_HA_USERNAME = cfg.homeAssistant.username;
+          })
           // cfg.environment;
 
           tailscaleExport =
@@ -218,9 +404,44 @@ in
               export TASMOTA_HOMEKIT_TS_AUTHKEY="$(cat "$CREDENTIALS_DIRECTORY/tailscale-authkey")"
             '';
 
+          authConfigExport =
+            optionalString (cfg.auth.configFile != null) ''
+              export TASMOTA_HOMEKIT_AUTH_CONFIG="$CREDENTIALS_DIRECTORY/auth-config"
+            '';
+
+          mqttSecretsExport =
+            optionalString (cfg.mqtt.secretsFile != null) ''
+              export TASMOTA_HOMEKIT_MQTT_SECRETS="$CREDENTIALS_DIRECTORY/mqtt-secrets"
+            '';
+
+          mqttTLSCertExport =
+            optionalString (cfg.mqtt.tls.certFile != null) ''
+              export TASMOTA_HOMEKIT_MQTT_TLS_CERT="$CREDENTIALS_DIRECTORY/mqtt-tls-cert"
+              export TASMOTA_HOMEKIT_MQTT_TLS_KEY="$CREDENTIALS_DIRECTORY/mqtt-tls-key"
+            '';
+
+          haPasswordExport =
+            optionalString (cfg.homeAssistant.passwordFile != null) ''
+              export TASMOTA_HOMEKIT_HA_PASSWORD="$(cat "$CREDENTIALS_DIRECTORY/ha-password")"
+            '';
+
+          credentials =
+            optional (cfg.tailscale.authKeyFile != null) "tailscale-authkey:${cfg.tailscale.authKeyFile}"
+            ++ optional (cfg.auth.configFile != null) "auth-config:${cfg.auth.configFile}"
+            ++ optional (cfg.mqtt.secretsFile != null) "mqtt-secrets:${cfg.mqtt.secretsFile}"
+            ++ optionals (cfg.mqtt.tls.certFile != null) [
+              "mqtt-tls-cert:${cfg.mqtt.tls.certFile}"
+              "mqtt-tls-key:${cfg.mqtt.tls.keyFile}"
+            ]
+            ++ optional (cfg.homeAssistant.passwordFile != null) "ha-password:${cfg.homeAssistant.passwordFile}";
+
           startScript = pkgs.writeShellScript "tasmota-homekit-start" ''
             set -euo pipefail
             ${tailscaleExport}
+            ${authConfigExport}
+            ${mqttSecretsExport}
+            ${mqttTLSCertExport}
+            ${haPasswordExport}
             exec ${cfg.package}/bin/tasmota-homekit
           '';
         in
@@ -243,8 +464,11 @@ in
           environment = envVars;
 
           serviceConfig = {
-            Type = "simple";
+            # Reports READY=1 once started and feeds the watchdog while live
+            Type = "notify";
             ExecStart = startScript;
+            # Re-reads the plugs file without restarting the bridge
+            ExecReload = "${pkgs.coreutils}/bin/kill -HUP $MAINPID";
             User = cfg.user;
             Group = cfg.group;
 
@@ -257,11 +481,14 @@ in
             StandardError = "journal";
             SyslogIdentifier = "tasmota-homekit";
           }
+          // (optionalAttrs (cfg.health.watchdog > 0) {
+            WatchdogSec = "${toString cfg.health.watchdog}s";
+          })
           // (optionalAttrs (cfg.environmentFile != null) {
             EnvironmentFile = cfg.environmentFile;
           })
-          // (optionalAttrs (cfg.tailscale.authKeyFile != null) {
-            LoadCredential = "tailscale-authkey:${cfg.tailscale.authKeyFile}";
+          // (optionalAttrs (credentials != [ ]) {
+            LoadCredential = credentials;
           });
         };
     }
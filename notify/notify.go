@@ -0,0 +1,512 @@
+// Package notify tells people when plugs go offline, come back, or are
+// switched off by a safety rule, through ntfy, Pushover or email as the
+// notification rules of the plugs file say.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"tailscale.com/util/eventbus"
+)
+
+const (
+	// tickInterval is how often delayed and held notifications are checked.
+	tickInterval = 10 * time.Second
+	queueSize    = 20
+	maxAttempts  = 3
+	firstBackoff = 5 * time.Second // doubled after every failed attempt
+	sendTimeout  = 30 * time.Second
+)
+
+// channel is a configured notifier and its queue, worked through by one
+// goroutine.
+type channel struct {
+	cfg    plugs.Notifier
+	sender Sender
+	queue  chan Message
+	cancel context.CancelFunc
+}
+
+// outage is a plug that is offline.
+type outage struct {
+	since  time.Time
+	reason string
+}
+
+// trackKey is what a rule tracks per plug and event, so the cooldown of
+// one event does not hold back the other.
+type trackKey struct {
+	rule   string
+	plugID string
+	event  string // plugs.NotifyOffline or plugs.NotifySafety
+}
+
+// track is what a rule has told about a plug.
+type track struct {
+	// due is when the offline notification is sent, zero when it is not
+	// waiting for the rule's delay
+	due time.Time
+	// notified is set once the offline notification was sent or held, a
+	// recovery notification follows
+	notified bool
+	lastSent time.Time
+}
+
+// held is a notification waiting for the quiet hours of its rule to end.
+type held struct {
+	key     trackKey
+	offline bool // dropped when the plug is back before it is sent
+	msg     Message
+}
+
+// Service sends notifications for the events the rules ask for.
+type Service struct {
+	client  *http.Client
+	now     func() time.Time
+	backoff func(attempt int) time.Duration
+
+	mu       sync.Mutex
+	ctx      context.Context
+	names    map[string]string
+	rules    map[string]plugs.NotifyRule
+	channels map[string]*channel
+	outages  map[string]outage
+	tracks   map[trackKey]*track
+	held     []held
+
+	stateSub *eventbus.Subscriber[events.StateUpdateEvent]
+	errorSub *eventbus.Subscriber[plugs.ErrorEvent]
+	alertSub *eventbus.Subscriber[events.AlertEvent]
+}
+
+// New returns a service without rules. Call SetEventBus and Update, then
+// Run.
+func New() *Service {
+	return &Service{
+		client: &http.Client{Timeout: sendTimeout},
+		now:    time.Now,
+		backoff: func(attempt int) time.Duration {
+			return firstBackoff << (attempt - 1)
+		},
+		names:    make(map[string]string),
+		rules:    make(map[string]plugs.NotifyRule),
+		channels: make(map[string]*channel),
+		outages:  make(map[string]outage),
+		tracks:   make(map[trackKey]*track),
+	}
+}
+
+// SetEventBus subscribes to plug states and errors, which tell when plugs
+// go offline and come back, and to safety alerts.
+func (s *Service) SetEventBus(bus *events.Bus) error {
+	client, err := bus.Client(events.ClientNotify)
+	if err != nil {
+		return fmt.Errorf("failed to get notify eventbus client: %w", err)
+	}
+
+	s.stateSub = eventbus.Subscribe[events.StateUpdateEvent](client)
+	s.errorSub = eventbus.Subscribe[plugs.ErrorEvent](client)
+	s.alertSub = eventbus.Subscribe[events.AlertEvent](client)
+	return nil
+}
+
+// Update applies the notifiers and rules of cfg. Notifiers that cannot be
+// set up, like a Pushover notifier without a token, are logged and
+// skipped.
+func (s *Service) Update(cfg *plugs.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clear(s.names)
+	for _, plug := range cfg.Plugs {
+		s.names[plug.ID] = plug.Name
+	}
+
+	keep := make(map[string]bool, len(cfg.Notifiers))
+	for _, n := range cfg.Notifiers {
+		ch, ok := s.channels[n.ID]
+		if ok && reflect.DeepEqual(ch.cfg, n) {
+			keep[n.ID] = true
+			continue
+		}
+		sender, err := NewSender(n, s.client)
+		if err != nil {
+			slog.Error("Failed to set up notifier", "notifier", n.ID, "error", err)
+			continue
+		}
+		keep[n.ID] = true
+		if ok {
+			// Queued messages go out through the new settings
+			ch.cfg, ch.sender = n, sender
+			continue
+		}
+		ch = &channel{cfg: n, sender: sender, queue: make(chan Message, queueSize)}
+		s.channels[n.ID] = ch
+		if s.ctx != nil {
+			s.startLocked(ch)
+		}
+	}
+	for id, ch := range s.channels {
+		if !keep[id] {
+			if ch.cancel != nil {
+				ch.cancel()
+			}
+			delete(s.channels, id)
+		}
+	}
+
+	clear(s.rules)
+	for _, r := range cfg.Notifications {
+		s.rules[r.ID] = r
+	}
+	for key := range s.tracks {
+		r, ok := s.rules[key.rule]
+		wanted := ok && r.Wants(key.event, key.plugID)
+		if _, known := s.names[key.plugID]; !wanted || !known {
+			delete(s.tracks, key)
+		}
+	}
+	for id := range s.outages {
+		if _, ok := s.names[id]; !ok {
+			delete(s.outages, id)
+		}
+	}
+	kept := s.held[:0]
+	for _, h := range s.held {
+		if _, ok := s.tracks[h.key]; ok {
+			kept = append(kept, h)
+		}
+	}
+	s.held = kept
+}
+
+// Run sends notifications until ctx is done.
+func (s *Service) Run(ctx context.Context) {
+	s.mu.Lock()
+	s.ctx = ctx
+	for _, ch := range s.channels {
+		s.startLocked(ch)
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	if s.stateSub == nil {
+		for {
+			select {
+			case <-ticker.C:
+				s.tick()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+	defer s.stateSub.Close()
+	defer s.errorSub.Close()
+	defer s.alertSub.Close()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.tick()
+		case event := <-s.stateSub.Events():
+			s.handleState(event)
+		case event := <-s.errorSub.Events():
+			if errors.Is(event.Error, plugs.ErrUnreachable) {
+				s.down(event.PlugID, event.Error.Error())
+			}
+		case event := <-s.alertSub.Events():
+			s.handleAlert(event)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleState tracks plugs going offline by their last will, and coming
+// back once they are heard from after going offline.
+func (s *Service) handleState(event events.StateUpdateEvent) {
+	if event.Offline {
+		s.down(event.PlugID, "the device disconnected from the broker")
+		return
+	}
+
+	s.mu.Lock()
+	o, ok := s.outages[event.PlugID]
+	s.mu.Unlock()
+	if ok && event.LastSeen.After(o.since) {
+		s.up(event.PlugID)
+	}
+}
+
+// down records a plug going offline. Every rule notifying about it sends
+// an offline notification once its delay has passed.
+func (s *Service) down(plugID, reason string) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.outages[plugID]; ok {
+		return
+	}
+	if _, ok := s.names[plugID]; !ok {
+		return
+	}
+	s.outages[plugID] = outage{since: now, reason: reason}
+	slog.Debug("Plug offline", "plug_id", plugID, "reason", reason)
+
+	for id, r := range s.rules {
+		if !r.Wants(plugs.NotifyOffline, plugID) {
+			continue
+		}
+		t := s.trackLocked(trackKey{id, plugID, plugs.NotifyOffline})
+		t.due = now.Add(r.DelayDuration())
+		t.notified = false
+	}
+	s.flushLocked(now)
+}
+
+// up records a plug coming back. Offline notifications still waiting are
+// dropped; rules that sent one send a recovery notification.
+func (s *Service) up(plugID string) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.outages[plugID]
+	if !ok {
+		return
+	}
+	delete(s.outages, plugID)
+	slog.Debug("Plug back online", "plug_id", plugID, "offline_for", now.Sub(o.since))
+
+	for id, r := range s.rules {
+		key := trackKey{id, plugID, plugs.NotifyOffline}
+		t, ok := s.tracks[key]
+		if !ok {
+			continue
+		}
+		t.due = time.Time{}
+		if !t.notified {
+			continue
+		}
+		t.notified = false
+		if s.dropHeldLocked(key) || !r.NotifyRecovery() {
+			continue
+		}
+
+		name := s.nameLocked(plugID)
+		s.sendLocked(key, false, Message{
+			Title:    name + " is back online",
+			Body:     fmt.Sprintf("%s is back online after %s.", name, formatDuration(now.Sub(o.since))),
+			Priority: PriorityLow,
+			Time:     now,
+		}, now)
+	}
+}
+
+// handleAlert notifies about a safety rule switching a plug off.
+func (s *Service) handleAlert(alert events.AlertEvent) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, r := range s.rules {
+		if !r.Wants(plugs.NotifySafety, alert.PlugID) {
+			continue
+		}
+		key := trackKey{id, alert.PlugID, plugs.NotifySafety}
+		t := s.trackLocked(key)
+		if coolingDown(r, t, now) {
+			slog.Debug("Notification suppressed by cooldown", "rule", id, "plug_id", alert.PlugID)
+			continue
+		}
+		t.lastSent = now
+
+		label := s.nameLocked(alert.PlugID)
+		if alert.Relay > 0 {
+			label = fmt.Sprintf("%s relay %d", label, alert.Relay)
+		}
+		s.sendLocked(key, false, Message{
+			Title:    label + " was switched off",
+			Body:     fmt.Sprintf("%s was switched off at %s: %s.", label, alert.Timestamp.Format("15:04"), alert.Reason),
+			Priority: PriorityHigh,
+			Time:     now,
+		}, now)
+	}
+}
+
+// tick sends the offline notifications whose delay has passed and those
+// held by quiet hours that ended.
+func (s *Service) tick() {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushLocked(now)
+}
+
+func (s *Service) flushLocked(now time.Time) {
+	for key, t := range s.tracks {
+		if t.due.IsZero() || now.Before(t.due) {
+			continue
+		}
+		t.due = time.Time{}
+		r := s.rules[key.rule]
+		if coolingDown(r, t, now) {
+			slog.Debug("Notification suppressed by cooldown", "rule", key.rule, "plug_id", key.plugID)
+			continue
+		}
+		t.notified = true
+		t.lastSent = now
+
+		o := s.outages[key.plugID]
+		name := s.nameLocked(key.plugID)
+		s.sendLocked(key, true, Message{
+			Title:    name + " is offline",
+			Body:     fmt.Sprintf("%s has been offline since %s: %s.", name, o.since.Format("15:04"), o.reason),
+			Priority: PriorityNormal,
+			Time:     now,
+		}, now)
+	}
+
+	kept := s.held[:0]
+	for _, h := range s.held {
+		r := s.rules[h.key.rule]
+		if r.QuietHours != nil && r.QuietHours.Contains(now) {
+			kept = append(kept, h)
+			continue
+		}
+		s.enqueueLocked(r, h.msg)
+	}
+	s.held = kept
+}
+
+// sendLocked queues msg on the notifiers of the rule, or holds it until
+// the rule's quiet hours end.
+func (s *Service) sendLocked(key trackKey, offline bool, msg Message, now time.Time) {
+	r := s.rules[key.rule]
+	if r.QuietHours != nil && r.QuietHours.Contains(now) {
+		s.held = append(s.held, held{key: key, offline: offline, msg: msg})
+		return
+	}
+	s.enqueueLocked(r, msg)
+}
+
+func (s *Service) enqueueLocked(r plugs.NotifyRule, msg Message) {
+	for _, id := range r.Notifiers {
+		ch, ok := s.channels[id]
+		if !ok {
+			continue
+		}
+		select {
+		case ch.queue <- msg:
+		default:
+			slog.Warn("Notifier queue full, dropping notification", "notifier", id, "title", msg.Title)
+		}
+	}
+}
+
+// dropHeldLocked drops a held offline notification, reporting whether
+// there was one.
+func (s *Service) dropHeldLocked(key trackKey) bool {
+	dropped := false
+	kept := s.held[:0]
+	for _, h := range s.held {
+		if h.offline && h.key == key {
+			dropped = true
+			continue
+		}
+		kept = append(kept, h)
+	}
+	s.held = kept
+	return dropped
+}
+
+func (s *Service) trackLocked(key trackKey) *track {
+	t, ok := s.tracks[key]
+	if !ok {
+		t = &track{}
+		s.tracks[key] = t
+	}
+	return t
+}
+
+func (s *Service) nameLocked(plugID string) string {
+	if name := s.names[plugID]; name != "" {
+		return name
+	}
+	return plugID
+}
+
+// coolingDown reports whether the rule sent about the plug too recently to
+// send again at now.
+func coolingDown(r plugs.NotifyRule, t *track, now time.Time) bool {
+	cooldown := r.CooldownDuration()
+	return cooldown > 0 && !t.lastSent.IsZero() && now.Sub(t.lastSent) < cooldown
+}
+
+func (s *Service) startLocked(ch *channel) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	ch.cancel = cancel
+	go s.work(ctx, ch)
+}
+
+// work sends the messages queued on a notifier, retrying failures with
+// backoff, until ctx is done.
+func (s *Service) work(ctx context.Context, ch *channel) {
+	for {
+		select {
+		case msg := <-ch.queue:
+			s.deliver(ctx, ch, msg)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Service) deliver(ctx context.Context, ch *channel, msg Message) {
+	for attempt := 1; ; attempt++ {
+		s.mu.Lock()
+		sender, id := ch.sender, ch.cfg.ID
+		s.mu.Unlock()
+
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		err := sender.Send(sendCtx, msg)
+		cancel()
+		if err == nil {
+			slog.Info("Notification sent", "notifier", id, "title", msg.Title)
+			return
+		}
+		if attempt >= maxAttempts || ctx.Err() != nil {
+			slog.Error("Failed to send notification", "notifier", id, "title", msg.Title, "attempts", attempt, "error", err)
+			return
+		}
+		slog.Warn("Failed to send notification, retrying", "notifier", id, "attempt", attempt, "error", err)
+
+		select {
+		case <-time.After(s.backoff(attempt)):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// formatDuration rounds d for people, to seconds below a minute and to
+// minutes above: "45s", "12m", "1h5m".
+func formatDuration(d time.Duration) string {
+	if d < time.Minute {
+		return d.Round(time.Second).String()
+	}
+	return strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+}
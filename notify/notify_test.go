@@ -0,0 +1,285 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/util/eventbus"
+)
+
+// clock is a settable time for Service.now.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+func newTestService(t *testing.T, rules ...plugs.NotifyRule) (*Service, *clock) {
+	t.Helper()
+	c := &clock{now: time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)}
+	s := New()
+	s.now = c.Now
+	s.Update(&plugs.Config{
+		Plugs:         []plugs.Plug{{ID: "desk", Name: "Desk"}, {ID: "fridge", Name: "Fridge"}},
+		Notifiers:     []plugs.Notifier{{ID: "phone", Type: plugs.NotifierNtfy, URL: "https://ntfy.example/hub"}},
+		Notifications: rules,
+	})
+	return s, c
+}
+
+// queued drains the messages queued on a notifier.
+func queued(s *Service, id string) []Message {
+	s.mu.Lock()
+	ch := s.channels[id]
+	s.mu.Unlock()
+
+	var msgs []Message
+	for {
+		select {
+		case msg := <-ch.queue:
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+func titles(msgs []Message) []string {
+	var out []string
+	for _, msg := range msgs {
+		out = append(out, msg.Title)
+	}
+	return out
+}
+
+func TestOfflineDebounce(t *testing.T) {
+	s, c := newTestService(t, plugs.NotifyRule{
+		ID:        "offline",
+		Events:    []string{plugs.NotifyOffline},
+		Plugs:     []string{"desk"},
+		Notifiers: []string{"phone"},
+		Delay:     "2m",
+		Cooldown:  "30m",
+	})
+	start := c.Now()
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+	heard := func(d time.Duration) {
+		c.Set(at(d))
+		s.handleState(events.StateUpdateEvent{PlugID: "desk", LastSeen: at(d)})
+	}
+
+	// A plug back within the delay is not notified at all
+	s.handleState(events.StateUpdateEvent{PlugID: "desk", Offline: true})
+	heard(time.Minute)
+	c.Set(at(5 * time.Minute))
+	s.tick()
+	require.Empty(t, queued(s, "phone"))
+
+	// Other plugs are not covered by the rule
+	s.down("fridge", "gone")
+
+	s.down("desk", fmt.Errorf("%w for 2m0s: timeout", plugs.ErrUnreachable).Error())
+	// Repeated reports of the same outage change nothing
+	c.Set(at(6 * time.Minute))
+	s.down("desk", "again")
+	s.tick()
+	require.Empty(t, queued(s, "phone"))
+
+	c.Set(at(7 * time.Minute))
+	s.tick()
+	msgs := queued(s, "phone")
+	require.Equal(t, []string{"Desk is offline"}, titles(msgs))
+	require.Equal(t, "Desk has been offline since 12:05: plug unreachable for 2m0s: timeout.", msgs[0].Body)
+
+	// Not heard from since the outage started: not back yet
+	s.handleState(events.StateUpdateEvent{PlugID: "desk", LastSeen: at(time.Minute)})
+	heard(17 * time.Minute)
+	msgs = queued(s, "phone")
+	require.Equal(t, []string{"Desk is back online"}, titles(msgs))
+	require.Equal(t, "Desk is back online after 12m.", msgs[0].Body)
+	require.Equal(t, PriorityLow, msgs[0].Priority)
+
+	// A second outage within the cooldown is not notified, nor its end
+	c.Set(at(20 * time.Minute))
+	s.down("desk", "gone")
+	c.Set(at(23 * time.Minute))
+	s.tick()
+	heard(25 * time.Minute)
+	require.Empty(t, queued(s, "phone"))
+
+	// After the cooldown it is
+	c.Set(at(40 * time.Minute))
+	s.down("desk", "gone")
+	c.Set(at(43 * time.Minute))
+	s.tick()
+	require.Equal(t, []string{"Desk is offline"}, titles(queued(s, "phone")))
+}
+
+func TestCooldownPerEvent(t *testing.T) {
+	s, c := newTestService(t, plugs.NotifyRule{
+		ID:        "desk",
+		Events:    []string{plugs.NotifyOffline, plugs.NotifySafety},
+		Notifiers: []string{"phone"},
+		Cooldown:  "30m",
+	})
+	start := c.Now()
+	alert := func() {
+		s.handleAlert(events.AlertEvent{Timestamp: c.Now(), PlugID: "desk", Rule: "max_power", Reason: "drawing 2400 W, limit 2000 W"})
+	}
+
+	// A safety trip does not hold back the outage that follows it
+	alert()
+	c.Set(start.Add(time.Minute))
+	s.down("desk", "gone")
+	require.Equal(t, []string{"Desk was switched off", "Desk is offline"}, titles(queued(s, "phone")))
+
+	// Nor the other way round, while each still cools down on its own
+	c.Set(start.Add(2 * time.Minute))
+	s.handleState(events.StateUpdateEvent{PlugID: "desk", LastSeen: c.Now()})
+	require.Equal(t, []string{"Desk is back online"}, titles(queued(s, "phone")))
+	alert()
+	c.Set(start.Add(3 * time.Minute))
+	s.down("desk", "gone")
+	require.Empty(t, queued(s, "phone"))
+
+	c.Set(start.Add(31 * time.Minute))
+	alert()
+	require.Equal(t, []string{"Desk was switched off"}, titles(queued(s, "phone")))
+}
+
+func TestQuietHours(t *testing.T) {
+	noRecovery := false
+	s, c := newTestService(t,
+		plugs.NotifyRule{
+			ID:         "night",
+			Events:     []string{plugs.NotifyOffline, plugs.NotifySafety},
+			Notifiers:  []string{"phone"},
+			QuietHours: &plugs.QuietHours{Start: "22:00", End: "07:00"},
+		},
+		plugs.NotifyRule{
+			ID:        "fridge",
+			Events:    []string{plugs.NotifyOffline},
+			Plugs:     []string{"fridge"},
+			Notifiers: []string{"phone"},
+			Recovery:  &noRecovery,
+		},
+	)
+	night := time.Date(2026, 3, 2, 23, 0, 0, 0, time.UTC)
+	c.Set(night)
+
+	// Back during the quiet hours: the held notification is dropped and
+	// there is nothing to recover from
+	s.down("desk", "gone")
+	c.Set(night.Add(30 * time.Minute))
+	s.handleState(events.StateUpdateEvent{PlugID: "desk", LastSeen: c.Now()})
+	s.tick()
+	require.Empty(t, queued(s, "phone"))
+
+	s.down("desk", "gone")
+	s.handleAlert(events.AlertEvent{Timestamp: c.Now(), PlugID: "desk", Relay: 2, Rule: "max_power", Reason: "drawing 2400 W, limit 2000 W"})
+	// The rule without quiet hours sends right away, without recovery
+	s.down("fridge", "gone")
+	require.Equal(t, []string{"Fridge is offline"}, titles(queued(s, "phone")))
+	c.Set(night.Add(45 * time.Minute))
+	s.handleState(events.StateUpdateEvent{PlugID: "fridge", LastSeen: c.Now()})
+	require.Empty(t, queued(s, "phone"))
+
+	c.Set(time.Date(2026, 3, 3, 6, 59, 0, 0, time.UTC))
+	s.tick()
+	require.Empty(t, queued(s, "phone"))
+
+	c.Set(time.Date(2026, 3, 3, 7, 0, 0, 0, time.UTC))
+	s.tick()
+	msgs := queued(s, "phone")
+	require.ElementsMatch(t, []string{"Desk is offline", "Desk relay 2 was switched off"}, titles(msgs))
+	for _, msg := range msgs {
+		if msg.Priority == PriorityHigh {
+			require.Equal(t, "Desk relay 2 was switched off at 23:30: drawing 2400 W, limit 2000 W.", msg.Body)
+		}
+	}
+
+	// Back after the quiet hours, the offline notification was sent
+	s.handleState(events.StateUpdateEvent{PlugID: "desk", LastSeen: c.Now()})
+	require.Equal(t, []string{"Desk is back online"}, titles(queued(s, "phone")))
+}
+
+func TestEventBus(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received = append(received, r.Header.Get("Title"))
+		mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+
+	bus, err := events.New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	t.Cleanup(func() { bus.Close() })
+
+	s := New()
+	require.NoError(t, s.SetEventBus(bus))
+	s.Update(&plugs.Config{
+		Plugs:         []plugs.Plug{{ID: "kettle", Name: "Kettle"}},
+		Notifiers:     []plugs.Notifier{{ID: "phone", Type: plugs.NotifierNtfy, URL: srv.URL}},
+		Notifications: []plugs.NotifyRule{{ID: "all", Events: []string{plugs.NotifyOffline, plugs.NotifySafety}, Notifiers: []string{"phone"}}},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.Run(ctx)
+
+	client, err := bus.Client(events.ClientPlugManager)
+	require.NoError(t, err)
+	bus.PublishAlert(client, events.AlertEvent{Timestamp: time.Now(), PlugID: "kettle", Rule: "idle", Reason: "below 5 W for 10m"})
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Only errors for unreachable plugs are outages
+	mqttClient, err := bus.Client(events.ClientMQTT)
+	require.NoError(t, err)
+	errorPublisher := eventbus.Publish[plugs.ErrorEvent](mqttClient)
+	publish := func(err error) {
+		errorPublisher.Publish(plugs.ErrorEvent{PlugID: "kettle", Error: err})
+	}
+	publish(fmt.Errorf("failed to set power"))
+	publish(fmt.Errorf("%w for 3m0s: timeout", plugs.ErrUnreachable))
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"Kettle was switched off", "Kettle is offline"}, received)
+}
+
+func TestFormatDuration(t *testing.T) {
+	require.Equal(t, "45s", formatDuration(44600*time.Millisecond))
+	require.Equal(t, "12m", formatDuration(12*time.Minute+10*time.Second))
+	require.Equal(t, "1h5m", formatDuration(time.Hour+5*time.Minute))
+}
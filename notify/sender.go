@@ -0,0 +1,282 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+)
+
+// DefaultPushoverURL is where Pushover notifiers without a URL send to.
+const DefaultPushoverURL = "https://api.pushover.net/1/messages.json"
+
+// Priority of a message, mapped to each service's own levels.
+type Priority int
+
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+// Message is a notification.
+type Message struct {
+	Title    string
+	Body     string
+	Priority Priority
+	Time     time.Time
+}
+
+// Sender delivers messages through one notifier.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewSender returns the sender of a notifier, sending HTTP requests with
+// client.
+func NewSender(n plugs.Notifier, client *http.Client) (Sender, error) {
+	switch n.Type {
+	case plugs.NotifierNtfy:
+		return &ntfy{url: n.URL, token: n.Token, client: client}, nil
+	case plugs.NotifierPushover:
+		if n.Token == "" {
+			return nil, fmt.Errorf("notifier %s has no pushover application token", n.ID)
+		}
+		endpoint := n.URL
+		if endpoint == "" {
+			endpoint = DefaultPushoverURL
+		}
+		return &pushover{url: endpoint, token: n.Token, user: n.User, client: client}, nil
+	case plugs.NotifierSMTP:
+		return newMailer(n)
+	default:
+		return nil, fmt.Errorf("notifier %s has unknown type %q", n.ID, n.Type)
+	}
+}
+
+// ntfy publishes to an ntfy topic, https://docs.ntfy.sh/publish/.
+type ntfy struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (n *ntfy) Send(ctx context.Context, msg Message) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, strings.NewReader(msg.Body))
+	if err != nil {
+		return fmt.Errorf("failed to create ntfy request: %w", err)
+	}
+	// ntfy decodes RFC 2047 for titles that are not ASCII
+	req.Header.Set("Title", mime.QEncoding.Encode("utf-8", msg.Title))
+	req.Header.Set("Priority", map[Priority]string{PriorityLow: "low", PriorityNormal: "default", PriorityHigh: "high"}[msg.Priority])
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to publish to ntfy: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("ntfy returned %s", resp.Status)
+	}
+	return nil
+}
+
+// pushover sends through the Pushover message API,
+// https://pushover.net/api, or a service speaking it.
+type pushover struct {
+	url    string
+	token  string
+	user   string
+	client *http.Client
+}
+
+func (p *pushover) Send(ctx context.Context, msg Message) error {
+	form := url.Values{
+		"token":    {p.token},
+		"user":     {p.user},
+		"title":    {msg.Title},
+		"message":  {msg.Body},
+		"priority": {strconv.Itoa(int(msg.Priority))},
+	}
+	if !msg.Time.IsZero() {
+		form.Set("timestamp", strconv.FormatInt(msg.Time.Unix(), 10))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create pushover request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send to pushover: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Status int      `json:"status"`
+		Errors []string `json:"errors"`
+	}
+	// Proxies answer errors with HTML; the status is reason enough then
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result)
+	if resp.StatusCode != http.StatusOK || result.Status != 1 {
+		if len(result.Errors) > 0 {
+			return fmt.Errorf("pushover returned %s: %s", resp.Status, strings.Join(result.Errors, "; "))
+		}
+		return fmt.Errorf("pushover returned %s", resp.Status)
+	}
+	return nil
+}
+
+// mailer sends email. Plain smtp:// connections are upgraded with STARTTLS
+// when the server offers it; smtps:// connects with TLS.
+type mailer struct {
+	addr        string
+	host        string
+	implicitTLS bool
+	username    string
+	password    string
+	from        *mail.Address
+	to          []*mail.Address
+}
+
+func newMailer(n plugs.Notifier) (*mailer, error) {
+	u, err := url.Parse(n.URL)
+	if err != nil {
+		return nil, fmt.Errorf("notifier %s has invalid url: %w", n.ID, err)
+	}
+	m := &mailer{
+		host:        u.Hostname(),
+		implicitTLS: u.Scheme == "smtps",
+		username:    n.Username,
+		password:    n.Password,
+	}
+	port := u.Port()
+	if port == "" {
+		port = "25"
+		if m.implicitTLS {
+			port = "465"
+		}
+	}
+	m.addr = net.JoinHostPort(m.host, port)
+
+	if m.from, err = mail.ParseAddress(n.From); err != nil {
+		return nil, fmt.Errorf("notifier %s has invalid from address: %w", n.ID, err)
+	}
+	for _, to := range n.To {
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return nil, fmt.Errorf("notifier %s has invalid to address: %w", n.ID, err)
+		}
+		m.to = append(m.to, addr)
+	}
+	return m, nil
+}
+
+func (m *mailer) Send(ctx context.Context, msg Message) error {
+	tlsConfig := &tls.Config{ServerName: m.host, MinVersion: tls.VersionTLS12}
+
+	var conn net.Conn
+	var err error
+	if m.implicitTLS {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", m.addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", m.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to mail server: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start smtp session: %w", err)
+	}
+	defer c.Close()
+
+	if !m.implicitTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("failed to start tls: %w", err)
+			}
+		}
+	}
+	if m.username != "" {
+		// PlainAuth refuses to send the password unencrypted, except to
+		// localhost
+		if err := c.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+
+	if err := c.Mail(m.from.Address); err != nil {
+		return fmt.Errorf("mail server refused sender: %w", err)
+	}
+	for _, to := range m.to {
+		if err := c.Rcpt(to.Address); err != nil {
+			return fmt.Errorf("mail server refused recipient %s: %w", to.Address, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	if _, err := w.Write(m.compose(msg)); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	return c.Quit()
+}
+
+// compose returns the message as a plain text email.
+func (m *mailer) compose(msg Message) []byte {
+	to := make([]string, 0, len(m.to))
+	for _, addr := range m.to {
+		to = append(to, addr.String())
+	}
+	date := msg.Time
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Title))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	if msg.Priority == PriorityHigh {
+		b.WriteString("Importance: high\r\nX-Priority: 1\r\n")
+	}
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&b)
+	_, _ = qp.Write([]byte(msg.Body))
+	_ = qp.Close()
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
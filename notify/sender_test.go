@@ -0,0 +1,196 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/stretchr/testify/require"
+)
+
+var testMessage = Message{
+	Title:    "Kettle was switched off ☕",
+	Body:     "Kettle was switched off at 07:30: below 5 W for 10m.",
+	Priority: PriorityHigh,
+	Time:     time.Date(2026, 3, 2, 7, 30, 0, 0, time.UTC),
+}
+
+func TestNtfy(t *testing.T) {
+	var req *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		req, body = r, string(data)
+		if r.URL.Path == "/full" {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	sender, err := NewSender(plugs.Notifier{ID: "phone", Type: plugs.NotifierNtfy, URL: srv.URL + "/hub", Token: "tk_1"}, srv.Client())
+	require.NoError(t, err)
+	require.NoError(t, sender.Send(context.Background(), testMessage))
+	require.Equal(t, http.MethodPost, req.Method)
+	require.Equal(t, "/hub", req.URL.Path)
+	require.Equal(t, testMessage.Body, body)
+	require.Equal(t, "=?utf-8?q?Kettle_was_switched_off_=E2=98=95?=", req.Header.Get("Title"))
+	require.Equal(t, "high", req.Header.Get("Priority"))
+	require.Equal(t, "Bearer tk_1", req.Header.Get("Authorization"))
+
+	sender, err = NewSender(plugs.Notifier{ID: "phone", Type: plugs.NotifierNtfy, URL: srv.URL + "/full"}, srv.Client())
+	require.NoError(t, err)
+	require.ErrorContains(t, sender.Send(context.Background(), Message{Title: "x"}), "ntfy returned 429")
+	require.Empty(t, req.Header.Get("Authorization"))
+}
+
+func TestPushover(t *testing.T) {
+	var form map[string][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		form = r.PostForm
+		if r.PostForm.Get("user") == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = io.WriteString(w, `{"user":"invalid","errors":["user identifier is not a valid user, group, or subscribed user key"],"status":0}`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"status":1,"request":"647d2300-702c-4b38-8b2f-d56326ae460b"}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	_, err := NewSender(plugs.Notifier{ID: "po", Type: plugs.NotifierPushover, User: "u1"}, srv.Client())
+	require.ErrorContains(t, err, "no pushover application token")
+
+	sender, err := NewSender(plugs.Notifier{ID: "po", Type: plugs.NotifierPushover, URL: srv.URL, Token: "app", User: "u1"}, srv.Client())
+	require.NoError(t, err)
+	require.NoError(t, sender.Send(context.Background(), testMessage))
+	require.Equal(t, "app", form["token"][0])
+	require.Equal(t, "u1", form["user"][0])
+	require.Equal(t, testMessage.Title, form["title"][0])
+	require.Equal(t, testMessage.Body, form["message"][0])
+	require.Equal(t, "1", form["priority"][0])
+	require.Equal(t, "1772436600", form["timestamp"][0])
+
+	sender, err = NewSender(plugs.Notifier{ID: "po", Type: plugs.NotifierPushover, URL: srv.URL, Token: "app", User: "bad"}, srv.Client())
+	require.NoError(t, err)
+	require.ErrorContains(t, sender.Send(context.Background(), testMessage), "user identifier is not a valid user")
+}
+
+// smtpSession is what the stand-in mail server received.
+type smtpSession struct {
+	auth string
+	from string
+	to   []string
+	data []byte
+}
+
+// serveSMTP accepts one SMTP session on a local port, offering AUTH PLAIN
+// but not STARTTLS.
+func serveSMTP(t *testing.T) (string, <-chan smtpSession) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	sessions := make(chan smtpSession, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tp := textproto.NewConn(conn)
+		var s smtpSession
+		reply := func(line string) { _ = tp.PrintfLine("%s", line) }
+		reply("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			verb, arg, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(verb) {
+			case "EHLO":
+				reply("250-localhost")
+				reply("250 AUTH PLAIN")
+			case "AUTH":
+				s.auth = strings.TrimPrefix(arg, "PLAIN ")
+				reply("235 2.7.0 Authentication successful")
+			case "MAIL":
+				s.from = arg
+				reply("250 OK")
+			case "RCPT":
+				s.to = append(s.to, arg)
+				reply("250 OK")
+			case "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				s.data, _ = tp.ReadDotBytes()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 Bye")
+				sessions <- s
+				return
+			default:
+				reply("502 Command not implemented")
+			}
+		}
+	}()
+	return ln.Addr().String(), sessions
+}
+
+func TestSMTP(t *testing.T) {
+	addr, sessions := serveSMTP(t)
+	sender, err := NewSender(plugs.Notifier{
+		ID:       "mail",
+		Type:     plugs.NotifierSMTP,
+		URL:      "smtp://" + addr,
+		Username: "hub",
+		Password: "secret",
+		From:     "Tasmota HomeKit <hub@example.com>",
+		To:       []string{"me@example.com", "Partner <you@example.com>"},
+	}, nil)
+	require.NoError(t, err)
+
+	msg := testMessage
+	msg.Body += "\n.\nA line with only a dot."
+	require.NoError(t, sender.Send(context.Background(), msg))
+
+	var s smtpSession
+	select {
+	case s = <-sessions:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail received")
+	}
+	auth, err := base64.StdEncoding.DecodeString(s.auth)
+	require.NoError(t, err)
+	require.Equal(t, "\x00hub\x00secret", string(auth))
+	require.Equal(t, "FROM:<hub@example.com>", s.from)
+	require.Equal(t, []string{"TO:<me@example.com>", "TO:<you@example.com>"}, s.to)
+
+	email, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(string(s.data))))
+	require.NoError(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(email.Header.Get("Subject"))
+	require.NoError(t, err)
+	require.Equal(t, msg.Title, subject)
+	require.Equal(t, `"Tasmota HomeKit" <hub@example.com>`, email.Header.Get("From"))
+	require.Equal(t, `<me@example.com>, "Partner" <you@example.com>`, email.Header.Get("To"))
+	require.Equal(t, "high", email.Header.Get("Importance"))
+	date, err := email.Header.Date()
+	require.NoError(t, err)
+	require.True(t, date.Equal(msg.Time))
+	body, err := io.ReadAll(quotedprintable.NewReader(email.Body))
+	require.NoError(t, err)
+	require.Equal(t, msg.Body, strings.TrimRight(string(body), "\r\n"))
+}
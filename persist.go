@@ -0,0 +1,56 @@
+package tasmotahomekit
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/kradalby/tasmota-homekit/store"
+)
+
+// statePersister saves plug state, connection history and the web event log
+// to the state store, so they survive a restart.
+type statePersister struct {
+	store *store.Store
+	plugs *plugs.Manager
+	web   *WebServer
+}
+
+// Save writes the current state to the store.
+func (p *statePersister) Save() error {
+	snapshot := &store.Snapshot{
+		States:      make(map[string]plugs.State),
+		Connections: p.plugs.ConnectionHistory(),
+	}
+	for id, item := range p.plugs.Snapshot() {
+		snapshot.States[id] = item.State
+	}
+	if p.web != nil {
+		snapshot.EventLog = p.web.EventLog()
+	}
+
+	return p.store.Save(snapshot)
+}
+
+// Run saves the state every interval until ctx is done. A zero interval
+// disables periodic saves.
+func (p *statePersister) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.Save(); err != nil {
+				slog.Warn("Failed to save state", "path", p.store.Path(), "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package tasmotahomekit
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/kradalby/tasmota-homekit/store"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatePersisterRoundTrip(t *testing.T) {
+	plugConfigs := []plugs.Plug{{ID: "desk", Name: "Desk", Address: "1"}}
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	before := setupStateSyncTest(t, plugConfigs)
+	_, err := before.manager.GetStatus(before.ctx, "desk")
+	require.NoError(t, err)
+	before.webServer.LogEvent("Web UI: Toggle desk → true")
+
+	persister := &statePersister{store: store.New(path), plugs: before.manager, web: before.webServer}
+	require.NoError(t, persister.Save())
+
+	// A restarted bridge starts from the saved state before the plug is polled
+	after := setupStateSyncTest(t, plugConfigs)
+	snapshot, err := store.New(path).Load()
+	require.NoError(t, err)
+	after.manager.Restore(snapshot.States, snapshot.Connections)
+	after.webServer.RestoreEventLog(snapshot.EventLog)
+	after.hapManager.applyPlugState("initial")
+
+	_, state, ok := after.manager.Plug("desk")
+	require.True(t, ok)
+	require.True(t, state.On)
+	require.True(t, state.Restored)
+	require.True(t, after.getHAPState("desk"))
+	require.Len(t, after.webServer.EventLog(), 1)
+	require.Contains(t, after.webServer.EventLog()[0], "Toggle desk")
+}
@@ -0,0 +1,139 @@
+package plugs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// MaxButtons is the number of buttons Tasmota reports actions for
+// (Button1..Button8).
+const MaxButtons = 8
+
+// Button describes a physical button of a plug. Buttons are decoupled from
+// the relays: pressing one no longer switches the plug but reports a
+// single, double or long press, exposed to HomeKit as a programmable switch.
+type Button struct {
+	Name string `json:"name,omitempty"`
+}
+
+// ButtonAction is a kind of button press.
+type ButtonAction string
+
+const (
+	ButtonSingle ButtonAction = "single"
+	ButtonDouble ButtonAction = "double"
+	ButtonLong   ButtonAction = "long"
+)
+
+// ButtonEvent is emitted when a decoupled button of a plug is pressed.
+type ButtonEvent struct {
+	PlugID string
+	Button int // 1-based, Tasmota Button1..N
+	Action ButtonAction
+}
+
+// ButtonName returns the display name of a 1-based button.
+func (p Plug) ButtonName(button int) string {
+	if button >= 1 && button <= len(p.Buttons) && p.Buttons[button-1].Name != "" {
+		return p.Buttons[button-1].Name
+	}
+	if len(p.Buttons) == 1 {
+		return p.Name + " Button"
+	}
+	return fmt.Sprintf("%s Button %d", p.Name, button)
+}
+
+func (p Plug) validateButtons() error {
+	if len(p.Buttons) > MaxButtons {
+		return fmt.Errorf("plug %s has %d buttons, maximum is %d", p.ID, len(p.Buttons), MaxButtons)
+	}
+	return nil
+}
+
+// buttonActions maps the actions Tasmota reports with SetOption73 to button
+// actions. SetOption1 limits them to these; others, like CLEAR after a
+// hold, are ignored.
+var buttonActions = map[string]ButtonAction{
+	"SINGLE": ButtonSingle,
+	"DOUBLE": ButtonDouble,
+	"HOLD":   ButtonLong,
+}
+
+// ParseButtonActions extracts button presses from a Tasmota payload object,
+// e.g. {"Button1":{"Action":"SINGLE"}}, keyed by 1-based button.
+func ParseButtonActions(payload map[string]interface{}) map[int]ButtonAction {
+	var actions map[int]ButtonAction
+	for key, value := range payload {
+		if !strings.HasPrefix(key, "Button") {
+			continue
+		}
+		button, err := strconv.Atoi(strings.TrimPrefix(key, "Button"))
+		if err != nil || button < 1 || button > MaxButtons {
+			continue
+		}
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		str, _ := obj["Action"].(string)
+		action, ok := buttonActions[str]
+		if !ok {
+			continue
+		}
+		if actions == nil {
+			actions = make(map[int]ButtonAction)
+		}
+		actions[button] = action
+	}
+	return actions
+}
+
+// buttonCommands decouple the buttons from the relays and make Tasmota
+// report their actions over MQTT, or restore the default when decouple is
+// false.
+func buttonCommands(decouple bool) []string {
+	if !decouple {
+		return []string{"SetOption73 0"}
+	}
+	// SetOption1 restricts presses to single, double and hold, so holding
+	// a button cannot reset the device
+	return []string{"SetOption73 1", "SetOption1 1"}
+}
+
+// ConfigureButtons decouples the plug's buttons from its relays so presses
+// are reported instead, for plugs with buttons configured.
+func (pm *Manager) ConfigureButtons(ctx context.Context, plugID string) error {
+	info, exists := pm.info(plugID)
+	if !exists {
+		return fmt.Errorf("plug %s not found", plugID)
+	}
+	if len(info.Config.Buttons) == 0 {
+		return nil
+	}
+
+	if _, err := info.Client.ExecuteBacklog(ctx, buttonCommands(true)...); err != nil {
+		return fmt.Errorf("failed to configure buttons: %w", err)
+	}
+
+	slog.Info("Buttons decoupled on plug", "plug_id", plugID, "buttons", len(info.Config.Buttons))
+	return nil
+}
+
+// ReleaseButtons couples the plug's buttons to its relays again, after its
+// buttons were removed from the configuration.
+func (pm *Manager) ReleaseButtons(ctx context.Context, plugID string) error {
+	info, exists := pm.info(plugID)
+	if !exists {
+		return fmt.Errorf("plug %s not found", plugID)
+	}
+
+	if _, err := info.Client.ExecuteBacklog(ctx, buttonCommands(false)...); err != nil {
+		return fmt.Errorf("failed to release buttons: %w", err)
+	}
+
+	slog.Info("Buttons coupled to relays on plug", "plug_id", plugID)
+	return nil
+}
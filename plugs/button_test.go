@@ -0,0 +1,62 @@
+package plugs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseButtonActions(t *testing.T) {
+	payload := map[string]interface{}{
+		"Button1": map[string]interface{}{"Action": "SINGLE"},
+		"Button2": map[string]interface{}{"Action": "HOLD"},
+		"Button3": map[string]interface{}{"Action": "CLEAR"},
+		"Button9": map[string]interface{}{"Action": "DOUBLE"},
+		"Button":  map[string]interface{}{"Action": "DOUBLE"},
+		"POWER":   "ON",
+	}
+	require.Equal(t, map[int]ButtonAction{1: ButtonSingle, 2: ButtonLong}, ParseButtonActions(payload))
+	require.Nil(t, ParseButtonActions(map[string]interface{}{"POWER": "ON"}))
+}
+
+func TestButtonName(t *testing.T) {
+	plug := Plug{Name: "Hall", Buttons: []Button{{}}}
+	require.Equal(t, "Hall Button", plug.ButtonName(1))
+
+	plug.Buttons = []Button{{Name: "Top"}, {}}
+	require.Equal(t, "Top", plug.ButtonName(1))
+	require.Equal(t, "Hall Button 2", plug.ButtonName(2))
+}
+
+func TestValidateButtons(t *testing.T) {
+	_, err := ParseConfig([]byte(`{"plugs": [{"id": "hall", "name": "Hall", "address": "1", "buttons": [{}, {}, {}, {}, {}, {}, {}, {}, {}]}]}`))
+	require.ErrorContains(t, err, "maximum is 8")
+}
+
+func TestConfigureButtons(t *testing.T) {
+	pm, fake, _ := newTestManager(t)
+	ctx := context.Background()
+
+	require.NoError(t, pm.ConfigureButtons(ctx, "plug-1"))
+	require.Empty(t, fake.backlog, "plug without buttons")
+
+	pm.plugs["plug-1"].Config.Buttons = []Button{{}}
+	require.NoError(t, pm.ConfigureButtons(ctx, "plug-1"))
+	require.Equal(t, []string{"SetOption73 1", "SetOption1 1"}, fake.backlog)
+
+	fake.backlog = nil
+	require.NoError(t, pm.ReleaseButtons(ctx, "plug-1"))
+	require.Equal(t, []string{"SetOption73 0"}, fake.backlog)
+
+	require.ErrorContains(t, pm.ConfigureButtons(ctx, "missing"), "not found")
+}
+
+func TestReconcileButtonsChange(t *testing.T) {
+	pm, _, _ := newTestManager(t)
+
+	diff, err := pm.Reconcile([]Plug{{ID: "plug-1", Name: "Plug", Address: "1", Buttons: []Button{{}}}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"plug-1"}, diff.Buttons)
+	require.Empty(t, diff.Reprovision())
+}
@@ -0,0 +1,256 @@
+package plugs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/events"
+)
+
+// MQTTPublisher publishes messages on the embedded broker, e.g. *mqtt.Server
+// with its inline client enabled.
+type MQTTPublisher interface {
+	Publish(topic string, payload []byte, retain bool, qos byte) error
+}
+
+var errNotConfirmed = errors.New("command not confirmed")
+
+type sourceKey struct{}
+
+// WithSource records the origin of commands sent with ctx, e.g. "homekit" or
+// "web", on the command events.
+func WithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+func sourceFrom(ctx context.Context) string {
+	source, _ := ctx.Value(sourceKey{}).(string)
+	return source
+}
+
+// commandWaiter waits for a state event from a plug confirming a command.
+type commandWaiter struct {
+	match func(StateChangedEvent) bool
+	done  chan struct{}
+}
+
+// commandWaiters tracks the commands awaiting confirmation, by plug.
+type commandWaiters struct {
+	mu      sync.Mutex
+	pending map[string][]*commandWaiter
+}
+
+func (cw *commandWaiters) add(plugID string, match func(StateChangedEvent) bool) *commandWaiter {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	if cw.pending == nil {
+		cw.pending = make(map[string][]*commandWaiter)
+	}
+	w := &commandWaiter{match: match, done: make(chan struct{})}
+	cw.pending[plugID] = append(cw.pending[plugID], w)
+	return w
+}
+
+func (cw *commandWaiters) remove(plugID string, w *commandWaiter) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	cw.pending[plugID] = slices.DeleteFunc(cw.pending[plugID], func(other *commandWaiter) bool {
+		return other == w
+	})
+	if len(cw.pending[plugID]) == 0 {
+		delete(cw.pending, plugID)
+	}
+}
+
+// confirm releases the waiters matched by event.
+func (cw *commandWaiters) confirm(event StateChangedEvent) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	if len(cw.pending[event.PlugID]) == 0 {
+		return
+	}
+	cw.pending[event.PlugID] = slices.DeleteFunc(cw.pending[event.PlugID], func(w *commandWaiter) bool {
+		if !w.match(event) {
+			return false
+		}
+		close(w.done)
+		return true
+	})
+	if len(cw.pending[event.PlugID]) == 0 {
+		delete(cw.pending, event.PlugID)
+	}
+}
+
+// powerConfirmed matches state events reporting relay (0 for the default
+// output) in the wanted state, or all count relays for allRelays.
+func powerConfirmed(relay, count int, on bool) func(StateChangedEvent) bool {
+	relays := []int{relay}
+	switch {
+	case relay == allRelays:
+		relays = relays[:0]
+		for r := 1; r <= count; r++ {
+			relays = append(relays, r)
+		}
+	case relay <= 0:
+		relays[0] = 1
+	}
+	return func(event StateChangedEvent) bool {
+		for _, r := range relays {
+			if !slices.Contains(event.UpdatedFields, RelayField(r)) || event.State.RelayOn(r) != on {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// lightConfirmed matches state events reporting any light attribute.
+func lightConfirmed(event StateChangedEvent) bool {
+	return slices.ContainsFunc(event.UpdatedFields, func(field string) bool {
+		switch field {
+		case "Brightness", "Hue", "Saturation", "ColorTemperature":
+			return true
+		}
+		return false
+	})
+}
+
+// mqttCommand returns the topic and payload delivering cmds to the plug.
+func mqttCommand(plug Plug, cmds []string) (string, []byte) {
+	base := plug.TopicFor(PrefixCommand)
+	if len(cmds) == 1 {
+		name, arg, _ := strings.Cut(cmds[0], " ")
+		return base + name, []byte(arg)
+	}
+	return base + "Backlog", []byte(strings.Join(cmds, "; "))
+}
+
+// SetMQTTPublisher enables sending commands over MQTT to plugs connected to
+// the broker. Commands not confirmed by the device within timeout are sent
+// over HTTP instead. A nil publisher or zero timeout sends everything over HTTP.
+func (pm *Manager) SetMQTTPublisher(publisher MQTTPublisher, timeout time.Duration) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.mqttPublisher = publisher
+	pm.mqttTimeout = timeout
+}
+
+// mqttRoute returns the publisher and confirmation timeout for commands to a
+// plug, or a nil publisher if the plug should be reached over HTTP.
+func (pm *Manager) mqttRoute(plugID string) (MQTTPublisher, time.Duration) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	state, ok := pm.states[plugID]
+	if pm.mqttPublisher == nil || pm.mqttTimeout <= 0 || !ok || !state.MQTTConnected {
+		return nil, 0
+	}
+	return pm.mqttPublisher, pm.mqttTimeout
+}
+
+// send delivers cmds to a plug. Plugs with a session on the broker get them
+// over MQTT, and the call waits for a state event matched by confirmed; all
+// other plugs, and MQTT commands not confirmed in time, go over HTTP.
+func (pm *Manager) send(
+	ctx context.Context,
+	info *Info,
+	cmds []string,
+	confirmed func(StateChangedEvent) bool,
+) (events.Transport, error) {
+	plugID := info.Config.ID
+	transport := events.TransportHTTP
+
+	if publisher, timeout := pm.mqttRoute(plugID); publisher != nil {
+		err := pm.sendMQTT(ctx, publisher, timeout, info.Config, cmds, confirmed)
+		if err == nil {
+			return events.TransportMQTT, nil
+		}
+		if ctx.Err() != nil {
+			return events.TransportMQTT, err
+		}
+
+		slog.Warn(
+			"MQTT command failed, falling back to HTTP",
+			"plug_id", plugID,
+			"error", err,
+		)
+		pm.markMQTTDisconnected(plugID)
+		transport = events.TransportHTTPFallback
+	}
+
+	var err error
+	if len(cmds) == 1 {
+		_, err = info.Client.ExecuteCommand(ctx, cmds[0])
+	} else {
+		_, err = info.Client.ExecuteBacklog(ctx, cmds...)
+	}
+	return transport, err
+}
+
+func (pm *Manager) sendMQTT(
+	ctx context.Context,
+	publisher MQTTPublisher,
+	timeout time.Duration,
+	plug Plug,
+	cmds []string,
+	confirmed func(StateChangedEvent) bool,
+) error {
+	plugID := plug.ID
+	waiter := pm.waiters.add(plugID, confirmed)
+	defer pm.waiters.remove(plugID, waiter)
+
+	topic, payload := mqttCommand(plug, cmds)
+	if err := publisher.Publish(topic, payload, false, 0); err != nil {
+		return fmt.Errorf("failed to publish %s: %w", topic, err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-waiter.done:
+		slog.Debug("MQTT command confirmed", "plug_id", plugID, "topic", topic)
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("%w within %s", errNotConfirmed, timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// markMQTTDisconnected routes commands to a plug over HTTP until it is heard
+// from on the broker again.
+func (pm *Manager) markMQTTDisconnected(plugID string) {
+	pm.mu.Lock()
+	state, ok := pm.states[plugID]
+	if !ok {
+		pm.mu.Unlock()
+		return
+	}
+	state.MQTTConnected = false
+	stateCopy := state.Clone()
+	pm.mu.Unlock()
+
+	pm.publishStateUpdate("mqtt-timeout", plugID, stateCopy)
+}
+
+// publishCommand records a command sent to a plug on the event bus.
+func (pm *Manager) publishCommand(ctx context.Context, event events.CommandEvent) {
+	if pm.eventBus == nil || pm.stateEventClient == nil {
+		return
+	}
+
+	event.Timestamp = time.Now()
+	event.Source = sourceFrom(ctx)
+	pm.eventBus.PublishCommand(pm.stateEventClient, event)
+}
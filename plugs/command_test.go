@@ -0,0 +1,185 @@
+package plugs
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/util/eventbus"
+)
+
+// fakePublisher records published messages and optionally answers them like
+// a device would.
+type fakePublisher struct {
+	mu        sync.Mutex
+	published map[string]string
+	reply     func(topic string)
+}
+
+func (f *fakePublisher) Publish(topic string, payload []byte, _ bool, _ byte) error {
+	f.mu.Lock()
+	if f.published == nil {
+		f.published = make(map[string]string)
+	}
+	f.published[topic] = string(payload)
+	reply := f.reply
+	f.mu.Unlock()
+
+	if reply != nil {
+		go reply(topic)
+	}
+	return nil
+}
+
+func (f *fakePublisher) payload(topic string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	payload, ok := f.published[topic]
+	return payload, ok
+}
+
+// connectMQTT marks plug-1 as holding a broker session.
+func connectMQTT(t *testing.T, pm *Manager) {
+	t.Helper()
+	pm.mu.Lock()
+	pm.states["plug-1"].MQTTConnected = true
+	pm.mu.Unlock()
+}
+
+func subscribeCommands(t *testing.T, pm *Manager) *eventbus.Subscriber[events.CommandEvent] {
+	t.Helper()
+	client, err := pm.eventBus.Client(events.ClientMetrics)
+	require.NoError(t, err)
+	sub := eventbus.Subscribe[events.CommandEvent](client)
+	t.Cleanup(sub.Close)
+	return sub
+}
+
+func nextCommand(t *testing.T, sub *eventbus.Subscriber[events.CommandEvent]) events.CommandEvent {
+	t.Helper()
+	select {
+	case evt := <-sub.Events():
+		return evt
+	case <-time.After(time.Second):
+		t.Fatal("expected command event")
+		return events.CommandEvent{}
+	}
+}
+
+func TestSetPowerOverMQTT(t *testing.T) {
+	pm, fake, _ := newTestManager(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go pm.ProcessStateEvents(ctx)
+
+	// The device answers on stat/.../RESULT, which the MQTT hook turns into
+	// a state event
+	publisher := &fakePublisher{reply: func(string) {
+		state := State{ID: "plug-1", MQTTConnected: true}
+		state.SetRelay(1, true)
+		pm.statePublisher.Publish(StateChangedEvent{
+			PlugID:        "plug-1",
+			State:         state,
+			UpdatedFields: []string{"On", RelayField(1)},
+		})
+	}}
+	pm.SetMQTTPublisher(publisher, time.Second)
+	connectMQTT(t, pm)
+	sub := subscribeCommands(t, pm)
+
+	require.NoError(t, pm.SetPower(WithSource(ctx, "web"), "plug-1", true))
+
+	payload, ok := publisher.payload("cmnd/tasmota/plug-1/Power")
+	require.True(t, ok)
+	require.Equal(t, "ON", payload)
+	require.Empty(t, fake.lastCmd, "no HTTP request expected")
+
+	evt := nextCommand(t, sub)
+	require.Equal(t, events.TransportMQTT, evt.Transport)
+	require.Equal(t, "web", evt.Source)
+}
+
+func TestSetPowerSwitchesEveryRelay(t *testing.T) {
+	pm, _, _ := newTestManager(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go pm.ProcessStateEvents(ctx)
+
+	pm.mu.Lock()
+	pm.plugs["plug-1"].Config.Relays = []Relay{{}, {}}
+	pm.mu.Unlock()
+
+	// A device answers Power0 with every relay; only relay 2 switching off
+	// does not confirm it
+	var replies []StateChangedEvent
+	for _, relays := range [][]bool{{true, false}, {false, false}} {
+		state := State{ID: "plug-1", MQTTConnected: true}
+		for i, on := range relays {
+			state.SetRelay(i+1, on)
+		}
+		replies = append(replies, StateChangedEvent{
+			PlugID:        "plug-1",
+			State:         state,
+			UpdatedFields: []string{"On", RelayField(1), RelayField(2)},
+		})
+	}
+	confirmed := powerConfirmed(allRelays, 2, false)
+	require.False(t, confirmed(replies[0]))
+	require.True(t, confirmed(replies[1]))
+
+	publisher := &fakePublisher{reply: func(string) { pm.statePublisher.Publish(replies[1]) }}
+	pm.SetMQTTPublisher(publisher, time.Second)
+	connectMQTT(t, pm)
+	sub := subscribeCommands(t, pm)
+
+	require.NoError(t, pm.SetRelayPower(ctx, "plug-1", 0, false))
+	payload, ok := publisher.payload("cmnd/tasmota/plug-1/Power0")
+	require.True(t, ok)
+	require.Equal(t, "OFF", payload)
+	require.Equal(t, events.TransportMQTT, nextCommand(t, sub).Transport)
+
+	require.Error(t, pm.SetRelayPower(ctx, "plug-1", -1, false))
+}
+
+func TestSetPowerFallsBackToHTTP(t *testing.T) {
+	pm, fake, _ := newTestManager(t)
+	publisher := &fakePublisher{}
+	pm.SetMQTTPublisher(publisher, 10*time.Millisecond)
+	connectMQTT(t, pm)
+	sub := subscribeCommands(t, pm)
+
+	require.NoError(t, pm.SetPower(context.Background(), "plug-1", true))
+
+	_, ok := publisher.payload("cmnd/tasmota/plug-1/Power")
+	require.True(t, ok)
+	require.Equal(t, events.TransportHTTPFallback, nextCommand(t, sub).Transport)
+
+	// The unconfirmed plug is reached over HTTP until it is heard from again
+	_, state, _ := pm.Plug("plug-1")
+	require.False(t, state.MQTTConnected)
+
+	fake.mu.Lock()
+	fake.lastCmd = ""
+	fake.mu.Unlock()
+	require.NoError(t, pm.SetPower(context.Background(), "plug-1", false))
+	require.Equal(t, events.TransportHTTP, nextCommand(t, sub).Transport)
+	require.Equal(t, "Status 0", fake.lastCmd)
+}
+
+func TestMQTTCommand(t *testing.T) {
+	bulb := Plug{ID: "bulb"}
+	topic, payload := mqttCommand(bulb, []string{"Dimmer 40"})
+	require.Equal(t, "cmnd/tasmota/bulb/Dimmer", topic)
+	require.Equal(t, "40", string(payload))
+
+	topic, payload = mqttCommand(bulb, []string{"Dimmer 40", "CT 300"})
+	require.Equal(t, "cmnd/tasmota/bulb/Backlog", topic)
+	require.Equal(t, "Dimmer 40; CT 300", string(payload))
+
+	shared := Plug{ID: "bulb", Topic: "kitchen", FullTopic: "%topic%/%prefix%/"}
+	topic, _ = mqttCommand(shared, []string{"Dimmer 40"})
+	require.Equal(t, "kitchen/cmnd/Dimmer", topic)
+}
@@ -0,0 +1,80 @@
+package plugs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tailscale/hujson"
+)
+
+// AddPlugToConfig appends plug to the HuJSON configuration file at path,
+// keeping existing comments. The resulting file is validated before it
+// replaces the original, so a rejected plug leaves the file untouched.
+func AddPlugToConfig(path string, plug Plug) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read plugs config file: %w", err)
+	}
+
+	value, err := hujson.Parse(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse HuJSON: %w", err)
+	}
+
+	plugJSON, err := json.Marshal(plug)
+	if err != nil {
+		return fmt.Errorf("failed to marshal plug: %w", err)
+	}
+	patch := fmt.Sprintf(`[{"op":"add","path":"/plugs/-","value":%s}]`, plugJSON)
+	if err := value.Patch([]byte(patch)); err != nil {
+		return fmt.Errorf("failed to add plug %s: %w", plug.ID, err)
+	}
+	value.Format()
+	updated := value.Pack()
+
+	if _, err := ParseConfig(updated); err != nil {
+		return fmt.Errorf("plug %s rejected: %w", plug.ID, err)
+	}
+
+	return writeFileAtomic(path, updated)
+}
+
+// RestoreConfig replaces the configuration file at path with data, the
+// content read before an edit that has to be undone.
+func RestoreConfig(path string, data []byte) error {
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic replaces path with data via a temporary file in the same
+// directory, so readers never see a partially written configuration.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary config file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary config file: %w", err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set config file mode: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary config file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace plugs config file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package plugs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAddPlugToConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plugs.hujson")
+	if err := os.WriteFile(path, []byte(`{"plugs":[{"id":"a","name":"A","address":"1"}]}`), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if err := AddPlugToConfig(path, Plug{ID: "b", Name: "B", Address: "2"}); err != nil {
+		t.Fatalf("AddPlugToConfig() error = %v", err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if len(cfg.Plugs) != 2 || cfg.Plugs[1].ID != "b" {
+		t.Fatalf("expected plug b appended, got %+v", cfg.Plugs)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected mode 0600 to be kept, got %v", info.Mode().Perm())
+	}
+}
+
+func TestAddPlugToConfigRejectsDuplicate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plugs.hujson")
+	original := []byte(`{"plugs":[{"id":"a","name":"A","address":"1"}]}`)
+	if err := os.WriteFile(path, original, 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if err := AddPlugToConfig(path, Plug{ID: "a", Name: "Again", Address: "2"}); err == nil {
+		t.Fatal("expected error for duplicate ID")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(data) != string(original) {
+		t.Fatalf("expected file untouched, got %s", data)
+	}
+}
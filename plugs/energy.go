@@ -0,0 +1,43 @@
+package plugs
+
+import "time"
+
+// EnergyReading is a set of energy counters reported by a device, from
+// ENERGY telemetry or a Status 0 poll.
+type EnergyReading struct {
+	Time      time.Time
+	Power     float64 // W
+	Total     float64 // kWh since the counter was last reset
+	Today     float64 // kWh
+	Yesterday float64 // kWh
+}
+
+// EnergyRecorder receives the energy readings of power-monitored plugs.
+type EnergyRecorder interface {
+	Record(plugID string, reading EnergyReading)
+}
+
+// SetEnergyRecorder sets where energy readings are sent.
+func (pm *Manager) SetEnergyRecorder(recorder EnergyRecorder) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.energyRecorder = recorder
+}
+
+// energyReading returns the recorder and the energy counters of state to
+// pass it, with a nil recorder when energy is not recorded. The caller must
+// hold pm.mu, and call Record only after releasing it: recording prices the
+// reading and publishes cost events.
+func (pm *Manager) energyReading(state State) (EnergyRecorder, EnergyReading) {
+	at := state.LastUpdated
+	if at.IsZero() {
+		at = time.Now()
+	}
+	return pm.energyRecorder, EnergyReading{
+		Time:      at,
+		Power:     state.Power,
+		Total:     state.Energy,
+		Today:     state.EnergyToday,
+		Yesterday: state.EnergyYesterday,
+	}
+}
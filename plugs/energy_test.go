@@ -0,0 +1,81 @@
+package plugs
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRecorder struct {
+	mu       sync.Mutex
+	readings []EnergyReading
+	// onRecord runs for every reading, e.g. to read back the manager
+	onRecord func()
+}
+
+func (f *fakeRecorder) Record(_ string, reading EnergyReading) {
+	if f.onRecord != nil {
+		f.onRecord()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.readings = append(f.readings, reading)
+}
+
+func (f *fakeRecorder) recorded() []EnergyReading {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]EnergyReading(nil), f.readings...)
+}
+
+func TestGetStatusRecordsEnergy(t *testing.T) {
+	pm, fake, _ := newTestManager(t)
+	// Recording runs without the manager's lock held
+	recorder := &fakeRecorder{onRecord: func() { pm.Plug("plug-1") }}
+	pm.SetEnergyRecorder(recorder)
+
+	fake.responses = [][]byte{[]byte(`{"StatusSTS":{"POWER":"ON"},"StatusSNS":{"ENERGY":{"Power":12.5,"Total":3.2,"Today":0.4,"Yesterday":0.9}}}`)}
+	_, err := pm.GetStatus(context.Background(), "plug-1")
+	require.NoError(t, err)
+	require.Empty(t, recorder.recorded(), "plug without power monitoring")
+
+	pm.plugs["plug-1"].Config.Features = &PlugFeatures{PowerMonitoring: true}
+	fake.responses = [][]byte{[]byte(`{"StatusSTS":{"POWER":"ON"},"StatusSNS":{"ENERGY":{"Power":12.5,"Total":3.2,"Today":0.4,"Yesterday":0.9}}}`)}
+	state, err := pm.GetStatus(context.Background(), "plug-1")
+	require.NoError(t, err)
+	require.Equal(t, 0.4, state.EnergyToday)
+	require.Equal(t, 0.9, state.EnergyYesterday)
+
+	readings := recorder.recorded()
+	require.Len(t, readings, 1)
+	require.Equal(t, EnergyReading{Time: state.LastUpdated, Power: 12.5, Total: 3.2, Today: 0.4, Yesterday: 0.9}, readings[0])
+}
+
+func TestEnergyTelemetryIsRecorded(t *testing.T) {
+	pm, _, _ := newTestManager(t)
+	recorder := &fakeRecorder{onRecord: func() { pm.Plug("plug-1") }}
+	pm.SetEnergyRecorder(recorder)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go pm.ProcessStateEvents(ctx)
+
+	pm.statePublisher.Publish(StateChangedEvent{
+		PlugID:        "plug-1",
+		State:         State{ID: "plug-1", On: true},
+		UpdatedFields: []string{"On"},
+	})
+	pm.statePublisher.Publish(StateChangedEvent{
+		PlugID:        "plug-1",
+		State:         State{ID: "plug-1", Power: 40, Energy: 7.5, EnergyToday: 1.5},
+		UpdatedFields: []string{"Power", "Energy", "EnergyToday"},
+	})
+
+	require.Eventually(t, func() bool { return len(recorder.recorded()) == 1 }, time.Second, 10*time.Millisecond)
+	reading := recorder.recorded()[0]
+	require.Equal(t, 40.0, reading.Power)
+	require.Equal(t, 7.5, reading.Total)
+	require.Equal(t, 1.5, reading.Today)
+}
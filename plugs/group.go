@@ -0,0 +1,307 @@
+package plugs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Aggregations of a group's state.
+const (
+	GroupAny = "any"
+	GroupAll = "all"
+)
+
+var (
+	ErrUnknownGroup = errors.New("unknown group")
+	ErrUnknownScene = errors.New("unknown scene")
+)
+
+// Group is a set of plugs switched as one, e.g. all downstairs lamps.
+// Multi-relay plugs are switched as a whole, every relay at once, and
+// counted by their default output.
+type Group struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name"`
+	Plugs []string `json:"plugs"`
+
+	// Aggregate is when the group counts as on: "any" (default) member on,
+	// or "all" members on.
+	Aggregate string `json:"aggregate,omitempty"`
+
+	HomeKit *bool `json:"homekit,omitempty"` // default true
+}
+
+// On aggregates the on state of the group's members. Members missing from
+// on count as off.
+func (g Group) On(on map[string]bool) bool {
+	if g.Aggregate == GroupAll {
+		for _, id := range g.Plugs {
+			if !on[id] {
+				return false
+			}
+		}
+		return len(g.Plugs) > 0
+	}
+	for _, id := range g.Plugs {
+		if on[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// Scene is a named target state for a number of plugs, applied at once.
+type Scene struct {
+	ID    string        `json:"id"`
+	Name  string        `json:"name"`
+	Plugs []SceneTarget `json:"plugs"`
+
+	HomeKit *bool `json:"homekit,omitempty"` // default true
+}
+
+// SceneTarget is the state a scene puts a plug, or one of its relays, in.
+type SceneTarget struct {
+	Plug   string `json:"plug"`
+	Relay  int    `json:"relay,omitempty"` // 1-based, 0 for the whole device (every relay)
+	Action string `json:"action"`          // "on" or "off"
+
+	// Brightness in percent, for dimmable bulbs switched on.
+	Brightness *int `json:"brightness,omitempty"`
+}
+
+// On reports whether the target switches its plug on.
+func (t SceneTarget) On() bool {
+	return t.Action == "on"
+}
+
+func (c *Config) validateGroups() error {
+	seen := make(map[string]bool, len(c.Groups))
+	for i, g := range c.Groups {
+		if g.ID == "" {
+			return fmt.Errorf("group %d has no id", i)
+		}
+		if seen[g.ID] {
+			return fmt.Errorf("duplicate group id %q", g.ID)
+		}
+		seen[g.ID] = true
+		if g.Name == "" {
+			return fmt.Errorf("group %s has no name", g.ID)
+		}
+		if g.Aggregate != "" && g.Aggregate != GroupAny && g.Aggregate != GroupAll {
+			return fmt.Errorf("group %s aggregate must be %q or %q, got %q", g.ID, GroupAny, GroupAll, g.Aggregate)
+		}
+		if len(g.Plugs) == 0 {
+			return fmt.Errorf("group %s has no plugs", g.ID)
+		}
+		for j, id := range g.Plugs {
+			if _, ok := c.plug(id); !ok {
+				return fmt.Errorf("group %s has unknown plug %q", g.ID, id)
+			}
+			if slices.Contains(g.Plugs[:j], id) {
+				return fmt.Errorf("group %s lists plug %s twice", g.ID, id)
+			}
+		}
+	}
+
+	seen = make(map[string]bool, len(c.Scenes))
+	for i, s := range c.Scenes {
+		if s.ID == "" {
+			return fmt.Errorf("scene %d has no id", i)
+		}
+		if seen[s.ID] {
+			return fmt.Errorf("duplicate scene id %q", s.ID)
+		}
+		seen[s.ID] = true
+		if s.Name == "" {
+			return fmt.Errorf("scene %s has no name", s.ID)
+		}
+		if len(s.Plugs) == 0 {
+			return fmt.Errorf("scene %s has no plugs", s.ID)
+		}
+		for j, t := range s.Plugs {
+			plug, ok := c.plug(t.Plug)
+			if !ok {
+				return fmt.Errorf("scene %s targets unknown plug %q", s.ID, t.Plug)
+			}
+			if t.Relay < 0 || t.Relay > plug.RelayCount() {
+				return fmt.Errorf("scene %s: plug %s has no relay %d", s.ID, t.Plug, t.Relay)
+			}
+			if slices.ContainsFunc(s.Plugs[:j], func(other SceneTarget) bool {
+				return other.Plug == t.Plug && other.Relay == t.Relay
+			}) {
+				return fmt.Errorf("scene %s targets plug %s twice", s.ID, t.Plug)
+			}
+			if t.Action != "on" && t.Action != "off" {
+				return fmt.Errorf("scene %s action must be \"on\" or \"off\", got %q", s.ID, t.Action)
+			}
+			if t.Brightness == nil {
+				continue
+			}
+			switch {
+			case !plug.HasBrightness():
+				return fmt.Errorf("scene %s: plug %s is not a dimmable bulb", s.ID, t.Plug)
+			case !t.On():
+				return fmt.Errorf("scene %s sets the brightness of plug %s while switching it off", s.ID, t.Plug)
+			case *t.Brightness < 0 || *t.Brightness > 100:
+				return fmt.Errorf("scene %s brightness of plug %s must be 0-100", s.ID, t.Plug)
+			}
+		}
+	}
+	return nil
+}
+
+// TargetError is a plug a group or scene failed to switch.
+type TargetError struct {
+	Plug  string
+	Relay int
+	Err   error
+}
+
+func (e TargetError) Error() string {
+	if e.Relay > 0 {
+		return fmt.Sprintf("%s relay %d: %v", e.Plug, e.Relay, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Plug, e.Err)
+}
+
+func (e TargetError) Unwrap() error {
+	return e.Err
+}
+
+// ApplyResult reports how switching a group or applying a scene went. The
+// plugs are switched independently, so some may fail while others succeed.
+type ApplyResult struct {
+	Total  int
+	Failed []TargetError
+}
+
+// Err returns an error listing the plugs that failed, nil if none did.
+func (r ApplyResult) Err() error {
+	if len(r.Failed) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(r.Failed))
+	for _, failed := range r.Failed {
+		msgs = append(msgs, failed.Error())
+	}
+	return fmt.Errorf("%d of %d plugs failed: %s", len(r.Failed), r.Total, strings.Join(msgs, "; "))
+}
+
+// SetGroups replaces the groups and scenes with the ones in cfg.
+func (pm *Manager) SetGroups(cfg *Config) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.groups = slices.Clone(cfg.Groups)
+	pm.scenes = slices.Clone(cfg.Scenes)
+}
+
+// Groups returns the configured groups, in configuration order.
+func (pm *Manager) Groups() []Group {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return slices.Clone(pm.groups)
+}
+
+// Scenes returns the configured scenes, in configuration order.
+func (pm *Manager) Scenes() []Scene {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return slices.Clone(pm.scenes)
+}
+
+// SetGroupPower switches every plug of a group on or off at once.
+func (pm *Manager) SetGroupPower(ctx context.Context, groupID string, on bool) (ApplyResult, error) {
+	pm.mu.RLock()
+	idx := slices.IndexFunc(pm.groups, func(g Group) bool { return g.ID == groupID })
+	var group Group
+	if idx >= 0 {
+		group = pm.groups[idx]
+	}
+	pm.mu.RUnlock()
+	if idx < 0 {
+		return ApplyResult{}, fmt.Errorf("%w %q", ErrUnknownGroup, groupID)
+	}
+
+	action := "off"
+	if on {
+		action = "on"
+	}
+	targets := make([]SceneTarget, 0, len(group.Plugs))
+	for _, id := range group.Plugs {
+		targets = append(targets, SceneTarget{Plug: id, Action: action})
+	}
+	return pm.apply(ctx, targets), nil
+}
+
+// ApplyScene puts every plug of a scene in its target state at once.
+func (pm *Manager) ApplyScene(ctx context.Context, sceneID string) (ApplyResult, error) {
+	pm.mu.RLock()
+	idx := slices.IndexFunc(pm.scenes, func(s Scene) bool { return s.ID == sceneID })
+	var scene Scene
+	if idx >= 0 {
+		scene = pm.scenes[idx]
+	}
+	pm.mu.RUnlock()
+	if idx < 0 {
+		return ApplyResult{}, fmt.Errorf("%w %q", ErrUnknownScene, sceneID)
+	}
+
+	return pm.apply(ctx, scene.Plugs), nil
+}
+
+// apply switches the targets concurrently and collects the failures, in
+// target order.
+func (pm *Manager) apply(ctx context.Context, targets []SceneTarget) ApplyResult {
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := pm.SetRelayPower(ctx, target.Plug, target.Relay, target.On()); err != nil {
+				errs[i] = err
+				return
+			}
+			if target.Brightness != nil {
+				errs[i] = pm.SetLight(ctx, target.Plug, LightSettings{Brightness: target.Brightness})
+			}
+		}()
+	}
+	wg.Wait()
+
+	result := ApplyResult{Total: len(targets)}
+	for i, err := range errs {
+		if err != nil {
+			result.Failed = append(result.Failed, TargetError{Plug: targets[i].Plug, Relay: targets[i].Relay, Err: err})
+		}
+	}
+	return result
+}
+
+// processGroupCommand switches a group or applies a scene for a command
+// event, e.g. from HomeKit.
+func (pm *Manager) processGroupCommand(ctx context.Context, cmd CommandEvent) {
+	var result ApplyResult
+	var err error
+	if cmd.Scene != "" {
+		result, err = pm.ApplyScene(ctx, cmd.Scene)
+	} else {
+		result, err = pm.SetGroupPower(ctx, cmd.Group, cmd.On)
+	}
+	if err == nil {
+		err = result.Err()
+	}
+	if err != nil {
+		slog.Error(
+			"Failed to process group command",
+			"group", cmd.Group,
+			"scene", cmd.Scene,
+			"error", err,
+		)
+	}
+}
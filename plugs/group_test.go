@@ -0,0 +1,123 @@
+package plugs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateGroups(t *testing.T) {
+	brightness := func(v int) *int { return &v }
+	for _, tt := range []struct {
+		name   string
+		group  *Group
+		scene  *Scene
+		errMsg string
+	}{
+		{"group", &Group{ID: "g", Name: "G", Plugs: []string{"a", "bulb"}, Aggregate: "all"}, nil, ""},
+		{"group no name", &Group{ID: "g", Plugs: []string{"a"}}, nil, "has no name"},
+		{"group aggregate", &Group{ID: "g", Name: "G", Plugs: []string{"a"}, Aggregate: "most"}, nil, "aggregate must be"},
+		{"group empty", &Group{ID: "g", Name: "G"}, nil, "has no plugs"},
+		{"group unknown plug", &Group{ID: "g", Name: "G", Plugs: []string{"x"}}, nil, `unknown plug "x"`},
+		{"group twice", &Group{ID: "g", Name: "G", Plugs: []string{"a", "a"}}, nil, "lists plug a twice"},
+		{"scene", nil, &Scene{ID: "s", Name: "S", Plugs: []SceneTarget{{Plug: "a", Action: "off"}, {Plug: "bulb", Action: "on", Brightness: brightness(30)}}}, ""},
+		{"scene no id", nil, &Scene{Name: "S", Plugs: []SceneTarget{{Plug: "a", Action: "off"}}}, "has no id"},
+		{"scene empty", nil, &Scene{ID: "s", Name: "S"}, "has no plugs"},
+		{"scene relay", nil, &Scene{ID: "s", Name: "S", Plugs: []SceneTarget{{Plug: "a", Relay: 2, Action: "on"}}}, "has no relay 2"},
+		{"scene action", nil, &Scene{ID: "s", Name: "S", Plugs: []SceneTarget{{Plug: "a", Action: "dim"}}}, "action must be"},
+		{"scene twice", nil, &Scene{ID: "s", Name: "S", Plugs: []SceneTarget{{Plug: "a", Action: "on"}, {Plug: "a", Action: "off"}}}, "targets plug a twice"},
+		{"scene not dimmable", nil, &Scene{ID: "s", Name: "S", Plugs: []SceneTarget{{Plug: "a", Action: "on", Brightness: brightness(30)}}}, "not a dimmable bulb"},
+		{"scene dim off", nil, &Scene{ID: "s", Name: "S", Plugs: []SceneTarget{{Plug: "bulb", Action: "off", Brightness: brightness(30)}}}, "while switching it off"},
+		{"scene brightness range", nil, &Scene{ID: "s", Name: "S", Plugs: []SceneTarget{{Plug: "bulb", Action: "on", Brightness: brightness(150)}}}, "must be 0-100"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Plugs: []Plug{
+				{ID: "a", Name: "A", Address: "1"},
+				{ID: "bulb", Name: "Bulb", Address: "2", Type: "bulb", Features: &PlugFeatures{Dimmer: true}},
+			}}
+			if tt.group != nil {
+				cfg.Groups = []Group{*tt.group}
+			}
+			if tt.scene != nil {
+				cfg.Scenes = []Scene{*tt.scene}
+			}
+			err := cfg.validateGroups()
+			if tt.errMsg == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestGroupOn(t *testing.T) {
+	anyOn := Group{Plugs: []string{"a", "b"}}
+	allOn := Group{Plugs: []string{"a", "b"}, Aggregate: GroupAll}
+
+	require.False(t, anyOn.On(nil))
+	require.True(t, anyOn.On(map[string]bool{"b": true}))
+	require.False(t, allOn.On(map[string]bool{"b": true}))
+	require.True(t, allOn.On(map[string]bool{"a": true, "b": true}))
+}
+
+type failingClient struct{}
+
+func (failingClient) ExecuteCommand(context.Context, string) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (failingClient) ExecuteBacklog(context.Context, ...string) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestApplyScenePartialFailure(t *testing.T) {
+	eventBus, err := events.New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = eventBus.Close() })
+
+	cfg := &Config{
+		Plugs: []Plug{
+			{ID: "a", Name: "A", Address: "1"},
+			{ID: "b", Name: "B", Address: "2"},
+			{ID: "c", Name: "C", Address: "3"},
+		},
+		Groups: []Group{{ID: "downstairs", Name: "Downstairs", Plugs: []string{"a", "b"}}},
+		Scenes: []Scene{{ID: "night", Name: "Night", Plugs: []SceneTarget{
+			{Plug: "a", Action: "off"},
+			{Plug: "b", Action: "on"},
+			{Plug: "c", Action: "off"},
+		}}},
+	}
+	pm, err := NewManager(cfg.Plugs, make(chan CommandEvent), eventBus)
+	require.NoError(t, err)
+	pm.SetGroups(cfg)
+	a, b := &fakeClient{}, &fakeClient{}
+	pm.plugs["a"].Client = a
+	pm.plugs["b"].Client = b
+	pm.plugs["c"].Client = failingClient{}
+
+	ctx := context.Background()
+	result, err := pm.ApplyScene(ctx, "night")
+	require.NoError(t, err)
+	require.Equal(t, 3, result.Total)
+	require.Len(t, result.Failed, 1)
+	require.Equal(t, "c", result.Failed[0].Plug)
+	require.ErrorContains(t, result.Err(), "1 of 3 plugs failed: c: ")
+	require.Equal(t, "Status 0", a.lastCmd)
+	require.True(t, pm.states["b"].On)
+
+	result, err = pm.SetGroupPower(ctx, "downstairs", true)
+	require.NoError(t, err)
+	require.NoError(t, result.Err())
+
+	_, err = pm.ApplyScene(ctx, "missing")
+	require.ErrorIs(t, err, ErrUnknownScene)
+	_, err = pm.SetGroupPower(ctx, "missing", true)
+	require.ErrorIs(t, err, ErrUnknownGroup)
+}
@@ -0,0 +1,109 @@
+package plugs
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kradalby/tasmota-homekit/events"
+)
+
+// Tasmota's CT command accepts mireds in this range (6500K..2000K).
+const (
+	MinColorTemperature = 153
+	MaxColorTemperature = 500
+)
+
+// LightSettings carries the light attributes a command should change; nil
+// fields are left untouched.
+type LightSettings struct {
+	Brightness       *int     // 0-100 (Dimmer)
+	Hue              *float64 // 0-360 (HSBColor1)
+	Saturation       *float64 // 0-100 (HSBColor2)
+	ColorTemperature *int     // mireds (CT)
+}
+
+// Empty reports whether no attribute is set.
+func (l LightSettings) Empty() bool {
+	return l.Brightness == nil && l.Hue == nil && l.Saturation == nil && l.ColorTemperature == nil
+}
+
+// commands returns the Tasmota commands applying the settings, with values
+// clamped to the ranges Tasmota accepts.
+func (l LightSettings) commands() []string {
+	var cmds []string
+	if l.Brightness != nil {
+		cmds = append(cmds, fmt.Sprintf("Dimmer %d", clampInt(*l.Brightness, 0, 100)))
+	}
+	if l.Hue != nil {
+		cmds = append(cmds, fmt.Sprintf("HSBColor1 %d", int(math.Round(clampFloat(*l.Hue, 0, 360)))))
+	}
+	if l.Saturation != nil {
+		cmds = append(cmds, fmt.Sprintf("HSBColor2 %d", int(math.Round(clampFloat(*l.Saturation, 0, 100)))))
+	}
+	if l.ColorTemperature != nil {
+		cmds = append(cmds, fmt.Sprintf("CT %d", clampInt(*l.ColorTemperature, MinColorTemperature, MaxColorTemperature)))
+	}
+	return cmds
+}
+
+// commandEvent returns the command event recording the settings.
+func (l LightSettings) commandEvent(plugID string, transport events.Transport) events.CommandEvent {
+	event := events.CommandEvent{
+		PlugID:           plugID,
+		Brightness:       l.Brightness,
+		Hue:              l.Hue,
+		Saturation:       l.Saturation,
+		ColorTemperature: l.ColorTemperature,
+		Transport:        transport,
+	}
+	switch {
+	case l.Brightness != nil:
+		event.CommandType = events.CommandTypeSetBrightness
+	case l.Hue != nil || l.Saturation != nil:
+		event.CommandType = events.CommandTypeSetColor
+	case l.ColorTemperature != nil:
+		event.CommandType = events.CommandTypeSetColorTemperature
+	}
+	return event
+}
+
+// ParseLightState applies the Dimmer, HSBColor and CT fields of a Tasmota
+// payload object to state and returns the names of the updated fields.
+func ParseLightState(payload map[string]interface{}, state *State) []string {
+	var fields []string
+
+	if dimmer, ok := payload["Dimmer"].(float64); ok {
+		state.Brightness = clampInt(int(dimmer), 0, 100)
+		fields = append(fields, "Brightness")
+	}
+
+	if hsb, ok := payload["HSBColor"].(string); ok {
+		parts := strings.Split(hsb, ",")
+		if len(parts) == 3 {
+			hue, errHue := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+			sat, errSat := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+			if errHue == nil && errSat == nil {
+				state.Hue = clampFloat(hue, 0, 360)
+				state.Saturation = clampFloat(sat, 0, 100)
+				fields = append(fields, "Hue", "Saturation")
+			}
+		}
+	}
+
+	if ct, ok := payload["CT"].(float64); ok {
+		state.ColorTemperature = clampInt(int(ct), MinColorTemperature, MaxColorTemperature)
+		fields = append(fields, "ColorTemperature")
+	}
+
+	return fields
+}
+
+func clampInt(v, lo, hi int) int {
+	return min(max(v, lo), hi)
+}
+
+func clampFloat(v, lo, hi float64) float64 {
+	return math.Min(math.Max(v, lo), hi)
+}
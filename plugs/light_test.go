@@ -0,0 +1,35 @@
+package plugs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLightState(t *testing.T) {
+	var state State
+	fields := ParseLightState(map[string]interface{}{
+		"POWER":    "ON",
+		"Dimmer":   float64(42),
+		"HSBColor": "210,80,42",
+		"CT":       float64(600),
+	}, &state)
+
+	require.Equal(t, []string{"Brightness", "Hue", "Saturation", "ColorTemperature"}, fields)
+	require.Equal(t, 42, state.Brightness)
+	require.InDelta(t, 210, state.Hue, 0.001)
+	require.InDelta(t, 80, state.Saturation, 0.001)
+	require.Equal(t, MaxColorTemperature, state.ColorTemperature)
+
+	require.Empty(t, ParseLightState(map[string]interface{}{"HSBColor": "bogus"}, &state))
+}
+
+func TestLightSettingsCommands(t *testing.T) {
+	brightness := 150
+	hue := 359.6
+	ct := 100
+
+	cmds := LightSettings{Brightness: &brightness, Hue: &hue, ColorTemperature: &ct}.commands()
+	require.Equal(t, []string{"Dimmer 100", "HSBColor1 360", "CT 153"}, cmds)
+	require.True(t, LightSettings{}.Empty())
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sync"
 	"time"
 
@@ -24,6 +25,28 @@ type Manager struct {
 	stateSubscriber  *eventbus.Subscriber[StateChangedEvent]
 	eventBus         *events.Bus
 	stateEventClient *eventbus.Client
+
+	// Broker TLS listener, used for plugs with MQTTTLS
+	tlsPort        int
+	tlsFingerprint string
+
+	// MQTT command path, see SetMQTTPublisher
+	mqttPublisher MQTTPublisher
+	mqttTimeout   time.Duration
+	waiters       commandWaiters
+
+	// Recent online/offline transitions per plug, see ConnectionHistory
+	connections map[string][]ConnectionChange
+
+	// Receives energy readings, see SetEnergyRecorder
+	energyRecorder EnergyRecorder
+
+	// Checks plug states against safety rules, see SetSafetyMonitor
+	safetyMonitor SafetyMonitor
+
+	// Groups and scenes, see SetGroups
+	groups []Group
+	scenes []Scene
 }
 
 // Info holds the client and configuration for a plug.
@@ -64,6 +87,7 @@ func NewManager(
 	pm := &Manager{
 		plugs:            make(map[string]*Info),
 		states:           make(map[string]*State),
+		connections:      make(map[string][]ConnectionChange),
 		commands:         commands,
 		statePublisher:   eventbus.Publish[StateChangedEvent](client),
 		errorPublisher:   eventbus.Publish[ErrorEvent](client),
@@ -73,24 +97,13 @@ func NewManager(
 	}
 
 	for _, plugConfig := range plugConfigs {
-		client, err := tasmota.NewClient(plugConfig.Address)
+		info, err := newInfo(plugConfig)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create client for %s: %w", plugConfig.ID, err)
-		}
-
-		pm.plugs[plugConfig.ID] = &Info{
-			Config: plugConfig,
-			Client: &tasmotaClient{Client: client},
+			return nil, err
 		}
 
-		pm.states[plugConfig.ID] = &State{
-			ID:            plugConfig.ID,
-			Name:          plugConfig.Name,
-			On:            false,
-			LastUpdated:   time.Now(),
-			MQTTConnected: false,
-			LastSeen:      time.Time{},
-		}
+		pm.plugs[plugConfig.ID] = info
+		pm.states[plugConfig.ID] = newState(plugConfig)
 
 		pm.publishStateUpdate("initial", plugConfig.ID, *pm.states[plugConfig.ID])
 
@@ -104,24 +117,100 @@ func NewManager(
 	return pm, nil
 }
 
+func newInfo(plugConfig Plug) (*Info, error) {
+	client, err := tasmota.NewClient(plugConfig.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client for %s: %w", plugConfig.ID, err)
+	}
+
+	return &Info{
+		Config: plugConfig,
+		Client: &tasmotaClient{Client: client},
+	}, nil
+}
+
+func newState(plugConfig Plug) *State {
+	return &State{
+		ID:            plugConfig.ID,
+		Name:          plugConfig.Name,
+		On:            false,
+		Relays:        make([]bool, plugConfig.RelayCount()),
+		LastUpdated:   time.Now(),
+		MQTTConnected: false,
+		LastSeen:      time.Time{},
+	}
+}
+
+// info returns the client and configuration of a plug.
+func (pm *Manager) info(plugID string) (*Info, bool) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	info, ok := pm.plugs[plugID]
+	return info, ok
+}
+
+// SetMQTTTLS sets the port of the broker's TLS listener and the Tasmota
+// fingerprint of its certificate, pushed to plugs that use TLS. An empty
+// fingerprint leaves the device's fingerprint setting alone.
+func (pm *Manager) SetMQTTTLS(port int, fingerprint string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.tlsPort = port
+	pm.tlsFingerprint = fingerprint
+}
+
 // ConfigureMQTT configures a plug to use the specified MQTT broker.
+// brokerPort is replaced by the TLS port for plugs that use TLS.
 func (pm *Manager) ConfigureMQTT(ctx context.Context, plugID, brokerHost string, brokerPort int) error {
-	info, exists := pm.plugs[plugID]
+	info, exists := pm.info(plugID)
 	if !exists {
 		return fmt.Errorf("plug %s not found", plugID)
 	}
 
+	pm.mu.RLock()
+	tlsPort, fingerprint := pm.tlsPort, pm.tlsFingerprint
+	pm.mu.RUnlock()
+
+	useTLS := info.Config.MQTTTLS
+	if useTLS {
+		if tlsPort == 0 {
+			return fmt.Errorf("plug %s wants MQTT over TLS but the TLS listener is disabled", plugID)
+		}
+		brokerPort = tlsPort
+	}
+
 	slog.Info(
 		"Configuring MQTT for plug",
 		"plug_id", plugID,
 		"broker", brokerHost,
 		"port", brokerPort,
+		"tls", useTLS,
 	)
 
 	commands := []string{
 		fmt.Sprintf("MqttHost %s", brokerHost),
 		fmt.Sprintf("MqttPort %d", brokerPort),
-		fmt.Sprintf("Topic tasmota/%s", plugID),
+	}
+	if !info.Config.KeepTopic {
+		commands = append(commands,
+			fmt.Sprintf("Topic %s", info.Config.MQTTTopic()),
+			fmt.Sprintf("FullTopic %s", info.Config.MQTTFullTopic()),
+		)
+	}
+	if username, password, ok := info.Config.MQTTCredentials(); ok {
+		commands = append(commands,
+			fmt.Sprintf("MqttUser %s", username),
+			fmt.Sprintf("MqttPassword %s", password),
+		)
+	}
+	if useTLS {
+		// SetOption103 switches the connection to TLS
+		commands = append(commands, "SetOption103 1")
+		if fingerprint != "" {
+			commands = append(commands, fmt.Sprintf("MqttFingerprint %s", fingerprint))
+		}
 	}
 
 	if _, err := info.Client.ExecuteBacklog(ctx, commands...); err != nil {
@@ -134,14 +223,24 @@ func (pm *Manager) ConfigureMQTT(ctx context.Context, plugID, brokerHost string,
 
 // SetPower sets the power state of a plug.
 func (pm *Manager) SetPower(ctx context.Context, plugID string, on bool) error {
-	info, exists := pm.plugs[plugID]
+	return pm.SetRelayPower(ctx, plugID, 0, on)
+}
+
+// SetRelayPower sets the power state of a single relay (1-based) of a plug.
+// Relay 0 addresses the whole device: every relay of a multi-relay plug.
+func (pm *Manager) SetRelayPower(ctx context.Context, plugID string, relay int, on bool) error {
+	info, exists := pm.info(plugID)
 	if !exists {
 		return fmt.Errorf("plug %s not found", plugID)
 	}
+	count := info.Config.RelayCount()
+	if relay < 0 || relay > count {
+		return fmt.Errorf("plug %s has no relay %d", plugID, relay)
+	}
 
 	pm.mu.RLock()
 	state := pm.states[plugID]
-	if !state.LastSeen.IsZero() && time.Since(state.LastSeen) > 60*time.Second {
+	if state != nil && !state.LastSeen.IsZero() && time.Since(state.LastSeen) > 60*time.Second {
 		slog.Warn(
 			"Attempting to control plug that hasn't been seen recently",
 			"id", plugID,
@@ -151,12 +250,21 @@ func (pm *Manager) SetPower(ctx context.Context, plugID string, on bool) error {
 	}
 	pm.mu.RUnlock()
 
-	command := "Power OFF"
-	if on {
-		command = "Power ON"
+	target := relay
+	if relay == 0 && count > 1 {
+		target = allRelays
 	}
-
-	if _, err := info.Client.ExecuteCommand(ctx, command); err != nil {
+	command := powerCommand(target, on)
+
+	transport, err := pm.send(ctx, info, []string{command}, powerConfirmed(target, count, on))
+	pm.publishCommand(ctx, events.CommandEvent{
+		PlugID:      plugID,
+		CommandType: events.CommandTypeSetPower,
+		Relay:       relay,
+		On:          &on,
+		Transport:   transport,
+	})
+	if err != nil {
 		pm.errorPublisher.Publish(ErrorEvent{
 			PlugID: plugID,
 			Error:  fmt.Errorf("failed to set power: %w", err),
@@ -164,6 +272,11 @@ func (pm *Manager) SetPower(ctx context.Context, plugID string, on bool) error {
 		return err
 	}
 
+	// The device's MQTT confirmation already carried the new state
+	if transport == events.TransportMQTT {
+		return nil
+	}
+
 	// Immediately query status to get actual device state
 	// This replaces the optimistic update and ensures we only publish confirmed state
 	if _, err := pm.GetStatus(ctx, plugID); err != nil {
@@ -175,9 +288,45 @@ func (pm *Manager) SetPower(ctx context.Context, plugID string, on bool) error {
 	return nil
 }
 
+// SetLight changes the brightness, color or white temperature of a bulb.
+func (pm *Manager) SetLight(ctx context.Context, plugID string, settings LightSettings) error {
+	info, exists := pm.info(plugID)
+	if !exists {
+		return fmt.Errorf("plug %s not found", plugID)
+	}
+	if !info.Config.HasBrightness() {
+		return fmt.Errorf("plug %s is not a dimmable bulb", plugID)
+	}
+
+	cmds := settings.commands()
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	transport, err := pm.send(ctx, info, cmds, lightConfirmed)
+	pm.publishCommand(ctx, settings.commandEvent(plugID, transport))
+	if err != nil {
+		pm.errorPublisher.Publish(ErrorEvent{
+			PlugID: plugID,
+			Error:  fmt.Errorf("failed to set light: %w", err),
+		})
+		return err
+	}
+
+	if transport == events.TransportMQTT {
+		return nil
+	}
+
+	if _, err := pm.GetStatus(ctx, plugID); err != nil {
+		slog.Debug("Failed to get status after light command", "plug_id", plugID, "error", err)
+	}
+
+	return nil
+}
+
 // GetStatus fetches the current status of a plug.
 func (pm *Manager) GetStatus(ctx context.Context, plugID string) (*State, error) {
-	info, exists := pm.plugs[plugID]
+	info, exists := pm.info(plugID)
 	if !exists {
 		return nil, fmt.Errorf("plug %s not found", plugID)
 	}
@@ -193,19 +342,28 @@ func (pm *Manager) GetStatus(ctx context.Context, plugID string) (*State, error)
 		Status struct {
 			Power int `json:"Power"`
 		} `json:"Status"`
-		StatusSTS struct {
-			Power string `json:"POWER"`
-		} `json:"StatusSTS"`
+		StatusSTS map[string]interface{} `json:"StatusSTS"`
 		StatusSNS struct {
 			Energy struct {
-				Power   float64 `json:"Power"`
-				Voltage float64 `json:"Voltage"`
-				Current float64 `json:"Current"`
-				Total   float64 `json:"Total"`
+				Power     float64 `json:"Power"`
+				Voltage   float64 `json:"Voltage"`
+				Current   float64 `json:"Current"`
+				Total     float64 `json:"Total"`
+				Today     float64 `json:"Today"`
+				Yesterday float64 `json:"Yesterday"`
 			} `json:"ENERGY"`
 		} `json:"StatusSNS"`
 	}
 
+	// Deferred first, so the reading is recorded after pm.mu is released
+	var recorder EnergyRecorder
+	var reading EnergyReading
+	defer func() {
+		if recorder != nil {
+			recorder.Record(plugID, reading)
+		}
+	}()
+
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
@@ -215,22 +373,44 @@ func (pm *Manager) GetStatus(ctx context.Context, plugID string) (*State, error)
 			Power string `json:"POWER"`
 		}
 		if err2 := json.Unmarshal(response, &altResp); err2 == nil {
-			state := pm.states[plugID]
-			state.On = altResp.Power == "ON"
+			state, ok := pm.states[plugID]
+			if !ok {
+				return nil, fmt.Errorf("plug %s not found", plugID)
+			}
+			state.SetRelay(1, altResp.Power == "ON")
+			state.Restored = false
 			state.LastUpdated = time.Now()
-			copy := *state
+			copy := state.Clone()
 			return &copy, nil
 		}
 		return nil, fmt.Errorf("failed to parse status: %w", err)
 	}
 
-	state := pm.states[plugID]
+	state, ok := pm.states[plugID]
+	if !ok {
+		return nil, fmt.Errorf("plug %s not found", plugID)
+	}
 
-	// Update Power State (prefer StatusSTS, fallback to Status)
-	if statusResp.StatusSTS.Power != "" {
-		state.On = statusResp.StatusSTS.Power == "ON"
+	// Update Power State (prefer StatusSTS, fallback to the Status bitmask)
+	if relays := ParsePowerStates(statusResp.StatusSTS); len(relays) > 0 {
+		for relay, on := range relays {
+			state.SetRelay(relay, on)
+		}
 	} else {
-		state.On = statusResp.Status.Power == 1
+		for relay := 1; relay <= info.Config.RelayCount(); relay++ {
+			state.SetRelay(relay, statusResp.Status.Power&(1<<(relay-1)) != 0)
+		}
+	}
+
+	ParseLightState(statusResp.StatusSTS, state)
+
+	var sensorResp struct {
+		StatusSNS map[string]interface{} `json:"StatusSNS"`
+	}
+	if err := json.Unmarshal(response, &sensorResp); err == nil {
+		if sensors := ParseSensorReadings(sensorResp.StatusSNS); len(sensors) > 0 {
+			state.Sensors = sensors
+		}
 	}
 
 	// Update Energy Stats
@@ -238,9 +418,22 @@ func (pm *Manager) GetStatus(ctx context.Context, plugID string) (*State, error)
 	state.Voltage = statusResp.StatusSNS.Energy.Voltage
 	state.Current = statusResp.StatusSNS.Energy.Current
 	state.Energy = statusResp.StatusSNS.Energy.Total
+	state.EnergyToday = statusResp.StatusSNS.Energy.Today
+	state.EnergyYesterday = statusResp.StatusSNS.Energy.Yesterday
 
+	// Answering over HTTP means the device is up, even if its broker
+	// session is not
+	if state.Offline {
+		pm.recordConnection(plugID, true)
+	}
+	state.Offline = false
+	state.Restored = false
 	state.LastUpdated = time.Now()
-	copy := *state
+	copy := state.Clone()
+	if info.Config.HasPowerMonitoring() {
+		recorder, reading = pm.energyReading(copy)
+	}
+	pm.checkSafety(plugID, copy)
 	pm.publishStateUpdate("status", plugID, copy)
 	return &copy, nil
 }
@@ -276,10 +469,27 @@ func (pm *Manager) ProcessCommands(ctx context.Context) {
 	for {
 		select {
 		case cmd := <-pm.commands:
-			if err := pm.SetPower(ctx, cmd.PlugID, cmd.On); err != nil {
+			cmdCtx := WithSource(ctx, cmd.Source)
+			if cmd.Group != "" || cmd.Scene != "" {
+				// Waits for every plug, so keep it from holding up other commands
+				go pm.processGroupCommand(cmdCtx, cmd)
+				continue
+			}
+			if cmd.Light != nil {
+				if err := pm.SetLight(cmdCtx, cmd.PlugID, *cmd.Light); err != nil {
+					slog.Error(
+						"Failed to process light command",
+						"plug_id", cmd.PlugID,
+						"error", err,
+					)
+				}
+				continue
+			}
+			if err := pm.SetRelayPower(cmdCtx, cmd.PlugID, cmd.Relay, cmd.On); err != nil {
 				slog.Error(
 					"Failed to process command",
 					"plug_id", cmd.PlugID,
+					"relay", cmd.Relay,
 					"error", err,
 				)
 			}
@@ -301,13 +511,26 @@ func (pm *Manager) ProcessStateEvents(ctx context.Context) {
 				slog.Warn("Received state event for unknown plug", "plug_id", event.PlugID)
 				continue
 			}
+			wasOffline := state.Offline
 
 			if len(event.UpdatedFields) > 0 {
 				// Selective update based on what changed
 				for _, field := range event.UpdatedFields {
+					if relay, ok := parseRelayField(field); ok {
+						state.SetRelay(relay, event.State.RelayOn(relay))
+						continue
+					}
 					switch field {
 					case "On":
-						state.On = event.State.On
+						state.SetRelay(1, event.State.On)
+					case "Brightness":
+						state.Brightness = event.State.Brightness
+					case "Hue":
+						state.Hue = event.State.Hue
+					case "Saturation":
+						state.Saturation = event.State.Saturation
+					case "ColorTemperature":
+						state.ColorTemperature = event.State.ColorTemperature
 					case "Power":
 						state.Power = event.State.Power
 					case "Voltage":
@@ -316,8 +539,16 @@ func (pm *Manager) ProcessStateEvents(ctx context.Context) {
 						state.Current = event.State.Current
 					case "Energy":
 						state.Energy = event.State.Energy
+					case "EnergyToday":
+						state.EnergyToday = event.State.EnergyToday
+					case "EnergyYesterday":
+						state.EnergyYesterday = event.State.EnergyYesterday
+					case "Sensors":
+						state.Sensors = cloneSensors(event.State.Sensors)
 					case "MQTTConnected":
 						state.MQTTConnected = event.State.MQTTConnected
+					case "Offline":
+						state.Offline = event.State.Offline
 					case "LastSeen":
 						state.LastSeen = event.State.LastSeen
 					case "LastUpdated":
@@ -333,17 +564,46 @@ func (pm *Manager) ProcessStateEvents(ctx context.Context) {
 
 				if !event.State.LastUpdated.IsZero() {
 					state.LastUpdated = event.State.LastUpdated
-					state.On = event.State.On
+					state.SetRelay(1, event.State.On)
+					for i, on := range event.State.Relays {
+						state.SetRelay(i+1, on)
+					}
+					state.Brightness = event.State.Brightness
+					state.Hue = event.State.Hue
+					state.Saturation = event.State.Saturation
+					state.ColorTemperature = event.State.ColorTemperature
 					state.Power = event.State.Power
 					state.Voltage = event.State.Voltage
 					state.Current = event.State.Current
 					state.Energy = event.State.Energy
+					state.EnergyToday = event.State.EnergyToday
+					state.EnergyYesterday = event.State.EnergyYesterday
+					if event.State.Sensors != nil {
+						state.Sensors = cloneSensors(event.State.Sensors)
+					}
 				}
 			}
 
-			stateCopy := *state
+			if state.Offline != wasOffline {
+				pm.recordConnection(event.PlugID, !state.Offline)
+			}
+			if confirmsState(event) {
+				state.Restored = false
+			}
+
+			stateCopy := state.Clone()
+			var recorder EnergyRecorder
+			var reading EnergyReading
+			if slices.Contains(event.UpdatedFields, "Energy") {
+				recorder, reading = pm.energyReading(stateCopy)
+			}
+			pm.checkSafety(event.PlugID, stateCopy)
 			pm.mu.Unlock()
 
+			if recorder != nil {
+				recorder.Record(event.PlugID, reading)
+			}
+
 			slog.Debug(
 				"Merged state from eventbus",
 				"plug_id", event.PlugID,
@@ -355,6 +615,7 @@ func (pm *Manager) ProcessStateEvents(ctx context.Context) {
 				"last_seen", stateCopy.LastSeen,
 			)
 			pm.publishStateUpdate("eventbus", event.PlugID, stateCopy)
+			pm.waiters.confirm(event)
 
 		case <-ctx.Done():
 			return
@@ -392,7 +653,7 @@ func (pm *Manager) MonitorConnections(ctx context.Context, brokerHost string, br
 							)
 							pm.errorPublisher.Publish(ErrorEvent{
 								PlugID: plugID,
-								Error:  fmt.Errorf("plug never connected, reconfiguration failed: %w", err),
+								Error:  fmt.Errorf("%w, never connected and reconfiguration failed: %w", ErrUnreachable, err),
 							})
 						} else {
 							if _, err := pm.GetStatus(ctx, plugID); err != nil {
@@ -431,7 +692,7 @@ func (pm *Manager) MonitorConnections(ctx context.Context, brokerHost string, br
 							)
 							pm.errorPublisher.Publish(ErrorEvent{
 								PlugID: plugID,
-								Error:  fmt.Errorf("plug unreachable for %s: %w", timeSince, err),
+								Error:  fmt.Errorf("%w for %s: %w", ErrUnreachable, timeSince, err),
 							})
 						} else {
 							slog.Info(
@@ -478,7 +739,7 @@ func (pm *Manager) Snapshot() map[string]struct {
 			State State
 		}{
 			Plug:  info.Config,
-			State: *state,
+			State: state.Clone(),
 		}
 	}
 
@@ -500,7 +761,7 @@ func (pm *Manager) Plug(plugID string) (Plug, State, bool) {
 		return Plug{}, State{}, false
 	}
 
-	return info.Config, *state, true
+	return info.Config, state.Clone(), true
 }
 
 func (pm *Manager) publishStateUpdate(source, plugID string, state State) {
@@ -508,33 +769,53 @@ func (pm *Manager) publishStateUpdate(source, plugID string, state State) {
 		return
 	}
 
-	info, ok := pm.plugs[plugID]
-	name := plugID
-	if ok {
-		name = info.Config.Name
-	}
-
-	connectionState, connectionNote := connectionStatus(state.LastSeen)
-
-	pm.eventBus.PublishStateUpdate(pm.stateEventClient, events.StateUpdateEvent{
-		Timestamp:       time.Now(),
-		Source:          source,
-		PlugID:          plugID,
-		Name:            name,
-		On:              state.On,
-		Power:           state.Power,
-		Voltage:         state.Voltage,
-		Current:         state.Current,
-		Energy:          state.Energy,
-		MQTTConnected:   state.MQTTConnected,
-		LastSeen:        state.LastSeen,
-		LastUpdated:     state.LastUpdated,
-		ConnectionState: connectionState,
-		ConnectionNote:  connectionNote,
-	})
+	pm.eventBus.PublishStateUpdate(pm.stateEventClient, NewStateUpdateEvent(source, plugID, state))
+}
+
+// NewStateUpdateEvent converts a plug state into the event published to
+// HomeKit, the web UI and other subscribers.
+func NewStateUpdateEvent(source, plugID string, state State) events.StateUpdateEvent {
+	name := state.Name
+	if name == "" {
+		name = plugID
+	}
+
+	connectionState, connectionNote := connectionStatus(state.LastSeen, state.Offline)
+	if state.Restored {
+		connectionState = "stale"
+		connectionNote = "Restored, waiting for device"
+	}
+
+	return events.StateUpdateEvent{
+		Timestamp:        time.Now(),
+		Source:           source,
+		PlugID:           plugID,
+		Name:             name,
+		On:               state.On,
+		Relays:           append([]bool(nil), state.Relays...),
+		Brightness:       state.Brightness,
+		Hue:              state.Hue,
+		Saturation:       state.Saturation,
+		ColorTemperature: state.ColorTemperature,
+		Power:            state.Power,
+		Voltage:          state.Voltage,
+		Current:          state.Current,
+		Energy:           state.Energy,
+		Sensors:          sensorEvents(state.Sensors),
+		MQTTConnected:    state.MQTTConnected,
+		Offline:          state.Offline,
+		Restored:         state.Restored,
+		LastSeen:         state.LastSeen,
+		LastUpdated:      state.LastUpdated,
+		ConnectionState:  connectionState,
+		ConnectionNote:   connectionNote,
+	}
 }
 
-func connectionStatus(lastSeen time.Time) (string, string) {
+func connectionStatus(lastSeen time.Time, offline bool) (string, string) {
+	if offline {
+		return "disconnected", "Offline"
+	}
 	if lastSeen.IsZero() {
 		return "disconnected", "Never seen"
 	}
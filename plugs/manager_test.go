@@ -87,3 +87,104 @@ func TestConfigureMQTTBacklog(t *testing.T) {
 	require.Contains(t, fake.backlog, "MqttPort 1234")
 	require.Contains(t, fake.backlog, "Topic tasmota/plug-1")
 }
+
+func TestConfigureMQTTPushesCredentials(t *testing.T) {
+	pm, fake, _ := newTestManager(t)
+	pm.plugs["plug-1"].Config.MQTTPassword = "s3cret"
+
+	err := pm.ConfigureMQTT(context.Background(), "plug-1", "host", 1234)
+	require.NoError(t, err)
+
+	require.Contains(t, fake.backlog, "MqttUser plug-1")
+	require.Contains(t, fake.backlog, "MqttPassword s3cret")
+}
+
+func TestConfigureMQTTOverTLS(t *testing.T) {
+	pm, fake, _ := newTestManager(t)
+	pm.plugs["plug-1"].Config.MQTTTLS = true
+
+	err := pm.ConfigureMQTT(context.Background(), "plug-1", "host", 1234)
+	require.Error(t, err, "TLS listener is disabled")
+
+	pm.SetMQTTTLS(8883, "AA BB")
+	require.NoError(t, pm.ConfigureMQTT(context.Background(), "plug-1", "host", 1234))
+
+	require.Contains(t, fake.backlog, "MqttPort 8883")
+	require.Contains(t, fake.backlog, "SetOption103 1")
+	require.Contains(t, fake.backlog, "MqttFingerprint AA BB")
+}
+
+func TestConfigureMQTTTopics(t *testing.T) {
+	pm, fake, _ := newTestManager(t)
+	pm.plugs["plug-1"].Config.Topic = "desk"
+	pm.plugs["plug-1"].Config.FullTopic = "%topic%/%prefix%/"
+
+	require.NoError(t, pm.ConfigureMQTT(context.Background(), "plug-1", "host", 1234))
+	require.Contains(t, fake.backlog, "Topic desk")
+	require.Contains(t, fake.backlog, "FullTopic %topic%/%prefix%/")
+
+	fake.backlog = nil
+	pm.plugs["plug-1"].Config.KeepTopic = true
+	require.NoError(t, pm.ConfigureMQTT(context.Background(), "plug-1", "host", 1234))
+	require.NotContains(t, fake.backlog, "Topic desk")
+	require.NotContains(t, fake.backlog, "FullTopic %topic%/%prefix%/")
+}
+
+func TestSetRelayPowerUpdatesRelayState(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	eventBus, err := events.New(logger)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = eventBus.Close() })
+
+	pm, err := NewManager([]Plug{{
+		ID:      "strip",
+		Name:    "Strip",
+		Address: "1",
+		Relays:  []Relay{{Name: "Left"}, {Name: "Right"}},
+	}}, make(chan CommandEvent, 1), eventBus)
+	require.NoError(t, err)
+
+	fake := &fakeClient{responses: [][]byte{
+		nil,
+		[]byte(`{"StatusSTS":{"POWER1":"OFF","POWER2":"ON"}}`),
+	}}
+	pm.plugs["strip"].Client = fake
+
+	require.NoError(t, pm.SetRelayPower(context.Background(), "strip", 2, true))
+
+	_, state, ok := pm.Plug("strip")
+	require.True(t, ok)
+	require.False(t, state.On)
+	require.Equal(t, []bool{false, true}, state.Relays)
+
+	require.Error(t, pm.SetRelayPower(context.Background(), "strip", 3, true))
+}
+
+func TestSetLightSendsDimmer(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	eventBus, err := events.New(logger)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = eventBus.Close() })
+
+	pm, err := NewManager([]Plug{
+		{ID: "bulb", Name: "Bulb", Address: "1", Type: "bulb", Features: &PlugFeatures{Dimmer: true}},
+		{ID: "plug", Name: "Plug", Address: "2"},
+	}, make(chan CommandEvent, 1), eventBus)
+	require.NoError(t, err)
+
+	fake := &fakeClient{responses: [][]byte{
+		nil,
+		[]byte(`{"StatusSTS":{"POWER":"ON","Dimmer":60}}`),
+	}}
+	pm.plugs["bulb"].Client = fake
+
+	brightness := 60
+	require.NoError(t, pm.SetLight(context.Background(), "bulb", LightSettings{Brightness: &brightness}))
+
+	_, state, ok := pm.Plug("bulb")
+	require.True(t, ok)
+	require.True(t, state.On)
+	require.Equal(t, 60, state.Brightness)
+
+	require.Error(t, pm.SetLight(context.Background(), "plug", LightSettings{Brightness: &brightness}))
+}
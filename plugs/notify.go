@@ -0,0 +1,230 @@
+package plugs
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// Notifier types.
+const (
+	NotifierNtfy     = "ntfy"     // ntfy-style HTTP push
+	NotifierPushover = "pushover" // Pushover-compatible message API
+	NotifierSMTP     = "smtp"     // email
+)
+
+// Notification events.
+const (
+	NotifyOffline = "offline" // a plug is unreachable, and back online
+	NotifySafety  = "safety"  // a safety rule switched a plug off
+)
+
+var (
+	notifierTypes = []string{NotifierNtfy, NotifierPushover, NotifierSMTP}
+	notifyEvents  = []string{NotifyOffline, NotifySafety}
+)
+
+// Notifier is a channel notifications are sent through.
+type Notifier struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+
+	// URL is the ntfy topic URL, the Pushover-compatible messages endpoint
+	// (default https://api.pushover.net/1/messages.json), or the mail
+	// server as smtp://host:587 (STARTTLS when offered) or smtps://host:465.
+	URL string `json:"url,omitempty"`
+
+	// Token is the ntfy access token or the Pushover application token,
+	// User the Pushover user or group key.
+	Token string `json:"token,omitempty"`
+	User  string `json:"user,omitempty"`
+
+	// SMTP account and addresses.
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+}
+
+// NotifyRule decides which events are sent through which notifiers.
+type NotifyRule struct {
+	ID        string   `json:"id"`
+	Events    []string `json:"events"`
+	Plugs     []string `json:"plugs,omitempty"` // all plugs when empty
+	Notifiers []string `json:"notifiers"`
+
+	// Delay is how long a plug must stay offline before it is notified,
+	// e.g. "2m"; a plug back within it is not notified at all. Cooldown is
+	// the least time between two notifications of the same plug and event,
+	// later ones are dropped.
+	Delay    string `json:"delay,omitempty"`
+	Cooldown string `json:"cooldown,omitempty"`
+
+	// Recovery notifies when a plug notified as offline is back, default
+	// true.
+	Recovery *bool `json:"recovery,omitempty"`
+
+	// QuietHours holds notifications until the quiet hours end.
+	QuietHours *QuietHours `json:"quiet_hours,omitempty"`
+}
+
+// QuietHours are local "HH:MM" times; a Start after End spans midnight.
+type QuietHours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// Wants reports whether the rule sends event for plugID.
+func (r NotifyRule) Wants(event, plugID string) bool {
+	if !slices.Contains(r.Events, event) {
+		return false
+	}
+	return len(r.Plugs) == 0 || slices.Contains(r.Plugs, plugID)
+}
+
+// DelayDuration returns the parsed Delay, zero when unset.
+func (r NotifyRule) DelayDuration() time.Duration {
+	return parseOptionalDuration(r.Delay)
+}
+
+// CooldownDuration returns the parsed Cooldown, zero when unset.
+func (r NotifyRule) CooldownDuration() time.Duration {
+	return parseOptionalDuration(r.Cooldown)
+}
+
+// NotifyRecovery reports whether the rule notifies when a plug is back
+// online.
+func (r NotifyRule) NotifyRecovery() bool {
+	return r.Recovery == nil || *r.Recovery
+}
+
+// Contains reports whether at falls in the quiet hours.
+func (q QuietHours) Contains(at time.Time) bool {
+	// Validated when the config was parsed
+	start, _ := parseClock(q.Start)
+	end, _ := parseClock(q.End)
+	minute := at.Hour()*60 + at.Minute()
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+func (c *Config) validateNotifications() error {
+	notifiers := make(map[string]bool, len(c.Notifiers))
+	for _, n := range c.Notifiers {
+		if n.ID == "" {
+			return fmt.Errorf("notifier without an id")
+		}
+		if notifiers[n.ID] {
+			return fmt.Errorf("duplicate notifier id %q", n.ID)
+		}
+		notifiers[n.ID] = true
+
+		if err := n.validate(); err != nil {
+			return err
+		}
+	}
+
+	plugIDs := make(map[string]bool, len(c.Plugs))
+	for _, plug := range c.Plugs {
+		plugIDs[plug.ID] = true
+	}
+
+	seen := make(map[string]bool, len(c.Notifications))
+	for _, r := range c.Notifications {
+		if r.ID == "" {
+			return fmt.Errorf("notification rule without an id")
+		}
+		if seen[r.ID] {
+			return fmt.Errorf("duplicate notification rule id %q", r.ID)
+		}
+		seen[r.ID] = true
+
+		if len(r.Events) == 0 {
+			return fmt.Errorf("notification rule %s needs events", r.ID)
+		}
+		for _, event := range r.Events {
+			if !slices.Contains(notifyEvents, event) {
+				return fmt.Errorf("notification rule %s has unknown event %q", r.ID, event)
+			}
+		}
+		for _, id := range r.Plugs {
+			if !plugIDs[id] {
+				return fmt.Errorf("notification rule %s references unknown plug %q", r.ID, id)
+			}
+		}
+		if len(r.Notifiers) == 0 {
+			return fmt.Errorf("notification rule %s needs notifiers", r.ID)
+		}
+		for _, id := range r.Notifiers {
+			if !notifiers[id] {
+				return fmt.Errorf("notification rule %s references unknown notifier %q", r.ID, id)
+			}
+		}
+		for name, value := range map[string]string{"delay": r.Delay, "cooldown": r.Cooldown} {
+			if value == "" {
+				continue
+			}
+			if d, err := time.ParseDuration(value); err != nil || d < 0 {
+				return fmt.Errorf("notification rule %s has invalid %s %q", r.ID, name, value)
+			}
+		}
+		if q := r.QuietHours; q != nil {
+			if _, err := parseClock(q.Start); err != nil {
+				return fmt.Errorf("notification rule %s quiet hours: %w", r.ID, err)
+			}
+			if _, err := parseClock(q.End); err != nil {
+				return fmt.Errorf("notification rule %s quiet hours: %w", r.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (n Notifier) validate() error {
+	if !slices.Contains(notifierTypes, n.Type) {
+		return fmt.Errorf("notifier %s has unknown type %q", n.ID, n.Type)
+	}
+
+	var u *url.URL
+	if n.URL != "" {
+		var err error
+		if u, err = url.Parse(n.URL); err != nil || u.Host == "" {
+			return fmt.Errorf("notifier %s has invalid url %q", n.ID, n.URL)
+		}
+	}
+
+	switch n.Type {
+	case NotifierNtfy:
+		if u == nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("notifier %s needs the http or https url of an ntfy topic", n.ID)
+		}
+	case NotifierPushover:
+		if u != nil && u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("notifier %s needs an http or https url", n.ID)
+		}
+		// The application token may come from the secrets file
+		if n.User == "" {
+			return fmt.Errorf("notifier %s needs a pushover user key", n.ID)
+		}
+	case NotifierSMTP:
+		if u == nil || (u.Scheme != "smtp" && u.Scheme != "smtps") {
+			return fmt.Errorf("notifier %s needs an smtp:// or smtps:// url", n.ID)
+		}
+		if _, err := mail.ParseAddress(n.From); err != nil {
+			return fmt.Errorf("notifier %s has invalid from address %q", n.ID, n.From)
+		}
+		if len(n.To) == 0 {
+			return fmt.Errorf("notifier %s needs to addresses", n.ID)
+		}
+		for _, to := range n.To {
+			if _, err := mail.ParseAddress(to); err != nil {
+				return fmt.Errorf("notifier %s has invalid to address %q", n.ID, to)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package plugs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateNotifications(t *testing.T) {
+	ntfy := Notifier{ID: "phone", Type: NotifierNtfy, URL: "https://ntfy.sh/hub"}
+	for _, tt := range []struct {
+		name      string
+		notifiers []Notifier
+		rules     []NotifyRule
+		errMsg    string
+	}{
+		{"ntfy", []Notifier{ntfy}, []NotifyRule{{ID: "r", Events: []string{NotifyOffline, NotifySafety}, Notifiers: []string{"phone"}, Delay: "2m", Cooldown: "1h", QuietHours: &QuietHours{Start: "22:00", End: "07:00"}}}, ""},
+		{"pushover", []Notifier{{ID: "p", Type: NotifierPushover, User: "u"}}, nil, ""},
+		{"smtp", []Notifier{{ID: "m", Type: NotifierSMTP, URL: "smtps://mail.example.com:465", From: "Hub <hub@example.com>", To: []string{"me@example.com"}}}, nil, ""},
+		{"no id", []Notifier{{Type: NotifierNtfy}}, nil, "notifier without an id"},
+		{"duplicate", []Notifier{ntfy, ntfy}, nil, `duplicate notifier id "phone"`},
+		{"type", []Notifier{{ID: "n", Type: "sms"}}, nil, `unknown type "sms"`},
+		{"ntfy url", []Notifier{{ID: "n", Type: NotifierNtfy}}, nil, "url of an ntfy topic"},
+		{"pushover user", []Notifier{{ID: "n", Type: NotifierPushover, Token: "t"}}, nil, "pushover user key"},
+		{"smtp url", []Notifier{{ID: "n", Type: NotifierSMTP, URL: "https://mail.example.com", From: "a@b.c", To: []string{"d@e.f"}}}, nil, "smtp:// or smtps://"},
+		{"smtp to", []Notifier{{ID: "n", Type: NotifierSMTP, URL: "smtp://mail.example.com:25", From: "a@b.c"}}, nil, "needs to addresses"},
+		{"smtp from", []Notifier{{ID: "n", Type: NotifierSMTP, URL: "smtp://mail.example.com:25", From: "nobody", To: []string{"d@e.f"}}}, nil, "invalid from address"},
+		{"rule event", []Notifier{ntfy}, []NotifyRule{{ID: "r", Events: []string{"power"}, Notifiers: []string{"phone"}}}, `unknown event "power"`},
+		{"rule notifier", []Notifier{ntfy}, []NotifyRule{{ID: "r", Events: []string{NotifyOffline}, Notifiers: []string{"pager"}}}, `unknown notifier "pager"`},
+		{"rule plug", []Notifier{ntfy}, []NotifyRule{{ID: "r", Events: []string{NotifyOffline}, Plugs: []string{"x"}, Notifiers: []string{"phone"}}}, `unknown plug "x"`},
+		{"rule delay", []Notifier{ntfy}, []NotifyRule{{ID: "r", Events: []string{NotifyOffline}, Notifiers: []string{"phone"}, Delay: "soon"}}, `invalid delay "soon"`},
+		{"quiet hours", []Notifier{ntfy}, []NotifyRule{{ID: "r", Events: []string{NotifyOffline}, Notifiers: []string{"phone"}, QuietHours: &QuietHours{Start: "22:00"}}}, "quiet hours"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				Plugs:         []Plug{{ID: "a", Name: "A", Address: "1"}},
+				Notifiers:     tt.notifiers,
+				Notifications: tt.rules,
+			}
+			err := cfg.validateNotifications()
+			if tt.errMsg == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestNotifyRule(t *testing.T) {
+	off := false
+	r := NotifyRule{Events: []string{NotifyOffline}, Plugs: []string{"a"}, Recovery: &off}
+	require.True(t, r.Wants(NotifyOffline, "a"))
+	require.False(t, r.Wants(NotifyOffline, "b"))
+	require.False(t, r.Wants(NotifySafety, "a"))
+	require.False(t, r.NotifyRecovery())
+	require.True(t, NotifyRule{}.NotifyRecovery())
+
+	night := QuietHours{Start: "22:00", End: "07:00"}
+	at := func(clock string) time.Time {
+		t, _ := time.Parse("15:04", clock)
+		return t
+	}
+	require.True(t, night.Contains(at("23:30")))
+	require.True(t, night.Contains(at("06:59")))
+	require.False(t, night.Contains(at("07:00")))
+	require.False(t, night.Contains(at("12:00")))
+	require.True(t, QuietHours{Start: "12:00", End: "13:00"}.Contains(at("12:30")))
+}
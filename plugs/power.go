@@ -0,0 +1,79 @@
+package plugs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParsePowerStates extracts relay states from a Tasmota payload object.
+// "POWER" maps to relay 1, "POWER<n>" to relay n; non-power keys are ignored.
+func ParsePowerStates(payload map[string]interface{}) map[int]bool {
+	var states map[int]bool
+	for key, value := range payload {
+		relay, ok := powerKeyRelay(key)
+		if !ok {
+			continue
+		}
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if states == nil {
+			states = make(map[int]bool)
+		}
+		states[relay] = str == "ON"
+	}
+	return states
+}
+
+func powerKeyRelay(key string) (int, bool) {
+	if !strings.HasPrefix(key, "POWER") {
+		return 0, false
+	}
+	suffix := strings.TrimPrefix(key, "POWER")
+	if suffix == "" {
+		return 1, true
+	}
+	relay, err := strconv.Atoi(suffix)
+	if err != nil || relay < 1 || relay > MaxRelays {
+		return 0, false
+	}
+	return relay, true
+}
+
+// RelayField is the StateChangedEvent.UpdatedFields entry for a 1-based relay.
+func RelayField(relay int) string {
+	return fmt.Sprintf("Relay%d", relay)
+}
+
+func parseRelayField(field string) (int, bool) {
+	if !strings.HasPrefix(field, "Relay") {
+		return 0, false
+	}
+	relay, err := strconv.Atoi(strings.TrimPrefix(field, "Relay"))
+	if err != nil || relay < 1 || relay > MaxRelays {
+		return 0, false
+	}
+	return relay, true
+}
+
+// allRelays is the powerCommand target switching every relay of a device
+// at once.
+const allRelays = -1
+
+// powerCommand returns the command switching relay, 0 for the device's
+// default output or allRelays for every relay.
+func powerCommand(relay int, on bool) string {
+	value := "OFF"
+	if on {
+		value = "ON"
+	}
+	switch {
+	case relay == allRelays:
+		return "Power0 " + value
+	case relay <= 0:
+		return "Power " + value
+	}
+	return fmt.Sprintf("Power%d %s", relay, value)
+}
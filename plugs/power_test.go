@@ -0,0 +1,28 @@
+package plugs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePowerStates(t *testing.T) {
+	states := ParsePowerStates(map[string]interface{}{
+		"POWER1":  "ON",
+		"POWER2":  "OFF",
+		"POWER3":  "ON",
+		"POWERX":  "ON",
+		"Dimmer":  50,
+		"POWER99": "ON",
+	})
+
+	require.Equal(t, map[int]bool{1: true, 2: false, 3: true}, states)
+	require.Equal(t, map[int]bool{1: true}, ParsePowerStates(map[string]interface{}{"POWER": "ON"}))
+	require.Nil(t, ParsePowerStates(map[string]interface{}{"Uptime": "1T00:00:00"}))
+}
+
+func TestPowerCommand(t *testing.T) {
+	require.Equal(t, "Power ON", powerCommand(0, true))
+	require.Equal(t, "Power2 OFF", powerCommand(2, false))
+	require.Equal(t, "Power0 OFF", powerCommand(allRelays, false))
+}
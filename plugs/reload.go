@@ -0,0 +1,157 @@
+package plugs
+
+import (
+	"log/slog"
+	"reflect"
+	"slices"
+	"sort"
+)
+
+// ConfigDiff describes how a reloaded configuration differs from the running one.
+type ConfigDiff struct {
+	Added       []string
+	Removed     []string
+	Updated     []string
+	Readdressed []string // updated plugs whose address changed, subset of Updated
+	MQTT        []string // updated plugs whose MQTT credentials, TLS or topics changed, subset of Updated
+	Safety      []string // updated plugs whose safety limits changed, subset of Updated
+	Buttons     []string // updated plugs whose buttons changed, subset of Updated
+}
+
+// Reprovision returns the plugs whose device needs ConfigureMQTT again.
+func (d ConfigDiff) Reprovision() []string {
+	ids := append(append([]string(nil), d.Added...), d.Readdressed...)
+	for _, id := range d.MQTT {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Empty reports whether the reload changed nothing.
+func (d ConfigDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Updated) == 0
+}
+
+// Reconcile replaces the managed plug set with plugConfigs, keeping the state
+// of plugs that remain. Clients for new or re-addressed plugs are created
+// before anything is changed, so a failure leaves the running set untouched.
+func (pm *Manager) Reconcile(plugConfigs []Plug) (ConfigDiff, error) {
+	var diff ConfigDiff
+
+	pm.mu.RLock()
+	wanted := make(map[string]Plug, len(plugConfigs))
+	newInfos := make(map[string]*Info)
+	for _, plugConfig := range plugConfigs {
+		wanted[plugConfig.ID] = plugConfig
+
+		current, exists := pm.plugs[plugConfig.ID]
+		switch {
+		case !exists:
+			diff.Added = append(diff.Added, plugConfig.ID)
+		case !reflect.DeepEqual(current.Config, plugConfig):
+			diff.Updated = append(diff.Updated, plugConfig.ID)
+			if mqttSettingsChanged(current.Config, plugConfig) {
+				diff.MQTT = append(diff.MQTT, plugConfig.ID)
+			}
+			if !reflect.DeepEqual(current.Config.Safety, plugConfig.Safety) {
+				diff.Safety = append(diff.Safety, plugConfig.ID)
+			}
+			if !reflect.DeepEqual(current.Config.Buttons, plugConfig.Buttons) {
+				diff.Buttons = append(diff.Buttons, plugConfig.ID)
+			}
+			if current.Config.Address == plugConfig.Address {
+				newInfos[plugConfig.ID] = &Info{Config: plugConfig, Client: current.Client}
+				continue
+			}
+			diff.Readdressed = append(diff.Readdressed, plugConfig.ID)
+		default:
+			continue
+		}
+
+		info, err := newInfo(plugConfig)
+		if err != nil {
+			pm.mu.RUnlock()
+			return ConfigDiff{}, err
+		}
+		newInfos[plugConfig.ID] = info
+	}
+	for id := range pm.plugs {
+		if _, ok := wanted[id]; !ok {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+	pm.mu.RUnlock()
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Updated)
+	sort.Strings(diff.Readdressed)
+	sort.Strings(diff.MQTT)
+	sort.Strings(diff.Safety)
+	sort.Strings(diff.Buttons)
+
+	if diff.Empty() {
+		return diff, nil
+	}
+
+	pm.mu.Lock()
+	for _, id := range diff.Removed {
+		delete(pm.plugs, id)
+		delete(pm.states, id)
+		delete(pm.connections, id)
+	}
+	for _, id := range diff.Added {
+		pm.plugs[id] = newInfos[id]
+		pm.states[id] = newState(wanted[id])
+	}
+	for _, id := range diff.Updated {
+		plugConfig := wanted[id]
+		pm.plugs[id] = newInfos[id]
+
+		state := pm.states[id]
+		state.Name = plugConfig.Name
+		if len(state.Relays) > plugConfig.RelayCount() {
+			state.Relays = state.Relays[:plugConfig.RelayCount()]
+		}
+		for len(state.Relays) < plugConfig.RelayCount() {
+			state.Relays = append(state.Relays, false)
+		}
+	}
+
+	published := make(map[string]State, len(diff.Added)+len(diff.Updated))
+	for _, id := range append(append([]string(nil), diff.Added...), diff.Updated...) {
+		published[id] = pm.states[id].Clone()
+	}
+	pm.mu.Unlock()
+
+	if pm.eventBus != nil {
+		for _, id := range diff.Removed {
+			pm.eventBus.ForgetState(id)
+		}
+	}
+	for id, state := range published {
+		pm.publishStateUpdate("reload", id, state)
+	}
+
+	slog.Info(
+		"Plug configuration reconciled",
+		"added", diff.Added,
+		"removed", diff.Removed,
+		"updated", diff.Updated,
+	)
+
+	return diff, nil
+}
+
+// mqttSettingsChanged reports whether ConfigureMQTT would push different
+// settings to the device.
+func mqttSettingsChanged(a, b Plug) bool {
+	return a.MQTTUsername != b.MQTTUsername ||
+		a.MQTTPassword != b.MQTTPassword ||
+		a.MQTTTLS != b.MQTTTLS ||
+		a.Topic != b.Topic ||
+		a.FullTopic != b.FullTopic ||
+		a.KeepTopic != b.KeepTopic
+}
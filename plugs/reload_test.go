@@ -0,0 +1,67 @@
+package plugs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcileAddsRemovesAndUpdates(t *testing.T) {
+	pm, fake, _ := newTestManager(t)
+	pm.states["plug-1"].On = true
+
+	diff, err := pm.Reconcile([]Plug{
+		{ID: "plug-1", Name: "Renamed", Address: "1"},
+		{ID: "plug-2", Name: "New", Address: "2"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"plug-2"}, diff.Added)
+	require.Empty(t, diff.Removed)
+	require.Equal(t, []string{"plug-1"}, diff.Updated)
+	require.Empty(t, diff.Readdressed)
+
+	// Same address keeps the client and the state
+	require.True(t, pm.plugs["plug-1"].Client == Client(fake))
+	require.True(t, pm.states["plug-1"].On)
+	require.Equal(t, "Renamed", pm.states["plug-1"].Name)
+	require.Contains(t, pm.states, "plug-2")
+
+	diff, err = pm.Reconcile([]Plug{{ID: "plug-2", Name: "New", Address: "2"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"plug-1"}, diff.Removed)
+	require.NotContains(t, pm.plugs, "plug-1")
+	require.NotContains(t, pm.states, "plug-1")
+}
+
+func TestReconcileReaddressAndRelays(t *testing.T) {
+	pm, fake, _ := newTestManager(t)
+
+	diff, err := pm.Reconcile([]Plug{{
+		ID:      "plug-1",
+		Name:    "Plug",
+		Address: "10",
+		Relays:  []Relay{{Name: "A"}, {Name: "B"}},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"plug-1"}, diff.Readdressed)
+	require.False(t, pm.plugs["plug-1"].Client == Client(fake))
+	require.Len(t, pm.states["plug-1"].Relays, 2)
+}
+
+func TestReconcileUnchanged(t *testing.T) {
+	pm, _, _ := newTestManager(t)
+
+	diff, err := pm.Reconcile([]Plug{{ID: "plug-1", Name: "Plug", Address: "1"}})
+	require.NoError(t, err)
+	require.True(t, diff.Empty())
+}
+
+func TestReconcileCredentialsChange(t *testing.T) {
+	pm, _, _ := newTestManager(t)
+
+	diff, err := pm.Reconcile([]Plug{{ID: "plug-1", Name: "Plug", Address: "1", MQTTPassword: "new"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"plug-1"}, diff.MQTT)
+	require.Empty(t, diff.Readdressed)
+	require.Equal(t, []string{"plug-1"}, diff.Reprovision())
+}
@@ -0,0 +1,107 @@
+package plugs
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// maxConnectionHistory is the number of transitions kept per plug.
+const maxConnectionHistory = 20
+
+// ConnectionChange records a plug going online or offline.
+type ConnectionChange struct {
+	Time   time.Time `json:"time"`
+	Online bool      `json:"online"`
+}
+
+// recordConnection appends a transition to the plug's connection history.
+// The caller must hold pm.mu.
+func (pm *Manager) recordConnection(plugID string, online bool) {
+	if pm.connections == nil {
+		pm.connections = make(map[string][]ConnectionChange)
+	}
+	history := append(pm.connections[plugID], ConnectionChange{Time: time.Now(), Online: online})
+	if len(history) > maxConnectionHistory {
+		history = history[len(history)-maxConnectionHistory:]
+	}
+	pm.connections[plugID] = history
+}
+
+// ConnectionHistory returns the recent online/offline transitions of every
+// plug, oldest first.
+func (pm *Manager) ConnectionHistory() map[string][]ConnectionChange {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	result := make(map[string][]ConnectionChange, len(pm.connections))
+	for id, history := range pm.connections {
+		result[id] = slices.Clone(history)
+	}
+	return result
+}
+
+// Restore seeds plug states and connection history saved before a restart.
+// Restored states are marked Restored until the device reports in, and
+// entries for plugs that are no longer configured are ignored. It must be
+// called before the plugs are first polled, as it overwrites current state.
+func (pm *Manager) Restore(states map[string]State, connections map[string][]ConnectionChange) {
+	pm.mu.Lock()
+	restored := make(map[string]State, len(states))
+	for id, saved := range states {
+		info, ok := pm.plugs[id]
+		if !ok {
+			continue
+		}
+
+		state := saved.Clone()
+		state.ID = id
+		state.Name = info.Config.Name
+		if len(state.Relays) > info.Config.RelayCount() {
+			state.Relays = state.Relays[:info.Config.RelayCount()]
+		}
+		for len(state.Relays) < info.Config.RelayCount() {
+			state.Relays = append(state.Relays, false)
+		}
+		// There is no broker session yet, whatever the device had before
+		state.MQTTConnected = false
+		state.Restored = true
+
+		pm.states[id] = &state
+		restored[id] = state.Clone()
+	}
+	for id, history := range connections {
+		if _, ok := pm.plugs[id]; !ok {
+			continue
+		}
+		if pm.connections == nil {
+			pm.connections = make(map[string][]ConnectionChange)
+		}
+		if len(history) > maxConnectionHistory {
+			history = history[len(history)-maxConnectionHistory:]
+		}
+		pm.connections[id] = slices.Clone(history)
+	}
+	pm.mu.Unlock()
+
+	for id, state := range restored {
+		pm.publishStateUpdate("restored", id, state)
+	}
+	slog.Info("Restored plug state", "count", len(restored))
+}
+
+// confirmsState reports whether event carries device state rather than
+// only connectivity, which confirms a restored state.
+func confirmsState(event StateChangedEvent) bool {
+	if len(event.UpdatedFields) == 0 {
+		return !event.State.LastUpdated.IsZero()
+	}
+	for _, field := range event.UpdatedFields {
+		switch field {
+		case "MQTTConnected", "Offline", "LastSeen", "LastUpdated":
+		default:
+			return true
+		}
+	}
+	return false
+}
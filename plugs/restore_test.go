@@ -0,0 +1,117 @@
+package plugs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreMarksStateUnconfirmed(t *testing.T) {
+	pm, _, _ := newTestManager(t)
+
+	lastSeen := time.Now().Add(-time.Hour)
+	pm.Restore(map[string]State{
+		"plug-1": {On: true, Relays: []bool{true, true}, Energy: 12.5, MQTTConnected: true, LastSeen: lastSeen},
+		"gone":   {On: true},
+	}, map[string][]ConnectionChange{
+		"plug-1": {{Time: lastSeen, Online: false}},
+		"gone":   {{Time: lastSeen, Online: true}},
+	})
+
+	_, state, ok := pm.Plug("plug-1")
+	require.True(t, ok)
+	require.True(t, state.On)
+	require.True(t, state.Restored)
+	require.Equal(t, 12.5, state.Energy)
+	require.Equal(t, "Plug", state.Name)
+	require.Equal(t, []bool{true}, state.Relays, "relays follow the current config")
+	require.False(t, state.MQTTConnected)
+	require.True(t, state.LastSeen.Equal(lastSeen))
+
+	_, _, ok = pm.Plug("gone")
+	require.False(t, ok)
+
+	history := pm.ConnectionHistory()
+	require.Len(t, history["plug-1"], 1)
+	require.NotContains(t, history, "gone")
+
+	evt := NewStateUpdateEvent("restored", "plug-1", state)
+	require.True(t, evt.Restored)
+	require.Equal(t, "stale", evt.ConnectionState)
+}
+
+func TestRestoredStateConfirmedByDevice(t *testing.T) {
+	pm, _, _ := newTestManager(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go pm.ProcessStateEvents(ctx)
+
+	pm.Restore(map[string]State{"plug-1": {On: true}}, nil)
+
+	restored := func() bool {
+		_, state, _ := pm.Plug("plug-1")
+		return state.Restored
+	}
+
+	// Coming online says nothing about the relays
+	pm.statePublisher.Publish(StateChangedEvent{
+		PlugID:        "plug-1",
+		State:         State{ID: "plug-1", MQTTConnected: true, LastSeen: time.Now()},
+		UpdatedFields: []string{"MQTTConnected", "Offline", "LastSeen"},
+	})
+	require.Eventually(t, func() bool {
+		_, state, _ := pm.Plug("plug-1")
+		return state.MQTTConnected
+	}, time.Second, 10*time.Millisecond)
+	require.True(t, restored())
+
+	pm.statePublisher.Publish(StateChangedEvent{
+		PlugID:        "plug-1",
+		State:         State{ID: "plug-1", On: false},
+		UpdatedFields: []string{"On"},
+	})
+	require.Eventually(t, func() bool { return !restored() }, time.Second, 10*time.Millisecond)
+
+	_, state, _ := pm.Plug("plug-1")
+	require.False(t, state.On)
+}
+
+func TestGetStatusConfirmsRestoredState(t *testing.T) {
+	pm, _, _ := newTestManager(t)
+	pm.Restore(map[string]State{"plug-1": {On: false, Offline: true}}, nil)
+
+	state, err := pm.GetStatus(context.Background(), "plug-1")
+	require.NoError(t, err)
+	require.True(t, state.On)
+	require.False(t, state.Restored)
+	require.False(t, state.Offline)
+
+	history := pm.ConnectionHistory()["plug-1"]
+	require.Len(t, history, 1)
+	require.True(t, history[0].Online)
+}
+
+func TestConnectionHistoryRecordsTransitions(t *testing.T) {
+	pm, _, _ := newTestManager(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go pm.ProcessStateEvents(ctx)
+
+	for _, offline := range []bool{true, true, false} {
+		pm.statePublisher.Publish(StateChangedEvent{
+			PlugID:        "plug-1",
+			State:         State{ID: "plug-1", Offline: offline},
+			UpdatedFields: []string{"Offline"},
+		})
+	}
+
+	require.Eventually(t, func() bool {
+		return len(pm.ConnectionHistory()["plug-1"]) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	history := pm.ConnectionHistory()["plug-1"]
+	require.False(t, history[0].Online)
+	require.True(t, history[1].Online)
+}
@@ -0,0 +1,139 @@
+package plugs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// maxPulseTime is the longest PulseTime Tasmota accepts, 64900 - 100 seconds.
+const maxPulseTime = 64800 * time.Second
+
+// Safety limits what a plug may draw and how long it may stay on. The bridge
+// switches the plug off and raises an alert when a limit is exceeded. With
+// Device set, MaxPower and PulseTime are also set on the device itself, so
+// the limits hold while the bridge is down.
+type Safety struct {
+	// Power limits in watts and amps, need power monitoring
+	MaxPower   float64 `json:"max_power,omitempty"`
+	MaxCurrent float64 `json:"max_current,omitempty"`
+
+	// MaxOnTime switches a relay off after it has been on this long, e.g.
+	// "2h".
+	MaxOnTime string `json:"max_on_time,omitempty"`
+
+	// IdlePower and IdleTime switch the plug off once it has drawn less than
+	// IdlePower watts for IdleTime while on, e.g. a kettle left on its base.
+	IdlePower float64 `json:"idle_power,omitempty"`
+	IdleTime  string  `json:"idle_time,omitempty"`
+
+	Device bool `json:"device,omitempty"`
+}
+
+// MaxOnDuration returns the parsed MaxOnTime, zero when unset.
+func (s Safety) MaxOnDuration() time.Duration {
+	return parseOptionalDuration(s.MaxOnTime)
+}
+
+// IdleDuration returns the parsed IdleTime, zero when unset.
+func (s Safety) IdleDuration() time.Duration {
+	return parseOptionalDuration(s.IdleTime)
+}
+
+func (p Plug) validateSafety() error {
+	s := p.Safety
+	if s == nil {
+		return nil
+	}
+
+	if s.MaxPower < 0 || s.MaxCurrent < 0 || s.IdlePower < 0 {
+		return fmt.Errorf("plug %s safety limits cannot be negative", p.ID)
+	}
+	if (s.MaxPower > 0 || s.MaxCurrent > 0 || s.IdlePower > 0) && !p.HasPowerMonitoring() {
+		return fmt.Errorf("plug %s safety power limits need power_monitoring", p.ID)
+	}
+	for name, value := range map[string]string{"max_on_time": s.MaxOnTime, "idle_time": s.IdleTime} {
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("plug %s safety has invalid %s %q", p.ID, name, value)
+		}
+	}
+	if (s.IdlePower > 0) != (s.IdleTime != "") {
+		return fmt.Errorf("plug %s safety needs both idle_power and idle_time", p.ID)
+	}
+	if s.Device && s.MaxOnDuration() > maxPulseTime {
+		return fmt.Errorf("plug %s safety max_on_time %s is longer than the device's PulseTime allows (18h)", p.ID, s.MaxOnTime)
+	}
+	return nil
+}
+
+// deviceCommands returns the commands that set the limits on the device.
+// Limits that are not configured are cleared.
+func (s Safety) deviceCommands(p Plug) []string {
+	var cmds []string
+	if p.HasPowerMonitoring() {
+		cmds = append(cmds, fmt.Sprintf("MaxPower %.0f", s.MaxPower))
+	}
+
+	// PulseTime counts tenths of a second up to 111, seconds plus 100 above;
+	// durations between 11.1s and 12s are rounded down to 11.1s
+	pulse := 0
+	if d := s.MaxOnDuration(); d > 0 {
+		if d <= 11100*time.Millisecond {
+			pulse = max(1, int(d/(100*time.Millisecond)))
+		} else {
+			pulse = int(d/time.Second) + 100
+		}
+	}
+	for relay := 1; relay <= p.RelayCount(); relay++ {
+		cmds = append(cmds, fmt.Sprintf("PulseTime%d %d", relay, pulse))
+	}
+	return cmds
+}
+
+// ConfigureSafety sets the plug's safety limits on the device, for plugs
+// with Safety.Device set.
+func (pm *Manager) ConfigureSafety(ctx context.Context, plugID string) error {
+	info, exists := pm.info(plugID)
+	if !exists {
+		return fmt.Errorf("plug %s not found", plugID)
+	}
+	safety := info.Config.Safety
+	if safety == nil || !safety.Device {
+		return nil
+	}
+
+	if _, err := info.Client.ExecuteBacklog(ctx, safety.deviceCommands(info.Config)...); err != nil {
+		return fmt.Errorf("failed to configure safety limits: %w", err)
+	}
+
+	slog.Info("Safety limits configured on plug", "plug_id", plugID)
+	return nil
+}
+
+// SafetyMonitor is given every merged plug state, see SetSafetyMonitor.
+// Check must not block.
+type SafetyMonitor interface {
+	Check(plug Plug, state State)
+}
+
+// SetSafetyMonitor sets where plug states are checked against safety rules.
+func (pm *Manager) SetSafetyMonitor(monitor SafetyMonitor) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.safetyMonitor = monitor
+}
+
+// checkSafety passes a plug's state to the safety monitor. The caller must
+// hold pm.mu.
+func (pm *Manager) checkSafety(plugID string, state State) {
+	info, ok := pm.plugs[plugID]
+	if pm.safetyMonitor == nil || !ok || info.Config.Safety == nil {
+		return
+	}
+	pm.safetyMonitor.Check(info.Config, state)
+}
@@ -0,0 +1,129 @@
+package plugs
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSafety(t *testing.T) {
+	monitored := &PlugFeatures{PowerMonitoring: true}
+	for _, tt := range []struct {
+		name     string
+		features *PlugFeatures
+		safety   Safety
+		errMsg   string
+	}{
+		{"limits", monitored, Safety{MaxPower: 2000, MaxCurrent: 10, MaxOnTime: "2h", IdlePower: 5, IdleTime: "10m", Device: true}, ""},
+		{"on time without monitoring", nil, Safety{MaxOnTime: "30m"}, ""},
+		{"power without monitoring", nil, Safety{MaxPower: 2000}, "need power_monitoring"},
+		{"negative", monitored, Safety{MaxCurrent: -1}, "cannot be negative"},
+		{"bad duration", nil, Safety{MaxOnTime: "forever"}, `invalid max_on_time "forever"`},
+		{"zero duration", nil, Safety{MaxOnTime: "0s"}, "invalid max_on_time"},
+		{"idle without time", monitored, Safety{IdlePower: 5}, "both idle_power and idle_time"},
+		{"idle without power", monitored, Safety{IdleTime: "5m"}, "both idle_power and idle_time"},
+		{"pulse time too long", nil, Safety{MaxOnTime: "24h", Device: true}, "PulseTime allows"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			plug := Plug{ID: "heater", Features: tt.features, Safety: &tt.safety}
+			err := plug.validateSafety()
+			if tt.errMsg == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestSafetyDeviceCommands(t *testing.T) {
+	heater := Plug{ID: "heater", Features: &PlugFeatures{PowerMonitoring: true}}
+	require.Equal(t,
+		[]string{"MaxPower 2000", "PulseTime1 7300"},
+		Safety{MaxPower: 2000, MaxOnTime: "2h"}.deviceCommands(heater),
+	)
+
+	strip := Plug{ID: "strip", Relays: []Relay{{}, {}}}
+	require.Equal(t,
+		[]string{"PulseTime1 50", "PulseTime2 50"},
+		Safety{MaxOnTime: "5s"}.deviceCommands(strip),
+	)
+	// Unset limits are cleared on the device
+	require.Equal(t, []string{"MaxPower 0", "PulseTime1 0"}, Safety{}.deviceCommands(heater))
+}
+
+func TestSafetyPulseTime(t *testing.T) {
+	tests := []struct {
+		maxOnTime string
+		want      string
+	}{
+		{"50ms", "PulseTime1 1"},
+		{"11.1s", "PulseTime1 111"},
+		{"11.5s", "PulseTime1 111"},
+		{"12s", "PulseTime1 112"},
+		{"1m", "PulseTime1 160"},
+	}
+	plug := Plug{ID: "heater"}
+	for _, tt := range tests {
+		t.Run(tt.maxOnTime, func(t *testing.T) {
+			require.Equal(t, []string{tt.want}, Safety{MaxOnTime: tt.maxOnTime}.deviceCommands(plug))
+		})
+	}
+}
+
+func TestConfigureSafety(t *testing.T) {
+	pm, fake, _ := newTestManager(t)
+	ctx := context.Background()
+
+	require.NoError(t, pm.ConfigureSafety(ctx, "plug-1"))
+	require.Empty(t, fake.backlog, "plug without safety limits")
+
+	pm.plugs["plug-1"].Config.Safety = &Safety{MaxOnTime: "1m"}
+	require.NoError(t, pm.ConfigureSafety(ctx, "plug-1"))
+	require.Empty(t, fake.backlog, "limits not meant for the device")
+
+	pm.plugs["plug-1"].Config.Safety.Device = true
+	require.NoError(t, pm.ConfigureSafety(ctx, "plug-1"))
+	require.Equal(t, []string{"PulseTime1 160"}, fake.backlog)
+
+	require.ErrorContains(t, pm.ConfigureSafety(ctx, "missing"), "not found")
+}
+
+type fakeSafetyMonitor struct {
+	mu     sync.Mutex
+	states []State
+}
+
+func (f *fakeSafetyMonitor) Check(_ Plug, state State) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.states = append(f.states, state)
+}
+
+func TestGetStatusChecksSafety(t *testing.T) {
+	pm, fake, _ := newTestManager(t)
+	monitor := &fakeSafetyMonitor{}
+	pm.SetSafetyMonitor(monitor)
+
+	_, err := pm.GetStatus(context.Background(), "plug-1")
+	require.NoError(t, err)
+	require.Empty(t, monitor.states, "plug without safety limits")
+
+	pm.plugs["plug-1"].Config.Safety = &Safety{MaxOnTime: "1h"}
+	fake.responses = [][]byte{[]byte(`{"StatusSTS":{"POWER":"ON"}}`)}
+	_, err = pm.GetStatus(context.Background(), "plug-1")
+	require.NoError(t, err)
+	require.Len(t, monitor.states, 1)
+	require.True(t, monitor.states[0].On)
+}
+
+func TestReconcileSafetyChange(t *testing.T) {
+	pm, _, _ := newTestManager(t)
+
+	diff, err := pm.Reconcile([]Plug{{ID: "plug-1", Name: "Plug", Address: "1", Safety: &Safety{MaxOnTime: "1h"}}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"plug-1"}, diff.Safety)
+	require.Empty(t, diff.Reprovision())
+}
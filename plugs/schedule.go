@@ -0,0 +1,182 @@
+package plugs
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/cron"
+)
+
+// Sun events a schedule can be relative to.
+const (
+	SunEventSunrise = "sunrise"
+	SunEventSunset  = "sunset"
+)
+
+// Location is where the bridge is, for sunrise and sunset schedules.
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Schedule switches a plug or group at times given by a cron expression or
+// relative to sunrise or sunset. Times are in the bridge's local time zone.
+type Schedule struct {
+	ID string `json:"id"`
+	// Exactly one of Plug or Group; a group is switched like its HomeKit
+	// switch, every plug at once.
+	Plug   string `json:"plug,omitempty"`
+	Group  string `json:"group,omitempty"`
+	Relay  int    `json:"relay,omitempty"` // 1-based, 0 for the whole device (every relay)
+	Action string `json:"action"`          // "on" or "off"
+
+	// Exactly one of Cron or Sun. Offset shifts a sun event, e.g. "-30m";
+	// Days restricts it to days of the week like tariff windows.
+	Cron   string   `json:"cron,omitempty"`
+	Sun    string   `json:"sun,omitempty"`
+	Offset string   `json:"offset,omitempty"`
+	Days   []string `json:"days,omitempty"`
+
+	// Jitter moves each run by a random amount of up to this duration
+	// either way, e.g. "15m".
+	Jitter string `json:"jitter,omitempty"`
+	// Away schedules only run while away mode is on, to make the house
+	// look lived in.
+	Away bool `json:"away,omitempty"`
+
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// On reports whether the schedule switches its plug on.
+func (s Schedule) On() bool {
+	return s.Action == "on"
+}
+
+// CronExpression returns the parsed Cron field.
+func (s Schedule) CronExpression() (cron.Expression, error) {
+	return cron.Parse(s.Cron)
+}
+
+// OffsetDuration returns the parsed Offset, zero when unset.
+func (s Schedule) OffsetDuration() time.Duration {
+	return parseOptionalDuration(s.Offset)
+}
+
+// JitterDuration returns the parsed Jitter, zero when unset.
+func (s Schedule) JitterDuration() time.Duration {
+	return parseOptionalDuration(s.Jitter)
+}
+
+// OnDay reports whether the schedule's Days include the weekday of t.
+func (s Schedule) OnDay(t time.Time) bool {
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, day := range s.Days {
+		if slices.Contains(weekdayNames[strings.ToLower(day)], t.Weekday()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validated when the config was parsed
+func parseOptionalDuration(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	d, _ := time.ParseDuration(s)
+	return d
+}
+
+func (c *Config) validateSchedules() error {
+	seen := make(map[string]bool, len(c.Schedules))
+	for i, s := range c.Schedules {
+		if s.ID == "" {
+			return fmt.Errorf("schedule %d has no id", i)
+		}
+		if seen[s.ID] {
+			return fmt.Errorf("duplicate schedule id %q", s.ID)
+		}
+		seen[s.ID] = true
+
+		switch {
+		case s.Plug != "" && s.Group != "":
+			return fmt.Errorf("schedule %s has both plug and group", s.ID)
+		case s.Group != "":
+			if !slices.ContainsFunc(c.Groups, func(g Group) bool { return g.ID == s.Group }) {
+				return fmt.Errorf("schedule %s targets unknown group %q", s.ID, s.Group)
+			}
+			if s.Relay != 0 {
+				return fmt.Errorf("schedule %s: relay does not apply to group %s", s.ID, s.Group)
+			}
+		default:
+			plug, ok := c.plug(s.Plug)
+			if !ok {
+				return fmt.Errorf("schedule %s targets unknown plug %q", s.ID, s.Plug)
+			}
+			if s.Relay < 0 || s.Relay > plug.RelayCount() {
+				return fmt.Errorf("schedule %s: plug %s has no relay %d", s.ID, s.Plug, s.Relay)
+			}
+		}
+		if s.Action != "on" && s.Action != "off" {
+			return fmt.Errorf("schedule %s action must be \"on\" or \"off\", got %q", s.ID, s.Action)
+		}
+
+		switch {
+		case s.Cron != "" && s.Sun != "":
+			return fmt.Errorf("schedule %s has both cron and sun", s.ID)
+		case s.Cron != "":
+			if _, err := s.CronExpression(); err != nil {
+				return fmt.Errorf("schedule %s: %w", s.ID, err)
+			}
+			if s.Offset != "" || len(s.Days) > 0 {
+				return fmt.Errorf("schedule %s: offset and days only apply to sun schedules", s.ID)
+			}
+		case s.Sun == SunEventSunrise || s.Sun == SunEventSunset:
+			if c.Location == nil {
+				return fmt.Errorf("schedule %s needs a location for %s", s.ID, s.Sun)
+			}
+		case s.Sun != "":
+			return fmt.Errorf("schedule %s sun must be %q or %q, got %q", s.ID, SunEventSunrise, SunEventSunset, s.Sun)
+		default:
+			return fmt.Errorf("schedule %s needs cron or sun", s.ID)
+		}
+
+		for name, value := range map[string]string{"offset": s.Offset, "jitter": s.Jitter} {
+			if value == "" {
+				continue
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("schedule %s has invalid %s %q", s.ID, name, value)
+			}
+			if name == "jitter" && d < 0 {
+				return fmt.Errorf("schedule %s jitter cannot be negative", s.ID)
+			}
+		}
+		for _, day := range s.Days {
+			if _, ok := weekdayNames[strings.ToLower(day)]; !ok {
+				return fmt.Errorf("schedule %s has unknown day %q", s.ID, day)
+			}
+		}
+	}
+
+	if loc := c.Location; loc != nil {
+		if loc.Latitude < -90 || loc.Latitude > 90 || loc.Longitude < -180 || loc.Longitude > 180 {
+			return fmt.Errorf("location %v,%v is out of range", loc.Latitude, loc.Longitude)
+		}
+	}
+	return nil
+}
+
+func (c *Config) plug(id string) (Plug, bool) {
+	for _, plug := range c.Plugs {
+		if plug.ID == id {
+			return plug, true
+		}
+	}
+	return Plug{}, false
+}
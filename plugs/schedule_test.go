@@ -0,0 +1,75 @@
+package plugs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSchedules(t *testing.T) {
+	loc := &Location{Latitude: 51.5, Longitude: -0.12}
+	for _, tt := range []struct {
+		name     string
+		schedule Schedule
+		location *Location
+		errMsg   string
+	}{
+		{"cron", Schedule{ID: "s", Plug: "a", Action: "on", Cron: "0 7 * * *"}, nil, ""},
+		{"sun", Schedule{ID: "s", Plug: "a", Action: "off", Sun: "sunrise", Offset: "30m", Days: []string{"weekday"}, Jitter: "10m"}, loc, ""},
+		{"no id", Schedule{Plug: "a", Action: "on", Cron: "0 7 * * *"}, nil, "has no id"},
+		{"unknown plug", Schedule{ID: "s", Plug: "b", Action: "on", Cron: "0 7 * * *"}, nil, `unknown plug "b"`},
+		{"no target", Schedule{ID: "s", Action: "on", Cron: "0 7 * * *"}, nil, `unknown plug ""`},
+		{"group", Schedule{ID: "s", Group: "g", Action: "on", Cron: "0 7 * * *"}, nil, ""},
+		{"unknown group", Schedule{ID: "s", Group: "h", Action: "on", Cron: "0 7 * * *"}, nil, `unknown group "h"`},
+		{"plug and group", Schedule{ID: "s", Plug: "a", Group: "g", Action: "on", Cron: "0 7 * * *"}, nil, "both plug and group"},
+		{"group relay", Schedule{ID: "s", Group: "g", Relay: 1, Action: "on", Cron: "0 7 * * *"}, nil, "relay does not apply to group g"},
+		{"relay", Schedule{ID: "s", Plug: "a", Relay: 2, Action: "on", Cron: "0 7 * * *"}, nil, "has no relay 2"},
+		{"action", Schedule{ID: "s", Plug: "a", Action: "toggle", Cron: "0 7 * * *"}, nil, "action must be"},
+		{"both", Schedule{ID: "s", Plug: "a", Action: "on", Cron: "0 7 * * *", Sun: "sunset"}, loc, "both cron and sun"},
+		{"neither", Schedule{ID: "s", Plug: "a", Action: "on"}, nil, "needs cron or sun"},
+		{"bad cron", Schedule{ID: "s", Plug: "a", Action: "on", Cron: "0 7 * *"}, nil, "needs 5 fields"},
+		{"cron offset", Schedule{ID: "s", Plug: "a", Action: "on", Cron: "0 7 * * *", Offset: "1h"}, nil, "only apply to sun"},
+		{"bad sun", Schedule{ID: "s", Plug: "a", Action: "on", Sun: "noon"}, loc, "sun must be"},
+		{"no location", Schedule{ID: "s", Plug: "a", Action: "on", Sun: "sunset"}, nil, "needs a location"},
+		{"bad offset", Schedule{ID: "s", Plug: "a", Action: "on", Sun: "sunset", Offset: "soon"}, loc, "invalid offset"},
+		{"negative jitter", Schedule{ID: "s", Plug: "a", Action: "on", Cron: "0 7 * * *", Jitter: "-5m"}, nil, "cannot be negative"},
+		{"bad day", Schedule{ID: "s", Plug: "a", Action: "on", Sun: "sunset", Days: []string{"funday"}}, loc, `unknown day "funday"`},
+		{"bad location", Schedule{ID: "s", Plug: "a", Action: "on", Sun: "sunset"}, &Location{Latitude: 91}, "out of range"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				Plugs:     []Plug{{ID: "a", Name: "A", Address: "1"}},
+				Groups:    []Group{{ID: "g", Name: "G", Plugs: []string{"a"}}},
+				Schedules: []Schedule{tt.schedule},
+				Location:  tt.location,
+			}
+			err := cfg.validateSchedules()
+			if tt.errMsg == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestValidateSchedulesDuplicateID(t *testing.T) {
+	_, err := ParseConfig([]byte(`{
+		"plugs": [{"id": "a", "name": "A", "address": "1"}],
+		"schedules": [
+			{"id": "s", "plug": "a", "action": "on", "cron": "0 7 * * *"},
+			{"id": "s", "plug": "a", "action": "off", "cron": "0 8 * * *"},
+		],
+	}`))
+	require.ErrorContains(t, err, `duplicate schedule id "s"`)
+}
+
+func TestScheduleOnDay(t *testing.T) {
+	s := Schedule{Days: []string{"weekend", "Wed"}}
+	// 2025-03-03 is a Monday
+	for day, want := range map[int]bool{3: false, 5: true, 8: true, 9: true} {
+		require.Equal(t, want, s.OnDay(time.Date(2025, 3, day, 12, 0, 0, 0, time.UTC)), day)
+	}
+	require.True(t, Schedule{}.OnDay(time.Now()))
+}
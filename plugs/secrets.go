@@ -0,0 +1,167 @@
+package plugs
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/tailscale/hujson"
+)
+
+// MQTTAccount is a broker account that is not tied to a plug. Read and
+// Write hold MQTT topic filters the account may subscribe and publish to.
+type MQTTAccount struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	Read     []string `json:"read,omitempty"`
+	Write    []string `json:"write,omitempty"`
+}
+
+// PlugSecret holds the broker credentials of one plug.
+type PlugSecret struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password"`
+}
+
+// Secrets is the optional secrets file, kept apart from the plugs file so
+// the latter can be committed or shared.
+type Secrets struct {
+	Plugs     map[string]PlugSecret `json:"plugs,omitempty"`
+	MQTTUsers []MQTTAccount         `json:"mqtt_users,omitempty"`
+	// Webhooks holds webhook signing secrets by webhook ID
+	Webhooks map[string]string `json:"webhooks,omitempty"`
+	// Notifiers holds the ntfy or Pushover token, or the SMTP password, by
+	// notifier ID
+	Notifiers map[string]string `json:"notifiers,omitempty"`
+}
+
+// LoadSecrets reads the HuJSON secrets file.
+func LoadSecrets(path string) (*Secrets, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets file: %w", err)
+	}
+
+	standardized, err := hujson.Standardize(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to standardize secrets HuJSON: %w", err)
+	}
+
+	var secrets Secrets
+	if err := json.Unmarshal(standardized, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal secrets file: %w", err)
+	}
+
+	return &secrets, nil
+}
+
+// LoadConfigWithSecrets loads the plugs file and, when secretsPath is set,
+// merges the secrets file into it.
+func LoadConfigWithSecrets(path, secretsPath string) (*Config, error) {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if secretsPath == "" {
+		return cfg, nil
+	}
+
+	secrets, err := LoadSecrets(secretsPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.ApplySecrets(secrets); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// ApplySecrets sets plug credentials, webhook and notifier secrets from the
+// secrets file, overriding the plugs file, and adds its broker accounts.
+func (c *Config) ApplySecrets(secrets *Secrets) error {
+	known := make(map[string]int, len(c.Plugs))
+	for i, plug := range c.Plugs {
+		known[plug.ID] = i
+	}
+
+	for id, secret := range secrets.Plugs {
+		i, ok := known[id]
+		if !ok {
+			// Secrets may outlive their plug; not worth refusing a reload for
+			slog.Warn("Secrets file has credentials for an unknown plug", "plug_id", id)
+			continue
+		}
+		if secret.Password == "" {
+			return fmt.Errorf("secrets for plug %s have no password", id)
+		}
+		c.Plugs[i].MQTTUsername = secret.Username
+		c.Plugs[i].MQTTPassword = secret.Password
+	}
+
+	for id, secret := range secrets.Webhooks {
+		i := slices.IndexFunc(c.Webhooks, func(w Webhook) bool { return w.ID == id })
+		if i < 0 {
+			slog.Warn("Secrets file has a secret for an unknown webhook", "webhook", id)
+			continue
+		}
+		c.Webhooks[i].Secret = secret
+	}
+
+	for id, secret := range secrets.Notifiers {
+		i := slices.IndexFunc(c.Notifiers, func(n Notifier) bool { return n.ID == id })
+		if i < 0 {
+			slog.Warn("Secrets file has a secret for an unknown notifier", "notifier", id)
+			continue
+		}
+		if c.Notifiers[i].Type == NotifierSMTP {
+			c.Notifiers[i].Password = secret
+		} else {
+			c.Notifiers[i].Token = secret
+		}
+	}
+
+	c.MQTTUsers = append(c.MQTTUsers, secrets.MQTTUsers...)
+
+	return c.validateMQTT()
+}
+
+// validateMQTT checks that broker usernames are unique and that credentials
+// can be sent in a Tasmota Backlog, which splits on semicolons.
+func (c *Config) validateMQTT() error {
+	seen := make(map[string]string)
+	claim := func(username, owner string) error {
+		if other, ok := seen[username]; ok {
+			return fmt.Errorf("MQTT username %q is used by both %s and %s", username, other, owner)
+		}
+		seen[username] = owner
+		return nil
+	}
+
+	for _, plug := range c.Plugs {
+		username, password, ok := plug.MQTTCredentials()
+		if !ok {
+			continue
+		}
+		if strings.ContainsAny(username+password, "; ") {
+			return fmt.Errorf("plug %s MQTT credentials cannot contain spaces or semicolons", plug.ID)
+		}
+		if err := claim(username, "plug "+plug.ID); err != nil {
+			return err
+		}
+	}
+
+	for _, account := range c.MQTTUsers {
+		if account.Username == "" || account.Password == "" {
+			return fmt.Errorf("MQTT users need a username and password")
+		}
+		if err := claim(account.Username, "user "+account.Username); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package plugs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigWithSecrets(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plugs.hujson")
+	secretsPath := filepath.Join(dir, "secrets.hujson")
+	if err := os.WriteFile(path, []byte(`{"plugs":[
+		{"id":"a","name":"A","address":"1","mqtt_password":"from-plugs"},
+		{"id":"b","name":"B","address":"2"},
+	], "webhooks": [{"id": "ops", "url": "https://example.com/hook"}],
+	"notifiers": [
+		{"id": "phone", "type": "pushover", "user": "u123"},
+		{"id": "mail", "type": "smtp", "url": "smtp://mail.example.com:587", "from": "hub@example.com", "to": ["me@example.com"]},
+	]}`), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.WriteFile(secretsPath, []byte(`{
+		// Overrides the plugs file
+		"plugs": {"a": {"username": "dev-a", "password": "from-secrets"}},
+		"mqtt_users": [{"username": "admin", "password": "pw", "read": ["#"]}],
+		"webhooks": {"ops": "hmac-key"},
+		"notifiers": {"phone": "app-token", "mail": "mail-password"},
+	}`), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	cfg, err := LoadConfigWithSecrets(path, secretsPath)
+	if err != nil {
+		t.Fatalf("LoadConfigWithSecrets() error = %v", err)
+	}
+
+	username, password, ok := cfg.Plugs[0].MQTTCredentials()
+	if !ok || username != "dev-a" || password != "from-secrets" {
+		t.Fatalf("plug a credentials = %q/%q/%v, want dev-a/from-secrets", username, password, ok)
+	}
+	if _, _, ok := cfg.Plugs[1].MQTTCredentials(); ok {
+		t.Fatal("plug b should have no credentials")
+	}
+	if len(cfg.MQTTUsers) != 1 || cfg.MQTTUsers[0].Username != "admin" {
+		t.Fatalf("expected admin MQTT user, got %+v", cfg.MQTTUsers)
+	}
+	if cfg.Webhooks[0].Secret != "hmac-key" {
+		t.Fatalf("webhook secret = %q, want hmac-key", cfg.Webhooks[0].Secret)
+	}
+	if cfg.Notifiers[0].Token != "app-token" || cfg.Notifiers[1].Password != "mail-password" {
+		t.Fatalf("notifier secrets = %+v", cfg.Notifiers)
+	}
+}
+
+func TestValidateMQTTCredentials(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    Config
+		errMsg string
+	}{
+		{
+			name: "duplicate username",
+			cfg: Config{
+				Plugs:     []Plug{{ID: "a", MQTTPassword: "x"}},
+				MQTTUsers: []MQTTAccount{{Username: "a", Password: "y"}},
+			},
+			errMsg: "used by both",
+		},
+		{
+			name:   "semicolon in password",
+			cfg:    Config{Plugs: []Plug{{ID: "a", MQTTPassword: "x;Power off"}}},
+			errMsg: "semicolons",
+		},
+		{
+			name:   "user without password",
+			cfg:    Config{MQTTUsers: []MQTTAccount{{Username: "admin"}}},
+			errMsg: "username and password",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.validateMQTT()
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package plugs
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// SensorKind is a quantity measured by a sensor attached to a plug.
+type SensorKind string
+
+const (
+	SensorTemperature SensorKind = "temperature" // °C
+	SensorHumidity    SensorKind = "humidity"    // %
+	SensorPressure    SensorKind = "pressure"    // hPa
+	SensorIlluminance SensorKind = "illuminance" // lx
+)
+
+// SensorKinds lists the sensor kinds in display order.
+var SensorKinds = []SensorKind{SensorTemperature, SensorHumidity, SensorPressure, SensorIlluminance}
+
+// sensorFields are the Tasmota fields of a sensor block holding each kind.
+var sensorFields = map[string]SensorKind{
+	"Temperature": SensorTemperature,
+	"Humidity":    SensorHumidity,
+	"Pressure":    SensorPressure,
+	"Illuminance": SensorIlluminance,
+}
+
+// sensorModels are the kinds common Tasmota sensors report, by the name
+// they appear under in SENSOR telemetry.
+var sensorModels = map[string][]SensorKind{
+	"DS18B20": {SensorTemperature},
+	"DS18S20": {SensorTemperature},
+	"DS1822":  {SensorTemperature},
+	"AM2301":  {SensorTemperature, SensorHumidity},
+	"DHT11":   {SensorTemperature, SensorHumidity},
+	"SHT3X":   {SensorTemperature, SensorHumidity},
+	"SHT4X":   {SensorTemperature, SensorHumidity},
+	"SI7021":  {SensorTemperature, SensorHumidity},
+	"HTU21":   {SensorTemperature, SensorHumidity},
+	"AHT2X":   {SensorTemperature, SensorHumidity},
+	"BME280":  {SensorTemperature, SensorHumidity, SensorPressure},
+	"BME680":  {SensorTemperature, SensorHumidity, SensorPressure},
+	"BMP280":  {SensorTemperature, SensorPressure},
+	"BMP180":  {SensorTemperature, SensorPressure},
+	"BH1750":  {SensorIlluminance},
+	"TSL2561": {SensorIlluminance},
+}
+
+// SensorReadings are the latest values of one sensor by kind.
+type SensorReadings map[SensorKind]float64
+
+// Sensor exposes a sensor attached to a plug to HomeKit. Readings of every
+// sensor a plug reports are kept and shown on the dashboard and in metrics;
+// only configured ones get a HomeKit accessory.
+type Sensor struct {
+	// Sensor is the name Tasmota reports the sensor under, e.g. "BME280",
+	// or "DS18B20-1" when there are several of a kind.
+	Sensor string `json:"sensor"`
+	Name   string `json:"name,omitempty"`
+
+	// Readings to expose, defaulting to everything the sensor model
+	// reports. Needed for models Tasmota names differently.
+	Readings []SensorKind `json:"readings,omitempty"`
+}
+
+// sensorModel strips the index or address Tasmota appends when there are
+// several sensors of a kind, "DS18B20-1" or "SHT3X-0x44".
+func sensorModel(sensor string) string {
+	model, _, _ := strings.Cut(sensor, "-")
+	return strings.ToUpper(model)
+}
+
+// Kinds returns the readings the sensor exposes.
+func (s Sensor) Kinds() []SensorKind {
+	if len(s.Readings) > 0 {
+		return s.Readings
+	}
+	return sensorModels[sensorModel(s.Sensor)]
+}
+
+// SensorName returns the display name of a configured sensor.
+func (p Plug) SensorName(s Sensor) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return p.Name + " " + s.Sensor
+}
+
+func (p Plug) validateSensors() error {
+	seen := make(map[string]bool, len(p.Sensors))
+	for _, s := range p.Sensors {
+		if s.Sensor == "" {
+			return fmt.Errorf("plug %s has a sensor without a name", p.ID)
+		}
+		if seen[s.Sensor] {
+			return fmt.Errorf("plug %s has duplicate sensor %q", p.ID, s.Sensor)
+		}
+		seen[s.Sensor] = true
+		for _, kind := range s.Readings {
+			if !slices.Contains(SensorKinds, kind) {
+				return fmt.Errorf("plug %s sensor %s has unknown reading %q", p.ID, s.Sensor, kind)
+			}
+		}
+		if len(s.Kinds()) == 0 {
+			return fmt.Errorf("plug %s sensor %s is not a known model, list its readings", p.ID, s.Sensor)
+		}
+	}
+	return nil
+}
+
+// ParseSensorReadings extracts the readings of every sensor block in a
+// Tasmota SENSOR payload, e.g. {"BME280":{"Temperature":21.3,...}}, keyed
+// by sensor name. Temperatures are converted to Celsius and pressures to
+// hPa by the payload's TempUnit and PressureUnit.
+func ParseSensorReadings(payload map[string]interface{}) map[string]SensorReadings {
+	var sensors map[string]SensorReadings
+	for key, value := range payload {
+		if key == "ENERGY" {
+			continue
+		}
+		block, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		readings := make(SensorReadings)
+		for field, kind := range sensorFields {
+			if v, ok := block[field].(float64); ok {
+				readings[kind] = v
+			}
+		}
+		if len(readings) == 0 {
+			continue
+		}
+
+		if t, ok := readings[SensorTemperature]; ok && payload["TempUnit"] == "F" {
+			readings[SensorTemperature] = (t - 32) * 5 / 9
+		}
+		if p, ok := readings[SensorPressure]; ok {
+			switch payload["PressureUnit"] {
+			case "mmHg":
+				readings[SensorPressure] = p * 1.333224
+			case "inHg":
+				readings[SensorPressure] = p * 33.86389
+			}
+		}
+
+		if sensors == nil {
+			sensors = make(map[string]SensorReadings)
+		}
+		sensors[key] = readings
+	}
+	return sensors
+}
+
+// cloneSensors returns a copy of sensors that does not share maps with it.
+func cloneSensors(sensors map[string]SensorReadings) map[string]SensorReadings {
+	if sensors == nil {
+		return nil
+	}
+	clone := make(map[string]SensorReadings, len(sensors))
+	for name, readings := range sensors {
+		clone[name] = maps.Clone(readings)
+	}
+	return clone
+}
+
+// sensorEvents converts sensor readings for a StateUpdateEvent.
+func sensorEvents(sensors map[string]SensorReadings) map[string]map[string]float64 {
+	if len(sensors) == 0 {
+		return nil
+	}
+	converted := make(map[string]map[string]float64, len(sensors))
+	for name, readings := range sensors {
+		values := make(map[string]float64, len(readings))
+		for kind, value := range readings {
+			values[string(kind)] = value
+		}
+		converted[name] = values
+	}
+	return converted
+}
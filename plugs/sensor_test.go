@@ -0,0 +1,72 @@
+package plugs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSensorReadings(t *testing.T) {
+	payload := map[string]interface{}{
+		"Time":         "2025-03-03T18:05:00",
+		"DS18B20-1":    map[string]interface{}{"Id": "01144A0CB2AA", "Temperature": 69.8},
+		"BME280":       map[string]interface{}{"Temperature": 70.0, "Humidity": 45.0, "DewPoint": 48.0, "Pressure": 29.92},
+		"BH1750":       map[string]interface{}{"Illuminance": 120.0},
+		"ENERGY":       map[string]interface{}{"Power": 10.0},
+		"AM2301":       map[string]interface{}{"Temperature": nil, "Humidity": nil},
+		"TempUnit":     "F",
+		"PressureUnit": "inHg",
+	}
+
+	sensors := ParseSensorReadings(payload)
+	require.Len(t, sensors, 3)
+	require.InDelta(t, 21.0, sensors["DS18B20-1"][SensorTemperature], 0.001)
+	require.InDelta(t, 1013.2, sensors["BME280"][SensorPressure], 0.1)
+	require.Equal(t, 45.0, sensors["BME280"][SensorHumidity])
+	require.Len(t, sensors["BME280"], 3)
+	require.Equal(t, SensorReadings{SensorIlluminance: 120}, sensors["BH1750"])
+
+	require.Nil(t, ParseSensorReadings(map[string]interface{}{"ENERGY": map[string]interface{}{"Power": 10.0}}))
+}
+
+func TestValidateSensors(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		sensors []Sensor
+		errMsg  string
+	}{
+		{"known models", []Sensor{{Sensor: "BME280"}, {Sensor: "DS18B20-1"}, {Sensor: "SHT3X-0x44"}}, ""},
+		{"unknown model with readings", []Sensor{{Sensor: "SCD30", Readings: []SensorKind{SensorTemperature}}}, ""},
+		{"unknown model", []Sensor{{Sensor: "SCD30"}}, "not a known model"},
+		{"unknown reading", []Sensor{{Sensor: "BME280", Readings: []SensorKind{"co2"}}}, `unknown reading "co2"`},
+		{"duplicate", []Sensor{{Sensor: "BME280"}, {Sensor: "BME280"}}, "duplicate sensor"},
+		{"no name", []Sensor{{}}, "without a name"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Plug{ID: "office", Sensors: tt.sensors}.validateSensors()
+			if tt.errMsg == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+
+	require.Equal(t, []SensorKind{SensorTemperature, SensorHumidity}, Sensor{Sensor: "SHT3X-0x44"}.Kinds())
+	require.Equal(t, "Office BME280", Plug{Name: "Office"}.SensorName(Sensor{Sensor: "BME280"}))
+}
+
+func TestGetStatusReadsSensors(t *testing.T) {
+	pm, fake, _ := newTestManager(t)
+	fake.responses = [][]byte{[]byte(`{"StatusSTS":{"POWER":"ON"},"StatusSNS":{"DS18B20":{"Temperature":19.5},"TempUnit":"C"}}`)}
+
+	state, err := pm.GetStatus(context.Background(), "plug-1")
+	require.NoError(t, err)
+	require.Equal(t, map[string]SensorReadings{"DS18B20": {SensorTemperature: 19.5}}, state.Sensors)
+
+	// The returned state does not share readings with the manager's
+	state.Sensors["DS18B20"][SensorTemperature] = 0
+	_, current, _ := pm.Plug("plug-1")
+	require.Equal(t, 19.5, current.Sensors["DS18B20"][SensorTemperature])
+}
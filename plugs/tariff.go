@@ -0,0 +1,157 @@
+package plugs
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// StandardWindow is the name of the tariff's default rate.
+const StandardWindow = "standard"
+
+// Tariff prices consumption for the cost figures of the energy history.
+// Rates are per kWh in Currency; the first matching window applies and
+// Rate covers all other times.
+type Tariff struct {
+	Currency string         `json:"currency"`
+	Rate     float64        `json:"rate"`
+	Windows  []TariffWindow `json:"windows,omitempty"`
+
+	// BillingDay is the day of the month billing periods start on, 1-28;
+	// 0 or unset means the 1st.
+	BillingDay int `json:"billing_day,omitempty"`
+}
+
+// TariffWindow is a time-of-use rate. Start and End are local "HH:MM"
+// times, a window ending before it starts runs past midnight, and leaving
+// both out covers the whole day. Days restricts the window to days of the
+// week ("mon".."sun", "weekday", "weekend"), matched against the day the
+// time falls on.
+type TariffWindow struct {
+	Name  string   `json:"name,omitempty"`
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start,omitempty"`
+	End   string   `json:"end,omitempty"`
+	Rate  float64  `json:"rate"`
+}
+
+var weekdayNames = map[string][]time.Weekday{
+	"mon":     {time.Monday},
+	"tue":     {time.Tuesday},
+	"wed":     {time.Wednesday},
+	"thu":     {time.Thursday},
+	"fri":     {time.Friday},
+	"sat":     {time.Saturday},
+	"sun":     {time.Sunday},
+	"weekday": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekend": {time.Saturday, time.Sunday},
+}
+
+func (t *Tariff) validate() error {
+	if t.Currency == "" {
+		return fmt.Errorf("tariff has no currency")
+	}
+	if t.Rate < 0 {
+		return fmt.Errorf("tariff rate cannot be negative")
+	}
+	if t.BillingDay < 0 || t.BillingDay > 28 {
+		return fmt.Errorf("tariff billing_day must be between 1 and 28, or 0 for the 1st, got %d", t.BillingDay)
+	}
+	for i, w := range t.Windows {
+		name := w.label(i)
+		if w.Rate < 0 {
+			return fmt.Errorf("tariff window %q rate cannot be negative", name)
+		}
+		if (w.Start == "") != (w.End == "") {
+			return fmt.Errorf("tariff window %q needs both start and end, or neither", name)
+		}
+		if w.Start != "" {
+			start, err := parseClock(w.Start)
+			if err != nil {
+				return fmt.Errorf("tariff window %q: %w", name, err)
+			}
+			end, err := parseClock(w.End)
+			if err != nil {
+				return fmt.Errorf("tariff window %q: %w", name, err)
+			}
+			// It would never match; leaving both out covers the whole day
+			if start == end {
+				return fmt.Errorf("tariff window %q starts and ends at %s, leave out start and end to cover the whole day", name, w.Start)
+			}
+		}
+		for _, day := range w.Days {
+			if _, ok := weekdayNames[strings.ToLower(day)]; !ok {
+				return fmt.Errorf("tariff window %q has unknown day %q", name, day)
+			}
+		}
+	}
+	return nil
+}
+
+// RateAt returns the name and rate of the window that applies at t.
+func (t *Tariff) RateAt(at time.Time) (string, float64) {
+	for i, w := range t.Windows {
+		if w.matches(at) {
+			return w.label(i), w.Rate
+		}
+	}
+	return StandardWindow, t.Rate
+}
+
+// BillingPeriod returns the billing period containing at.
+func (t *Tariff) BillingPeriod(at time.Time) (start, end time.Time) {
+	day := 1
+	if t != nil && t.BillingDay > 0 {
+		day = t.BillingDay
+	}
+
+	start = time.Date(at.Year(), at.Month(), day, 0, 0, 0, 0, at.Location())
+	if at.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start, start.AddDate(0, 1, 0)
+}
+
+func (w TariffWindow) label(i int) string {
+	if w.Name != "" {
+		return w.Name
+	}
+	return fmt.Sprintf("window %d", i+1)
+}
+
+func (w TariffWindow) matches(at time.Time) bool {
+	if len(w.Days) > 0 {
+		match := false
+		for _, day := range w.Days {
+			if slices.Contains(weekdayNames[strings.ToLower(day)], at.Weekday()) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	if w.Start == "" {
+		return true
+	}
+
+	// Validated when the config was parsed
+	start, _ := parseClock(w.Start)
+	end, _ := parseClock(w.End)
+	minute := at.Hour()*60 + at.Minute()
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// parseClock parses "HH:MM" into minutes past midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,93 @@
+package plugs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTariffRateAt(t *testing.T) {
+	tariff := &Tariff{
+		Currency: "EUR",
+		Rate:     0.30,
+		Windows: []TariffWindow{
+			{Name: "night", Start: "22:00", End: "06:00", Rate: 0.10},
+			{Name: "weekend", Days: []string{"weekend"}, Rate: 0.20},
+			{Days: []string{"Mon"}, Start: "12:00", End: "13:00", Rate: 0.25},
+		},
+	}
+	require.NoError(t, tariff.validate())
+
+	// 2025-03-03 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2025, 3, day, hour, minute, 0, 0, time.UTC)
+	}
+	for _, tt := range []struct {
+		at     time.Time
+		window string
+		rate   float64
+	}{
+		{at(3, 10, 0), StandardWindow, 0.30},
+		{at(3, 23, 30), "night", 0.10},
+		{at(4, 5, 59), "night", 0.10},
+		{at(4, 6, 0), StandardWindow, 0.30},
+		{at(3, 12, 30), "window 3", 0.25},
+		{at(4, 12, 30), StandardWindow, 0.30},
+		// The first matching window wins
+		{at(8, 2, 0), "night", 0.10},
+		{at(8, 14, 0), "weekend", 0.20},
+	} {
+		window, rate := tariff.RateAt(tt.at)
+		require.Equal(t, tt.window, window, tt.at)
+		require.Equal(t, tt.rate, rate, tt.at)
+	}
+}
+
+func TestTariffValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		tariff Tariff
+		errMsg string
+	}{
+		{"no currency", Tariff{Rate: 0.3}, "no currency"},
+		{"negative rate", Tariff{Currency: "EUR", Rate: -1}, "cannot be negative"},
+		{"billing day", Tariff{Currency: "EUR", BillingDay: 31}, "billing_day must be between 1 and 28, or 0 for the 1st, got 31"},
+		{"negative billing day", Tariff{Currency: "EUR", BillingDay: -1}, "billing_day"},
+		{"start without end", Tariff{Currency: "EUR", Windows: []TariffWindow{{Start: "22:00"}}}, "both start and end"},
+		{"empty window", Tariff{Currency: "EUR", Windows: []TariffWindow{{Name: "x", Start: "00:00", End: "00:00"}}}, `tariff window "x" starts and ends at 00:00`},
+		{"bad time", Tariff{Currency: "EUR", Windows: []TariffWindow{{Start: "25:00", End: "06:00"}}}, "want HH:MM"},
+		{"bad day", Tariff{Currency: "EUR", Windows: []TariffWindow{{Name: "x", Days: []string{"funday"}}}}, `unknown day "funday"`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			require.ErrorContains(t, tt.tariff.validate(), tt.errMsg)
+		})
+	}
+
+	// 0 is the documented default
+	require.NoError(t, (&Tariff{Currency: "EUR", BillingDay: 0}).validate())
+	require.NoError(t, (&Tariff{Currency: "EUR", BillingDay: 28}).validate())
+
+	_, err := ParseConfig([]byte(`{
+		"tariff": {"currency": "EUR", "rate": -0.1},
+		"plugs": [{"id": "a", "name": "A", "address": "1"}],
+	}`))
+	require.ErrorContains(t, err, "tariff")
+}
+
+func TestTariffBillingPeriod(t *testing.T) {
+	at := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	var none *Tariff
+	start, end := none.BillingPeriod(at)
+	require.Equal(t, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), start)
+	require.Equal(t, time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), end)
+
+	tariff := &Tariff{Currency: "EUR", BillingDay: 15}
+	start, end = tariff.BillingPeriod(at)
+	require.Equal(t, time.Date(2025, 2, 15, 0, 0, 0, 0, time.UTC), start)
+	require.Equal(t, time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC), end)
+
+	start, _ = tariff.BillingPeriod(time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC))
+	require.Equal(t, time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC), start)
+}
@@ -0,0 +1,109 @@
+package plugs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultFullTopic is Tasmota's default FullTopic.
+const DefaultFullTopic = "%prefix%/%topic%/"
+
+// Tasmota topic prefixes, substituted for %prefix% in the FullTopic.
+const (
+	PrefixCommand   = "cmnd"
+	PrefixStatus    = "stat"
+	PrefixTelemetry = "tele"
+)
+
+// MQTTTopic returns the Tasmota Topic of the plug, tasmota/<id> unless
+// configured.
+func (p Plug) MQTTTopic() string {
+	if p.Topic != "" {
+		return p.Topic
+	}
+	return "tasmota/" + p.ID
+}
+
+// MQTTFullTopic returns the Tasmota FullTopic of the plug.
+func (p Plug) MQTTFullTopic() string {
+	if p.FullTopic != "" {
+		return p.FullTopic
+	}
+	return DefaultFullTopic
+}
+
+// TopicFor returns the topic the plug uses for prefix, ending in a slash,
+// e.g. cmnd/tasmota/desk/. Command and message names are appended to it.
+func (p Plug) TopicFor(prefix string) string {
+	topic := strings.NewReplacer(
+		"%prefix%", prefix,
+		"%topic%", p.MQTTTopic(),
+	).Replace(p.MQTTFullTopic())
+	if !strings.HasSuffix(topic, "/") {
+		topic += "/"
+	}
+	return topic
+}
+
+func (p Plug) validateTopic() error {
+	if p.KeepTopic && p.Topic == "" {
+		return fmt.Errorf("plug %s has keep_topic but no topic", p.ID)
+	}
+	if strings.ContainsAny(p.Topic, "#+; %") {
+		return fmt.Errorf("plug %s topic %q cannot contain wildcards, spaces, semicolons or %%", p.ID, p.Topic)
+	}
+	if p.FullTopic == "" {
+		return nil
+	}
+	if strings.ContainsAny(p.FullTopic, "#+; ") {
+		return fmt.Errorf("plug %s full_topic %q cannot contain wildcards, spaces or semicolons", p.ID, p.FullTopic)
+	}
+	if !strings.Contains(p.FullTopic, "%prefix%") {
+		return fmt.Errorf("plug %s full_topic %q must contain %%prefix%%", p.ID, p.FullTopic)
+	}
+	// Other Tasmota tokens like %hostname% or %id% are device specific and
+	// cannot be resolved here
+	rest := strings.NewReplacer("%prefix%", "", "%topic%", "").Replace(p.FullTopic)
+	if strings.Contains(rest, "%") {
+		return fmt.Errorf("plug %s full_topic %q may only use %%prefix%% and %%topic%%", p.ID, p.FullTopic)
+	}
+	return nil
+}
+
+// TopicMatch is an MQTT topic resolved to the plug it belongs to.
+type TopicMatch struct {
+	PlugID string
+	Prefix string // PrefixCommand, PrefixStatus or PrefixTelemetry
+	Suffix string // the rest of the topic, e.g. RESULT, SENSOR or LWT
+}
+
+// ResolveTopic returns the plug whose FullTopic topic falls under. When
+// several match, e.g. topics tasmota/a and tasmota/a/b, the most specific wins.
+func ResolveTopic(plugConfigs []Plug, topic string) (TopicMatch, bool) {
+	var best TopicMatch
+	bestLen := 0
+	for _, plug := range plugConfigs {
+		for _, prefix := range []string{PrefixStatus, PrefixTelemetry, PrefixCommand} {
+			base := plug.TopicFor(prefix)
+			rest, ok := strings.CutPrefix(topic, base)
+			if !ok || rest == "" || len(base) <= bestLen {
+				continue
+			}
+			best = TopicMatch{PlugID: plug.ID, Prefix: prefix, Suffix: rest}
+			bestLen = len(base)
+		}
+	}
+	return best, bestLen > 0
+}
+
+// ResolveTopic returns the managed plug that topic belongs to.
+func (pm *Manager) ResolveTopic(topic string) (TopicMatch, bool) {
+	pm.mu.RLock()
+	plugConfigs := make([]Plug, 0, len(pm.plugs))
+	for _, info := range pm.plugs {
+		plugConfigs = append(plugConfigs, info.Config)
+	}
+	pm.mu.RUnlock()
+
+	return ResolveTopic(plugConfigs, topic)
+}
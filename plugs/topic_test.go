@@ -0,0 +1,54 @@
+package plugs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopicFor(t *testing.T) {
+	require.Equal(t, "cmnd/tasmota/desk/", Plug{ID: "desk"}.TopicFor(PrefixCommand))
+	require.Equal(t, "stat/lights/desk/", Plug{ID: "desk", Topic: "lights/desk"}.TopicFor(PrefixStatus))
+	require.Equal(t, "desk/tele/", Plug{ID: "desk", Topic: "desk", FullTopic: "%topic%/%prefix%"}.TopicFor(PrefixTelemetry))
+}
+
+func TestResolveTopic(t *testing.T) {
+	plugConfigs := []Plug{
+		{ID: "desk"},
+		{ID: "kitchen", Topic: "kitchen", FullTopic: "home/%topic%/%prefix%/"},
+		{ID: "a", Topic: "strip"},
+		{ID: "b", Topic: "strip/b"},
+	}
+
+	tests := []struct {
+		topic string
+		want  TopicMatch
+		ok    bool
+	}{
+		{"stat/tasmota/desk/RESULT", TopicMatch{PlugID: "desk", Prefix: PrefixStatus, Suffix: "RESULT"}, true},
+		{"home/kitchen/tele/LWT", TopicMatch{PlugID: "kitchen", Prefix: PrefixTelemetry, Suffix: "LWT"}, true},
+		{"stat/strip/b/POWER", TopicMatch{PlugID: "b", Prefix: PrefixStatus, Suffix: "POWER"}, true},
+		{"stat/strip/POWER", TopicMatch{PlugID: "a", Prefix: PrefixStatus, Suffix: "POWER"}, true},
+		{"stat/tasmota/unknown/RESULT", TopicMatch{}, false},
+		{"tele/kitchen/STATE", TopicMatch{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := ResolveTopic(plugConfigs, tt.topic)
+		require.Equal(t, tt.ok, ok, tt.topic)
+		require.Equal(t, tt.want, got, tt.topic)
+	}
+}
+
+func TestValidateTopic(t *testing.T) {
+	for _, plug := range []Plug{
+		{ID: "p", KeepTopic: true},
+		{ID: "p", Topic: "a b"},
+		{ID: "p", Topic: "a/#"},
+		{ID: "p", FullTopic: "%topic%/"},
+		{ID: "p", FullTopic: "%prefix%/%hostname%/"},
+	} {
+		require.Error(t, plug.validateTopic(), "%+v", plug)
+	}
+
+	require.NoError(t, Plug{ID: "p", Topic: "desk", FullTopic: "%topic%/%prefix%/", KeepTopic: true}.validateTopic())
+}
@@ -2,6 +2,7 @@ package plugs
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -12,6 +13,30 @@ import (
 // Config defines the plug configuration file structure.
 type Config struct {
 	Plugs []Plug `json:"plugs"`
+
+	// MQTTUsers are additional broker accounts, e.g. for debugging tools.
+	MQTTUsers []MQTTAccount `json:"mqtt_users,omitempty"`
+
+	// Tariff prices the consumption recorded in the energy history.
+	Tariff *Tariff `json:"tariff,omitempty"`
+
+	// Schedules switch plugs at set times; Location is needed for the ones
+	// relative to sunrise or sunset.
+	Schedules []Schedule `json:"schedules,omitempty"`
+	Location  *Location  `json:"location,omitempty"`
+
+	// Groups switch several plugs as one; Scenes put several plugs in a set
+	// state.
+	Groups []Group `json:"groups,omitempty"`
+	Scenes []Scene `json:"scenes,omitempty"`
+
+	// Webhooks post plug events to other systems.
+	Webhooks []Webhook `json:"webhooks,omitempty"`
+
+	// Notifications tell people about offline plugs and safety alerts
+	// through the Notifiers they name.
+	Notifiers     []Notifier   `json:"notifiers,omitempty"`
+	Notifications []NotifyRule `json:"notifications,omitempty"`
 }
 
 // LoadConfig reads and validates the HuJSON plug configuration file.
@@ -21,6 +46,11 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read plugs config file: %w", err)
 	}
 
+	return ParseConfig(data)
+}
+
+// ParseConfig parses and validates HuJSON plug configuration.
+func ParseConfig(data []byte) (*Config, error) {
 	standardized, err := hujson.Standardize(data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to standardize HuJSON: %w", err)
@@ -51,6 +81,27 @@ func LoadConfig(path string) (*Config, error) {
 			return nil, fmt.Errorf("duplicate plug id %q", plug.ID)
 		}
 		seenIDs[plug.ID] = struct{}{}
+		if len(plug.Relays) > MaxRelays {
+			return nil, fmt.Errorf("plug %s has %d relays, maximum is %d", plug.ID, len(plug.Relays), MaxRelays)
+		}
+		if plug.InUseThreshold != nil && *plug.InUseThreshold < 0 {
+			return nil, fmt.Errorf("plug %s has a negative in_use_threshold", plug.ID)
+		}
+		if plug.MQTTUsername != "" && plug.MQTTPassword == "" {
+			return nil, fmt.Errorf("plug %s has an mqtt_username but no mqtt_password", plug.ID)
+		}
+		if err := plug.validateTopic(); err != nil {
+			return nil, err
+		}
+		if err := plug.validateSafety(); err != nil {
+			return nil, err
+		}
+		if err := plug.validateButtons(); err != nil {
+			return nil, err
+		}
+		if err := plug.validateSensors(); err != nil {
+			return nil, err
+		}
 
 		// Set defaults for HomeKit and Web if not specified
 		if cfg.Plugs[i].HomeKit == nil {
@@ -63,9 +114,37 @@ func LoadConfig(path string) (*Config, error) {
 		}
 	}
 
+	if err := cfg.validateMQTT(); err != nil {
+		return nil, err
+	}
+	if cfg.Tariff != nil {
+		if err := cfg.Tariff.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.validateSchedules(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateGroups(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateWebhooks(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateNotifications(); err != nil {
+		return nil, err
+	}
+
 	return &cfg, nil
 }
 
+// MaxRelays is the highest relay index Tasmota addresses (POWER1..POWER32).
+const MaxRelays = 32
+
+// DefaultInUseThreshold is the draw in watts above which a power-monitored
+// outlet reports OutletInUse.
+const DefaultInUseThreshold = 1.0
+
 // Plug describes a single Tasmota plug.
 type Plug struct {
 	ID       string        `json:"id"`
@@ -74,28 +153,181 @@ type Plug struct {
 	Model    string        `json:"model,omitempty"`
 	Type     string        `json:"type,omitempty"` // "plug" or "bulb"
 	Features *PlugFeatures `json:"features,omitempty"`
+	Relays   []Relay       `json:"relays,omitempty"`  // multi-relay devices, in POWER1..N order
 	HomeKit  *bool         `json:"homekit,omitempty"` // default true
 	Web      *bool         `json:"web,omitempty"`     // default true
+
+	// InUseThreshold is the draw in watts above which OutletInUse is set,
+	// defaults to DefaultInUseThreshold; 0 sets it whenever the outlet is
+	// on. Only used with power monitoring.
+	InUseThreshold *float64 `json:"in_use_threshold,omitempty"`
+
+	// MQTT broker credentials, pushed to the device by ConfigureMQTT. The
+	// username defaults to the plug ID. May also come from a secrets file.
+	MQTTUsername string `json:"mqtt_username,omitempty"`
+	MQTTPassword string `json:"mqtt_password,omitempty"`
+
+	// MQTTTLS points the device at the broker's TLS listener. Needs a
+	// Tasmota build with USE_MQTT_TLS.
+	MQTTTLS bool `json:"mqtt_tls,omitempty"`
+
+	// Tasmota Topic and FullTopic of the device, defaulting to tasmota/<id>
+	// and %prefix%/%topic%/. ConfigureMQTT pushes them to the device unless
+	// KeepTopic is set, for devices whose topics other systems rely on.
+	Topic     string `json:"topic,omitempty"`
+	FullTopic string `json:"full_topic,omitempty"`
+	KeepTopic bool   `json:"keep_topic,omitempty"`
+
+	// Safety switches the plug off when it draws too much, stays on too
+	// long or idles.
+	Safety *Safety `json:"safety,omitempty"`
+
+	// Buttons are the physical buttons of the device, in Button1..N order.
+	// Configuring any decouples them from the relays, see Button.
+	Buttons []Button `json:"buttons,omitempty"`
+
+	// Sensors attached to the device to expose to HomeKit.
+	Sensors []Sensor `json:"sensors,omitempty"`
+}
+
+// MQTTCredentials returns the broker username and password of the plug.
+// ok is false when no password is configured.
+func (p Plug) MQTTCredentials() (username, password string, ok bool) {
+	if p.MQTTPassword == "" {
+		return "", "", false
+	}
+	if p.MQTTUsername == "" {
+		return p.ID, p.MQTTPassword, true
+	}
+	return p.MQTTUsername, p.MQTTPassword, true
+}
+
+// Relay describes one independently switchable output of a multi-relay device.
+type Relay struct {
+	Name string `json:"name,omitempty"`
+}
+
+// RelayCount returns the number of relays the plug exposes (at least one).
+func (p Plug) RelayCount() int {
+	if len(p.Relays) == 0 {
+		return 1
+	}
+	return len(p.Relays)
+}
+
+// RelayName returns the display name of a 1-based relay.
+func (p Plug) RelayName(relay int) string {
+	if relay >= 1 && relay <= len(p.Relays) && p.Relays[relay-1].Name != "" {
+		return p.Relays[relay-1].Name
+	}
+	if p.RelayCount() == 1 {
+		return p.Name
+	}
+	return fmt.Sprintf("%s %d", p.Name, relay)
 }
 
 // PlugFeatures indicates optional features of a plug.
 type PlugFeatures struct {
-	PowerMonitoring bool `json:"power_monitoring"`
-	EnergyTracking  bool `json:"energy_tracking"`
+	PowerMonitoring  bool `json:"power_monitoring"`
+	EnergyTracking   bool `json:"energy_tracking"`
+	Dimmer           bool `json:"dimmer,omitempty"`            // bulbs: Dimmer (brightness)
+	Color            bool `json:"color,omitempty"`             // bulbs: HSBColor (hue/saturation)
+	ColorTemperature bool `json:"color_temperature,omitempty"` // bulbs: CT (white temperature)
+}
+
+// HasPowerMonitoring reports whether the plug reports power readings.
+func (p Plug) HasPowerMonitoring() bool {
+	return p.Features != nil && p.Features.PowerMonitoring
+}
+
+// InUseWatts returns the OutletInUse threshold in watts.
+func (p Plug) InUseWatts() float64 {
+	if p.InUseThreshold != nil {
+		return *p.InUseThreshold
+	}
+	return DefaultInUseThreshold
+}
+
+// HasBrightness reports whether the plug is a bulb with a dimmer. Color and
+// white temperature channels always come with one.
+func (p Plug) HasBrightness() bool {
+	return p.Type == "bulb" && p.Features != nil &&
+		(p.Features.Dimmer || p.Features.Color || p.Features.ColorTemperature)
+}
+
+// HasColor reports whether the plug is a bulb with RGB channels.
+func (p Plug) HasColor() bool {
+	return p.Type == "bulb" && p.Features != nil && p.Features.Color
+}
+
+// HasColorTemperature reports whether the plug is a bulb with white temperature control.
+func (p Plug) HasColorTemperature() bool {
+	return p.Type == "bulb" && p.Features != nil && p.Features.ColorTemperature
 }
 
 // State represents the runtime state of a plug.
 type State struct {
-	ID            string
-	Name          string
-	On            bool
-	Power         float64 // Watts
-	Voltage       float64 // Volts
-	Current       float64 // Amperes
-	Energy        float64 // kWh
+	ID               string
+	Name             string
+	On               bool    // relay 1 (Tasmota POWER/POWER1)
+	Relays           []bool  // every relay, index 0 is POWER1
+	Brightness       int     // percent (Tasmota Dimmer)
+	Hue              float64 // degrees
+	Saturation       float64 // percent
+	ColorTemperature int     // mireds, zero until the device reports CT
+	Power            float64 // Watts
+	Voltage          float64 // Volts
+	Current          float64 // Amperes
+	Energy           float64 // kWh
+	EnergyToday      float64 // kWh since the device's midnight
+	EnergyYesterday  float64 // kWh
+	// Sensors holds the latest readings of the sensors the device reports,
+	// by Tasmota sensor name
+	Sensors       map[string]SensorReadings
 	LastUpdated   time.Time
 	MQTTConnected bool
-	LastSeen      time.Time
+	// Offline is set when the device's broker session ends or it publishes
+	// LWT Offline, and cleared once it is heard from again.
+	Offline  bool
+	LastSeen time.Time
+	// Restored is set for state loaded from the state store at startup and
+	// cleared once the device reports its actual state.
+	Restored bool
+}
+
+// Clone returns a copy of the state that does not share slices or maps
+// with s.
+func (s State) Clone() State {
+	if s.Relays != nil {
+		s.Relays = append([]bool(nil), s.Relays...)
+	}
+	s.Sensors = cloneSensors(s.Sensors)
+	return s
+}
+
+// RelayOn reports whether the 1-based relay is on.
+func (s State) RelayOn(relay int) bool {
+	if relay <= 1 && len(s.Relays) == 0 {
+		return s.On
+	}
+	if relay < 1 || relay > len(s.Relays) {
+		return false
+	}
+	return s.Relays[relay-1]
+}
+
+// SetRelay records the state of a 1-based relay, keeping On in sync with relay 1.
+func (s *State) SetRelay(relay int, on bool) {
+	if relay < 1 {
+		relay = 1
+	}
+	for len(s.Relays) < relay {
+		s.Relays = append(s.Relays, false)
+	}
+	s.Relays[relay-1] = on
+	if relay == 1 {
+		s.On = on
+	}
 }
 
 // StateChangedEvent is emitted when a plug's state changes.
@@ -105,12 +337,23 @@ type StateChangedEvent struct {
 	UpdatedFields []string
 }
 
-// CommandEvent requests a plug command.
+// CommandEvent requests a plug command. Light commands carry Light and
+// ignore Relay/On. Commands for a Group switch all its plugs to On, and
+// commands for a Scene apply it; both leave PlugID empty.
 type CommandEvent struct {
 	PlugID string
+	Relay  int // 1-based relay, 0 addresses every relay of the device
 	On     bool
+	Light  *LightSettings
+	Group  string
+	Scene  string
+	Source string // Origin recorded on the command event, e.g. "homekit"
 }
 
+// ErrUnreachable is wrapped by the errors of ErrorEvents reporting a plug
+// that cannot be reached.
+var ErrUnreachable = errors.New("plug unreachable")
+
 // ErrorEvent is emitted when a plug encounters an error.
 type ErrorEvent struct {
 	PlugID string
@@ -47,3 +47,36 @@ func TestLoadConfigRejectsDuplicateIDs(t *testing.T) {
 		t.Fatal("expected error for duplicate IDs")
 	}
 }
+
+func TestInUseWatts(t *testing.T) {
+	if got := (Plug{}).InUseWatts(); got != DefaultInUseThreshold {
+		t.Fatalf("InUseWatts() = %v, want default %v", got, DefaultInUseThreshold)
+	}
+	threshold := 5.0
+	if got := (Plug{InUseThreshold: &threshold}).InUseWatts(); got != 5 {
+		t.Fatalf("InUseWatts() = %v, want 5", got)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "zero.hujson")
+	if err := os.WriteFile(path, []byte(`{"plugs":[{"id":"a","name":"A","address":"1","in_use_threshold":0}]}`), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	// An explicit 0 is kept rather than replaced by the default
+	if got := cfg.Plugs[0].InUseWatts(); got != 0 {
+		t.Fatalf("InUseWatts() = %v, want 0", got)
+	}
+
+	path = filepath.Join(dir, "threshold.hujson")
+	if err := os.WriteFile(path, []byte(`{"plugs":[{"id":"a","name":"A","address":"1","in_use_threshold":-1}]}`), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if _, err := LoadConfig(path); err == nil {
+		t.Fatal("expected error for negative in_use_threshold")
+	}
+}
@@ -0,0 +1,132 @@
+package plugs
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"text/template"
+)
+
+// WebhookEvent is a kind of event a webhook can be sent for.
+type WebhookEvent string
+
+const (
+	WebhookState      WebhookEvent = "state"      // plug state updates
+	WebhookCommand    WebhookEvent = "command"    // commands sent to plugs
+	WebhookError      WebhookEvent = "error"      // plug errors
+	WebhookConnection WebhookEvent = "connection" // component connection status
+)
+
+// WebhookEvents lists the webhook event kinds.
+var WebhookEvents = []WebhookEvent{WebhookState, WebhookCommand, WebhookError, WebhookConnection}
+
+// WebhookFields are the state fields a webhook can be limited to changes
+// of, named as in the state JSON.
+var WebhookFields = []string{
+	"on", "relays", "brightness", "hue", "saturation", "color_temperature",
+	"power", "voltage", "current", "energy", "sensors",
+	"mqtt_connected", "offline", "connection_state",
+}
+
+// Webhook posts events to a URL. The body is the event as JSON, or the
+// output of Template executed with it.
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+
+	// Filters, all optional: the event kinds and plugs to send, and for
+	// state updates, the fields that must have changed. Connection events
+	// are not tied to a plug and pass the plug filter.
+	Events []WebhookEvent `json:"events,omitempty"`
+	Plugs  []string       `json:"plugs,omitempty"`
+	Fields []string       `json:"fields,omitempty"`
+
+	// Template is a Go text/template for the body; ContentType defaults to
+	// application/json without one and text/plain with one.
+	Template    string            `json:"template,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+
+	// Secret signs the body with HMAC-SHA256. May also come from the
+	// secrets file.
+	Secret string `json:"secret,omitempty"`
+}
+
+// Wants reports whether the webhook is sent for events of kind on plugID,
+// empty for events that are not about a plug.
+func (w Webhook) Wants(kind WebhookEvent, plugID string) bool {
+	if len(w.Events) > 0 && !slices.Contains(w.Events, kind) {
+		return false
+	}
+	if plugID != "" && len(w.Plugs) > 0 && !slices.Contains(w.Plugs, plugID) {
+		return false
+	}
+	return true
+}
+
+// WantsChanges reports whether a state update that changed the given
+// fields passes the webhook's field filter.
+func (w Webhook) WantsChanges(changed []string) bool {
+	if len(w.Fields) == 0 {
+		return true
+	}
+	for _, field := range changed {
+		if slices.Contains(w.Fields, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTemplate returns the parsed body template, nil without one.
+func (w Webhook) ParseTemplate() (*template.Template, error) {
+	if w.Template == "" {
+		return nil, nil
+	}
+	return template.New(w.ID).Option("missingkey=error").Parse(w.Template)
+}
+
+func (c *Config) validateWebhooks() error {
+	plugIDs := make(map[string]bool, len(c.Plugs))
+	for _, plug := range c.Plugs {
+		plugIDs[plug.ID] = true
+	}
+
+	seen := make(map[string]bool, len(c.Webhooks))
+	for _, w := range c.Webhooks {
+		if w.ID == "" {
+			return fmt.Errorf("webhook without an id")
+		}
+		if seen[w.ID] {
+			return fmt.Errorf("duplicate webhook id %q", w.ID)
+		}
+		seen[w.ID] = true
+
+		u, err := url.Parse(w.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook %s needs an http or https url", w.ID)
+		}
+		for _, kind := range w.Events {
+			if !slices.Contains(WebhookEvents, kind) {
+				return fmt.Errorf("webhook %s has unknown event %q", w.ID, kind)
+			}
+		}
+		for _, id := range w.Plugs {
+			if !plugIDs[id] {
+				return fmt.Errorf("webhook %s references unknown plug %q", w.ID, id)
+			}
+		}
+		for _, field := range w.Fields {
+			if !slices.Contains(WebhookFields, field) {
+				return fmt.Errorf("webhook %s has unknown field %q", w.ID, field)
+			}
+		}
+		if len(w.Fields) > 0 && len(w.Events) > 0 && !slices.Contains(w.Events, WebhookState) {
+			return fmt.Errorf("webhook %s filters on fields but is not sent for state events", w.ID)
+		}
+		if _, err := w.ParseTemplate(); err != nil {
+			return fmt.Errorf("webhook %s has an invalid template: %w", w.ID, err)
+		}
+	}
+	return nil
+}
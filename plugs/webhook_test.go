@@ -0,0 +1,62 @@
+package plugs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateWebhooks(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		webhooks []Webhook
+		errMsg   string
+	}{
+		{"minimal", []Webhook{{ID: "w", URL: "https://example.com/hook"}}, ""},
+		{"filtered", []Webhook{{ID: "w", URL: "http://10.0.0.2:8123/api/webhook/x", Events: []WebhookEvent{WebhookState}, Plugs: []string{"a"}, Fields: []string{"on", "offline"}}}, ""},
+		{"template", []Webhook{{ID: "w", URL: "https://example.com", Template: `{"text": "{{.PlugID}}"}`}}, ""},
+		{"no id", []Webhook{{URL: "https://example.com"}}, "without an id"},
+		{"duplicate", []Webhook{{ID: "w", URL: "https://a.example"}, {ID: "w", URL: "https://b.example"}}, `duplicate webhook id "w"`},
+		{"no url", []Webhook{{ID: "w"}}, "needs an http or https url"},
+		{"bad scheme", []Webhook{{ID: "w", URL: "ftp://example.com"}}, "needs an http or https url"},
+		{"event", []Webhook{{ID: "w", URL: "https://example.com", Events: []WebhookEvent{"alert"}}}, `unknown event "alert"`},
+		{"plug", []Webhook{{ID: "w", URL: "https://example.com", Plugs: []string{"x"}}}, `unknown plug "x"`},
+		{"field", []Webhook{{ID: "w", URL: "https://example.com", Fields: []string{"colour"}}}, `unknown field "colour"`},
+		{"fields without state", []Webhook{{ID: "w", URL: "https://example.com", Events: []WebhookEvent{WebhookCommand}, Fields: []string{"on"}}}, "not sent for state events"},
+		{"bad template", []Webhook{{ID: "w", URL: "https://example.com", Template: "{{.PlugID"}}, "invalid template"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				Plugs:    []Plug{{ID: "a", Name: "A", Address: "1"}},
+				Webhooks: tt.webhooks,
+			}
+			err := cfg.validateWebhooks()
+			if tt.errMsg == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestWebhookFilters(t *testing.T) {
+	all := Webhook{ID: "all"}
+	require.True(t, all.Wants(WebhookState, "a"))
+	require.True(t, all.Wants(WebhookConnection, ""))
+	require.True(t, all.WantsChanges(nil))
+
+	filtered := Webhook{
+		ID:     "filtered",
+		Events: []WebhookEvent{WebhookState, WebhookConnection},
+		Plugs:  []string{"a"},
+		Fields: []string{"on"},
+	}
+	require.True(t, filtered.Wants(WebhookState, "a"))
+	require.False(t, filtered.Wants(WebhookState, "b"))
+	require.False(t, filtered.Wants(WebhookCommand, "a"))
+	require.True(t, filtered.Wants(WebhookConnection, ""), "connection events pass the plug filter")
+	require.True(t, filtered.WantsChanges([]string{"power", "on"}))
+	require.False(t, filtered.WantsChanges([]string{"power"}))
+	require.False(t, filtered.WantsChanges(nil))
+}
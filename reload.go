@@ -0,0 +1,246 @@
+package tasmotahomekit
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"tailscale.com/util/eventbus"
+)
+
+// configComponent is the connection status component for config reloads.
+const configComponent = "config"
+
+// PlugsReloader applies changes to the plugs configuration file, and the
+// optional secrets file, without restarting the bridge. Invalid files are
+// rejected and the running configuration is kept.
+type PlugsReloader struct {
+	path        string
+	secretsPath string
+	plugManager *plugs.Manager
+	hapManager  *HAPManager
+	eventBus    *events.Bus
+	client      *eventbus.Client
+	onNewPlug   func(ctx context.Context, plugID string)
+	onReload    []func(cfg *plugs.Config)
+
+	mu       sync.Mutex
+	checksum [sha256.Size]byte
+}
+
+// NewPlugsReloader creates a reloader for the configuration at path and
+// secretsPath (which may be empty), the files the running configuration was
+// loaded from. onNewPlug is called for added plugs and plugs whose address or
+// credentials changed, e.g. to point them at the broker.
+func NewPlugsReloader(
+	path string,
+	secretsPath string,
+	plugManager *plugs.Manager,
+	hapManager *HAPManager,
+	bus *events.Bus,
+	onNewPlug func(ctx context.Context, plugID string),
+) (*PlugsReloader, error) {
+	client, err := bus.Client(events.ClientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config eventbus client: %w", err)
+	}
+
+	r := &PlugsReloader{
+		path:        path,
+		secretsPath: secretsPath,
+		plugManager: plugManager,
+		hapManager:  hapManager,
+		eventBus:    bus,
+		client:      client,
+		onNewPlug:   onNewPlug,
+	}
+
+	checksum, err := r.sum()
+	if err != nil {
+		return nil, err
+	}
+	r.checksum = checksum
+
+	return r, nil
+}
+
+// OnReload registers f to be called with every successfully applied
+// configuration, whether or not the plug set changed.
+func (r *PlugsReloader) OnReload(f func(cfg *plugs.Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onReload = append(r.onReload, f)
+}
+
+// sum hashes the plugs file and the secrets file.
+func (r *PlugsReloader) sum() ([sha256.Size]byte, error) {
+	h := sha256.New()
+	for _, path := range []string{r.path, r.secretsPath} {
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return [sha256.Size]byte{}, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		h.Write(data)
+		h.Write([]byte{0})
+	}
+
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// Run reloads the configuration on SIGHUP and, when interval is positive,
+// whenever the file content changes.
+func (r *PlugsReloader) Run(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-hup:
+			slog.Info("SIGHUP received, reloading plugs configuration", "path", r.path)
+			if err := r.Reload(ctx); err != nil {
+				slog.Error("Failed to reload plugs configuration", "error", err)
+			}
+		case <-tick:
+			if !r.changed() {
+				continue
+			}
+			slog.Info("Plugs configuration changed, reloading", "path", r.path)
+			if err := r.Reload(ctx); err != nil {
+				slog.Error("Failed to reload plugs configuration", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// changed reports whether the file content differs from the last reload attempt.
+func (r *PlugsReloader) changed() bool {
+	checksum, err := r.sum()
+	if err != nil {
+		// Editors may replace the file non-atomically; try again next tick
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return checksum != r.checksum
+}
+
+// Reload loads the configuration file and applies the difference to the
+// plug manager and HomeKit accessories.
+func (r *PlugsReloader) Reload(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	checksum, err := r.sum()
+	if err != nil {
+		r.publishStatus(events.ConnectionStatusFailed, err.Error())
+		return err
+	}
+	// Remember invalid content too, so a broken file is reported once
+	// rather than on every poll
+	r.checksum = checksum
+
+	cfg, err := plugs.LoadConfigWithSecrets(r.path, r.secretsPath)
+	if err != nil {
+		r.publishStatus(events.ConnectionStatusFailed, err.Error())
+		return fmt.Errorf("rejected plugs configuration: %w", err)
+	}
+
+	diff, err := r.plugManager.Reconcile(cfg.Plugs)
+	if err != nil {
+		r.publishStatus(events.ConnectionStatusFailed, err.Error())
+		return fmt.Errorf("failed to apply plugs configuration: %w", err)
+	}
+	r.publishStatus(events.ConnectionStatusConnected, "")
+
+	for _, f := range r.onReload {
+		f(cfg)
+	}
+
+	if diff.Empty() {
+		slog.Info("Plugs configuration reloaded, no changes")
+		return nil
+	}
+
+	if r.hapManager != nil {
+		r.hapManager.Reload(cfg.Plugs)
+	}
+
+	reprovision := diff.Reprovision()
+	if r.onNewPlug != nil {
+		for _, id := range reprovision {
+			go r.onNewPlug(ctx, id)
+		}
+	}
+	// Reprovisioned plugs get their safety limits from onNewPlug
+	for _, id := range diff.Safety {
+		if slices.Contains(reprovision, id) {
+			continue
+		}
+		go func() {
+			if err := r.plugManager.ConfigureSafety(ctx, id); err != nil {
+				slog.Error("Failed to configure safety limits on plug", "plug_id", id, "error", err)
+			}
+		}()
+	}
+
+	// Reprovisioned plugs get their buttons decoupled from onNewPlug, but
+	// only the plugs that still have buttons
+	for _, id := range diff.Buttons {
+		plug, _, ok := r.plugManager.Plug(id)
+		if !ok || (len(plug.Buttons) > 0 && slices.Contains(reprovision, id)) {
+			continue
+		}
+		go func() {
+			configure := r.plugManager.ConfigureButtons
+			if len(plug.Buttons) == 0 {
+				configure = r.plugManager.ReleaseButtons
+			}
+			if err := configure(ctx, id); err != nil {
+				slog.Error("Failed to configure buttons on plug", "plug_id", id, "error", err)
+			}
+		}()
+	}
+
+	r.eventBus.PublishConfigChanged(r.client, events.ConfigChangedEvent{
+		Timestamp: time.Now(),
+		Added:     diff.Added,
+		Removed:   diff.Removed,
+		Updated:   diff.Updated,
+	})
+
+	return nil
+}
+
+func (r *PlugsReloader) publishStatus(status events.ConnectionStatus, errMsg string) {
+	r.eventBus.PublishConnectionStatus(r.client, events.ConnectionStatusEvent{
+		Timestamp: time.Now(),
+		Component: configComponent,
+		Status:    status,
+		Error:     errMsg,
+	})
+}
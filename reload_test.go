@@ -0,0 +1,87 @@
+package tasmotahomekit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/util/eventbus"
+)
+
+const reloadTestConfig = `{
+  "plugs": [
+    {"id": "plug-1", "name": "Desk Lamp", "address": "192.168.1.10"},
+  ],
+}`
+
+func newTestReloader(t *testing.T) (*PlugsReloader, string, *HAPManager, *events.Bus) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "plugs.hujson")
+	require.NoError(t, os.WriteFile(path, []byte(reloadTestConfig), 0o600))
+
+	cfg, err := plugs.LoadConfig(path)
+	require.NoError(t, err)
+
+	eventBus := newTestEventsBus(t)
+	commands := make(chan plugs.CommandEvent, 1)
+	pm, err := plugs.NewManager(cfg.Plugs, commands, eventBus)
+	require.NoError(t, err)
+	hm := NewHAPManager(cfg.Plugs, "Test Bridge", commands, pm, eventBus)
+
+	r, err := NewPlugsReloader(path, "", pm, hm, eventBus, nil)
+	require.NoError(t, err)
+
+	return r, path, hm, eventBus
+}
+
+func TestPlugsReloaderAddsPlug(t *testing.T) {
+	r, path, hm, eventBus := newTestReloader(t)
+
+	client, err := eventBus.Client(events.ClientWeb)
+	require.NoError(t, err)
+	sub := eventbus.Subscribe[events.ConfigChangedEvent](client)
+	defer sub.Close()
+
+	require.NoError(t, os.WriteFile(path, []byte(`{
+  "plugs": [
+    {"id": "plug-1", "name": "Desk Lamp", "address": "192.168.1.10"},
+    {"id": "plug-2", "name": "Heater", "address": "192.168.1.11"},
+  ],
+}`), 0o600))
+	require.True(t, r.changed())
+	require.NoError(t, r.Reload(context.Background()))
+	require.False(t, r.changed())
+
+	select {
+	case event := <-sub.Events():
+		require.Equal(t, []string{"plug-2"}, event.Added)
+		require.Empty(t, event.Removed)
+	case <-time.After(time.Second):
+		t.Fatal("expected config changed event")
+	}
+
+	require.Len(t, hm.GetAccessories(), 3)
+}
+
+func TestPlugsReloaderRejectsInvalidConfig(t *testing.T) {
+	r, path, hm, _ := newTestReloader(t)
+
+	// Duplicate IDs fail validation; the running configuration stays
+	require.NoError(t, os.WriteFile(path, []byte(`{
+  "plugs": [
+    {"id": "plug-1", "name": "Desk Lamp", "address": "192.168.1.10"},
+    {"id": "plug-1", "name": "Heater", "address": "192.168.1.11"},
+  ],
+}`), 0o600))
+	require.Error(t, r.Reload(context.Background()))
+
+	require.Len(t, hm.GetAccessories(), 2)
+	_, ok := r.plugManager.Snapshot()["plug-1"]
+	require.True(t, ok)
+}
@@ -0,0 +1,259 @@
+// Package safety switches plugs off when they break their safety limits:
+// drawing too much power or current, staying on too long or idling.
+package safety
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"tailscale.com/util/eventbus"
+)
+
+// Source is the source of the commands the monitor sends.
+const Source = "safety"
+
+// Rules reported in alerts.
+const (
+	RuleMaxPower   = "max_power"
+	RuleMaxCurrent = "max_current"
+	RuleMaxOnTime  = "max_on_time"
+	RuleIdle       = "idle"
+)
+
+// checkInterval is how often the time limits are checked between state
+// updates.
+const checkInterval = 10 * time.Second
+
+// watch is what the monitor knows about a plug.
+type watch struct {
+	plug  plugs.Plug
+	state plugs.State
+
+	// When each relay was first seen on, and when the plug started idling
+	onSince   map[int]time.Time
+	idleSince time.Time
+
+	// Relays switched off by a rule, 0 for the whole device, until they are
+	// seen off. Keeps a command that did not take from being sent on every
+	// update.
+	tripped map[int]bool
+}
+
+type trip struct {
+	command plugs.CommandEvent
+	alert   events.AlertEvent
+}
+
+// Monitor checks plug states against the plugs' safety limits and switches
+// them off through the commands channel, the same way as HomeKit, when a
+// limit is broken.
+type Monitor struct {
+	commands chan<- plugs.CommandEvent
+	now      func() time.Time
+	wake     chan struct{}
+
+	mu      sync.Mutex
+	watches map[string]*watch
+	pending []trip
+
+	// Alerts for the web UI and notifiers, see SetEventBus
+	eventBus *events.Bus
+	client   *eventbus.Client
+}
+
+var _ plugs.SafetyMonitor = (*Monitor)(nil)
+
+// New returns a monitor sending to commands. Call Run to start it.
+func New(commands chan<- plugs.CommandEvent) *Monitor {
+	return &Monitor{
+		commands: commands,
+		now:      time.Now,
+		wake:     make(chan struct{}, 1),
+		watches:  make(map[string]*watch),
+	}
+}
+
+// SetEventBus publishes an events.AlertEvent for every plug switched off.
+func (m *Monitor) SetEventBus(bus *events.Bus) error {
+	client, err := bus.Client(events.ClientSafety)
+	if err != nil {
+		return fmt.Errorf("failed to get safety eventbus client: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.eventBus = bus
+	m.client = client
+	return nil
+}
+
+// Check records a plug's state and checks it right away. It does not block;
+// plugs breaking a limit are switched off by Run.
+func (m *Monitor) Check(plug plugs.Plug, state plugs.State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.watches[plug.ID]
+	if !ok {
+		w = &watch{onSince: make(map[int]time.Time), tripped: make(map[int]bool)}
+		m.watches[plug.ID] = w
+	}
+	w.plug = plug
+	w.state = state
+	m.evaluate(w, m.now())
+}
+
+// Update forgets plugs that were removed or lost their safety limits.
+func (m *Monitor) Update(cfg *plugs.Config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keep := make(map[string]bool, len(cfg.Plugs))
+	for _, plug := range cfg.Plugs {
+		keep[plug.ID] = plug.Safety != nil
+	}
+	for id := range m.watches {
+		if !keep[id] {
+			delete(m.watches, id)
+		}
+	}
+}
+
+// Run switches off plugs that broke a limit and checks the time limits
+// until ctx is done.
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.mu.Lock()
+			now := m.now()
+			for _, w := range m.watches {
+				m.evaluate(w, now)
+			}
+			m.mu.Unlock()
+		case <-m.wake:
+		case <-ctx.Done():
+			return
+		}
+
+		m.mu.Lock()
+		pending := m.pending
+		m.pending = nil
+		bus, client := m.eventBus, m.client
+		m.mu.Unlock()
+
+		for _, t := range pending {
+			slog.Warn(
+				"Safety limit broken, switching plug off",
+				"plug_id", t.alert.PlugID,
+				"relay", t.alert.Relay,
+				"rule", t.alert.Rule,
+				"reason", t.alert.Reason,
+			)
+			select {
+			case m.commands <- t.command:
+			case <-ctx.Done():
+				return
+			}
+			if bus != nil {
+				bus.PublishAlert(client, t.alert)
+			}
+		}
+	}
+}
+
+// evaluate queues a trip for every limit w breaks at now. Caller holds m.mu.
+func (m *Monitor) evaluate(w *watch, now time.Time) {
+	safety := w.plug.Safety
+	state := w.state
+	// Stale states say nothing about what the device is doing now
+	if safety == nil || state.Offline || state.Restored {
+		return
+	}
+
+	anyOn := false
+	for relay := 1; relay <= w.plug.RelayCount(); relay++ {
+		if !state.RelayOn(relay) {
+			delete(w.onSince, relay)
+			delete(w.tripped, relay)
+			continue
+		}
+		anyOn = true
+		if _, ok := w.onSince[relay]; !ok {
+			w.onSince[relay] = now
+		}
+	}
+	if !anyOn {
+		w.idleSince = time.Time{}
+		clear(w.tripped)
+		return
+	}
+
+	switch {
+	case safety.MaxPower > 0 && state.Power > safety.MaxPower:
+		m.trip(w, 0, RuleMaxPower, fmt.Sprintf("drawing %.0f W, limit %.0f W", state.Power, safety.MaxPower), now)
+	case safety.MaxCurrent > 0 && state.Current > safety.MaxCurrent:
+		m.trip(w, 0, RuleMaxCurrent, fmt.Sprintf("drawing %.2f A, limit %.2f A", state.Current, safety.MaxCurrent), now)
+	}
+
+	if idle := safety.IdleDuration(); idle > 0 {
+		switch {
+		case state.Power >= safety.IdlePower:
+			w.idleSince = time.Time{}
+		case w.idleSince.IsZero():
+			w.idleSince = now
+		case now.Sub(w.idleSince) >= idle:
+			m.trip(w, 0, RuleIdle, fmt.Sprintf("below %.0f W for %s", safety.IdlePower, safety.IdleTime), now)
+		}
+	}
+
+	if maxOn := safety.MaxOnDuration(); maxOn > 0 {
+		for relay, since := range w.onSince {
+			if now.Sub(since) < maxOn {
+				continue
+			}
+			target := relay
+			if w.plug.RelayCount() == 1 {
+				target = 0
+			}
+			m.trip(w, target, RuleMaxOnTime, fmt.Sprintf("on for more than %s", safety.MaxOnTime), now)
+		}
+	}
+}
+
+// trip queues switching off relay of w, 0 for the whole device (every relay
+// of a multi-relay plug). Caller holds m.mu.
+func (m *Monitor) trip(w *watch, relay int, rule, reason string, now time.Time) {
+	if w.tripped[0] || w.tripped[relay] {
+		return
+	}
+	w.tripped[relay] = true
+
+	m.pending = append(m.pending, trip{
+		command: plugs.CommandEvent{
+			PlugID: w.plug.ID,
+			Relay:  relay,
+			On:     false,
+			Source: Source,
+		},
+		alert: events.AlertEvent{
+			Timestamp: now,
+			PlugID:    w.plug.ID,
+			Relay:     relay,
+			Rule:      rule,
+			Reason:    reason,
+		},
+	})
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
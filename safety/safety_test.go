@@ -0,0 +1,176 @@
+package safety
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/util/eventbus"
+)
+
+var kettle = plugs.Plug{
+	ID:       "kettle",
+	Name:     "Kettle",
+	Features: &plugs.PlugFeatures{PowerMonitoring: true},
+	Safety:   &plugs.Safety{MaxPower: 2000, MaxCurrent: 10, IdlePower: 5, IdleTime: "5m"},
+}
+
+func newTestMonitor(t *testing.T) (*Monitor, *time.Time) {
+	t.Helper()
+	now := time.Date(2025, 3, 3, 12, 0, 0, 0, time.UTC)
+	m := New(make(chan plugs.CommandEvent, 10))
+	m.now = func() time.Time { return now }
+	return m, &now
+}
+
+func onState(power, current float64) plugs.State {
+	return plugs.State{On: true, Relays: []bool{true}, Power: power, Current: current}
+}
+
+func pendingAlerts(m *Monitor) []events.AlertEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var alerts []events.AlertEvent
+	for _, t := range m.pending {
+		alerts = append(alerts, t.alert)
+	}
+	m.pending = nil
+	return alerts
+}
+
+func TestPowerLimits(t *testing.T) {
+	m, now := newTestMonitor(t)
+
+	m.Check(kettle, onState(1800, 7.8))
+	require.Empty(t, pendingAlerts(m))
+
+	m.Check(kettle, onState(2300, 10))
+	alerts := pendingAlerts(m)
+	require.Len(t, alerts, 1)
+	require.Equal(t, events.AlertEvent{
+		Timestamp: *now,
+		PlugID:    "kettle",
+		Rule:      RuleMaxPower,
+		Reason:    "drawing 2300 W, limit 2000 W",
+	}, alerts[0])
+
+	// Not again until the plug has been seen off
+	m.Check(kettle, onState(2300, 10))
+	require.Empty(t, pendingAlerts(m))
+	m.Check(kettle, plugs.State{Relays: []bool{false}})
+	m.Check(kettle, onState(100, 10.5))
+	alerts = pendingAlerts(m)
+	require.Len(t, alerts, 1)
+	require.Equal(t, RuleMaxCurrent, alerts[0].Rule)
+}
+
+func TestPowerLimitSwitchesWholeDevice(t *testing.T) {
+	m, _ := newTestMonitor(t)
+	strip := plugs.Plug{
+		ID:       "strip",
+		Relays:   []plugs.Relay{{}, {}},
+		Features: &plugs.PlugFeatures{PowerMonitoring: true},
+		Safety:   &plugs.Safety{MaxPower: 2000},
+	}
+
+	// The overload is on relay 2; relay 0 switches every relay off
+	m.Check(strip, plugs.State{Relays: []bool{false, true}, Power: 2400})
+	m.mu.Lock()
+	require.Len(t, m.pending, 1)
+	require.Equal(t, plugs.CommandEvent{PlugID: "strip", Relay: 0, Source: Source}, m.pending[0].command)
+	m.pending = nil
+	m.mu.Unlock()
+
+	// Once every relay is off, the strip trips again
+	m.Check(strip, plugs.State{Relays: []bool{false, false}})
+	m.Check(strip, plugs.State{Relays: []bool{true, true}, Power: 2400})
+	require.Len(t, pendingAlerts(m), 1)
+}
+
+func TestIdle(t *testing.T) {
+	m, now := newTestMonitor(t)
+
+	m.Check(kettle, onState(1, 0))
+	*now = now.Add(4 * time.Minute)
+	m.Check(kettle, onState(1500, 6.5))
+	*now = now.Add(2 * time.Minute)
+	m.Check(kettle, onState(2, 0))
+	*now = now.Add(4 * time.Minute)
+	m.Check(kettle, onState(2, 0))
+	require.Empty(t, pendingAlerts(m))
+
+	*now = now.Add(time.Minute)
+	m.Check(kettle, onState(2, 0))
+	alerts := pendingAlerts(m)
+	require.Len(t, alerts, 1)
+	require.Equal(t, RuleIdle, alerts[0].Rule)
+	require.Equal(t, "below 5 W for 5m", alerts[0].Reason)
+}
+
+func TestMaxOnTimePerRelay(t *testing.T) {
+	m, now := newTestMonitor(t)
+	heater := plugs.Plug{ID: "strip", Relays: []plugs.Relay{{}, {}}, Safety: &plugs.Safety{MaxOnTime: "1h"}}
+
+	m.Check(heater, plugs.State{On: true, Relays: []bool{true, false}})
+	*now = now.Add(30 * time.Minute)
+	m.Check(heater, plugs.State{On: true, Relays: []bool{true, true}})
+	*now = now.Add(30 * time.Minute)
+	m.Check(heater, plugs.State{On: true, Relays: []bool{true, true}})
+
+	m.mu.Lock()
+	require.Len(t, m.pending, 1)
+	require.Equal(t, plugs.CommandEvent{PlugID: "strip", Relay: 1, Source: Source}, m.pending[0].command)
+	m.pending = nil
+	m.mu.Unlock()
+
+	// Offline and restored states are stale
+	*now = now.Add(time.Hour)
+	m.Check(heater, plugs.State{On: true, Relays: []bool{true, true}, Offline: true})
+	require.Empty(t, pendingAlerts(m))
+}
+
+func TestUpdateForgetsPlugs(t *testing.T) {
+	m, _ := newTestMonitor(t)
+	m.Check(kettle, onState(100, 1))
+
+	m.Update(&plugs.Config{Plugs: []plugs.Plug{{ID: "kettle"}}})
+	require.Empty(t, m.watches)
+}
+
+func TestRunSwitchesOffAndAlerts(t *testing.T) {
+	bus, err := events.New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = bus.Close() })
+	webClient, err := bus.Client(events.ClientWeb)
+	require.NoError(t, err)
+	alerts := eventbus.Subscribe[events.AlertEvent](webClient)
+	defer alerts.Close()
+
+	commands := make(chan plugs.CommandEvent, 1)
+	m := New(commands)
+	require.NoError(t, m.SetEventBus(bus))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	m.Check(kettle, onState(2500, 11))
+
+	select {
+	case cmd := <-commands:
+		require.Equal(t, plugs.CommandEvent{PlugID: "kettle", Source: Source}, cmd)
+	case <-time.After(5 * time.Second):
+		t.Fatal("plug was not switched off")
+	}
+	select {
+	case alert := <-alerts.Events():
+		require.Equal(t, RuleMaxPower, alert.Rule)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no alert published")
+	}
+}
@@ -0,0 +1,470 @@
+// Package schedule switches plugs at times given by cron expressions,
+// sunrise and sunset, and one-shot timers.
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/cron"
+	"github.com/kradalby/tasmota-homekit/plugs"
+)
+
+// Source is the source of the commands the scheduler sends.
+const Source = "schedule"
+
+// ErrUnknownSchedule is returned for a schedule ID that is not configured.
+var ErrUnknownSchedule = errors.New("unknown schedule")
+
+// Run is an upcoming switch of a plug or group by a schedule or timer.
+type Run struct {
+	At         time.Time `json:"at"`
+	ScheduleID string    `json:"schedule_id,omitempty"`
+	TimerID    string    `json:"timer_id,omitempty"`
+	PlugID     string    `json:"plug_id"`
+	GroupID    string    `json:"group_id,omitempty"`
+	Relay      int       `json:"relay,omitempty"`
+	On         bool      `json:"on"`
+	// Away runs only happen while away mode is on
+	Away bool `json:"away,omitempty"`
+	// Skipped runs were skipped from the web UI
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// Status is a configured schedule with its runtime state.
+type Status struct {
+	plugs.Schedule
+	Enabled bool      `json:"enabled"`
+	Next    time.Time `json:"next"`
+	Skip    bool      `json:"skip"`
+	LastRun time.Time `json:"last_run"`
+}
+
+// Timer is a one-shot switch of a plug.
+type Timer struct {
+	ID     string    `json:"id"`
+	PlugID string    `json:"plug_id"`
+	Relay  int       `json:"relay,omitempty"`
+	On     bool      `json:"on"`
+	At     time.Time `json:"at"`
+}
+
+type entry struct {
+	schedule plugs.Schedule
+	cron     cron.Expression
+
+	// base is the next occurrence, at the time it runs with jitter
+	base time.Time
+	at   time.Time
+
+	enabled bool
+	skip    bool
+	lastRun time.Time
+}
+
+// Scheduler sends plug commands on the commands channel, the same way as
+// HomeKit, when schedules and timers are due.
+type Scheduler struct {
+	commands chan<- plugs.CommandEvent
+	now      func() time.Time
+	wake     chan struct{}
+
+	mu       sync.Mutex
+	plugs    map[string]plugs.Plug
+	location *plugs.Location
+	entries  []*entry
+	timers   map[string]Timer
+	timerSeq int
+	away     bool
+}
+
+// New returns a scheduler sending to commands. Call Update with the plugs
+// configuration and Run to start it.
+func New(commands chan<- plugs.CommandEvent) *Scheduler {
+	return &Scheduler{
+		commands: commands,
+		now:      time.Now,
+		wake:     make(chan struct{}, 1),
+		plugs:    make(map[string]plugs.Plug),
+		timers:   make(map[string]Timer),
+	}
+}
+
+// Update replaces the schedules with the ones in cfg. Schedules that keep
+// their ID keep being enabled, disabled or skipped from the web UI.
+func (s *Scheduler) Update(cfg *plugs.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := make(map[string]*entry, len(s.entries))
+	for _, e := range s.entries {
+		previous[e.schedule.ID] = e
+	}
+
+	s.plugs = make(map[string]plugs.Plug, len(cfg.Plugs))
+	for _, plug := range cfg.Plugs {
+		s.plugs[plug.ID] = plug
+	}
+	s.location = cfg.Location
+
+	now := s.now()
+	s.entries = make([]*entry, 0, len(cfg.Schedules))
+	for _, schedule := range cfg.Schedules {
+		e := &entry{schedule: schedule, enabled: !schedule.Disabled}
+		if schedule.Cron != "" {
+			// Validated when the config was parsed
+			e.cron, _ = schedule.CronExpression()
+		}
+		if old, ok := previous[schedule.ID]; ok {
+			e.enabled = old.enabled
+			e.skip = old.skip
+			e.lastRun = old.lastRun
+		}
+		s.plan(e, now)
+		s.entries = append(s.entries, e)
+	}
+
+	for id, timer := range s.timers {
+		if _, ok := s.plugs[timer.PlugID]; !ok {
+			delete(s.timers, id)
+		}
+	}
+
+	s.notify()
+}
+
+// Run fires schedules and timers until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		due, next := s.due(s.now())
+		for _, cmd := range due {
+			select {
+			case s.commands <- cmd:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		var (
+			timer *time.Timer
+			wait  <-chan time.Time
+		)
+		if !next.IsZero() {
+			timer = time.NewTimer(next.Sub(s.now()))
+			wait = timer.C
+		}
+
+		select {
+		case <-wait:
+		case <-s.wake:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// due returns the commands of the runs due at now and advances them, and
+// the time of the next run.
+func (s *Scheduler) due(now time.Time) ([]plugs.CommandEvent, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []plugs.CommandEvent
+	for _, e := range s.entries {
+		if e.at.IsZero() || e.at.After(now) {
+			continue
+		}
+
+		switch {
+		case !e.enabled:
+		case e.skip:
+			slog.Info("Skipped scheduled run", "schedule", e.schedule.ID, "plug_id", e.schedule.Plug, "group", e.schedule.Group)
+			e.skip = false
+		case e.schedule.Away && !s.away:
+			slog.Debug("Away schedule not run, away mode is off", "schedule", e.schedule.ID)
+		default:
+			slog.Info("Schedule fired", "schedule", e.schedule.ID, "plug_id", e.schedule.Plug, "group", e.schedule.Group, "on", e.schedule.On())
+			e.lastRun = now
+			// The plug manager fans group commands out to every member
+			due = append(due, plugs.CommandEvent{
+				PlugID: e.schedule.Plug,
+				Group:  e.schedule.Group,
+				Relay:  e.schedule.Relay,
+				On:     e.schedule.On(),
+				Source: Source,
+			})
+		}
+
+		// A run moved early by jitter must not find its own occurrence again
+		s.plan(e, latest(e.base, now))
+	}
+
+	for _, id := range slices.Sorted(maps.Keys(s.timers)) {
+		timer := s.timers[id]
+		if timer.At.After(now) {
+			continue
+		}
+		slog.Info("Timer fired", "timer", id, "plug_id", timer.PlugID, "on", timer.On)
+		due = append(due, plugs.CommandEvent{
+			PlugID: timer.PlugID,
+			Relay:  timer.Relay,
+			On:     timer.On,
+			Source: Source,
+		})
+		delete(s.timers, id)
+	}
+
+	return due, s.nextRun()
+}
+
+// nextRun returns the time of the earliest run. Caller holds s.mu.
+func (s *Scheduler) nextRun() time.Time {
+	var next time.Time
+	consider := func(t time.Time) {
+		if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	for _, e := range s.entries {
+		consider(e.at)
+	}
+	for _, timer := range s.timers {
+		consider(timer.At)
+	}
+	return next
+}
+
+// plan sets the next occurrence of e after after. Caller holds s.mu.
+func (s *Scheduler) plan(e *entry, after time.Time) {
+	e.base = s.occurrence(e, after)
+	e.at = e.base
+	if e.base.IsZero() {
+		return
+	}
+
+	if jitter := e.schedule.JitterDuration(); jitter > 0 {
+		at := e.base.Add(time.Duration(rand.Int64N(int64(2*jitter+1))) - jitter)
+		// Never move a run before the time it was planned at
+		if at.After(after) {
+			e.at = at
+		}
+	}
+}
+
+// occurrence returns the first occurrence of e after after, without jitter,
+// or the zero time if there is none.
+func (s *Scheduler) occurrence(e *entry, after time.Time) time.Time {
+	if e.schedule.Cron != "" {
+		return e.cron.Next(after)
+	}
+	if s.location == nil {
+		return time.Time{}
+	}
+
+	// Start a day early, a negative offset can pull tomorrow's event into
+	// today
+	day := time.Date(after.Year(), after.Month(), after.Day()-1, 0, 0, 0, 0, after.Location())
+	for range 370 {
+		if e.schedule.OnDay(day) {
+			sunrise, sunset, ok := sunTimes(day, *s.location)
+			event := sunrise
+			if e.schedule.Sun == plugs.SunEventSunset {
+				event = sunset
+			}
+			if at := event.Add(e.schedule.OffsetDuration()); ok && at.After(after) {
+				return at
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Time{}
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Schedules returns the configured schedules in config order.
+func (s *Scheduler) Schedules() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]Status, 0, len(s.entries))
+	for _, e := range s.entries {
+		statuses = append(statuses, Status{
+			Schedule: e.schedule,
+			Enabled:  e.enabled,
+			Next:     e.at,
+			Skip:     e.skip,
+			LastRun:  e.lastRun,
+		})
+	}
+	return statuses
+}
+
+// Upcoming returns the next n runs of enabled schedules and timers.
+func (s *Scheduler) Upcoming(n int) []Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var runs []Run
+	for _, e := range s.entries {
+		if !e.enabled {
+			continue
+		}
+		// Later occurrences get their own jitter when they come up
+		at, skipped := e.at, e.skip
+		for i := 0; i < n && !at.IsZero(); i++ {
+			runs = append(runs, Run{
+				At:         at,
+				ScheduleID: e.schedule.ID,
+				PlugID:     e.schedule.Plug,
+				GroupID:    e.schedule.Group,
+				Relay:      e.schedule.Relay,
+				On:         e.schedule.On(),
+				Away:       e.schedule.Away,
+				Skipped:    skipped,
+			})
+			at, skipped = s.occurrence(e, latest(at, e.base)), false
+		}
+	}
+	for _, timer := range s.timers {
+		runs = append(runs, Run{
+			At:      timer.At,
+			TimerID: timer.ID,
+			PlugID:  timer.PlugID,
+			Relay:   timer.Relay,
+			On:      timer.On,
+		})
+	}
+
+	slices.SortStableFunc(runs, func(a, b Run) int { return a.At.Compare(b.At) })
+	if len(runs) > n {
+		runs = runs[:n]
+	}
+	return runs
+}
+
+// SetEnabled enables or disables a schedule until the next restart.
+func (s *Scheduler) SetEnabled(id string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.entry(id)
+	if e == nil {
+		return fmt.Errorf("%w %q", ErrUnknownSchedule, id)
+	}
+	e.enabled = enabled
+	return nil
+}
+
+// Skip skips or unskips the next run of a schedule.
+func (s *Scheduler) Skip(id string, skip bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.entry(id)
+	if e == nil {
+		return fmt.Errorf("%w %q", ErrUnknownSchedule, id)
+	}
+	e.skip = skip
+	return nil
+}
+
+func (s *Scheduler) entry(id string) *entry {
+	for _, e := range s.entries {
+		if e.schedule.ID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// Away reports whether away mode is on.
+func (s *Scheduler) Away() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.away
+}
+
+// SetAway turns away mode on or off. Schedules marked away only run while
+// it is on.
+func (s *Scheduler) SetAway(away bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.away = away
+}
+
+// AddTimer switches a plug once, after the given duration.
+func (s *Scheduler) AddTimer(plugID string, relay int, on bool, after time.Duration) (Timer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	plug, ok := s.plugs[plugID]
+	if !ok {
+		return Timer{}, fmt.Errorf("plug %s not found", plugID)
+	}
+	if relay < 0 || relay > plug.RelayCount() {
+		return Timer{}, fmt.Errorf("plug %s has no relay %d", plugID, relay)
+	}
+	if after <= 0 {
+		return Timer{}, fmt.Errorf("timer must be in the future")
+	}
+
+	s.timerSeq++
+	timer := Timer{
+		ID:     fmt.Sprintf("timer-%d", s.timerSeq),
+		PlugID: plugID,
+		Relay:  relay,
+		On:     on,
+		At:     s.now().Add(after),
+	}
+	s.timers[timer.ID] = timer
+	s.notify()
+	return timer, nil
+}
+
+// CancelTimer removes a pending timer. It reports whether there was one.
+func (s *Scheduler) CancelTimer(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.timers[id]
+	delete(s.timers, id)
+	return ok
+}
+
+// Timers returns the pending timers, soonest first.
+func (s *Scheduler) Timers() []Timer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	timers := make([]Timer, 0, len(s.timers))
+	for _, timer := range s.timers {
+		timers = append(timers, timer)
+	}
+	slices.SortFunc(timers, func(a, b Timer) int { return a.At.Compare(b.At) })
+	return timers
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,193 @@
+package schedule
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/stretchr/testify/require"
+)
+
+// 2025-03-03 is a Monday
+var monday = time.Date(2025, 3, 3, 6, 0, 0, 0, time.UTC)
+
+func newTestScheduler(t *testing.T, now time.Time, schedules ...plugs.Schedule) *Scheduler {
+	t.Helper()
+	s := New(make(chan plugs.CommandEvent, 10))
+	s.now = func() time.Time { return now }
+	s.Update(&plugs.Config{
+		Plugs: []plugs.Plug{
+			{ID: "lamp", Name: "Lamp", Address: "1"},
+			{ID: "strip", Name: "Strip", Address: "2", Relays: []plugs.Relay{{}, {}}},
+		},
+		Schedules: schedules,
+		Location:  &plugs.Location{Latitude: 51.5074, Longitude: -0.1278},
+	})
+	return s
+}
+
+func TestCronScheduleFires(t *testing.T) {
+	s := newTestScheduler(t, monday, plugs.Schedule{ID: "morning", Plug: "lamp", Action: "on", Cron: "0 7 * * *"})
+	require.Equal(t, monday.Add(time.Hour), s.Schedules()[0].Next)
+
+	due, next := s.due(monday.Add(59 * time.Minute))
+	require.Empty(t, due)
+	require.Equal(t, monday.Add(time.Hour), next)
+
+	due, next = s.due(monday.Add(time.Hour))
+	require.Equal(t, []plugs.CommandEvent{{PlugID: "lamp", On: true, Source: Source}}, due)
+	require.Equal(t, monday.Add(25*time.Hour), next)
+	require.Equal(t, monday.Add(time.Hour), s.Schedules()[0].LastRun)
+}
+
+func TestGroupScheduleFires(t *testing.T) {
+	s := New(make(chan plugs.CommandEvent, 10))
+	s.now = func() time.Time { return monday }
+	s.Update(&plugs.Config{
+		Plugs:     []plugs.Plug{{ID: "lamp", Name: "Lamp", Address: "1"}},
+		Groups:    []plugs.Group{{ID: "downstairs", Name: "Downstairs", Plugs: []string{"lamp"}}},
+		Schedules: []plugs.Schedule{{ID: "night", Group: "downstairs", Action: "off", Cron: "0 23 * * *"}},
+	})
+
+	runs := s.Upcoming(1)
+	require.Len(t, runs, 1)
+	require.Equal(t, "downstairs", runs[0].GroupID)
+	require.Empty(t, runs[0].PlugID)
+
+	due, _ := s.due(monday.Add(17 * time.Hour))
+	require.Equal(t, []plugs.CommandEvent{{Group: "downstairs", On: false, Source: Source}}, due)
+}
+
+func TestSunScheduleWithOffset(t *testing.T) {
+	midsummer := time.Date(2025, 6, 21, 0, 0, 0, 0, time.UTC)
+	s := newTestScheduler(t, midsummer, plugs.Schedule{ID: "dusk", Plug: "lamp", Action: "on", Sun: plugs.SunEventSunset, Offset: "-30m"})
+	require.WithinDuration(t, time.Date(2025, 6, 21, 19, 51, 0, 0, time.UTC), s.Schedules()[0].Next, 3*time.Minute)
+
+	// Sunday 2025-06-22 is skipped
+	s = newTestScheduler(t, midsummer, plugs.Schedule{ID: "dawn", Plug: "lamp", Action: "off", Sun: plugs.SunEventSunrise, Days: []string{"weekday"}})
+	require.WithinDuration(t, time.Date(2025, 6, 23, 3, 43, 0, 0, time.UTC), s.Schedules()[0].Next, 3*time.Minute)
+}
+
+func TestJitterStaysInRange(t *testing.T) {
+	for range 50 {
+		s := newTestScheduler(t, monday, plugs.Schedule{ID: "j", Plug: "lamp", Action: "on", Cron: "0 7 * * *", Jitter: "15m"})
+		next := s.Schedules()[0].Next
+		require.WithinDuration(t, monday.Add(time.Hour), next, 15*time.Minute)
+
+		// Firing early must not find the same occurrence again
+		_, following := s.due(next)
+		require.WithinDuration(t, monday.Add(25*time.Hour), following, 15*time.Minute)
+	}
+}
+
+func TestSkippedRuns(t *testing.T) {
+	s := newTestScheduler(
+		t,
+		monday,
+		plugs.Schedule{ID: "skip", Plug: "lamp", Action: "on", Cron: "0 7 * * *"},
+		plugs.Schedule{ID: "disabled", Plug: "lamp", Action: "on", Cron: "0 7 * * *"},
+		plugs.Schedule{ID: "away", Plug: "strip", Relay: 2, Action: "on", Cron: "0 7 * * *", Away: true},
+	)
+	require.NoError(t, s.Skip("skip", true))
+	require.NoError(t, s.SetEnabled("disabled", false))
+	require.ErrorIs(t, s.Skip("missing", true), ErrUnknownSchedule)
+
+	due, _ := s.due(monday.Add(time.Hour))
+	require.Empty(t, due)
+	require.False(t, s.Schedules()[0].Skip)
+
+	s.SetAway(true)
+	due, _ = s.due(monday.Add(25 * time.Hour))
+	require.Equal(t, []plugs.CommandEvent{
+		{PlugID: "lamp", On: true, Source: Source},
+		{PlugID: "strip", Relay: 2, On: true, Source: Source},
+	}, due)
+}
+
+func TestUpdateKeepsRuntimeState(t *testing.T) {
+	s := newTestScheduler(t, monday, plugs.Schedule{ID: "a", Plug: "lamp", Action: "on", Cron: "0 7 * * *"})
+	require.NoError(t, s.SetEnabled("a", false))
+
+	s.Update(&plugs.Config{
+		Plugs: []plugs.Plug{{ID: "lamp", Name: "Lamp", Address: "1"}},
+		Schedules: []plugs.Schedule{
+			{ID: "a", Plug: "lamp", Action: "off", Cron: "0 8 * * *"},
+			{ID: "b", Plug: "lamp", Action: "on", Cron: "0 9 * * *", Disabled: true},
+		},
+	})
+
+	statuses := s.Schedules()
+	require.Len(t, statuses, 2)
+	require.False(t, statuses[0].Enabled)
+	require.Equal(t, monday.Add(2*time.Hour), statuses[0].Next)
+	require.False(t, statuses[1].Enabled)
+}
+
+func TestTimers(t *testing.T) {
+	s := newTestScheduler(t, monday)
+
+	_, err := s.AddTimer("missing", 0, false, time.Minute)
+	require.ErrorContains(t, err, "not found")
+	_, err = s.AddTimer("lamp", 2, false, time.Minute)
+	require.ErrorContains(t, err, "no relay 2")
+
+	off, err := s.AddTimer("lamp", 0, false, 30*time.Minute)
+	require.NoError(t, err)
+	cancelled, err := s.AddTimer("strip", 1, true, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, s.Timers(), 2)
+	require.True(t, s.CancelTimer(cancelled.ID))
+	require.False(t, s.CancelTimer(cancelled.ID))
+
+	due, next := s.due(monday)
+	require.Empty(t, due)
+	require.Equal(t, off.At, next)
+
+	due, next = s.due(monday.Add(30 * time.Minute))
+	require.Equal(t, []plugs.CommandEvent{{PlugID: "lamp", Source: Source}}, due)
+	require.True(t, next.IsZero())
+	require.Empty(t, s.Timers())
+}
+
+func TestUpcoming(t *testing.T) {
+	s := newTestScheduler(
+		t,
+		monday,
+		plugs.Schedule{ID: "on", Plug: "lamp", Action: "on", Cron: "0 7 * * *"},
+		plugs.Schedule{ID: "off", Plug: "lamp", Action: "off", Cron: "0 23 * * *"},
+	)
+	require.NoError(t, s.Skip("on", true))
+	_, err := s.AddTimer("lamp", 0, false, 90*time.Minute)
+	require.NoError(t, err)
+
+	runs := s.Upcoming(4)
+	require.Len(t, runs, 4)
+	require.Equal(t, "on", runs[0].ScheduleID)
+	require.True(t, runs[0].Skipped)
+	require.NotEmpty(t, runs[1].TimerID)
+	require.Equal(t, monday.Add(90*time.Minute), runs[1].At)
+	require.Equal(t, "off", runs[2].ScheduleID)
+	require.Equal(t, monday.Add(25*time.Hour), runs[3].At)
+	require.False(t, runs[3].Skipped)
+}
+
+func TestRunSendsCommands(t *testing.T) {
+	commands := make(chan plugs.CommandEvent, 1)
+	s := New(commands)
+	s.Update(&plugs.Config{Plugs: []plugs.Plug{{ID: "lamp", Name: "Lamp", Address: "1"}}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	_, err := s.AddTimer("lamp", 0, true, 10*time.Millisecond)
+	require.NoError(t, err)
+
+	select {
+	case cmd := <-commands:
+		require.Equal(t, plugs.CommandEvent{PlugID: "lamp", On: true, Source: Source}, cmd)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timer did not fire")
+	}
+}
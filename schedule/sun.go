@@ -0,0 +1,45 @@
+package schedule
+
+import (
+	"math"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+)
+
+// sunTimes returns sunrise and sunset of the calendar day of date (in its
+// location) at loc, using the sunrise equation with the usual correction
+// for refraction and the sun's radius. ok is false on days without a
+// sunrise or sunset, near the poles. Accurate to a minute or two.
+func sunTimes(date time.Time, loc plugs.Location) (sunrise, sunset time.Time, ok bool) {
+	const (
+		j2000        = 2451545.0
+		unixEpochJD  = 2440587.5
+		secondsInDay = 86400.0
+	)
+	rad := math.Pi / 180
+
+	noon := time.Date(date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, time.UTC)
+	n := math.Round(float64(noon.Unix())/secondsInDay + unixEpochJD - j2000)
+
+	// Mean solar noon, solar anomaly and the equation of the center
+	jStar := n - loc.Longitude/360
+	m := math.Mod(357.5291+0.98560028*jStar, 360)
+	c := 1.9148*math.Sin(m*rad) + 0.0200*math.Sin(2*m*rad) + 0.0003*math.Sin(3*m*rad)
+	lambda := math.Mod(m+c+180+102.9372, 360)
+	transit := j2000 + jStar + 0.0053*math.Sin(m*rad) - 0.0069*math.Sin(2*lambda*rad)
+
+	sinDecl := math.Sin(lambda*rad) * math.Sin(23.4397*rad)
+	cosDecl := math.Cos(math.Asin(sinDecl))
+	cosHourAngle := (math.Sin(-0.833*rad) - math.Sin(loc.Latitude*rad)*sinDecl) / (math.Cos(loc.Latitude*rad) * cosDecl)
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+	hourAngle := math.Acos(cosHourAngle) / rad
+
+	toTime := func(jd float64) time.Time {
+		seconds := (jd - unixEpochJD) * secondsInDay
+		return time.Unix(int64(math.Round(seconds)), 0).In(date.Location())
+	}
+	return toTime(transit - hourAngle/360), toTime(transit + hourAngle/360), true
+}
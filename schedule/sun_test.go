@@ -0,0 +1,28 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSunTimes(t *testing.T) {
+	london := plugs.Location{Latitude: 51.5074, Longitude: -0.1278}
+	sunrise, sunset, ok := sunTimes(time.Date(2025, 6, 21, 0, 0, 0, 0, time.UTC), london)
+	require.True(t, ok)
+	require.WithinDuration(t, time.Date(2025, 6, 21, 3, 43, 0, 0, time.UTC), sunrise, 3*time.Minute)
+	require.WithinDuration(t, time.Date(2025, 6, 21, 20, 21, 0, 0, time.UTC), sunset, 3*time.Minute)
+
+	sunrise, sunset, ok = sunTimes(time.Date(2025, 12, 21, 0, 0, 0, 0, time.UTC), london)
+	require.True(t, ok)
+	require.WithinDuration(t, time.Date(2025, 12, 21, 8, 4, 0, 0, time.UTC), sunrise, 3*time.Minute)
+	require.WithinDuration(t, time.Date(2025, 12, 21, 15, 54, 0, 0, time.UTC), sunset, 3*time.Minute)
+}
+
+func TestSunTimesPolarDay(t *testing.T) {
+	tromso := plugs.Location{Latitude: 69.65, Longitude: 18.96}
+	_, _, ok := sunTimes(time.Date(2025, 6, 21, 0, 0, 0, 0, time.UTC), tromso)
+	require.False(t, ok)
+}
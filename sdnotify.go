@@ -0,0 +1,74 @@
+package tasmotahomekit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends a state such as READY=1 to systemd when it started the
+// service with Type=notify, and does nothing otherwise.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// Abstract sockets are announced with a leading @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to systemd notify socket: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return nil
+}
+
+// sdWatchdogInterval returns how often systemd expects a keep-alive, half
+// its WatchdogSec, or zero when the watchdog is off.
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	// WATCHDOG_PID is set when the watchdog is meant for another process
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// runWatchdog keeps the systemd watchdog fed while live reports true, so
+// systemd restarts the service once a required component has failed.
+func runWatchdog(ctx context.Context, live func() bool) {
+	interval := sdWatchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !live() {
+				slog.Warn("Not live, withholding the systemd watchdog keep-alive")
+				continue
+			}
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				slog.Warn("Failed to feed the systemd watchdog", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
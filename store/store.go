@@ -0,0 +1,120 @@
+// Package store persists runtime state of the bridge across restarts.
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+)
+
+// version is the current snapshot format. Snapshots written by a newer
+// version are rejected rather than misread.
+const version = 1
+
+// Snapshot is the state saved to disk.
+type Snapshot struct {
+	Version     int                                 `json:"version"`
+	SavedAt     time.Time                           `json:"saved_at"`
+	States      map[string]plugs.State              `json:"states"`
+	Connections map[string][]plugs.ConnectionChange `json:"connections,omitempty"`
+	EventLog    []string                            `json:"event_log,omitempty"`
+}
+
+// Store reads and writes snapshots to a single JSON file.
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// New returns a store backed by the file at path. The file and its directory
+// are created on the first Save.
+func New(path string) *Store {
+	return &Store{path: path}
+}
+
+// Path returns the file the store writes to.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Load reads the last saved snapshot. A missing file yields an empty
+// snapshot, as on first start.
+func (s *Store) Load() (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Snapshot{Version: version}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state store: %w", err)
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse state store %s: %w", s.path, err)
+	}
+	if snapshot.Version > version {
+		return nil, fmt.Errorf("state store %s has version %d, newer than supported %d", s.path, snapshot.Version, version)
+	}
+
+	return &snapshot, nil
+}
+
+// Save writes snapshot to the store file, see WriteFile.
+func (s *Store) Save(snapshot *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot.Version = version
+	if snapshot.SavedAt.IsZero() {
+		snapshot.SavedAt = time.Now()
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	return WriteFile(s.path, data)
+}
+
+// WriteFile replaces path with data via a temporary file in the same
+// directory, creating the directory if needed. Readers and a crash
+// mid-write leave either the old or the new content, never a mix.
+func WriteFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMissingFile(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "state.json"))
+
+	snapshot, err := s.Load()
+	require.NoError(t, err)
+	require.Empty(t, snapshot.States)
+	require.Empty(t, snapshot.EventLog)
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "state.json")
+	s := New(path)
+
+	lastSeen := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, s.Save(&Snapshot{
+		States: map[string]plugs.State{
+			"desk": {ID: "desk", On: true, Relays: []bool{true}, Energy: 1.25, LastSeen: lastSeen},
+		},
+		Connections: map[string][]plugs.ConnectionChange{
+			"desk": {{Time: lastSeen, Online: true}},
+		},
+		EventLog: []string{"12:00:00: Server starting..."},
+	}))
+
+	snapshot, err := New(path).Load()
+	require.NoError(t, err)
+	require.Equal(t, version, snapshot.Version)
+	require.False(t, snapshot.SavedAt.IsZero())
+
+	desk := snapshot.States["desk"]
+	require.True(t, desk.On)
+	require.Equal(t, 1.25, desk.Energy)
+	require.True(t, desk.LastSeen.Equal(lastSeen))
+	require.Len(t, snapshot.Connections["desk"], 1)
+	require.Equal(t, []string{"12:00:00: Server starting..."}, snapshot.EventLog)
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestLoadRejectsInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	corrupt := filepath.Join(dir, "corrupt.json")
+	require.NoError(t, os.WriteFile(corrupt, []byte("{"), 0o600))
+	_, err := New(corrupt).Load()
+	require.Error(t, err)
+
+	newer := filepath.Join(dir, "newer.json")
+	require.NoError(t, os.WriteFile(newer, []byte(`{"version": 99}`), 0o600))
+	_, err = New(newer).Load()
+	require.ErrorContains(t, err, "newer than supported")
+}
@@ -7,8 +7,10 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -16,6 +18,8 @@ import (
 	"github.com/chasefleming/elem-go"
 	"github.com/chasefleming/elem-go/attrs"
 	"github.com/kradalby/kra/web"
+	"github.com/kradalby/tasmota-homekit/auth"
+	"github.com/kradalby/tasmota-homekit/discovery"
 	"github.com/kradalby/tasmota-homekit/events"
 	"github.com/kradalby/tasmota-homekit/plugs"
 	"tailscale.com/util/eventbus"
@@ -37,9 +41,21 @@ type plugStateProvider interface {
 
 type PlugController interface {
 	SetPower(ctx context.Context, plugID string, on bool) error
+	SetRelayPower(ctx context.Context, plugID string, relay int, on bool) error
+	SetLight(ctx context.Context, plugID string, settings plugs.LightSettings) error
+	GetStatus(ctx context.Context, plugID string) (*plugs.State, error)
 	RefreshAll(ctx context.Context)
 }
 
+// discoveryService lists and adopts Tasmota devices that are not configured.
+type discoveryService interface {
+	Unconfigured() []discovery.Device
+	Adopt(mac string) (plugs.Plug, error)
+	ScanEnabled() bool
+	Scanning() bool
+	StartScan() error
+}
+
 // WebServer manages the web UI
 type WebServer struct {
 	logger           *slog.Logger
@@ -47,19 +63,34 @@ type WebServer struct {
 	plugProvider     plugStateProvider
 	controller       PlugController
 	eventLog         []string
+	eventLogMu       sync.RWMutex
 	eventBus         *events.Bus
 	client           *eventbus.Client
 	stateSubscriber  *eventbus.Subscriber[events.StateUpdateEvent]
 	statusSubscriber *eventbus.Subscriber[events.ConnectionStatusEvent]
+	configSubscriber *eventbus.Subscriber[events.ConfigChangedEvent]
+	alertSubscriber  *eventbus.Subscriber[events.AlertEvent]
 	currentState     map[string]events.StateUpdateEvent
 	connectionState  map[string]events.ConnectionStatusEvent
 	stateMu          sync.RWMutex
 	statusMu         sync.RWMutex
-	sseClients       map[chan events.StateUpdateEvent]struct{}
+	alerts           map[string]events.AlertEvent
+	alertsMu         sync.RWMutex
+	sseClients       map[chan sseMessage]struct{}
 	sseClientsMu     sync.RWMutex
 	hapPin           string
 	qrCode           string
 	hapManager       *HAPManager
+	discovery        discoveryService
+	energy           energyHistory
+	schedule         scheduleService
+	groups           groupService
+	webhooks         webhookService
+	pairing          hapPairing
+	readiness        ReadinessCriteria
+	mqttActivity     mqttActivity
+	healthMu         sync.RWMutex
+	auth             *auth.Authenticator
 	ctx              context.Context
 }
 
@@ -70,7 +101,7 @@ func NewWebServer(logger *slog.Logger, plugProvider plugStateProvider, controlle
 		panic(fmt.Sprintf("failed to create web client: %v", err))
 	}
 
-	return &WebServer{
+	ws := &WebServer{
 		logger:           logger,
 		kraweb:           kraweb,
 		plugProvider:     plugProvider,
@@ -80,28 +111,68 @@ func NewWebServer(logger *slog.Logger, plugProvider plugStateProvider, controlle
 		client:           client,
 		stateSubscriber:  eventbus.Subscribe[events.StateUpdateEvent](client),
 		statusSubscriber: eventbus.Subscribe[events.ConnectionStatusEvent](client),
+		configSubscriber: eventbus.Subscribe[events.ConfigChangedEvent](client),
+		alertSubscriber:  eventbus.Subscribe[events.AlertEvent](client),
 		currentState:     make(map[string]events.StateUpdateEvent),
 		connectionState:  make(map[string]events.ConnectionStatusEvent),
-		sseClients:       make(map[chan events.StateUpdateEvent]struct{}),
+		alerts:           make(map[string]events.AlertEvent),
+		sseClients:       make(map[chan sseMessage]struct{}),
 		hapPin:           hapPin,
 		qrCode:           qrCode,
 		hapManager:       hapManager,
 		ctx:              context.Background(),
 	}
+	if hapManager != nil {
+		ws.pairing = hapManager
+	}
+	// Components started before the web server reported in already; later
+	// statuses arrive on the subscription, which is open by now
+	maps.Copy(ws.connectionState, bus.ConnectionStatuses())
+	return ws
 }
 
 // LogEvent adds an event to the log
 func (ws *WebServer) LogEvent(event string) {
+	ws.eventLogMu.Lock()
+	defer ws.eventLogMu.Unlock()
+
 	ws.eventLog = append(ws.eventLog, fmt.Sprintf("%s: %s", time.Now().Format("15:04:05"), event))
 	if len(ws.eventLog) > 100 {
 		ws.eventLog = ws.eventLog[1:]
 	}
 }
 
+// EventLog returns a copy of the event log, oldest first.
+func (ws *WebServer) EventLog() []string {
+	ws.eventLogMu.RLock()
+	defer ws.eventLogMu.RUnlock()
+
+	return append([]string(nil), ws.eventLog...)
+}
+
+// RestoreEventLog puts entries saved before a restart ahead of the current
+// log.
+func (ws *WebServer) RestoreEventLog(entries []string) {
+	ws.eventLogMu.Lock()
+	defer ws.eventLogMu.Unlock()
+
+	ws.eventLog = append(append([]string(nil), entries...), ws.eventLog...)
+	if len(ws.eventLog) > 100 {
+		ws.eventLog = ws.eventLog[len(ws.eventLog)-100:]
+	}
+}
+
+// SetDiscovery enables the discovery page.
+func (ws *WebServer) SetDiscovery(d discoveryService) {
+	ws.discovery = d
+}
+
 func (ws *WebServer) Start(ctx context.Context) {
 	ws.ctx = ctx
 	go ws.processStateChanges(ctx)
 	go ws.processConnectionStatuses(ctx)
+	go ws.processConfigChanges(ctx)
+	go ws.processAlerts(ctx)
 	ws.publishConnectionStatus(events.ConnectionStatusConnecting, "")
 
 	go func() {
@@ -126,12 +197,14 @@ func (ws *WebServer) Start(ctx context.Context) {
 func (ws *WebServer) Close() {
 	ws.stateSubscriber.Close()
 	ws.statusSubscriber.Close()
+	ws.configSubscriber.Close()
+	ws.alertSubscriber.Close()
 
 	ws.sseClientsMu.Lock()
 	for client := range ws.sseClients {
 		close(client)
 	}
-	ws.sseClients = make(map[chan events.StateUpdateEvent]struct{})
+	ws.sseClients = make(map[chan sseMessage]struct{})
 	ws.sseClientsMu.Unlock()
 }
 
@@ -157,7 +230,7 @@ func (ws *WebServer) processStateChanges(ctx context.Context) {
 			ws.stateMu.Unlock()
 
 			ws.logger.Debug("Web UI: State change received", "plug_id", event.PlugID, "on", event.On)
-			ws.broadcastSSE(event)
+			ws.broadcastSSE(sseMessage{data: event})
 		case <-ctx.Done():
 			return
 		}
@@ -177,14 +250,44 @@ func (ws *WebServer) processConnectionStatuses(ctx context.Context) {
 	}
 }
 
-// broadcastSSE sends state updates to connected clients.
-func (ws *WebServer) broadcastSSE(event events.StateUpdateEvent) {
+// processConfigChanges drops removed plugs from the UI state and tells
+// browsers to reload, since the set of plug cards has changed.
+func (ws *WebServer) processConfigChanges(ctx context.Context) {
+	for {
+		select {
+		case event := <-ws.configSubscriber.Events():
+			ws.stateMu.Lock()
+			for _, id := range event.Removed {
+				delete(ws.currentState, id)
+			}
+			ws.stateMu.Unlock()
+
+			ws.LogEvent(fmt.Sprintf(
+				"Plugs configuration reloaded (%d added, %d removed, %d updated)",
+				len(event.Added), len(event.Removed), len(event.Updated),
+			))
+			ws.broadcastSSE(sseMessage{event: "config", data: event})
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sseMessage is a single SSE message. Messages without an event name are
+// state updates and reach the browser's default message handler.
+type sseMessage struct {
+	event string
+	data  any
+}
+
+// broadcastSSE sends a message to connected clients.
+func (ws *WebServer) broadcastSSE(msg sseMessage) {
 	ws.sseClientsMu.RLock()
 	defer ws.sseClientsMu.RUnlock()
 
 	for client := range ws.sseClients {
 		select {
-		case client <- event:
+		case client <- msg:
 		default:
 		}
 	}
@@ -258,25 +361,22 @@ func (ws *WebServer) renderPlugCard(plugID string, info plugs.Plug, state plugs.
 		buttonAction = "off"
 	}
 
-	// Determine connection status
-	var connectionIndicator, connectionText string
-	if state.LastSeen.IsZero() {
-		connectionIndicator = "disconnected"
-		connectionText = "Never seen"
-	} else {
-		timeSinceSeen := time.Since(state.LastSeen)
-		if timeSinceSeen < 30*time.Second {
-			connectionIndicator = "connected"
-			connectionText = fmt.Sprintf("Last seen: %s ago", timeSinceSeen.Round(time.Second))
-		} else if timeSinceSeen < 60*time.Second {
-			connectionIndicator = "stale"
-			connectionText = fmt.Sprintf("Last seen: %s ago", timeSinceSeen.Round(time.Second))
-		} else {
-			connectionIndicator = "disconnected"
-			connectionText = fmt.Sprintf("Last seen: %s ago", timeSinceSeen.Round(time.Second))
+	// Multi-relay devices are "on" while any relay is on
+	multiRelay := info.RelayCount() > 1
+	if multiRelay {
+		for relay := 1; relay <= info.RelayCount(); relay++ {
+			if state.RelayOn(relay) {
+				statusClass = "on"
+				statusText = "ON"
+				break
+			}
 		}
 	}
 
+	// Connection status, matching what SSE updates show
+	event := plugs.NewStateUpdateEvent("web", plugID, state)
+	connectionIndicator, connectionText := event.ConnectionState, event.ConnectionNote
+
 	// Icon selection
 	icon := "🔌" // Default plug icon
 	if info.Type == "bulb" {
@@ -341,20 +441,42 @@ func (ws *WebServer) renderPlugCard(plugID string, info plugs.Plug, state plugs.
 				),
 			),
 		))
+
+		if ws.energy != nil {
+			if series, ok := ws.energy.Series(plugID); ok {
+				cardChildren = append(cardChildren, renderEnergyHistory(series, ws.energy.Currency(), time.Now()))
+			}
+		}
 	}
 
-	cardChildren = append(cardChildren, elem.Form(
-		attrs.Props{
-			"hx-post":   "/toggle/" + plugID,
-			"hx-target": "#plug-" + plugID,
-			"hx-swap":   "outerHTML",
-		},
-		elem.Input(attrs.Props{attrs.Type: "hidden", attrs.Name: "action", attrs.Value: buttonAction, "data-role": "action-input"}),
-		elem.Button(
-			attrs.Props{attrs.Type: "submit", attrs.Class: buttonClass, "data-role": "toggle-button"},
-			elem.Text(buttonText),
-		),
-	))
+	if sensors := renderSensors(info, state); sensors != nil {
+		cardChildren = append(cardChildren, sensors)
+	}
+
+	if info.HasBrightness() {
+		cardChildren = append(cardChildren, ws.renderLightControls(plugID, info, state))
+	}
+
+	if multiRelay {
+		relayRows := make([]elem.Node, 0, info.RelayCount())
+		for relay := 1; relay <= info.RelayCount(); relay++ {
+			relayRows = append(relayRows, ws.renderRelayRow(plugID, info.RelayName(relay), relay, state.RelayOn(relay)))
+		}
+		cardChildren = append(cardChildren, elem.Div(attrs.Props{attrs.Class: "relays"}, relayRows...))
+	} else {
+		cardChildren = append(cardChildren, elem.Form(
+			attrs.Props{
+				"hx-post":   "/toggle/" + plugID,
+				"hx-target": "#plug-" + plugID,
+				"hx-swap":   "outerHTML",
+			},
+			elem.Input(attrs.Props{attrs.Type: "hidden", attrs.Name: "action", attrs.Value: buttonAction, "data-role": "action-input"}),
+			elem.Button(
+				attrs.Props{attrs.Type: "submit", attrs.Class: buttonClass, "data-role": "toggle-button"},
+				elem.Text(buttonText),
+			),
+		))
+	}
 
 	return elem.Div(
 		attrs.Props{
@@ -366,6 +488,86 @@ func (ws *WebServer) renderPlugCard(plugID string, info plugs.Plug, state plugs.
 	)
 }
 
+// renderRelayRow renders the status and toggle for one relay of a multi-relay plug
+func (ws *WebServer) renderRelayRow(plugID, name string, relay int, on bool) elem.Node {
+	statusClass := "off"
+	statusText := "OFF"
+	buttonClass := "on"
+	buttonText := "Turn On"
+	buttonAction := "on"
+
+	if on {
+		statusClass = "on"
+		statusText = "ON"
+		buttonClass = "off"
+		buttonText = "Turn Off"
+		buttonAction = "off"
+	}
+
+	return elem.Div(
+		attrs.Props{attrs.Class: "relay-row " + statusClass, "data-relay": strconv.Itoa(relay)},
+		elem.Span(attrs.Props{attrs.Class: "relay-name"}, elem.Text(name)),
+		elem.Span(attrs.Props{attrs.Class: "relay-status", "data-role": "relay-status"}, elem.Text(statusText)),
+		elem.Form(
+			attrs.Props{
+				"hx-post":   "/toggle/" + plugID,
+				"hx-target": "#plug-" + plugID,
+				"hx-swap":   "outerHTML",
+			},
+			elem.Input(attrs.Props{attrs.Type: "hidden", attrs.Name: "relay", attrs.Value: strconv.Itoa(relay)}),
+			elem.Input(attrs.Props{attrs.Type: "hidden", attrs.Name: "action", attrs.Value: buttonAction, "data-role": "relay-action-input"}),
+			elem.Button(
+				attrs.Props{attrs.Type: "submit", attrs.Class: buttonClass, "data-role": "relay-toggle-button"},
+				elem.Text(buttonText),
+			),
+		),
+	)
+}
+
+// renderLightControls renders the brightness, color and white temperature
+// sliders of a bulb. Each slider posts its own value on change.
+func (ws *WebServer) renderLightControls(plugID string, info plugs.Plug, state plugs.State) elem.Node {
+	slider := func(label, name string, minValue, maxValue, value int) elem.Node {
+		return elem.Label(
+			attrs.Props{attrs.Class: "light-control"},
+			elem.Span(attrs.Props{attrs.Class: "stat-label"}, elem.Text(label)),
+			elem.Input(attrs.Props{
+				attrs.Type:   "range",
+				attrs.Name:   name,
+				"min":        strconv.Itoa(minValue),
+				"max":        strconv.Itoa(maxValue),
+				attrs.Value:  strconv.Itoa(value),
+				"data-role":  name + "-input",
+				"hx-post":    "/light/" + plugID,
+				"hx-trigger": "change",
+				"hx-target":  "#plug-" + plugID,
+				"hx-swap":    "outerHTML",
+			}),
+		)
+	}
+
+	controls := []elem.Node{
+		slider("Brightness", "brightness", 0, 100, state.Brightness),
+	}
+	if info.HasColor() {
+		controls = append(controls,
+			slider("Hue", "hue", 0, 360, int(state.Hue)),
+			slider("Saturation", "saturation", 0, 100, int(state.Saturation)),
+		)
+	}
+	if info.HasColorTemperature() {
+		ct := state.ColorTemperature
+		if ct == 0 {
+			ct = plugs.MinColorTemperature
+		}
+		controls = append(controls,
+			slider("White", "color_temperature", plugs.MinColorTemperature, plugs.MaxColorTemperature, ct),
+		)
+	}
+
+	return elem.Div(attrs.Props{attrs.Class: "light-controls"}, controls...)
+}
+
 // HandleIndex renders the main dashboard
 func (ws *WebServer) HandleIndex(w http.ResponseWriter, r *http.Request) {
 	// Trigger a concurrent refresh of all plugs
@@ -391,13 +593,15 @@ func (ws *WebServer) HandleIndex(w http.ResponseWriter, r *http.Request) {
 
 	// Add event log
 	var eventElements []elem.Node
-	for i := len(ws.eventLog) - 1; i >= 0 && i >= len(ws.eventLog)-20; i-- {
-		eventElements = append(eventElements, elem.Div(attrs.Props{attrs.Class: "event"}, elem.Text(ws.eventLog[i])))
+	eventLog := ws.EventLog()
+	for i := len(eventLog) - 1; i >= 0 && i >= len(eventLog)-20; i-- {
+		eventElements = append(eventElements, elem.Div(attrs.Props{attrs.Class: "event"}, elem.Text(eventLog[i])))
 	}
 
-	// Build HomeKit pairing section
+	// Build HomeKit pairing section, the PIN is for admins only
 	var homekitSection elem.Node
-	if ws.hapPin != "" {
+	viewer := identity(r.Context())
+	if ws.hapPin != "" && viewer.Role >= auth.RoleAdmin {
 		var qrContent []elem.Node
 		qrContent = append(
 			qrContent,
@@ -450,11 +654,40 @@ func (ws *WebServer) HandleIndex(w http.ResponseWriter, r *http.Request) {
 		)
 	}
 
-	content := elem.Div(
-		attrs.Props{},
+	summary := []elem.Node{elem.Text(fmt.Sprintf("Managing %d plugs", len(snapshot)))}
+	if viewer.Method != auth.MethodDisabled && viewer.Name != "" {
+		summary = append(summary, elem.Text(fmt.Sprintf(" · Signed in as %s (%s)", viewer.Name, viewer.Role)))
+	}
+	if ws.schedule != nil {
+		summary = append(
+			summary,
+			elem.Text(" · "),
+			elem.A(attrs.Props{attrs.Href: "/schedules"}, elem.Text("Schedules")),
+		)
+	}
+	if ws.discovery != nil && viewer.Role >= auth.RoleAdmin {
+		summary = append(
+			summary,
+			elem.Text(" · "),
+			elem.A(attrs.Props{attrs.Href: "/discovery"}, elem.Text("Discover devices")),
+		)
+	}
+
+	contentChildren := []elem.Node{
 		elem.H1(attrs.Props{}, elem.Text("Tasmota HomeKit Bridge")),
-		elem.P(attrs.Props{}, elem.Text(fmt.Sprintf("Managing %d plugs", len(snapshot)))),
-		homekitSection,
+		elem.P(attrs.Props{}, summary...),
+	}
+	if alerts := ws.renderAlerts(viewer.Role); alerts != nil {
+		contentChildren = append(contentChildren, alerts)
+	}
+	if homekitSection != nil {
+		contentChildren = append(contentChildren, homekitSection)
+	}
+	if groups := ws.renderGroups(viewer.Role); groups != nil {
+		contentChildren = append(contentChildren, groups)
+	}
+	contentChildren = append(
+		contentChildren,
 		elem.Div(attrs.Props{attrs.Class: "plugs-grid"}, plugElements...),
 		elem.Div(
 			attrs.Props{attrs.Class: "events"},
@@ -462,6 +695,7 @@ func (ws *WebServer) HandleIndex(w http.ResponseWriter, r *http.Request) {
 			elem.Div(attrs.Props{}, eventElements...),
 		),
 	)
+	content := elem.Div(attrs.Props{}, contentChildren...)
 
 	w.Header().Set("Content-Type", "text/html")
 	if _, err := fmt.Fprint(w, ws.renderPage("Tasmota HomeKit", content)); err != nil {
@@ -495,13 +729,34 @@ func (ws *WebServer) HandleToggle(w http.ResponseWriter, r *http.Request) {
 	action := r.FormValue("action")
 	on := action == "on"
 
-	if err := ws.controller.SetPower(r.Context(), plugID, on); err != nil {
-		ws.logger.Error("Failed to set power", "plug_id", plugID, "error", err)
+	relay := 0
+	if value := r.FormValue("relay"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > plug.RelayCount() {
+			http.Error(w, "Invalid relay", http.StatusBadRequest)
+			return
+		}
+		relay = parsed
+	}
+
+	ctx := plugs.WithSource(r.Context(), identity(r.Context()).Source("web"))
+	var err error
+	if relay > 0 {
+		err = ws.controller.SetRelayPower(ctx, plugID, relay, on)
+	} else {
+		err = ws.controller.SetPower(ctx, plugID, on)
+	}
+	if err != nil {
+		ws.logger.Error("Failed to set power", "plug_id", plugID, "relay", relay, "error", err)
 		http.Error(w, "Failed to set power", http.StatusInternalServerError)
 		return
 	}
 
-	ws.LogEvent(fmt.Sprintf("Web UI: Toggle %s → %v", plugID, on))
+	if relay > 0 {
+		ws.LogEvent(fmt.Sprintf("Web UI: Toggle %s relay %d → %v", plugID, relay, on))
+	} else {
+		ws.LogEvent(fmt.Sprintf("Web UI: Toggle %s → %v", plugID, on))
+	}
 
 	// If HTMX request, return partial HTML
 	if r.Header.Get("HX-Request") == "true" {
@@ -527,6 +782,239 @@ func (ws *WebServer) HandleToggle(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// HandleLight handles brightness, color and white temperature changes for bulbs
+func (ws *WebServer) HandleLight(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	plugID := strings.TrimPrefix(r.URL.Path, "/light/")
+
+	plug, state, exists := ws.plugProvider.Plug(plugID)
+	if !exists || (plug.Web != nil && !*plug.Web) {
+		http.Error(w, "Plug not found", http.StatusNotFound)
+		return
+	}
+	if !plug.HasBrightness() {
+		http.Error(w, "Plug is not a dimmable bulb", http.StatusBadRequest)
+		return
+	}
+
+	var settings plugs.LightSettings
+	if value := r.FormValue("brightness"); value != "" {
+		brightness, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid brightness", http.StatusBadRequest)
+			return
+		}
+		settings.Brightness = &brightness
+	}
+	if value := r.FormValue("hue"); value != "" {
+		hue, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			http.Error(w, "Invalid hue", http.StatusBadRequest)
+			return
+		}
+		settings.Hue = &hue
+	}
+	if value := r.FormValue("saturation"); value != "" {
+		saturation, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			http.Error(w, "Invalid saturation", http.StatusBadRequest)
+			return
+		}
+		settings.Saturation = &saturation
+	}
+	if value := r.FormValue("color_temperature"); value != "" {
+		ct, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid color temperature", http.StatusBadRequest)
+			return
+		}
+		settings.ColorTemperature = &ct
+	}
+	if settings.Empty() {
+		http.Error(w, "No light setting given", http.StatusBadRequest)
+		return
+	}
+
+	if err := ws.controller.SetLight(plugs.WithSource(r.Context(), identity(r.Context()).Source("web")), plugID, settings); err != nil {
+		ws.logger.Error("Failed to set light", "plug_id", plugID, "error", err)
+		http.Error(w, "Failed to set light", http.StatusInternalServerError)
+		return
+	}
+
+	ws.LogEvent(fmt.Sprintf("Web UI: Light %s updated", plugID))
+
+	if r.Header.Get("HX-Request") == "true" {
+		if updatedPlug, updatedState, ok := ws.plugProvider.Plug(plugID); ok {
+			plug = updatedPlug
+			state = updatedState
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		if _, err := fmt.Fprint(w, ws.renderPlugCard(plugID, plug, state).Render()); err != nil {
+			ws.logger.Error("Failed to write response", slog.Any("error", err))
+		}
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// HandleDiscovery lists discovered devices that are not configured yet.
+func (ws *WebServer) HandleDiscovery(w http.ResponseWriter, r *http.Request) {
+	if ws.discovery == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	devices := ws.discovery.Unconfigured()
+
+	rows := []elem.Node{
+		elem.Tr(
+			attrs.Props{},
+			elem.Th(attrs.Props{}, elem.Text("Name")),
+			elem.Th(attrs.Props{}, elem.Text("IP")),
+			elem.Th(attrs.Props{}, elem.Text("Hostname")),
+			elem.Th(attrs.Props{}, elem.Text("MAC")),
+			elem.Th(attrs.Props{}, elem.Text("Module")),
+			elem.Th(attrs.Props{}, elem.Text("Firmware")),
+			elem.Th(attrs.Props{}, elem.Text("Relays")),
+			elem.Th(attrs.Props{}, elem.Text("Source")),
+			elem.Th(attrs.Props{}, elem.Text("")),
+		),
+	}
+	for _, device := range devices {
+		rows = append(rows, elem.Tr(
+			attrs.Props{attrs.ID: "device-" + device.MAC},
+			elem.Td(attrs.Props{}, elem.Text(device.Name())),
+			elem.Td(attrs.Props{}, elem.A(attrs.Props{attrs.Href: "http://" + device.IP}, elem.Text(device.IP))),
+			elem.Td(attrs.Props{}, elem.Text(device.Hostname)),
+			elem.Td(attrs.Props{}, elem.Text(device.DisplayMAC())),
+			elem.Td(attrs.Props{}, elem.Text(device.Module)),
+			elem.Td(attrs.Props{}, elem.Text(device.Firmware)),
+			elem.Td(attrs.Props{}, elem.Text(strconv.Itoa(device.Relays))),
+			elem.Td(attrs.Props{}, elem.Text(device.Source)),
+			elem.Td(attrs.Props{}, elem.Form(
+				attrs.Props{
+					attrs.Method: "post",
+					attrs.Action: "/discovery/adopt",
+					"hx-post":    "/discovery/adopt",
+					"hx-target":  "#device-" + device.MAC,
+					"hx-swap":    "outerHTML",
+				},
+				elem.Input(attrs.Props{attrs.Type: "hidden", attrs.Name: "mac", attrs.Value: device.MAC}),
+				elem.Button(attrs.Props{attrs.Type: "submit", attrs.Class: "on"}, elem.Text("Adopt")),
+			)),
+		))
+	}
+
+	var listing elem.Node = elem.Table(attrs.Props{attrs.Class: "discovery-table"}, rows...)
+	if len(devices) == 0 {
+		listing = elem.P(attrs.Props{}, elem.Text("No unconfigured Tasmota devices found yet."))
+	}
+
+	var scanSection elem.Node
+	switch {
+	case !ws.discovery.ScanEnabled():
+		scanSection = elem.P(attrs.Props{attrs.Class: "discovery-note"}, elem.Text("Set TASMOTA_HOMEKIT_DISCOVERY_SCAN_CIDR to also sweep a network range."))
+	case ws.discovery.Scanning():
+		scanSection = elem.P(attrs.Props{attrs.Class: "discovery-note"}, elem.Text("Network scan in progress, reload the page for results."))
+	default:
+		scanSection = elem.Form(
+			attrs.Props{attrs.Method: "post", attrs.Action: "/discovery/scan"},
+			elem.Button(attrs.Props{attrs.Type: "submit"}, elem.Text("Scan network")),
+		)
+	}
+
+	content := elem.Div(
+		attrs.Props{},
+		elem.H1(attrs.Props{}, elem.Text("Discovered Devices")),
+		elem.P(
+			attrs.Props{},
+			elem.Text("Tasmota devices announcing themselves over MQTT (SetOption19 0) or found by a network scan that are not in the plugs configuration. "),
+			elem.A(attrs.Props{attrs.Href: "/"}, elem.Text("Back to plugs")),
+		),
+		scanSection,
+		listing,
+	)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := fmt.Fprint(w, ws.renderPage("Discovered Devices", content)); err != nil {
+		ws.logger.Error("Failed to write discovery response", slog.Any("error", err))
+	}
+}
+
+// HandleDiscoveryAdopt adds a discovered device to the plugs configuration.
+func (ws *WebServer) HandleDiscoveryAdopt(w http.ResponseWriter, r *http.Request) {
+	if ws.discovery == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mac := discovery.NormalizeMAC(r.FormValue("mac"))
+	if mac == "" {
+		http.Error(w, "Missing mac", http.StatusBadRequest)
+		return
+	}
+
+	plug, err := ws.discovery.Adopt(mac)
+	if err != nil {
+		ws.logger.Error("Failed to adopt device", "mac", mac, "error", err)
+		http.Error(w, fmt.Sprintf("Failed to adopt device: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	ws.LogEvent(fmt.Sprintf("Web UI: Adopted %s as %s", plug.Address, plug.ID))
+
+	if r.Header.Get("HX-Request") == "true" {
+		row := elem.Tr(
+			attrs.Props{attrs.ID: "device-" + mac, attrs.Class: "adopted"},
+			elem.Td(
+				attrs.Props{"colspan": "9"},
+				elem.Text(fmt.Sprintf("Adopted %s as %s (%s)", plug.Address, plug.ID, plug.Name)),
+			),
+		)
+		w.Header().Set("Content-Type", "text/html")
+		if _, err := fmt.Fprint(w, row.Render()); err != nil {
+			ws.logger.Error("Failed to write response", slog.Any("error", err))
+		}
+		return
+	}
+
+	http.Redirect(w, r, "/discovery", http.StatusSeeOther)
+}
+
+// HandleDiscoveryScan starts a sweep of the configured network range.
+func (ws *WebServer) HandleDiscoveryScan(w http.ResponseWriter, r *http.Request) {
+	if ws.discovery == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := ws.discovery.StartScan(); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	ws.LogEvent("Web UI: Network scan started")
+
+	http.Redirect(w, r, "/discovery", http.StatusSeeOther)
+}
+
 // HandleEventBusDebug renders a simple diagnostic view of the current state map.
 func (ws *WebServer) HandleEventBusDebug(w http.ResponseWriter, r *http.Request) {
 	snapshot := ws.snapshotState()
@@ -617,7 +1105,7 @@ func (ws *WebServer) HandleSSE(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
-	clientChan := make(chan events.StateUpdateEvent, 10)
+	clientChan := make(chan sseMessage, 10)
 
 	ws.sseClientsMu.Lock()
 	ws.sseClients[clientChan] = struct{}{}
@@ -633,20 +1121,25 @@ func (ws *WebServer) HandleSSE(w http.ResponseWriter, r *http.Request) {
 	// Send current snapshot immediately.
 	for _, evt := range ws.snapshotState() {
 		select {
-		case clientChan <- evt:
+		case clientChan <- sseMessage{data: evt}:
 		default:
 		}
 	}
 
 	for {
 		select {
-		case evt := <-clientChan:
-			payload, err := json.Marshal(evt)
+		case msg := <-clientChan:
+			payload, err := json.Marshal(msg.data)
 			if err != nil {
 				ws.logger.Error("Failed to marshal SSE payload", slog.Any("error", err))
 				continue
 			}
 
+			if msg.event != "" {
+				if _, err := fmt.Fprintf(w, "event: %s\n", msg.event); err != nil {
+					return
+				}
+			}
 			if _, err := fmt.Fprintf(w, "data: %s\n\n", payload); err != nil {
 				return
 			}
@@ -661,6 +1154,8 @@ func (ws *WebServer) HandleSSE(w http.ResponseWriter, r *http.Request) {
 }
 
 // HandleHealth exposes a JSON health summary that matches nefit-homekit.
+// It always reports ok; /health/live and /health/ready check the
+// components.
 func (ws *WebServer) HandleHealth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
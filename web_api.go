@@ -0,0 +1,304 @@
+package tasmotahomekit
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+)
+
+//go:embed assets/openapi.json
+var openAPIDocument []byte
+
+// apiPrefix is the path every REST API route lives under.
+const apiPrefix = "/api/v1/"
+
+// apiError is the body of every API error response.
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiPlug is a plug with its current state as returned by the API.
+type apiPlug struct {
+	ID       string              `json:"id"`
+	Name     string              `json:"name"`
+	Model    string              `json:"model,omitempty"`
+	Type     string              `json:"type"`
+	Features *plugs.PlugFeatures `json:"features,omitempty"`
+	Relays   []apiRelay          `json:"relays"`
+	State    apiState            `json:"state"`
+}
+
+type apiRelay struct {
+	Relay int    `json:"relay"`
+	Name  string `json:"name"`
+	On    bool   `json:"on"`
+}
+
+type apiState struct {
+	On               bool                            `json:"on"`
+	Brightness       int                             `json:"brightness,omitempty"`
+	Hue              float64                         `json:"hue,omitempty"`
+	Saturation       float64                         `json:"saturation,omitempty"`
+	ColorTemperature int                             `json:"color_temperature,omitempty"`
+	Power            float64                         `json:"power"`
+	Voltage          float64                         `json:"voltage"`
+	Current          float64                         `json:"current"`
+	Energy           float64                         `json:"energy"`
+	EnergyToday      float64                         `json:"energy_today"`
+	EnergyYesterday  float64                         `json:"energy_yesterday"`
+	Sensors          map[string]plugs.SensorReadings `json:"sensors,omitempty"`
+	MQTTConnected    bool                            `json:"mqtt_connected"`
+	Offline          bool                            `json:"offline"`
+	Restored         bool                            `json:"restored"`
+	ConnectionState  string                          `json:"connection_state"`
+	LastSeen         time.Time                       `json:"last_seen"`
+	LastUpdated      time.Time                       `json:"last_updated"`
+}
+
+// apiPowerRequest is the body of PUT /plugs/{id}/power.
+type apiPowerRequest struct {
+	State string `json:"state"`           // "on", "off" or "toggle"
+	Relay int    `json:"relay,omitempty"` // 1-based, 0 for the whole device (every relay)
+}
+
+func newAPIPlug(info plugs.Plug, state plugs.State) apiPlug {
+	plugType := info.Type
+	if plugType == "" {
+		plugType = "plug"
+	}
+
+	relays := make([]apiRelay, info.RelayCount())
+	for i := range relays {
+		relays[i] = apiRelay{
+			Relay: i + 1,
+			Name:  info.RelayName(i + 1),
+			On:    state.RelayOn(i + 1),
+		}
+	}
+
+	update := plugs.NewStateUpdateEvent("api", info.ID, state)
+	return apiPlug{
+		ID:       info.ID,
+		Name:     info.Name,
+		Model:    info.Model,
+		Type:     plugType,
+		Features: info.Features,
+		Relays:   relays,
+		State: apiState{
+			On:               state.On,
+			Brightness:       state.Brightness,
+			Hue:              state.Hue,
+			Saturation:       state.Saturation,
+			ColorTemperature: state.ColorTemperature,
+			Power:            state.Power,
+			Voltage:          state.Voltage,
+			Current:          state.Current,
+			Energy:           state.Energy,
+			EnergyToday:      state.EnergyToday,
+			EnergyYesterday:  state.EnergyYesterday,
+			Sensors:          state.Sensors,
+			MQTTConnected:    state.MQTTConnected,
+			Offline:          state.Offline,
+			Restored:         state.Restored,
+			ConnectionState:  update.ConnectionState,
+			LastSeen:         state.LastSeen,
+			LastUpdated:      state.LastUpdated,
+		},
+	}
+}
+
+// HandleAPI serves the versioned JSON API under /api/v1/:
+//
+//	GET  /api/v1/openapi.json
+//	GET  /api/v1/plugs
+//	GET  /api/v1/plugs/{id}
+//	PUT  /api/v1/plugs/{id}/power
+//	POST /api/v1/plugs/{id}/refresh
+//
+// Errors are returned as {"error": {"code": ..., "message": ...}}.
+func (ws *WebServer) HandleAPI(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "openapi.json":
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(openAPIDocument); err != nil {
+			ws.logger.Error("Failed to write OpenAPI document", slog.Any("error", err))
+		}
+	case path == "plugs":
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		ws.apiListPlugs(w)
+	case len(parts) == 2 && parts[0] == "plugs":
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		ws.apiGetPlug(w, parts[1])
+	case len(parts) == 3 && parts[0] == "plugs" && parts[2] == "power":
+		if !allowMethod(w, r, http.MethodPut) {
+			return
+		}
+		ws.apiSetPower(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "plugs" && parts[2] == "refresh":
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		ws.apiRefresh(w, r, parts[1])
+	default:
+		writeAPIError(w, http.StatusNotFound, "not_found", fmt.Sprintf("no API route for %s", r.URL.Path))
+	}
+}
+
+func (ws *WebServer) apiListPlugs(w http.ResponseWriter) {
+	snapshot := ws.plugProvider.Snapshot()
+
+	list := make([]apiPlug, 0, len(snapshot))
+	for _, item := range snapshot {
+		if item.Plug.Web != nil && !*item.Plug.Web {
+			continue
+		}
+		list = append(list, newAPIPlug(item.Plug, item.State))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	ws.writeAPI(w, http.StatusOK, struct {
+		Plugs []apiPlug `json:"plugs"`
+	}{Plugs: list})
+}
+
+func (ws *WebServer) apiGetPlug(w http.ResponseWriter, plugID string) {
+	plug, state, ok := ws.apiPlug(w, plugID)
+	if !ok {
+		return
+	}
+	ws.writeAPI(w, http.StatusOK, newAPIPlug(plug, state))
+}
+
+func (ws *WebServer) apiSetPower(w http.ResponseWriter, r *http.Request, plugID string) {
+	plug, state, ok := ws.apiPlug(w, plugID)
+	if !ok {
+		return
+	}
+
+	var req apiPowerRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if req.Relay < 0 || req.Relay > plug.RelayCount() {
+		writeAPIError(w, http.StatusBadRequest, "invalid_relay", fmt.Sprintf("plug %s has no relay %d", plugID, req.Relay))
+		return
+	}
+
+	var on bool
+	switch req.State {
+	case "on":
+		on = true
+	case "off":
+		on = false
+	case "toggle":
+		// The whole device toggles off while any of its relays is on
+		if req.Relay > 0 {
+			on = !state.RelayOn(req.Relay)
+		} else {
+			on = !state.RelayOn(1) && !slices.Contains(state.Relays, true)
+		}
+	default:
+		writeAPIError(w, http.StatusBadRequest, "invalid_state", `state must be "on", "off" or "toggle"`)
+		return
+	}
+
+	ctx := plugs.WithSource(r.Context(), identity(r.Context()).Source("api"))
+	var err error
+	if req.Relay > 0 {
+		err = ws.controller.SetRelayPower(ctx, plugID, req.Relay, on)
+	} else {
+		err = ws.controller.SetPower(ctx, plugID, on)
+	}
+	if err != nil {
+		ws.logger.Error("Failed to set power", "plug_id", plugID, "relay", req.Relay, "error", err)
+		writeAPIError(w, http.StatusBadGateway, "command_failed", fmt.Sprintf("failed to set power: %v", err))
+		return
+	}
+
+	if req.Relay > 0 {
+		ws.LogEvent(fmt.Sprintf("API: Set %s relay %d → %v", plugID, req.Relay, on))
+	} else {
+		ws.LogEvent(fmt.Sprintf("API: Set %s → %v", plugID, on))
+	}
+
+	if updatedPlug, updatedState, ok := ws.plugProvider.Plug(plugID); ok {
+		plug = updatedPlug
+		state = updatedState
+	}
+	ws.writeAPI(w, http.StatusOK, newAPIPlug(plug, state))
+}
+
+func (ws *WebServer) apiRefresh(w http.ResponseWriter, r *http.Request, plugID string) {
+	plug, _, ok := ws.apiPlug(w, plugID)
+	if !ok {
+		return
+	}
+
+	state, err := ws.controller.GetStatus(r.Context(), plugID)
+	if err != nil {
+		ws.logger.Warn("Failed to refresh plug", "plug_id", plugID, "error", err)
+		writeAPIError(w, http.StatusBadGateway, "device_unreachable", fmt.Sprintf("failed to refresh plug: %v", err))
+		return
+	}
+	ws.writeAPI(w, http.StatusOK, newAPIPlug(plug, *state))
+}
+
+// apiPlug looks up a plug shown on the web, writing a 404 if there is none.
+func (ws *WebServer) apiPlug(w http.ResponseWriter, plugID string) (plugs.Plug, plugs.State, bool) {
+	plug, state, exists := ws.plugProvider.Plug(plugID)
+	if !exists || (plug.Web != nil && !*plug.Web) {
+		writeAPIError(w, http.StatusNotFound, "plug_not_found", fmt.Sprintf("plug %q not found", plugID))
+		return plugs.Plug{}, plugs.State{}, false
+	}
+	return plug, state, true
+}
+
+func (ws *WebServer) writeAPI(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		ws.logger.Error("Failed to write API response", slog.Any("error", err))
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(apiError{Error: apiErrorDetail{Code: code, Message: message}})
+}
+
+// allowMethod writes a 405 unless r uses method.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", fmt.Sprintf("%s is not allowed, use %s", r.Method, method))
+	return false
+}
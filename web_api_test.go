@@ -0,0 +1,172 @@
+package tasmotahomekit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+)
+
+func serveAPI(ws *WebServer, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	ws.HandleAPI(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func decodeAPIError(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp apiError
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("error response invalid json: %v: %s", err, rec.Body.String())
+	}
+	return resp.Error.Code
+}
+
+func TestAPIListAndGetPlugs(t *testing.T) {
+	ws, provider, _, _ := newTestWebServer(t)
+	hidden := false
+	provider.items["hidden"] = struct {
+		Plug  plugs.Plug
+		State plugs.State
+	}{Plug: plugs.Plug{ID: "hidden", Name: "Hidden", Web: &hidden}}
+
+	rec := serveAPI(ws, http.MethodGet, "/api/v1/plugs", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200", rec.Code)
+	}
+	var list struct {
+		Plugs []apiPlug `json:"plugs"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("list response invalid json: %v", err)
+	}
+	if len(list.Plugs) != 1 || list.Plugs[0].ID != "plug-1" || list.Plugs[0].Type != "plug" || len(list.Plugs[0].Relays) != 1 {
+		t.Fatalf("unexpected plugs: %+v", list.Plugs)
+	}
+
+	rec = serveAPI(ws, http.MethodGet, "/api/v1/plugs/plug-1", "")
+	var plug apiPlug
+	if err := json.Unmarshal(rec.Body.Bytes(), &plug); err != nil || plug.Name != "Test Plug" {
+		t.Fatalf("unexpected plug response %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = serveAPI(ws, http.MethodGet, "/api/v1/plugs/hidden", "")
+	if rec.Code != http.StatusNotFound || decodeAPIError(t, rec) != "plug_not_found" {
+		t.Fatalf("hidden plug: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	rec = serveAPI(ws, http.MethodDelete, "/api/v1/plugs/plug-1", "")
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodGet {
+		t.Fatalf("DELETE: status = %d, Allow = %q", rec.Code, rec.Header().Get("Allow"))
+	}
+
+	rec = serveAPI(ws, http.MethodGet, "/api/v1/nope", "")
+	if rec.Code != http.StatusNotFound || decodeAPIError(t, rec) != "not_found" {
+		t.Fatalf("unknown route: status = %d", rec.Code)
+	}
+}
+
+func TestAPISetPower(t *testing.T) {
+	ws, provider, controller, _ := newTestWebServer(t)
+
+	type call struct {
+		relay int
+		on    bool
+	}
+	var calls []call
+	controller.setPowerFunc = func(_ context.Context, _ string, on bool) error {
+		calls = append(calls, call{0, on})
+		return nil
+	}
+	controller.setRelayPowerFunc = func(_ context.Context, _ string, relay int, on bool) error {
+		calls = append(calls, call{relay, on})
+		return nil
+	}
+
+	item := provider.items["plug-1"]
+	item.Plug.Relays = []plugs.Relay{{Name: "A"}, {Name: "B"}}
+	item.State.SetRelay(2, true)
+	provider.items["plug-1"] = item
+
+	for _, body := range []string{`{"state":"on"}`, `{"state":"toggle","relay":2}`, `{"state":"toggle"}`} {
+		rec := serveAPI(ws, http.MethodPut, "/api/v1/plugs/plug-1/power", body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body = %s", body, rec.Code, rec.Body.String())
+		}
+	}
+	// Toggling the whole device switches it off while any relay is on
+	want := []call{{0, true}, {2, false}, {0, false}}
+	if len(calls) != len(want) {
+		t.Fatalf("calls = %+v; want %+v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("calls = %+v; want %+v", calls, want)
+		}
+	}
+
+	for body, code := range map[string]string{
+		`{"state":"dim"}`:          "invalid_state",
+		`{"state":"on","relay":3}`: "invalid_relay",
+		`{"state":"on","x":1}`:     "invalid_body",
+		`not json`:                 "invalid_body",
+	} {
+		rec := serveAPI(ws, http.MethodPut, "/api/v1/plugs/plug-1/power", body)
+		if rec.Code != http.StatusBadRequest || decodeAPIError(t, rec) != code {
+			t.Fatalf("%s: status = %d, body = %s; want %s", body, rec.Code, rec.Body.String(), code)
+		}
+	}
+
+	controller.setPowerFunc = func(context.Context, string, bool) error { return errors.New("timeout") }
+	rec := serveAPI(ws, http.MethodPut, "/api/v1/plugs/plug-1/power", `{"state":"off"}`)
+	if rec.Code != http.StatusBadGateway || decodeAPIError(t, rec) != "command_failed" {
+		t.Fatalf("failed command: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAPIRefresh(t *testing.T) {
+	ws, _, controller, _ := newTestWebServer(t)
+	controller.getStatusFunc = func(_ context.Context, plugID string) (*plugs.State, error) {
+		return &plugs.State{ID: plugID, On: true, Power: 12}, nil
+	}
+
+	rec := serveAPI(ws, http.MethodPost, "/api/v1/plugs/plug-1/refresh", "")
+	var plug apiPlug
+	if err := json.Unmarshal(rec.Body.Bytes(), &plug); err != nil || !plug.State.On || plug.State.Power != 12 {
+		t.Fatalf("unexpected refresh response %d: %s", rec.Code, rec.Body.String())
+	}
+
+	controller.getStatusFunc = func(context.Context, string) (*plugs.State, error) { return nil, errors.New("unreachable") }
+	rec = serveAPI(ws, http.MethodPost, "/api/v1/plugs/plug-1/refresh", "")
+	if rec.Code != http.StatusBadGateway || decodeAPIError(t, rec) != "device_unreachable" {
+		t.Fatalf("failed refresh: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAPIOpenAPIDocument(t *testing.T) {
+	ws, _, _, _ := newTestWebServer(t)
+
+	rec := serveAPI(ws, http.MethodGet, "/api/v1/openapi.json", "")
+	var doc struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("OpenAPI document invalid json: %v", err)
+	}
+	for path, method := range map[string]string{
+		"/plugs":              "get",
+		"/plugs/{id}":         "get",
+		"/plugs/{id}/power":   "put",
+		"/plugs/{id}/refresh": "post",
+	} {
+		if _, ok := doc.Paths[path][method]; !ok {
+			t.Fatalf("OpenAPI document missing %s %s", method, path)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package tasmotahomekit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kradalby/tasmota-homekit/auth"
+	"tailscale.com/client/local"
+)
+
+// SetAuthenticator enables authentication of web and API requests routed
+// through Require.
+func (ws *WebServer) SetAuthenticator(a *auth.Authenticator) {
+	ws.auth = a
+}
+
+// Require serves next to clients with at least role and stores their
+// identity in the request context.
+func (ws *WebServer) Require(role auth.Role, next http.Handler) http.Handler {
+	return ws.RequireFunc(func(*http.Request) auth.Role { return role }, next)
+}
+
+// RequireFunc is Require with the role picked per request.
+func (ws *WebServer) RequireFunc(roleFor func(*http.Request) auth.Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := ws.auth.Authenticate(r)
+		role := roleFor(r)
+		switch {
+		case errors.Is(err, auth.ErrInvalidCredentials), err == nil && id.Role == auth.RoleNone:
+			if ws.auth.PasswordLogin() {
+				w.Header().Set("WWW-Authenticate", `Basic realm="tasmota-homekit", charset="UTF-8"`)
+			}
+			ws.denyRequest(w, r, http.StatusUnauthorized, "unauthorized", "Authentication required")
+			return
+		case err != nil:
+			ws.logger.Error("Failed to authenticate request", "path", r.URL.Path, "error", err)
+			ws.denyRequest(w, r, http.StatusInternalServerError, "internal_error", "Authentication failed")
+			return
+		case id.Role < role:
+			ws.logger.Info(
+				"Request denied",
+				"identity", id.Name,
+				"role", id.Role,
+				"required", role,
+				"path", r.URL.Path,
+			)
+			ws.denyRequest(w, r, http.StatusForbidden, "forbidden", fmt.Sprintf("Requires the %s role", role))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), id)))
+	})
+}
+
+// denyRequest writes a structured error for API requests and a plain one
+// for the web UI.
+func (ws *WebServer) denyRequest(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if strings.HasPrefix(r.URL.Path, apiPrefix) {
+		writeAPIError(w, status, code, message)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// apiRole lets viewers read the API and operators change plugs.
+func apiRole(r *http.Request) auth.Role {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return auth.RoleViewer
+	}
+	return auth.RoleOperator
+}
+
+// guardedMux registers handlers on a mux behind a role, for handlers set up
+// outside the web server such as SetupDebugHandlers.
+type guardedMux struct {
+	ws   *WebServer
+	mux  interface{ Handle(string, http.Handler) }
+	role auth.Role
+}
+
+func (g guardedMux) Handle(pattern string, handler http.Handler) {
+	g.mux.Handle(pattern, g.ws.Require(g.role, handler))
+}
+
+// identity returns the identity Require stored for the request, or the
+// admin identity of an unauthenticated server for handlers served directly.
+func identity(ctx context.Context) auth.Identity {
+	if id, ok := auth.FromContext(ctx); ok {
+		return id
+	}
+	return auth.Identity{Name: auth.MethodDisabled, Method: auth.MethodDisabled, Role: auth.RoleAdmin}
+}
+
+// tsnetServer is implemented by web servers that expose the local client of
+// the tsnet node they listen on.
+type tsnetServer interface {
+	LocalClient() (*local.Client, error)
+}
+
+// tailscaleWhoIs resolves the login name of tailnet peers with the local
+// client of the tsnet node serving the web UI.
+func tailscaleWhoIs(lc *local.Client) auth.WhoIsFunc {
+	return func(ctx context.Context, remoteAddr string) (string, error) {
+		who, err := lc.WhoIs(ctx, remoteAddr)
+		if err != nil {
+			return "", err
+		}
+		if who.UserProfile == nil {
+			return "", fmt.Errorf("no user profile for %s", remoteAddr)
+		}
+		return who.UserProfile.LoginName, nil
+	}
+}
@@ -0,0 +1,113 @@
+package tasmotahomekit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kradalby/tasmota-homekit/auth"
+)
+
+const testAPIToken = "0123456789abcdef0123"
+
+func newAuthTestWebServer(t *testing.T) (*WebServer, *mockPlugController) {
+	t.Helper()
+	ws, _, controller, _ := newTestWebServer(t)
+	ws.SetAuthenticator(auth.New(&auth.Config{
+		Tokens: []auth.Token{{Name: "script", Token: testAPIToken, Role: "operator"}},
+		Users: []auth.User{
+			{Username: "guest", Password: "guest-pw", Role: "viewer"},
+			{Username: "root", Password: "root-pw", Role: "admin"},
+		},
+	}))
+	return ws, controller
+}
+
+func TestRequireRole(t *testing.T) {
+	ws, _ := newAuthTestWebServer(t)
+	handler := ws.Require(auth.RoleOperator, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(identity(r.Context()).Name))
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/toggle/plug-1", nil))
+	if rec.Code != http.StatusUnauthorized || !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "Basic") {
+		t.Fatalf("anonymous: status = %d, WWW-Authenticate = %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/toggle/plug-1", nil)
+	req.SetBasicAuth("guest", "guest-pw")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("viewer: status = %d; want 403", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/toggle/plug-1", nil)
+	req.Header.Set("Authorization", "Bearer "+testAPIToken)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "token:script" {
+		t.Fatalf("operator: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+
+	// API clients get structured errors
+	rec = httptest.NewRecorder()
+	ws.RequireFunc(apiRole, http.HandlerFunc(ws.HandleAPI)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/plugs", nil))
+	if rec.Code != http.StatusUnauthorized || decodeAPIError(t, rec) != "unauthorized" {
+		t.Fatalf("API anonymous: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCommandsCarryIdentity(t *testing.T) {
+	ws, controller := newAuthTestWebServer(t)
+	var sources []string
+	controller.setPowerFunc = func(ctx context.Context, _ string, _ bool) error {
+		sources = append(sources, identity(ctx).Name)
+		return nil
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/toggle/plug-1", strings.NewReader("action=on"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("root", "root-pw")
+	rec := httptest.NewRecorder()
+	ws.Require(auth.RoleOperator, http.HandlerFunc(ws.HandleToggle)).ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("toggle: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/api/v1/plugs/plug-1/power", strings.NewReader(`{"state":"on"}`))
+	req.Header.Set("Authorization", "Bearer "+testAPIToken)
+	rec = httptest.NewRecorder()
+	ws.RequireFunc(apiRole, http.HandlerFunc(ws.HandleAPI)).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("API: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	if len(sources) != 2 || sources[0] != "root" || sources[1] != "token:script" {
+		t.Fatalf("sources = %v", sources)
+	}
+}
+
+func TestIndexHidesPINFromNonAdmins(t *testing.T) {
+	ws, _ := newAuthTestWebServer(t)
+	index := ws.Require(auth.RoleViewer, http.HandlerFunc(ws.HandleIndex))
+
+	for user, wantPIN := range map[string]bool{"guest": false, "root": true} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(user, user+"-pw")
+		rec := httptest.NewRecorder()
+		index.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d", user, rec.Code)
+		}
+		if got := strings.Contains(rec.Body.String(), "00102003"); got != wantPIN {
+			t.Fatalf("%s: PIN shown = %v; want %v", user, got, wantPIN)
+		}
+		if !strings.Contains(rec.Body.String(), "Signed in as "+user) {
+			t.Fatalf("%s: index missing identity", user)
+		}
+	}
+}
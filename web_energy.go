@@ -0,0 +1,140 @@
+package tasmotahomekit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chasefleming/elem-go"
+	"github.com/chasefleming/elem-go/attrs"
+	"github.com/kradalby/tasmota-homekit/energy"
+)
+
+// energyHistory provides the recorded energy history of plugs.
+type energyHistory interface {
+	Series(plugID string) (energy.Series, bool)
+	Currency() string
+	BillingPeriod(at time.Time) (start, end time.Time)
+	WriteCSV(w io.Writer, start, end time.Time) error
+}
+
+// SetEnergyHistory enables the energy charts and the /energy endpoint.
+func (ws *WebServer) SetEnergyHistory(h energyHistory) {
+	ws.energy = h
+}
+
+// HandleEnergy returns the energy history of a plug as JSON: raw power
+// samples of the last day and hourly, daily, weekly and monthly rollups.
+func (ws *WebServer) HandleEnergy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if ws.energy == nil {
+		http.Error(w, "Energy history disabled", http.StatusNotFound)
+		return
+	}
+
+	plugID := strings.TrimPrefix(r.URL.Path, "/energy/")
+	plug, _, exists := ws.plugProvider.Plug(plugID)
+	if !exists || (plug.Web != nil && !*plug.Web) {
+		http.Error(w, "Plug not found", http.StatusNotFound)
+		return
+	}
+
+	series, _ := ws.energy.Series(plugID)
+	resp := struct {
+		PlugID string `json:"plug_id"`
+		energy.Series
+		Weekly []energy.Bucket `json:"weekly"`
+	}{
+		PlugID: plugID,
+		Series: series,
+		Weekly: series.Weekly(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		ws.logger.Error("Failed to write energy response", slog.Any("error", err))
+	}
+}
+
+// HandleEnergyExport returns the daily consumption and cost of all plugs in
+// a billing period as CSV. The period is picked by ?period=YYYY-MM, the
+// month it starts in, and defaults to the current one.
+func (ws *WebServer) HandleEnergyExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if ws.energy == nil {
+		http.Error(w, "Energy history disabled", http.StatusNotFound)
+		return
+	}
+
+	at := time.Now()
+	if period := r.URL.Query().Get("period"); period != "" {
+		month, err := time.ParseInLocation("2006-01", period, time.Local)
+		if err != nil {
+			http.Error(w, "Invalid period, want YYYY-MM", http.StatusBadRequest)
+			return
+		}
+		// Billing days are at most the 28th, so this falls in the
+		// period starting that month
+		at = month.AddDate(0, 1, -1)
+	}
+	start, end := ws.energy.BillingPeriod(at)
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="energy-%s.csv"`, start.Format(time.DateOnly)))
+	if err := ws.energy.WriteCSV(w, start, end); err != nil {
+		ws.logger.Error("Failed to write energy export", slog.Any("error", err))
+	}
+}
+
+// renderEnergyHistory renders the device's daily counters, the month so far
+// and a bar chart of the last 24 hours. With a currency, the cost of today
+// and the month is shown too.
+func renderEnergyHistory(series energy.Series, currency string, now time.Time) elem.Node {
+	hours := series.LastHours(now, 24)
+	peak := 0.0
+	for _, bucket := range hours {
+		peak = max(peak, bucket.Energy)
+	}
+
+	bars := make([]elem.Node, 0, len(hours))
+	for _, bucket := range hours {
+		height := 0.0
+		if peak > 0 {
+			height = bucket.Energy / peak * 100
+		}
+		bars = append(bars, elem.Div(attrs.Props{
+			attrs.Class: "energy-bar",
+			attrs.Style: fmt.Sprintf("height: %.0f%%", height),
+			attrs.Title: fmt.Sprintf("%s: %.3f kWh, avg %.1f W", bucket.Start.Format("15:04"), bucket.Energy, bucket.AvgPower),
+		}))
+	}
+
+	children := []elem.Node{
+		elem.Div(
+			attrs.Props{attrs.Class: "energy-summary"},
+			elem.Span(attrs.Props{}, elem.Text(fmt.Sprintf("Today: %.2f kWh", series.Today))),
+			elem.Span(attrs.Props{}, elem.Text(fmt.Sprintf("Yesterday: %.2f kWh", series.Yesterday))),
+			elem.Span(attrs.Props{}, elem.Text(fmt.Sprintf("This month: %.2f kWh", series.Month(now).Energy))),
+		),
+	}
+	if currency != "" {
+		children = append(children, elem.Div(
+			attrs.Props{attrs.Class: "energy-summary", "data-role": "energy-cost"},
+			elem.Span(attrs.Props{}, elem.Text(fmt.Sprintf("Cost today: %.2f %s", series.Day(now).Cost, currency))),
+			elem.Span(attrs.Props{}, elem.Text(fmt.Sprintf("This month: %.2f %s", series.Month(now).Cost, currency))),
+		))
+	}
+	children = append(children, elem.Div(attrs.Props{attrs.Class: "energy-chart", attrs.Title: "Last 24 hours"}, bars...))
+
+	return elem.Div(attrs.Props{attrs.Class: "energy-history", "data-role": "energy-history"}, children...)
+}
@@ -0,0 +1,97 @@
+package tasmotahomekit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/energy"
+	"github.com/kradalby/tasmota-homekit/plugs"
+)
+
+func TestHandleEnergy(t *testing.T) {
+	ws, provider, _, _ := newTestWebServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/energy/plug-1", nil)
+	rec := httptest.NewRecorder()
+	ws.HandleEnergy(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status without history = %d; want 404", rec.Code)
+	}
+
+	history := energy.New(filepath.Join(t.TempDir(), "energy.json"))
+	now := time.Now()
+	history.Record("plug-1", plugs.EnergyReading{Time: now.Add(-time.Minute), Power: 50, Total: 2.0, Today: 0.5})
+	history.Record("plug-1", plugs.EnergyReading{Time: now, Power: 60, Total: 2.25, Today: 0.75})
+	ws.SetEnergyHistory(history)
+
+	rec = httptest.NewRecorder()
+	ws.HandleEnergy(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200", rec.Code)
+	}
+
+	var payload struct {
+		PlugID string          `json:"plug_id"`
+		Today  float64         `json:"today"`
+		Daily  []energy.Bucket `json:"daily"`
+		Weekly []energy.Bucket `json:"weekly"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("energy response invalid json: %v", err)
+	}
+	if payload.PlugID != "plug-1" || payload.Today != 0.75 || len(payload.Daily) == 0 || len(payload.Weekly) == 0 {
+		t.Fatalf("unexpected payload: %+v", payload)
+	}
+
+	rec = httptest.NewRecorder()
+	ws.HandleEnergy(rec, httptest.NewRequest(http.MethodGet, "/energy/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status for unknown plug = %d; want 404", rec.Code)
+	}
+
+	// Power-monitored plugs get a chart on their card
+	item := provider.items["plug-1"]
+	item.Plug.Features = &plugs.PlugFeatures{PowerMonitoring: true}
+	provider.items["plug-1"] = item
+
+	rec = httptest.NewRecorder()
+	ws.HandleIndex(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, `data-role="energy-history"`) || !strings.Contains(body, "Today: 0.75 kWh") {
+		t.Fatalf("index missing energy history: %s", body)
+	}
+}
+
+func TestHandleEnergyExport(t *testing.T) {
+	ws, _, _, _ := newTestWebServer(t)
+
+	history := energy.New(filepath.Join(t.TempDir(), "energy.json"))
+	history.SetTariff(&plugs.Tariff{Currency: "EUR", Rate: 0.5})
+	day := time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+	history.Record("plug-1", plugs.EnergyReading{Time: day, Total: 2.0})
+	history.Record("plug-1", plugs.EnergyReading{Time: day.Add(time.Hour), Total: 3.0})
+	ws.SetEnergyHistory(history)
+
+	rec := httptest.NewRecorder()
+	ws.HandleEnergyExport(rec, httptest.NewRequest(http.MethodGet, "/energy/export.csv?period=2025-03", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Disposition"); !strings.Contains(got, "energy-2025-03-01.csv") {
+		t.Fatalf("Content-Disposition = %q", got)
+	}
+	if !strings.Contains(rec.Body.String(), "2025-03-10,plug-1,standard,1.0000,0.5000,EUR") {
+		t.Fatalf("export missing row: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	ws.HandleEnergyExport(rec, httptest.NewRequest(http.MethodGet, "/energy/export.csv?period=march", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status for invalid period = %d; want 400", rec.Code)
+	}
+}
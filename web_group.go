@@ -0,0 +1,142 @@
+package tasmotahomekit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/chasefleming/elem-go"
+	"github.com/chasefleming/elem-go/attrs"
+	"github.com/kradalby/tasmota-homekit/auth"
+	"github.com/kradalby/tasmota-homekit/plugs"
+)
+
+// groupService switches plug groups and applies scenes.
+type groupService interface {
+	Groups() []plugs.Group
+	Scenes() []plugs.Scene
+	SetGroupPower(ctx context.Context, groupID string, on bool) (plugs.ApplyResult, error)
+	ApplyScene(ctx context.Context, sceneID string) (plugs.ApplyResult, error)
+}
+
+// SetGroups shows groups and scenes on the dashboard.
+func (ws *WebServer) SetGroups(g groupService) {
+	ws.groups = g
+}
+
+// renderGroups renders the groups with their aggregated state and the
+// scenes, or nil without any. Operators get buttons to switch them.
+func (ws *WebServer) renderGroups(role auth.Role) elem.Node {
+	if ws.groups == nil {
+		return nil
+	}
+	groups, scenes := ws.groups.Groups(), ws.groups.Scenes()
+	if len(groups) == 0 && len(scenes) == 0 {
+		return nil
+	}
+
+	on := make(map[string]bool)
+	for id, item := range ws.plugProvider.Snapshot() {
+		on[id] = item.State.On
+	}
+
+	rows := make([]elem.Node, 0, len(groups)+len(scenes))
+	for _, group := range groups {
+		statusClass, statusText, action := "off", "OFF", "on"
+		if group.On(on) {
+			statusClass, statusText, action = "on", "ON", "off"
+		}
+		children := []elem.Node{
+			elem.Span(attrs.Props{attrs.Class: "relay-name"}, elem.Text(fmt.Sprintf("%s (%d plugs)", group.Name, len(group.Plugs)))),
+			elem.Span(attrs.Props{attrs.Class: "relay-status", "data-role": "group-status"}, elem.Text(statusText)),
+		}
+		if role >= auth.RoleOperator {
+			children = append(children, elem.Form(
+				attrs.Props{attrs.Method: "post", attrs.Action: "/groups/" + group.ID, attrs.Class: "inline-form"},
+				elem.Input(attrs.Props{attrs.Type: "hidden", attrs.Name: "action", attrs.Value: action}),
+				elem.Button(attrs.Props{attrs.Type: "submit", attrs.Class: action}, elem.Text("Turn "+strings.ToUpper(action[:1])+action[1:])),
+			))
+		}
+		rows = append(rows, elem.Div(
+			attrs.Props{attrs.ID: "group-" + group.ID, attrs.Class: "relay-row " + statusClass},
+			children...,
+		))
+	}
+	for _, scene := range scenes {
+		children := []elem.Node{
+			elem.Span(attrs.Props{attrs.Class: "relay-name"}, elem.Text(fmt.Sprintf("%s (%d plugs)", scene.Name, len(scene.Plugs)))),
+		}
+		if role >= auth.RoleOperator {
+			children = append(children, elem.Form(
+				attrs.Props{attrs.Method: "post", attrs.Action: "/scenes/" + scene.ID, attrs.Class: "inline-form"},
+				elem.Button(attrs.Props{attrs.Type: "submit"}, elem.Text("Apply")),
+			))
+		}
+		rows = append(rows, elem.Div(
+			attrs.Props{attrs.ID: "scene-" + scene.ID, attrs.Class: "relay-row scene"},
+			children...,
+		))
+	}
+
+	return elem.Div(
+		attrs.Props{attrs.Class: "groups", "data-role": "groups"},
+		elem.H2(attrs.Props{}, elem.Text("Groups & Scenes")),
+		elem.Div(attrs.Props{}, rows...),
+	)
+}
+
+// HandleGroup switches every plug of the group in the path, /groups/<id>,
+// on or off with the "action" form value.
+func (ws *WebServer) HandleGroup(w http.ResponseWriter, r *http.Request) {
+	if ws.groups == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	groupID := strings.TrimPrefix(r.URL.Path, "/groups/")
+	on := r.FormValue("action") == "on"
+	source := identity(r.Context()).Source("web")
+	result, err := ws.groups.SetGroupPower(plugs.WithSource(r.Context(), source), groupID, on)
+	ws.respondApply(w, r, fmt.Sprintf("%s: Group %s → %v", source, groupID, on), result, err)
+}
+
+// HandleScene applies the scene in the path, /scenes/<id>.
+func (ws *WebServer) HandleScene(w http.ResponseWriter, r *http.Request) {
+	if ws.groups == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sceneID := strings.TrimPrefix(r.URL.Path, "/scenes/")
+	source := identity(r.Context()).Source("web")
+	result, err := ws.groups.ApplyScene(plugs.WithSource(r.Context(), source), sceneID)
+	ws.respondApply(w, r, fmt.Sprintf("%s: Scene %s applied", source, sceneID), result, err)
+}
+
+// respondApply logs a group or scene command and redirects to the
+// dashboard, or reports the plugs that failed.
+func (ws *WebServer) respondApply(w http.ResponseWriter, r *http.Request, event string, result plugs.ApplyResult, err error) {
+	if errors.Is(err, plugs.ErrUnknownGroup) || errors.Is(err, plugs.ErrUnknownScene) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := result.Err(); err != nil {
+		ws.LogEvent(fmt.Sprintf("%s, %v", event, err))
+		ws.logger.Error("Failed to switch some plugs", "error", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	ws.LogEvent(event)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,96 @@
+package tasmotahomekit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kradalby/tasmota-homekit/auth"
+	"github.com/kradalby/tasmota-homekit/plugs"
+)
+
+type fakeGroupService struct {
+	applied []string
+	failed  []plugs.TargetError
+}
+
+func (f *fakeGroupService) Groups() []plugs.Group {
+	return []plugs.Group{{ID: "downstairs", Name: "Downstairs", Plugs: []string{"plug-1", "plug-2"}}}
+}
+
+func (f *fakeGroupService) Scenes() []plugs.Scene {
+	return []plugs.Scene{{ID: "night", Name: "Night", Plugs: []plugs.SceneTarget{{Plug: "plug-1", Action: "off"}}}}
+}
+
+func (f *fakeGroupService) SetGroupPower(_ context.Context, groupID string, on bool) (plugs.ApplyResult, error) {
+	if groupID != "downstairs" {
+		return plugs.ApplyResult{}, fmt.Errorf("%w %q", plugs.ErrUnknownGroup, groupID)
+	}
+	f.applied = append(f.applied, fmt.Sprintf("group %s %v", groupID, on))
+	return plugs.ApplyResult{Total: 2, Failed: f.failed}, nil
+}
+
+func (f *fakeGroupService) ApplyScene(_ context.Context, sceneID string) (plugs.ApplyResult, error) {
+	if sceneID != "night" {
+		return plugs.ApplyResult{}, fmt.Errorf("%w %q", plugs.ErrUnknownScene, sceneID)
+	}
+	f.applied = append(f.applied, "scene "+sceneID)
+	return plugs.ApplyResult{Total: 1}, nil
+}
+
+func TestGroupsAndScenes(t *testing.T) {
+	ws, _, _, _ := newTestWebServer(t)
+	if ws.renderGroups(auth.RoleAdmin) != nil {
+		t.Fatal("groups rendered without a group service")
+	}
+
+	groups := &fakeGroupService{}
+	ws.SetGroups(groups)
+
+	rec := httptest.NewRecorder()
+	ws.HandleIndex(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := rec.Body.String()
+	for _, want := range []string{`id="group-downstairs"`, "Downstairs (2 plugs)", `action="/groups/downstairs"`, `action="/scenes/night"`} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("index missing %q: %s", want, body)
+		}
+	}
+	if rendered := ws.renderGroups(auth.RoleViewer).Render(); strings.Contains(rendered, "<form") {
+		t.Fatalf("viewer can switch groups: %s", rendered)
+	}
+
+	post := func(handler http.HandlerFunc, path, form string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	if rec := post(ws.HandleGroup, "/groups/downstairs", "action=on"); rec.Code != http.StatusSeeOther {
+		t.Fatalf("group status = %d; want 303", rec.Code)
+	}
+	if rec := post(ws.HandleScene, "/scenes/night", ""); rec.Code != http.StatusSeeOther {
+		t.Fatalf("scene status = %d; want 303", rec.Code)
+	}
+	if rec := post(ws.HandleScene, "/scenes/missing", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown scene status = %d; want 404", rec.Code)
+	}
+	if got := strings.Join(groups.applied, ", "); got != "group downstairs true, scene night" {
+		t.Fatalf("applied = %q", got)
+	}
+
+	groups.failed = []plugs.TargetError{{Plug: "plug-2", Err: errors.New("timeout")}}
+	rec = post(ws.HandleGroup, "/groups/downstairs", "action=off")
+	if rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), "1 of 2 plugs failed: plug-2: timeout") {
+		t.Fatalf("partial failure: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	log := ws.EventLog()
+	if last := log[len(log)-1]; !strings.Contains(last, "Group downstairs → false, 1 of 2 plugs failed") {
+		t.Fatalf("event log = %q", last)
+	}
+}
@@ -0,0 +1,275 @@
+package tasmotahomekit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/events"
+)
+
+// defaultPlugsSeenWindow is how recently a plug must have been heard from
+// to count as reachable when no readiness criteria are set.
+const defaultPlugsSeenWindow = 2 * time.Minute
+
+// requiredComponents must be connected for the bridge to be ready; one of
+// them failing means the process needs a restart.
+var requiredComponents = []string{
+	string(events.ClientMQTT),
+	string(events.ClientHAP),
+	string(events.ClientWeb),
+}
+
+// ReadinessCriteria decides what /health/ready requires on top of the
+// required components being connected.
+type ReadinessCriteria struct {
+	// PlugsSeenPercent of the plugs must have been heard from within
+	// PlugsSeenWindow
+	PlugsSeenPercent int
+	PlugsSeenWindow  time.Duration
+	// RequirePaired requires a HomeKit controller to be paired
+	RequirePaired bool
+	// MQTTSilence is how long the broker may go without a message from a
+	// plug; zero disables the check
+	MQTTSilence time.Duration
+}
+
+// mqttActivity reports when a plug last published, implemented by
+// *MQTTHook.
+type mqttActivity interface {
+	LastMessage() time.Time
+}
+
+// hapPairing reports whether the HAP server runs and is paired, implemented
+// by *HAPManager.
+type hapPairing interface {
+	Paired() (running, paired bool)
+}
+
+// SetReadiness sets the criteria of /health/ready and where the time of the
+// last MQTT message comes from.
+func (ws *WebServer) SetReadiness(criteria ReadinessCriteria, mqtt mqttActivity) {
+	ws.healthMu.Lock()
+	defer ws.healthMu.Unlock()
+	ws.readiness = criteria
+	ws.mqttActivity = mqtt
+}
+
+// healthReport is the body of /health/live and /health/ready.
+type healthReport struct {
+	Status     string                     `json:"status"`
+	Components map[string]componentHealth `json:"components"`
+	Plugs      *plugsHealth               `json:"plugs,omitempty"`
+	Timestamp  time.Time                  `json:"timestamp"`
+
+	live  bool
+	ready bool
+}
+
+type componentHealth struct {
+	Status   string `json:"status"`
+	Required bool   `json:"required"`
+	Ready    bool   `json:"ready"`
+	// Reason explains why the component is not ready
+	Reason string     `json:"reason,omitempty"`
+	Error  string     `json:"error,omitempty"`
+	Since  *time.Time `json:"since,omitempty"`
+	// Paired is set for hap once the server runs
+	Paired *bool `json:"paired,omitempty"`
+	// LastMessage is set for mqtt once a plug has published
+	LastMessage *time.Time `json:"last_message,omitempty"`
+}
+
+type plugsHealth struct {
+	Ready           bool         `json:"ready"`
+	Reason          string       `json:"reason,omitempty"`
+	Seen            int          `json:"seen"`
+	Total           int          `json:"total"`
+	RequiredPercent int          `json:"required_percent"`
+	Window          string       `json:"window"`
+	Plugs           []plugHealth `json:"plugs"`
+}
+
+type plugHealth struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Reachable bool       `json:"reachable"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+}
+
+// health evaluates the components and plugs. The bridge is live unless a
+// required component failed, and ready once the required components are
+// connected and the readiness criteria are met.
+func (ws *WebServer) health(now time.Time) healthReport {
+	ws.healthMu.RLock()
+	criteria := ws.readiness
+	activity := ws.mqttActivity
+	ws.healthMu.RUnlock()
+	if criteria.PlugsSeenWindow <= 0 {
+		criteria.PlugsSeenWindow = defaultPlugsSeenWindow
+	}
+
+	report := healthReport{
+		Components: make(map[string]componentHealth),
+		Timestamp:  now,
+		live:       true,
+		ready:      true,
+	}
+
+	for _, evt := range ws.snapshotStatuses() {
+		since := evt.Timestamp
+		report.Components[evt.Component] = componentHealth{
+			Status: string(evt.Status),
+			Ready:  evt.Status == events.ConnectionStatusConnected,
+			Error:  evt.Error,
+			Since:  &since,
+		}
+	}
+	for _, name := range requiredComponents {
+		c, ok := report.Components[name]
+		if !ok {
+			c = componentHealth{Status: "unknown", Reason: "not started"}
+		}
+		c.Required = true
+		if !c.Ready && c.Reason == "" {
+			c.Reason = fmt.Sprintf("%s, not %s", c.Status, events.ConnectionStatusConnected)
+		}
+		if c.Status == string(events.ConnectionStatusFailed) {
+			report.live = false
+		}
+		report.Components[name] = c
+	}
+
+	mqtt := report.Components[string(events.ClientMQTT)]
+	if activity != nil {
+		if last := activity.LastMessage(); !last.IsZero() {
+			mqtt.LastMessage = &last
+		}
+		// Until a plug publishes, the broker counts as quiet since it started
+		if criteria.MQTTSilence > 0 && mqtt.Ready {
+			quietSince := *mqtt.Since
+			if mqtt.LastMessage != nil && mqtt.LastMessage.After(quietSince) {
+				quietSince = *mqtt.LastMessage
+			}
+			if quiet := now.Sub(quietSince); quiet > criteria.MQTTSilence {
+				mqtt.Ready = false
+				mqtt.Reason = fmt.Sprintf("no message from a plug for %s", quiet.Round(time.Second))
+			}
+		}
+	}
+	report.Components[string(events.ClientMQTT)] = mqtt
+
+	hap := report.Components[string(events.ClientHAP)]
+	if ws.pairing != nil {
+		if running, paired := ws.pairing.Paired(); running {
+			hap.Paired = &paired
+		}
+	}
+	if criteria.RequirePaired && hap.Ready && (hap.Paired == nil || !*hap.Paired) {
+		hap.Ready = false
+		hap.Reason = "not paired with HomeKit"
+	}
+	report.Components[string(events.ClientHAP)] = hap
+
+	for _, c := range report.Components {
+		if c.Required && !c.Ready {
+			report.ready = false
+		}
+	}
+
+	report.Plugs = ws.plugsHealth(now, criteria)
+	if !report.Plugs.Ready {
+		report.ready = false
+	}
+
+	return report
+}
+
+// plugsHealth counts the plugs heard from within the window. Plugs that are
+// offline, or only known from the state saved before a restart, are not
+// reachable.
+func (ws *WebServer) plugsHealth(now time.Time, criteria ReadinessCriteria) *plugsHealth {
+	snapshot := ws.plugProvider.Snapshot()
+	h := &plugsHealth{
+		Total:           len(snapshot),
+		RequiredPercent: criteria.PlugsSeenPercent,
+		Window:          criteria.PlugsSeenWindow.String(),
+		Plugs:           make([]plugHealth, 0, len(snapshot)),
+	}
+	for id, item := range snapshot {
+		p := plugHealth{ID: id, Name: item.Plug.Name}
+		if state := item.State; !state.LastSeen.IsZero() {
+			lastSeen := state.LastSeen
+			p.LastSeen = &lastSeen
+			p.Reachable = !state.Offline && !state.Restored && now.Sub(lastSeen) <= criteria.PlugsSeenWindow
+		}
+		if p.Reachable {
+			h.Seen++
+		}
+		h.Plugs = append(h.Plugs, p)
+	}
+	sort.Slice(h.Plugs, func(i, j int) bool {
+		return h.Plugs[i].ID < h.Plugs[j].ID
+	})
+
+	h.Ready = h.Seen*100 >= criteria.PlugsSeenPercent*h.Total
+	if !h.Ready {
+		h.Reason = fmt.Sprintf("%d of %d plugs seen in the last %s, %d%% required",
+			h.Seen, h.Total, criteria.PlugsSeenWindow, criteria.PlugsSeenPercent)
+	}
+	return h
+}
+
+// Live reports whether none of the required components has failed.
+func (ws *WebServer) Live() bool {
+	return ws.health(time.Now()).live
+}
+
+// HandleHealthLive answers 503 when a required component has failed and
+// only a restart helps, for liveness probes.
+func (ws *WebServer) HandleHealthLive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report := ws.health(time.Now())
+	report.Plugs = nil
+	report.Status = "ok"
+	status := http.StatusOK
+	if !report.live {
+		report.Status = "failed"
+		status = http.StatusServiceUnavailable
+	}
+	ws.writeHealth(w, status, report)
+}
+
+// HandleHealthReady answers 503 with the reasons per component when the
+// bridge is degraded, for uptime checks.
+func (ws *WebServer) HandleHealthReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report := ws.health(time.Now())
+	report.Status = "ok"
+	status := http.StatusOK
+	if !report.ready {
+		report.Status = "degraded"
+		status = http.StatusServiceUnavailable
+	}
+	ws.writeHealth(w, status, report)
+}
+
+func (ws *WebServer) writeHealth(w http.ResponseWriter, status int, report healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		ws.logger.Error("Failed to write health response", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,229 @@
+package tasmotahomekit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/kradalby/tasmota-homekit/plugs"
+)
+
+type fakeMQTTActivity struct{ last time.Time }
+
+func (f fakeMQTTActivity) LastMessage() time.Time { return f.last }
+
+type fakePairing struct{ running, paired bool }
+
+func (f fakePairing) Paired() (bool, bool) { return f.running, f.paired }
+
+// setStatus records a component status as if it came over the event bus.
+func setStatus(ws *WebServer, component string, status events.ConnectionStatus, since time.Time) {
+	ws.statusMu.Lock()
+	defer ws.statusMu.Unlock()
+	ws.connectionState[component] = events.ConnectionStatusEvent{
+		Timestamp: since,
+		Component: component,
+		Status:    status,
+	}
+}
+
+func getHealth(t *testing.T, handler http.HandlerFunc, path string) (int, healthReport) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var report healthReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("health response invalid json: %v: %s", err, rec.Body.String())
+	}
+	return rec.Code, report
+}
+
+func TestHealthLive(t *testing.T) {
+	ws, _, _, _ := newTestWebServer(t)
+
+	// Still starting up is live
+	code, report := getHealth(t, ws.HandleHealthLive, "/health/live")
+	if code != http.StatusOK || report.Status != "ok" {
+		t.Fatalf("live while starting = %d %q, want 200 ok", code, report.Status)
+	}
+	if report.Plugs != nil {
+		t.Fatalf("liveness should not list plugs, got %+v", report.Plugs)
+	}
+
+	now := time.Now()
+	setStatus(ws, "mqtt", events.ConnectionStatusFailed, now)
+	setStatus(ws, "hap", events.ConnectionStatusConnected, now)
+	// Optional components failing do not matter
+	setStatus(ws, "homeassistant", events.ConnectionStatusFailed, now)
+
+	code, report = getHealth(t, ws.HandleHealthLive, "/health/live")
+	if code != http.StatusServiceUnavailable || report.Status != "failed" {
+		t.Fatalf("live with failed broker = %d %q, want 503 failed", code, report.Status)
+	}
+	if c := report.Components["mqtt"]; c.Status != "failed" || !c.Required {
+		t.Fatalf("mqtt component = %+v", c)
+	}
+	if c := report.Components["homeassistant"]; c.Required {
+		t.Fatalf("homeassistant should not be required: %+v", c)
+	}
+	if ws.Live() {
+		t.Fatal("Live() = true with a failed broker")
+	}
+
+	rec := httptest.NewRecorder()
+	ws.HandleHealthLive(rec, httptest.NewRequest(http.MethodPost, "/health/live", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST status = %d, want 405", rec.Code)
+	}
+}
+
+func TestHealthReady(t *testing.T) {
+	ws, provider, _, _ := newTestWebServer(t)
+	now := time.Now()
+	provider.items = map[string]struct {
+		Plug  plugs.Plug
+		State plugs.State
+	}{
+		"desk":    {Plug: plugs.Plug{ID: "desk", Name: "Desk"}, State: plugs.State{LastSeen: now.Add(-30 * time.Second)}},
+		"kettle":  {Plug: plugs.Plug{ID: "kettle", Name: "Kettle"}, State: plugs.State{LastSeen: now.Add(-10 * time.Second), Offline: true}},
+		"heater":  {Plug: plugs.Plug{ID: "heater", Name: "Heater"}, State: plugs.State{LastSeen: now.Add(-10 * time.Minute)}},
+		"unknown": {Plug: plugs.Plug{ID: "unknown", Name: "Unknown"}},
+	}
+
+	code, report := getHealth(t, ws.HandleHealthReady, "/health/ready")
+	if code != http.StatusServiceUnavailable || report.Status != "degraded" {
+		t.Fatalf("ready before startup = %d %q, want 503 degraded", code, report.Status)
+	}
+	if c := report.Components["hap"]; c.Status != "unknown" || c.Reason != "not started" {
+		t.Fatalf("hap component = %+v", c)
+	}
+
+	for _, component := range []string{"mqtt", "hap", "web"} {
+		setStatus(ws, component, events.ConnectionStatusConnected, now.Add(-time.Hour))
+	}
+	code, report = getHealth(t, ws.HandleHealthReady, "/health/ready")
+	if code != http.StatusOK || report.Status != "ok" {
+		t.Fatalf("ready = %d %+v, want 200 ok", code, report)
+	}
+	if p := report.Plugs; p.Seen != 1 || p.Total != 4 || p.Window != "2m0s" {
+		t.Fatalf("plugs = %+v, want 1 of 4 seen in 2m0s", p)
+	}
+	reachable := map[string]bool{}
+	for _, p := range report.Plugs.Plugs {
+		reachable[p.ID] = p.Reachable
+	}
+	if !reachable["desk"] || reachable["kettle"] || reachable["heater"] || reachable["unknown"] {
+		t.Fatalf("reachable plugs = %v, want only desk", reachable)
+	}
+
+	ws.SetReadiness(ReadinessCriteria{PlugsSeenPercent: 50, PlugsSeenWindow: 15 * time.Minute}, nil)
+	code, report = getHealth(t, ws.HandleHealthReady, "/health/ready")
+	if code != http.StatusOK || report.Plugs.Seen != 2 {
+		t.Fatalf("ready with 2 of 4 plugs seen = %d %+v, want 200", code, report.Plugs)
+	}
+
+	ws.SetReadiness(ReadinessCriteria{PlugsSeenPercent: 50}, nil)
+	code, report = getHealth(t, ws.HandleHealthReady, "/health/ready")
+	if code != http.StatusServiceUnavailable || report.Plugs.Ready {
+		t.Fatalf("ready with 1 of 4 plugs seen = %d %+v, want 503", code, report.Plugs)
+	}
+	if !strings.Contains(report.Plugs.Reason, "1 of 4 plugs seen in the last 2m0s, 50% required") {
+		t.Fatalf("plugs reason = %q", report.Plugs.Reason)
+	}
+
+	setStatus(ws, "hap", events.ConnectionStatusReconnecting, now)
+	ws.SetReadiness(ReadinessCriteria{}, nil)
+	code, report = getHealth(t, ws.HandleHealthReady, "/health/ready")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("ready while HAP restarts = %d, want 503", code)
+	}
+	if c := report.Components["hap"]; c.Reason != "reconnecting, not connected" {
+		t.Fatalf("hap component = %+v", c)
+	}
+}
+
+func TestHealthReadyAfterStartup(t *testing.T) {
+	bus, err := events.New(testLogger())
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	t.Cleanup(func() { _ = bus.Close() })
+
+	// The broker and HAP server report in before the web server exists,
+	// as they do in Main
+	for _, name := range []events.ClientName{events.ClientMQTT, events.ClientHAP} {
+		client, err := bus.Client(name)
+		if err != nil {
+			t.Fatalf("Client(%s) error = %v", name, err)
+		}
+		for _, status := range []events.ConnectionStatus{events.ConnectionStatusConnecting, events.ConnectionStatusConnected} {
+			bus.PublishConnectionStatus(client, events.ConnectionStatusEvent{
+				Timestamp: time.Now(),
+				Component: string(name),
+				Status:    status,
+			})
+		}
+	}
+
+	ws := NewWebServer(testLogger(), newFakePlugProvider(), &mockPlugController{}, bus, nil, "00102003", "QR", nil)
+	t.Cleanup(ws.Close)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	ws.Start(ctx)
+	// What the web listener reports once it serves
+	ws.publishConnectionStatus(events.ConnectionStatusConnected, "")
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		code, report := getHealth(t, ws.HandleHealthReady, "/health/ready")
+		if code == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("ready after startup = %d %+v, want 200", code, report.Components)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHealthReadyPairingAndMQTTSilence(t *testing.T) {
+	ws, _, _, _ := newTestWebServer(t)
+	now := time.Now()
+	for _, component := range []string{"mqtt", "hap", "web"} {
+		setStatus(ws, component, events.ConnectionStatusConnected, now.Add(-time.Hour))
+	}
+
+	ws.SetReadiness(ReadinessCriteria{RequirePaired: true, MQTTSilence: 5 * time.Minute}, fakeMQTTActivity{last: now.Add(-10 * time.Minute)})
+	ws.pairing = fakePairing{running: true}
+	code, report := getHealth(t, ws.HandleHealthReady, "/health/ready")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("ready unpaired and quiet = %d, want 503", code)
+	}
+	hap := report.Components["hap"]
+	if hap.Paired == nil || *hap.Paired || hap.Reason != "not paired with HomeKit" {
+		t.Fatalf("hap component = %+v", hap)
+	}
+	mqtt := report.Components["mqtt"]
+	if mqtt.LastMessage == nil || mqtt.Ready || !strings.HasPrefix(mqtt.Reason, "no message from a plug for 10m") {
+		t.Fatalf("mqtt component = %+v", mqtt)
+	}
+
+	ws.pairing = fakePairing{running: true, paired: true}
+	ws.SetReadiness(ReadinessCriteria{RequirePaired: true, MQTTSilence: 5 * time.Minute}, fakeMQTTActivity{last: now.Add(-time.Minute)})
+	if code, report := getHealth(t, ws.HandleHealthReady, "/health/ready"); code != http.StatusOK {
+		t.Fatalf("ready paired and recently heard = %d %+v, want 200", code, report)
+	}
+
+	// A broker that just started gets the silence period to hear from a plug
+	setStatus(ws, "mqtt", events.ConnectionStatusConnected, now.Add(-time.Minute))
+	ws.SetReadiness(ReadinessCriteria{MQTTSilence: 5 * time.Minute}, fakeMQTTActivity{})
+	if code, report := getHealth(t, ws.HandleHealthReady, "/health/ready"); code != http.StatusOK {
+		t.Fatalf("ready right after broker start = %d %+v, want 200", code, report)
+	}
+}
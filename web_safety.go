@@ -0,0 +1,94 @@
+package tasmotahomekit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/chasefleming/elem-go"
+	"github.com/chasefleming/elem-go/attrs"
+	"github.com/kradalby/tasmota-homekit/auth"
+)
+
+// processAlerts keeps the latest safety alert of every plug for the banner
+// on the dashboard and tells browsers to reload to show it.
+func (ws *WebServer) processAlerts(ctx context.Context) {
+	for {
+		select {
+		case event := <-ws.alertSubscriber.Events():
+			ws.alertsMu.Lock()
+			ws.alerts[event.PlugID] = event
+			ws.alertsMu.Unlock()
+
+			ws.LogEvent(fmt.Sprintf("Safety: %s switched off, %s", ws.relayLabel(event.PlugID, event.Relay), event.Reason))
+			ws.broadcastSSE(sseMessage{event: "alert", data: event})
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// renderAlerts renders a banner with the safety alerts that have not been
+// dismissed, or nil without any. Operators get a button to dismiss them.
+func (ws *WebServer) renderAlerts(role auth.Role) elem.Node {
+	ws.alertsMu.RLock()
+	plugIDs := make([]string, 0, len(ws.alerts))
+	for id := range ws.alerts {
+		plugIDs = append(plugIDs, id)
+	}
+	sort.Strings(plugIDs)
+
+	items := make([]elem.Node, 0, len(plugIDs))
+	for _, id := range plugIDs {
+		alert := ws.alerts[id]
+		children := []elem.Node{
+			elem.Text(fmt.Sprintf(
+				"%s %s was switched off: %s",
+				alert.Timestamp.Format("15:04"),
+				ws.relayLabel(alert.PlugID, alert.Relay),
+				alert.Reason,
+			)),
+		}
+		if role >= auth.RoleOperator {
+			children = append(children, elem.Form(
+				attrs.Props{attrs.Method: "post", attrs.Action: "/alerts/dismiss", attrs.Class: "inline-form"},
+				elem.Input(attrs.Props{attrs.Type: "hidden", attrs.Name: "plug", attrs.Value: id}),
+				elem.Button(attrs.Props{attrs.Type: "submit"}, elem.Text("Dismiss")),
+			))
+		}
+		items = append(items, elem.Li(attrs.Props{"data-plug-id": id}, children...))
+	}
+	ws.alertsMu.RUnlock()
+
+	if len(items) == 0 {
+		return nil
+	}
+	return elem.Div(
+		attrs.Props{attrs.Class: "alert-banner", "data-role": "safety-alerts"},
+		elem.Strong(attrs.Props{}, elem.Text("Safety alerts")),
+		elem.Ul(attrs.Props{}, items...),
+	)
+}
+
+// HandleDismissAlert removes the safety alert of the plug given by the
+// "plug" form value from the dashboard.
+func (ws *WebServer) HandleDismissAlert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	plugID := r.FormValue("plug")
+	ws.alertsMu.Lock()
+	_, ok := ws.alerts[plugID]
+	delete(ws.alerts, plugID)
+	ws.alertsMu.Unlock()
+	if !ok {
+		http.Error(w, "Alert not found", http.StatusNotFound)
+		return
+	}
+
+	ws.LogEvent(fmt.Sprintf("%s: Dismissed safety alert of %s", identity(r.Context()).Source("web"), plugID))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,59 @@
+package tasmotahomekit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/auth"
+	"github.com/kradalby/tasmota-homekit/events"
+)
+
+func TestSafetyAlerts(t *testing.T) {
+	ws, _, _, _ := newTestWebServer(t)
+
+	if ws.renderAlerts(auth.RoleAdmin) != nil {
+		t.Fatal("banner rendered without alerts")
+	}
+
+	ws.alerts["plug-1"] = events.AlertEvent{
+		Timestamp: time.Date(2025, 3, 3, 18, 5, 0, 0, time.UTC),
+		PlugID:    "plug-1",
+		Rule:      "max_power",
+		Reason:    "drawing 2300 W, limit 2000 W",
+	}
+
+	rec := httptest.NewRecorder()
+	ws.HandleIndex(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := rec.Body.String()
+	for _, want := range []string{`data-role="safety-alerts"`, "18:05 Test Plug was switched off: drawing 2300 W, limit 2000 W", `action="/alerts/dismiss"`} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("index missing %q: %s", want, body)
+		}
+	}
+	if banner := ws.renderAlerts(auth.RoleViewer).Render(); strings.Contains(banner, "Dismiss") {
+		t.Fatalf("viewer can dismiss alerts: %s", banner)
+	}
+
+	dismiss := func(method, plugID string) int {
+		req := httptest.NewRequest(method, "/alerts/dismiss", strings.NewReader("plug="+plugID))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		ws.HandleDismissAlert(rec, req)
+		return rec.Code
+	}
+	if code := dismiss(http.MethodGet, "plug-1"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET status = %d; want 405", code)
+	}
+	if code := dismiss(http.MethodPost, "plug-2"); code != http.StatusNotFound {
+		t.Fatalf("unknown alert status = %d; want 404", code)
+	}
+	if code := dismiss(http.MethodPost, "plug-1"); code != http.StatusSeeOther {
+		t.Fatalf("dismiss status = %d; want 303", code)
+	}
+	if len(ws.alerts) != 0 {
+		t.Fatalf("alert not dismissed: %+v", ws.alerts)
+	}
+}
@@ -0,0 +1,333 @@
+package tasmotahomekit
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chasefleming/elem-go"
+	"github.com/chasefleming/elem-go/attrs"
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/kradalby/tasmota-homekit/schedule"
+)
+
+// scheduleService runs schedules and timers.
+type scheduleService interface {
+	Schedules() []schedule.Status
+	Timers() []schedule.Timer
+	Upcoming(n int) []schedule.Run
+	Away() bool
+	SetAway(away bool)
+	SetEnabled(id string, enabled bool) error
+	Skip(id string, skip bool) error
+	AddTimer(plugID string, relay int, on bool, after time.Duration) (schedule.Timer, error)
+	CancelTimer(id string) bool
+}
+
+// upcomingRuns is how many runs the schedules page lists.
+const upcomingRuns = 20
+
+// SetSchedule enables the /schedules page.
+func (ws *WebServer) SetSchedule(s scheduleService) {
+	ws.schedule = s
+}
+
+// HandleSchedules lists the upcoming runs, the configured schedules and the
+// pending timers, with forms to change them.
+func (ws *WebServer) HandleSchedules(w http.ResponseWriter, r *http.Request) {
+	if ws.schedule == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	away := ws.schedule.Away()
+	awayLabel, awayValue := "Away mode is off", "on"
+	if away {
+		awayLabel, awayValue = "Away mode is on", "off"
+	}
+	awaySection := elem.Form(
+		attrs.Props{attrs.Method: "post", attrs.Action: "/schedules/away", "data-role": "away-mode"},
+		elem.Span(attrs.Props{}, elem.Text(awayLabel+" ")),
+		elem.Input(attrs.Props{attrs.Type: "hidden", attrs.Name: "away", attrs.Value: awayValue}),
+		elem.Button(attrs.Props{attrs.Type: "submit"}, elem.Text("Turn "+awayValue)),
+	)
+
+	runRows := []elem.Node{
+		elem.Tr(
+			attrs.Props{},
+			elem.Th(attrs.Props{}, elem.Text("When")),
+			elem.Th(attrs.Props{}, elem.Text("Plug")),
+			elem.Th(attrs.Props{}, elem.Text("Action")),
+			elem.Th(attrs.Props{}, elem.Text("From")),
+			elem.Th(attrs.Props{}, elem.Text("")),
+		),
+	}
+	for _, run := range ws.schedule.Upcoming(upcomingRuns) {
+		from, note := run.ScheduleID, ""
+		if run.TimerID != "" {
+			from = "timer"
+		}
+		switch {
+		case run.Skipped:
+			note = "skipped"
+		case run.Away && !away:
+			note = "away mode only"
+		}
+		runRows = append(runRows, elem.Tr(
+			attrs.Props{"data-role": "upcoming-run"},
+			elem.Td(attrs.Props{}, elem.Text(run.At.Format("Mon 2 Jan 15:04"))),
+			elem.Td(attrs.Props{}, elem.Text(ws.targetLabel(run.PlugID, run.GroupID, run.Relay))),
+			elem.Td(attrs.Props{}, elem.Text(onOff(run.On))),
+			elem.Td(attrs.Props{}, elem.Text(from)),
+			elem.Td(attrs.Props{}, elem.Text(note)),
+		))
+	}
+
+	scheduleRows := []elem.Node{
+		elem.Tr(
+			attrs.Props{},
+			elem.Th(attrs.Props{}, elem.Text("ID")),
+			elem.Th(attrs.Props{}, elem.Text("Plug")),
+			elem.Th(attrs.Props{}, elem.Text("Action")),
+			elem.Th(attrs.Props{}, elem.Text("When")),
+			elem.Th(attrs.Props{}, elem.Text("Next")),
+			elem.Th(attrs.Props{}, elem.Text("")),
+		),
+	}
+	for _, status := range ws.schedule.Schedules() {
+		next := "never"
+		if !status.Enabled {
+			next = "disabled"
+		} else if !status.Next.IsZero() {
+			next = status.Next.Format("Mon 2 Jan 15:04")
+		}
+
+		toggle, toggleLabel := "disable", "Disable"
+		if !status.Enabled {
+			toggle, toggleLabel = "enable", "Enable"
+		}
+		buttons := []elem.Node{scheduleButton("/schedules/"+toggle, status.ID, toggleLabel)}
+		if status.Enabled {
+			if status.Skip {
+				buttons = append(buttons, scheduleButton("/schedules/unskip", status.ID, "Don't skip"))
+			} else {
+				buttons = append(buttons, scheduleButton("/schedules/skip", status.ID, "Skip next"))
+			}
+		}
+
+		scheduleRows = append(scheduleRows, elem.Tr(
+			attrs.Props{attrs.ID: "schedule-" + status.ID},
+			elem.Td(attrs.Props{}, elem.Text(status.ID)),
+			elem.Td(attrs.Props{}, elem.Text(ws.targetLabel(status.Plug, status.Group, status.Relay))),
+			elem.Td(attrs.Props{}, elem.Text(status.Action)),
+			elem.Td(attrs.Props{}, elem.Text(describeSchedule(status.Schedule))),
+			elem.Td(attrs.Props{}, elem.Text(next)),
+			elem.Td(attrs.Props{}, buttons...),
+		))
+	}
+
+	var timerItems []elem.Node
+	for _, timer := range ws.schedule.Timers() {
+		timerItems = append(timerItems, elem.Li(
+			attrs.Props{attrs.ID: timer.ID},
+			elem.Text(fmt.Sprintf(
+				"%s %s at %s ",
+				ws.relayLabel(timer.PlugID, timer.Relay),
+				onOff(timer.On),
+				timer.At.Format("15:04"),
+			)),
+			scheduleButton("/schedules/cancel", timer.ID, "Cancel"),
+		))
+	}
+	if len(timerItems) == 0 {
+		timerItems = append(timerItems, elem.Li(attrs.Props{}, elem.Text("No timers pending.")))
+	}
+
+	content := elem.Div(
+		attrs.Props{},
+		elem.H1(attrs.Props{}, elem.Text("Schedules")),
+		elem.P(attrs.Props{}, elem.A(attrs.Props{attrs.Href: "/"}, elem.Text("Back to plugs"))),
+		awaySection,
+		elem.H2(attrs.Props{}, elem.Text("Upcoming")),
+		elem.Table(attrs.Props{attrs.Class: "schedule-table"}, runRows...),
+		elem.H2(attrs.Props{}, elem.Text("Timers")),
+		elem.Ul(attrs.Props{}, timerItems...),
+		ws.renderTimerForm(),
+		elem.H2(attrs.Props{}, elem.Text("Schedules")),
+		elem.Table(attrs.Props{attrs.Class: "schedule-table"}, scheduleRows...),
+	)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := fmt.Fprint(w, ws.renderPage("Schedules", content)); err != nil {
+		ws.logger.Error("Failed to write schedules response", slog.Any("error", err))
+	}
+}
+
+// renderTimerForm renders the form starting a one-shot timer.
+func (ws *WebServer) renderTimerForm() elem.Node {
+	snapshot := ws.plugProvider.Snapshot()
+	ids := make([]string, 0, len(snapshot))
+	for id, item := range snapshot {
+		if item.Plug.Web != nil && !*item.Plug.Web {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	options := make([]elem.Node, 0, len(ids))
+	for _, id := range ids {
+		options = append(options, elem.Option(attrs.Props{attrs.Value: id}, elem.Text(snapshot[id].Plug.Name)))
+	}
+
+	return elem.Form(
+		attrs.Props{attrs.Method: "post", attrs.Action: "/schedules/timer", attrs.Class: "timer-form"},
+		elem.Text("Turn "),
+		elem.Select(attrs.Props{attrs.Name: "plug"}, options...),
+		elem.Text(" "),
+		elem.Select(
+			attrs.Props{attrs.Name: "action"},
+			elem.Option(attrs.Props{attrs.Value: "off"}, elem.Text("off")),
+			elem.Option(attrs.Props{attrs.Value: "on"}, elem.Text("on")),
+		),
+		elem.Text(" in "),
+		elem.Input(attrs.Props{attrs.Type: "number", attrs.Name: "minutes", attrs.Value: "30", "min": "1"}),
+		elem.Text(" minutes "),
+		elem.Button(attrs.Props{attrs.Type: "submit"}, elem.Text("Start timer")),
+	)
+}
+
+// HandleScheduleAction changes schedules and timers from the schedules page:
+// /schedules/timer, cancel, away, enable, disable, skip and unskip.
+func (ws *WebServer) HandleScheduleAction(w http.ResponseWriter, r *http.Request) {
+	if ws.schedule == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.FormValue("id")
+	source := identity(r.Context()).Source("web")
+	var err error
+	switch action := strings.TrimPrefix(r.URL.Path, "/schedules/"); action {
+	case "timer":
+		plugID := r.FormValue("plug")
+		on := r.FormValue("action") == "on"
+		minutes, convErr := strconv.Atoi(r.FormValue("minutes"))
+		if convErr != nil || minutes < 1 {
+			http.Error(w, "Invalid minutes", http.StatusBadRequest)
+			return
+		}
+		relay, _ := strconv.Atoi(r.FormValue("relay"))
+
+		var timer schedule.Timer
+		timer, err = ws.schedule.AddTimer(plugID, relay, on, time.Duration(minutes)*time.Minute)
+		if err == nil {
+			ws.LogEvent(fmt.Sprintf("%s: Timer turns %s %s at %s", source, plugID, onOff(on), timer.At.Format("15:04")))
+		}
+	case "cancel":
+		if !ws.schedule.CancelTimer(id) {
+			http.Error(w, "Timer not found", http.StatusNotFound)
+			return
+		}
+		ws.LogEvent(fmt.Sprintf("%s: Cancelled %s", source, id))
+	case "away":
+		away := r.FormValue("away") == "on"
+		ws.schedule.SetAway(away)
+		ws.LogEvent(fmt.Sprintf("%s: Away mode %s", source, onOff(away)))
+	case "enable", "disable":
+		if err = ws.schedule.SetEnabled(id, action == "enable"); err == nil {
+			ws.LogEvent(fmt.Sprintf("%s: Schedule %s %sd", source, id, action))
+		}
+	case "skip", "unskip":
+		if err = ws.schedule.Skip(id, action == "skip"); err == nil {
+			ws.LogEvent(fmt.Sprintf("%s: Schedule %s next run %sped", source, id, action))
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/schedules", http.StatusSeeOther)
+}
+
+// relayLabel names a plug, or one of its relays.
+func (ws *WebServer) relayLabel(plugID string, relay int) string {
+	name := plugID
+	if plug, _, ok := ws.plugProvider.Plug(plugID); ok {
+		name = plug.Name
+	}
+	if relay > 0 {
+		return fmt.Sprintf("%s relay %d", name, relay)
+	}
+	return name
+}
+
+// targetLabel names the plug, relay or group a schedule switches.
+func (ws *WebServer) targetLabel(plugID, groupID string, relay int) string {
+	if groupID == "" {
+		return ws.relayLabel(plugID, relay)
+	}
+	name := groupID
+	if ws.groups != nil {
+		for _, group := range ws.groups.Groups() {
+			if group.ID == groupID {
+				name = group.Name
+			}
+		}
+	}
+	return name + " (group)"
+}
+
+// describeSchedule returns when a schedule runs, as configured.
+func describeSchedule(s plugs.Schedule) string {
+	var b strings.Builder
+	if s.Cron != "" {
+		b.WriteString("cron " + s.Cron)
+	} else {
+		b.WriteString(s.Sun)
+		if s.Offset != "" {
+			b.WriteString(" " + s.Offset)
+		}
+		if len(s.Days) > 0 {
+			b.WriteString(" on " + strings.Join(s.Days, ", "))
+		}
+	}
+	if s.Jitter != "" {
+		b.WriteString(" ±" + s.Jitter)
+	}
+	if s.Away {
+		b.WriteString(" (away)")
+	}
+	return b.String()
+}
+
+func scheduleButton(action, id, label string) elem.Node {
+	return elem.Form(
+		attrs.Props{attrs.Method: "post", attrs.Action: action, attrs.Class: "inline-form"},
+		elem.Input(attrs.Props{attrs.Type: "hidden", attrs.Name: "id", attrs.Value: id}),
+		elem.Button(attrs.Props{attrs.Type: "submit"}, elem.Text(label)),
+	)
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
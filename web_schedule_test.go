@@ -0,0 +1,73 @@
+package tasmotahomekit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/kradalby/tasmota-homekit/schedule"
+)
+
+func TestHandleSchedules(t *testing.T) {
+	ws, _, _, _ := newTestWebServer(t)
+
+	rec := httptest.NewRecorder()
+	ws.HandleSchedules(rec, httptest.NewRequest(http.MethodGet, "/schedules", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status without scheduler = %d; want 404", rec.Code)
+	}
+
+	scheduler := schedule.New(make(chan plugs.CommandEvent, 1))
+	scheduler.Update(&plugs.Config{
+		Plugs:  []plugs.Plug{{ID: "plug-1", Name: "Plug 1", Address: "1"}},
+		Groups: []plugs.Group{{ID: "downstairs", Name: "Downstairs", Plugs: []string{"plug-1"}}},
+		Schedules: []plugs.Schedule{
+			{ID: "evening", Plug: "plug-1", Action: "on", Cron: "0 18 * * *", Jitter: "10m"},
+			{ID: "night", Group: "downstairs", Action: "off", Cron: "0 23 * * *"},
+		},
+	})
+	ws.SetSchedule(scheduler)
+	ws.SetGroups(&fakeGroupService{})
+
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		ws.HandleScheduleAction(rec, req)
+		return rec
+	}
+
+	if rec := post("/schedules/timer", url.Values{"plug": {"plug-1"}, "action": {"off"}, "minutes": {"30"}}); rec.Code != http.StatusSeeOther {
+		t.Fatalf("timer status = %d; want 303: %s", rec.Code, rec.Body.String())
+	}
+	if rec := post("/schedules/timer", url.Values{"plug": {"missing"}, "minutes": {"30"}}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("timer for unknown plug status = %d; want 400", rec.Code)
+	}
+	if rec := post("/schedules/skip", url.Values{"id": {"evening"}}); rec.Code != http.StatusSeeOther {
+		t.Fatalf("skip status = %d; want 303", rec.Code)
+	}
+	if rec := post("/schedules/disable", url.Values{"id": {"missing"}}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("disable unknown schedule status = %d; want 400", rec.Code)
+	}
+	if rec := post("/schedules/away", url.Values{"away": {"on"}}); rec.Code != http.StatusSeeOther || !scheduler.Away() {
+		t.Fatalf("away status = %d, away = %v", rec.Code, scheduler.Away())
+	}
+	if len(scheduler.Timers()) != 1 || !scheduler.Schedules()[0].Skip {
+		t.Fatalf("actions not applied: timers %+v, schedules %+v", scheduler.Timers(), scheduler.Schedules())
+	}
+
+	rec = httptest.NewRecorder()
+	ws.HandleSchedules(rec, httptest.NewRequest(http.MethodGet, "/schedules", nil))
+	body := rec.Body.String()
+	for _, want := range []string{`id="schedule-evening"`, "cron 0 18 * * * ±10m", "Test Plug off at", "Downstairs (group)", "Away mode is on", "skipped", "Don&#39;t skip"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("schedules page missing %q: %s", want, body)
+		}
+	}
+	if got := strings.Count(body, `data-role="upcoming-run"`); got != 20 {
+		t.Fatalf("upcoming runs = %d; want 20", got)
+	}
+}
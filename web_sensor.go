@@ -0,0 +1,63 @@
+package tasmotahomekit
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/chasefleming/elem-go"
+	"github.com/chasefleming/elem-go/attrs"
+	"github.com/kradalby/tasmota-homekit/plugs"
+)
+
+// sensorFormats are the value formats of the sensor kinds on the dashboard,
+// matching script.js.
+var sensorFormats = map[plugs.SensorKind]string{
+	plugs.SensorTemperature: "%.1f °C",
+	plugs.SensorHumidity:    "%.0f %%",
+	plugs.SensorPressure:    "%.1f hPa",
+	plugs.SensorIlluminance: "%.0f lx",
+}
+
+// renderSensors renders the readings of every sensor the plug reports, or
+// nil without any. Configured sensors are labelled with their name.
+func renderSensors(info plugs.Plug, state plugs.State) elem.Node {
+	if len(state.Sensors) == 0 {
+		return nil
+	}
+
+	names := make(map[string]string, len(info.Sensors))
+	for _, sensor := range info.Sensors {
+		names[sensor.Sensor] = info.SensorName(sensor)
+	}
+
+	var items []elem.Node
+	for _, sensor := range slices.Sorted(maps.Keys(state.Sensors)) {
+		label, ok := names[sensor]
+		if !ok {
+			label = sensor
+		}
+		readings := state.Sensors[sensor]
+		for _, kind := range plugs.SensorKinds {
+			value, ok := readings[kind]
+			if !ok {
+				continue
+			}
+			items = append(items, elem.Div(
+				attrs.Props{attrs.Class: "stat-item"},
+				elem.Span(attrs.Props{attrs.Class: "stat-label"}, elem.Text(label+" "+string(kind)+":")),
+				elem.Span(
+					attrs.Props{
+						attrs.Class:   "stat-value",
+						"data-role":   "sensor-value",
+						"data-sensor": sensor,
+						"data-kind":   string(kind),
+					},
+					elem.Text(fmt.Sprintf(sensorFormats[kind], value)),
+				),
+			))
+		}
+	}
+
+	return elem.Div(attrs.Props{attrs.Class: "electrical-stats sensor-readings"}, items...)
+}
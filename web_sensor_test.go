@@ -0,0 +1,38 @@
+package tasmotahomekit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+)
+
+func TestSensorReadouts(t *testing.T) {
+	ws, provider, _, _ := newTestWebServer(t)
+
+	item := provider.items["plug-1"]
+	item.Plug.Sensors = []plugs.Sensor{{Sensor: "BME280", Name: "Office"}}
+	item.State.Sensors = map[string]plugs.SensorReadings{
+		"BME280":    {plugs.SensorTemperature: 21.34, plugs.SensorHumidity: 45.6, plugs.SensorPressure: 1013.25},
+		"DS18B20-1": {plugs.SensorTemperature: -4.5},
+	}
+	provider.items["plug-1"] = item
+
+	rec := httptest.NewRecorder()
+	ws.HandleIndex(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"Office temperature:", "21.3 °C", "46 %", "1013.2 hPa",
+		"DS18B20-1 temperature:", "-4.5 °C",
+		`data-sensor="DS18B20-1"`, `data-kind="humidity"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("index missing %q: %s", want, body)
+		}
+	}
+	if strings.Index(body, "BME280") > strings.Index(body, "DS18B20-1") {
+		t.Fatal("sensors not sorted by name")
+	}
+}
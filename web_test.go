@@ -12,6 +12,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/kradalby/tasmota-homekit/discovery"
 	"github.com/kradalby/tasmota-homekit/events"
 	"github.com/kradalby/tasmota-homekit/plugs"
 	"github.com/stretchr/testify/assert"
@@ -71,8 +72,11 @@ func testLogger() *slog.Logger {
 }
 
 type mockPlugController struct {
-	setPowerFunc func(ctx context.Context, plugID string, on bool) error
-	refreshFunc  func(ctx context.Context)
+	setPowerFunc      func(ctx context.Context, plugID string, on bool) error
+	setRelayPowerFunc func(ctx context.Context, plugID string, relay int, on bool) error
+	setLightFunc      func(ctx context.Context, plugID string, settings plugs.LightSettings) error
+	getStatusFunc     func(ctx context.Context, plugID string) (*plugs.State, error)
+	refreshFunc       func(ctx context.Context)
 }
 
 func (m *mockPlugController) SetPower(ctx context.Context, plugID string, on bool) error {
@@ -82,6 +86,27 @@ func (m *mockPlugController) SetPower(ctx context.Context, plugID string, on boo
 	return nil
 }
 
+func (m *mockPlugController) SetRelayPower(ctx context.Context, plugID string, relay int, on bool) error {
+	if m.setRelayPowerFunc != nil {
+		return m.setRelayPowerFunc(ctx, plugID, relay, on)
+	}
+	return nil
+}
+
+func (m *mockPlugController) SetLight(ctx context.Context, plugID string, settings plugs.LightSettings) error {
+	if m.setLightFunc != nil {
+		return m.setLightFunc(ctx, plugID, settings)
+	}
+	return nil
+}
+
+func (m *mockPlugController) GetStatus(ctx context.Context, plugID string) (*plugs.State, error) {
+	if m.getStatusFunc != nil {
+		return m.getStatusFunc(ctx, plugID)
+	}
+	return &plugs.State{ID: plugID}, nil
+}
+
 func (m *mockPlugController) RefreshAll(ctx context.Context) {
 	if m.refreshFunc != nil {
 		m.refreshFunc(ctx)
@@ -175,6 +200,111 @@ func TestHandleToggleCallsSetPower(t *testing.T) {
 	}
 }
 
+func TestHandleToggleRelay(t *testing.T) {
+	ws, provider, controller, _ := newTestWebServer(t)
+
+	provider.items["strip"] = struct {
+		Plug  plugs.Plug
+		State plugs.State
+	}{
+		Plug: plugs.Plug{
+			ID:      "strip",
+			Name:    "Power Strip",
+			Address: "1.2.3.5",
+			Relays:  []plugs.Relay{{Name: "Desk"}, {Name: "Monitor"}},
+		},
+		State: plugs.State{ID: "strip", Name: "Power Strip", Relays: []bool{false, true}},
+	}
+
+	var gotRelay int
+	controller.setRelayPowerFunc = func(ctx context.Context, plugID string, relay int, on bool) error {
+		gotRelay = relay
+		if plugID != "strip" || !on {
+			t.Errorf("SetRelayPower(%s, %d, %v)", plugID, relay, on)
+		}
+		return nil
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/toggle/strip", strings.NewReader("relay=2&action=on"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+	rec := httptest.NewRecorder()
+
+	ws.HandleToggle(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200", rec.Code)
+	}
+	if gotRelay != 2 {
+		t.Fatalf("relay = %d, want 2", gotRelay)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `data-relay="2"`) || !strings.Contains(body, "Monitor") {
+		t.Fatalf("response missing relay rows: %s", body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/toggle/strip", strings.NewReader("relay=3&action=on"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+
+	ws.HandleToggle(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d; want 400", rec.Code)
+	}
+}
+
+func TestHandleLight(t *testing.T) {
+	ws, provider, controller, _ := newTestWebServer(t)
+
+	provider.items["bulb"] = struct {
+		Plug  plugs.Plug
+		State plugs.State
+	}{
+		Plug: plugs.Plug{
+			ID:       "bulb",
+			Name:     "Bulb",
+			Address:  "1.2.3.6",
+			Type:     "bulb",
+			Features: &plugs.PlugFeatures{Dimmer: true},
+		},
+		State: plugs.State{ID: "bulb", Name: "Bulb", On: true, Brightness: 20},
+	}
+
+	var got plugs.LightSettings
+	controller.setLightFunc = func(ctx context.Context, plugID string, settings plugs.LightSettings) error {
+		got = settings
+		return nil
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/light/bulb", strings.NewReader("brightness=55"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+	rec := httptest.NewRecorder()
+
+	ws.HandleLight(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200", rec.Code)
+	}
+	if got.Brightness == nil || *got.Brightness != 55 {
+		t.Fatalf("brightness = %v, want 55", got.Brightness)
+	}
+	if !strings.Contains(rec.Body.String(), `data-role="brightness-input"`) {
+		t.Fatalf("response missing brightness slider: %s", rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/light/plug-1", strings.NewReader("brightness=55"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+
+	ws.HandleLight(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d; want 400", rec.Code)
+	}
+}
+
 // flushRecorder wraps httptest.ResponseRecorder so the SSE handler can call
 // Flush. httptest.ResponseRecorder is not safe for concurrent use, so a mutex
 // guards writes against the test goroutine reading the body.
@@ -381,3 +511,62 @@ func TestHandleEventBusDebug(t *testing.T) {
 		t.Fatalf("expected plug info in response: %s", body)
 	}
 }
+
+type fakeDiscovery struct {
+	devices []discovery.Device
+	adopted string
+}
+
+func (f *fakeDiscovery) Unconfigured() []discovery.Device { return f.devices }
+func (f *fakeDiscovery) ScanEnabled() bool                { return false }
+func (f *fakeDiscovery) Scanning() bool                   { return false }
+func (f *fakeDiscovery) StartScan() error                 { return ErrScanDisabled }
+
+func (f *fakeDiscovery) Adopt(mac string) (plugs.Plug, error) {
+	f.adopted = mac
+	return plugs.Plug{ID: "heater", Name: "Heater", Address: "192.168.1.20"}, nil
+}
+
+func TestHandleDiscovery(t *testing.T) {
+	ws, _, _, _ := newTestWebServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/discovery", nil)
+	rec := httptest.NewRecorder()
+	ws.HandleDiscovery(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d; want 404 without discovery", rec.Code)
+	}
+
+	fake := &fakeDiscovery{devices: []discovery.Device{{
+		MAC:      "A4CF12000002",
+		IP:       "192.168.1.20",
+		Hostname: "heater",
+		Relays:   1,
+		Source:   discovery.SourceMQTT,
+	}}}
+	ws.SetDiscovery(fake)
+
+	rec = httptest.NewRecorder()
+	ws.HandleDiscovery(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "A4:CF:12:00:00:02") {
+		t.Fatalf("response missing discovered device: %s", rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/discovery/adopt", strings.NewReader("mac=A4CF12000002"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+	rec = httptest.NewRecorder()
+	ws.HandleDiscoveryAdopt(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200", rec.Code)
+	}
+	if fake.adopted != "A4CF12000002" {
+		t.Fatalf("adopted = %q, want A4CF12000002", fake.adopted)
+	}
+	if !strings.Contains(rec.Body.String(), "Adopted 192.168.1.20 as heater") {
+		t.Fatalf("response missing adoption notice: %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,188 @@
+package tasmotahomekit
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chasefleming/elem-go"
+	"github.com/chasefleming/elem-go/attrs"
+	"github.com/kradalby/tasmota-homekit/webhook"
+)
+
+// webhookService delivers webhooks and keeps their log.
+type webhookService interface {
+	Webhooks() []webhook.Status
+	Deliveries() []webhook.Attempt
+	DeadLetters() []webhook.Delivery
+	Retry(id string) (int, error)
+}
+
+// SetWebhooks enables the /debug/webhooks page.
+func (ws *WebServer) SetWebhooks(w webhookService) {
+	ws.webhooks = w
+}
+
+// HandleWebhooksDebug lists the configured webhooks, the delivery log and
+// the dead-letter queue, with forms to retry dead letters.
+func (ws *WebServer) HandleWebhooksDebug(w http.ResponseWriter, r *http.Request) {
+	if ws.webhooks == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	table := attrs.Props{"border": "1", "cellpadding": "4", "cellspacing": "0"}
+	orAll := func(values []string) string {
+		if len(values) == 0 {
+			return "all"
+		}
+		return strings.Join(values, ", ")
+	}
+
+	hookRows := []elem.Node{
+		elem.Tr(
+			attrs.Props{},
+			elem.Th(attrs.Props{}, elem.Text("ID")),
+			elem.Th(attrs.Props{}, elem.Text("URL")),
+			elem.Th(attrs.Props{}, elem.Text("Events")),
+			elem.Th(attrs.Props{}, elem.Text("Plugs")),
+			elem.Th(attrs.Props{}, elem.Text("Fields")),
+			elem.Th(attrs.Props{}, elem.Text("Queued")),
+		),
+	}
+	for _, status := range ws.webhooks.Webhooks() {
+		kinds := make([]string, 0, len(status.Events))
+		for _, kind := range status.Events {
+			kinds = append(kinds, string(kind))
+		}
+		hookRows = append(hookRows, elem.Tr(
+			attrs.Props{attrs.ID: "webhook-" + status.ID},
+			elem.Td(attrs.Props{}, elem.Text(status.ID)),
+			elem.Td(attrs.Props{}, elem.Text(status.URL)),
+			elem.Td(attrs.Props{}, elem.Text(orAll(kinds))),
+			elem.Td(attrs.Props{}, elem.Text(orAll(status.Plugs))),
+			elem.Td(attrs.Props{}, elem.Text(orAll(status.Fields))),
+			elem.Td(attrs.Props{}, elem.Text(strconv.Itoa(status.Queued))),
+		))
+	}
+
+	logRows := []elem.Node{
+		elem.Tr(
+			attrs.Props{},
+			elem.Th(attrs.Props{}, elem.Text("Time")),
+			elem.Th(attrs.Props{}, elem.Text("Webhook")),
+			elem.Th(attrs.Props{}, elem.Text("Event")),
+			elem.Th(attrs.Props{}, elem.Text("Plug")),
+			elem.Th(attrs.Props{}, elem.Text("Attempt")),
+			elem.Th(attrs.Props{}, elem.Text("Status")),
+			elem.Th(attrs.Props{}, elem.Text("Duration")),
+			elem.Th(attrs.Props{}, elem.Text("Error")),
+		),
+	}
+	for _, attempt := range ws.webhooks.Deliveries() {
+		status := ""
+		if attempt.StatusCode != 0 {
+			status = strconv.Itoa(attempt.StatusCode)
+		}
+		errText := attempt.Error
+		if attempt.DeadLettered {
+			errText = strings.TrimSpace(errText + " (dead-lettered)")
+		}
+		logRows = append(logRows, elem.Tr(
+			attrs.Props{"data-role": "webhook-attempt"},
+			elem.Td(attrs.Props{}, elem.Text(attempt.Time.Format(time.RFC3339))),
+			elem.Td(attrs.Props{}, elem.Text(attempt.Webhook)),
+			elem.Td(attrs.Props{}, elem.Text(string(attempt.Event))),
+			elem.Td(attrs.Props{}, elem.Text(attempt.PlugID)),
+			elem.Td(attrs.Props{}, elem.Text(strconv.Itoa(attempt.Attempt))),
+			elem.Td(attrs.Props{}, elem.Text(status)),
+			elem.Td(attrs.Props{}, elem.Text(attempt.Duration.Round(time.Millisecond).String())),
+			elem.Td(attrs.Props{}, elem.Text(errText)),
+		))
+	}
+
+	dead := ws.webhooks.DeadLetters()
+	deadRows := []elem.Node{
+		elem.Tr(
+			attrs.Props{},
+			elem.Th(attrs.Props{}, elem.Text("Created")),
+			elem.Th(attrs.Props{}, elem.Text("Webhook")),
+			elem.Th(attrs.Props{}, elem.Text("Event")),
+			elem.Th(attrs.Props{}, elem.Text("Plug")),
+			elem.Th(attrs.Props{}, elem.Text("Attempts")),
+			elem.Th(attrs.Props{}, elem.Text("Last error")),
+			elem.Th(attrs.Props{}, elem.Text("")),
+		),
+	}
+	for _, delivery := range dead {
+		deadRows = append(deadRows, elem.Tr(
+			attrs.Props{attrs.ID: "dead-letter-" + delivery.ID},
+			elem.Td(attrs.Props{}, elem.Text(delivery.Created.Format(time.RFC3339))),
+			elem.Td(attrs.Props{}, elem.Text(delivery.Webhook)),
+			elem.Td(attrs.Props{}, elem.Text(string(delivery.Event))),
+			elem.Td(attrs.Props{}, elem.Text(delivery.PlugID)),
+			elem.Td(attrs.Props{}, elem.Text(strconv.Itoa(delivery.Attempts))),
+			elem.Td(attrs.Props{}, elem.Text(delivery.LastError)),
+			elem.Td(attrs.Props{}, webhookRetryButton(delivery.ID, "Retry")),
+		))
+	}
+
+	deadSection := []elem.Node{elem.H2(attrs.Props{}, elem.Text(fmt.Sprintf("Dead letters (%d)", len(dead))))}
+	if len(dead) > 0 {
+		deadSection = append(deadSection,
+			webhookRetryButton("", "Retry all"),
+			elem.Table(table, deadRows...),
+		)
+	}
+
+	content := elem.Div(
+		attrs.Props{},
+		elem.H1(attrs.Props{}, elem.Text("Webhooks")),
+		elem.Table(table, hookRows...),
+		elem.Div(attrs.Props{}, deadSection...),
+		elem.H2(attrs.Props{}, elem.Text("Delivery log")),
+		elem.Table(table, logRows...),
+	)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := fmt.Fprint(w, ws.renderPage("Webhooks", content)); err != nil {
+		ws.logger.Error("Failed to write webhooks debug response", slog.Any("error", err))
+	}
+}
+
+// HandleWebhookRetry queues the dead letter given by the "id" form value
+// for delivery again, or all of them without one.
+func (ws *WebServer) HandleWebhookRetry(w http.ResponseWriter, r *http.Request) {
+	if ws.webhooks == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queued, err := ws.webhooks.Retry(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ws.LogEvent(fmt.Sprintf("%s: Retrying %d webhook deliveries", identity(r.Context()).Source("web"), queued))
+
+	http.Redirect(w, r, "/debug/webhooks", http.StatusSeeOther)
+}
+
+func webhookRetryButton(id, label string) elem.Node {
+	return elem.Form(
+		attrs.Props{attrs.Method: "post", attrs.Action: "/debug/webhooks/retry", attrs.Class: "inline-form"},
+		elem.Input(attrs.Props{attrs.Type: "hidden", attrs.Name: "id", attrs.Value: id}),
+		elem.Button(attrs.Props{attrs.Type: "submit"}, elem.Text(label)),
+	)
+}
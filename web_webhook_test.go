@@ -0,0 +1,87 @@
+package tasmotahomekit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/kradalby/tasmota-homekit/webhook"
+)
+
+type fakeWebhooks struct {
+	dead    []webhook.Delivery
+	retried []string
+}
+
+func (f *fakeWebhooks) Webhooks() []webhook.Status {
+	return []webhook.Status{{ID: "ops", URL: "https://example.com/hook", Events: []plugs.WebhookEvent{plugs.WebhookState}, Fields: []string{"on"}, Queued: 2}}
+}
+
+func (f *fakeWebhooks) Deliveries() []webhook.Attempt {
+	return []webhook.Attempt{
+		{Time: time.Now(), Webhook: "ops", Event: plugs.WebhookState, PlugID: "plug-1", Attempt: 5, StatusCode: 503, Error: "unexpected status 503 Service Unavailable", DeadLettered: true},
+		{Time: time.Now(), Webhook: "ops", Event: plugs.WebhookState, PlugID: "plug-1", Attempt: 1, StatusCode: 204},
+	}
+}
+
+func (f *fakeWebhooks) DeadLetters() []webhook.Delivery {
+	return f.dead
+}
+
+func (f *fakeWebhooks) Retry(id string) (int, error) {
+	if id != "" && id != f.dead[0].ID {
+		return 0, fmt.Errorf("dead letter %q not found", id)
+	}
+	f.retried = append(f.retried, id)
+	return len(f.dead), nil
+}
+
+func TestHandleWebhooksDebug(t *testing.T) {
+	ws, _, _, _ := newTestWebServer(t)
+
+	rec := httptest.NewRecorder()
+	ws.HandleWebhooksDebug(rec, httptest.NewRequest(http.MethodGet, "/debug/webhooks", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status without webhooks = %d; want 404", rec.Code)
+	}
+
+	hooks := &fakeWebhooks{dead: []webhook.Delivery{{ID: "d1", Webhook: "ops", Event: plugs.WebhookState, Attempts: 5, LastError: "unexpected status 503 Service Unavailable"}}}
+	ws.SetWebhooks(hooks)
+
+	rec = httptest.NewRecorder()
+	ws.HandleWebhooksDebug(rec, httptest.NewRequest(http.MethodGet, "/debug/webhooks", nil))
+	body := rec.Body.String()
+	for _, want := range []string{`id="webhook-ops"`, "https://example.com/hook", `id="dead-letter-d1"`, "Dead letters (1)", "Retry all", "(dead-lettered)"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("webhooks page missing %q: %s", want, body)
+		}
+	}
+	if got := strings.Count(body, `data-role="webhook-attempt"`); got != 2 {
+		t.Fatalf("delivery log rows = %d; want 2", got)
+	}
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/debug/webhooks/retry", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		ws.HandleWebhookRetry(rec, req)
+		return rec
+	}
+	if rec := post(url.Values{"id": {"d1"}}); rec.Code != http.StatusSeeOther {
+		t.Fatalf("retry status = %d; want 303: %s", rec.Code, rec.Body.String())
+	}
+	if rec := post(url.Values{"id": {""}}); rec.Code != http.StatusSeeOther {
+		t.Fatalf("retry all status = %d; want 303", rec.Code)
+	}
+	if rec := post(url.Values{"id": {"missing"}}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("retry unknown status = %d; want 400", rec.Code)
+	}
+	if len(hooks.retried) != 2 || hooks.retried[0] != "d1" || hooks.retried[1] != "" {
+		t.Fatalf("retried = %q", hooks.retried)
+	}
+}
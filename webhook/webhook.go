@@ -0,0 +1,649 @@
+// Package webhook posts plug events to other systems: state changes,
+// commands, errors and component connection status, filtered and
+// formatted per webhook, signed, and retried with backoff. Deliveries that
+// keep failing are kept in a dead-letter queue, saved to disk, from which
+// they can be retried.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/kradalby/tasmota-homekit/store"
+	"tailscale.com/util/eventbus"
+)
+
+// Headers set on every request.
+const (
+	HeaderEvent     = "X-Tasmota-Homekit-Event"
+	HeaderDelivery  = "X-Tasmota-Homekit-Delivery"
+	HeaderSignature = "X-Tasmota-Homekit-Signature" // "sha256=" and the hex HMAC of the body
+)
+
+const (
+	maxAttempts    = 5
+	firstBackoff   = 2 * time.Second // doubled after every failed attempt
+	requestTimeout = 10 * time.Second
+	queueSize      = 100
+	logSize        = 200
+	maxDeadLetters = 500
+)
+
+// Payload is the body of a JSON webhook and the data of a templated one.
+// Exactly one of State, Command, Error and Connection is set, by Event.
+type Payload struct {
+	Delivery  string             `json:"delivery"`
+	Webhook   string             `json:"webhook"`
+	Event     plugs.WebhookEvent `json:"event"`
+	Timestamp time.Time          `json:"timestamp"`
+	PlugID    string             `json:"plug_id,omitempty"`
+	// Changed lists the state fields that changed since the plug's
+	// previous state, empty for its first state
+	Changed    []string                      `json:"changed,omitempty"`
+	State      *events.StateUpdateEvent      `json:"state,omitempty"`
+	Command    *events.CommandEvent          `json:"command,omitempty"`
+	Error      *ErrorPayload                 `json:"error,omitempty"`
+	Connection *events.ConnectionStatusEvent `json:"connection,omitempty"`
+}
+
+// ErrorPayload describes a plug error.
+type ErrorPayload struct {
+	PlugID  string `json:"plug_id"`
+	Message string `json:"message"`
+}
+
+// Delivery is a rendered request to a webhook and its attempts so far.
+type Delivery struct {
+	ID          string             `json:"id"`
+	Webhook     string             `json:"webhook"`
+	Event       plugs.WebhookEvent `json:"event"`
+	PlugID      string             `json:"plug_id,omitempty"`
+	Created     time.Time          `json:"created"`
+	ContentType string             `json:"content_type"`
+	Body        []byte             `json:"body"`
+	Attempts    int                `json:"attempts"`
+	LastError   string             `json:"last_error,omitempty"`
+}
+
+// Attempt is an entry of the delivery log.
+type Attempt struct {
+	Time       time.Time
+	Delivery   string
+	Webhook    string
+	Event      plugs.WebhookEvent
+	PlugID     string
+	Attempt    int
+	StatusCode int
+	Duration   time.Duration
+	Error      string
+	// DeadLettered is set on the attempt after which the delivery was
+	// given up on
+	DeadLettered bool
+}
+
+// Status describes a configured webhook.
+type Status struct {
+	ID     string
+	URL    string
+	Events []plugs.WebhookEvent
+	Plugs  []string
+	Fields []string
+	Queued int
+}
+
+// hook is a configured webhook and its delivery queue, worked through in
+// order by one goroutine.
+type hook struct {
+	cfg    plugs.Webhook
+	tmpl   *template.Template
+	queue  chan Delivery
+	cancel context.CancelFunc
+}
+
+// Dispatcher sends events to the configured webhooks.
+type Dispatcher struct {
+	path   string // dead-letter file, empty keeps them in memory only
+	client *http.Client
+	now    func() time.Time
+	// backoff returns the wait after a failed attempt, 1-based
+	backoff func(attempt int) time.Duration
+
+	mu         sync.Mutex
+	ctx        context.Context // set by Run, workers start once it is
+	hooks      map[string]*hook
+	lastStates map[string]events.StateUpdateEvent
+	log        []Attempt // oldest first
+	dead       []Delivery
+
+	stateSub      *eventbus.Subscriber[events.StateUpdateEvent]
+	commandSub    *eventbus.Subscriber[events.CommandEvent]
+	errorSub      *eventbus.Subscriber[plugs.ErrorEvent]
+	connectionSub *eventbus.Subscriber[events.ConnectionStatusEvent]
+}
+
+// New returns a dispatcher keeping its dead letters in path. Call
+// SetEventBus and Update, then Run.
+func New(path string) *Dispatcher {
+	return &Dispatcher{
+		path:   path,
+		client: &http.Client{Timeout: requestTimeout},
+		now:    time.Now,
+		backoff: func(attempt int) time.Duration {
+			return firstBackoff << (attempt - 1)
+		},
+		hooks:      make(map[string]*hook),
+		lastStates: make(map[string]events.StateUpdateEvent),
+	}
+}
+
+// Load reads the dead letters saved by an earlier run. A missing file is
+// not an error.
+func (d *Dispatcher) Load() error {
+	if d.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(d.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read webhook dead letters: %w", err)
+	}
+
+	var dead []Delivery
+	if err := json.Unmarshal(data, &dead); err != nil {
+		return fmt.Errorf("failed to parse webhook dead letters %s: %w", d.path, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dead = dead
+	return nil
+}
+
+// SetEventBus subscribes to the events webhooks are sent for.
+func (d *Dispatcher) SetEventBus(bus *events.Bus) error {
+	client, err := bus.Client(events.ClientWebhook)
+	if err != nil {
+		return fmt.Errorf("failed to get webhook eventbus client: %w", err)
+	}
+
+	d.stateSub = eventbus.Subscribe[events.StateUpdateEvent](client)
+	d.commandSub = eventbus.Subscribe[events.CommandEvent](client)
+	d.errorSub = eventbus.Subscribe[plugs.ErrorEvent](client)
+	d.connectionSub = eventbus.Subscribe[events.ConnectionStatusEvent](client)
+	return nil
+}
+
+// Update applies the webhooks of cfg. Queued deliveries of webhooks that
+// are kept are sent with the new settings; those of removed webhooks are
+// dropped.
+func (d *Dispatcher) Update(cfg *plugs.Config) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	keep := make(map[string]bool, len(cfg.Webhooks))
+	for _, w := range cfg.Webhooks {
+		keep[w.ID] = true
+		// Validated with the config, so this cannot fail
+		tmpl, _ := w.ParseTemplate()
+
+		if h, ok := d.hooks[w.ID]; ok {
+			h.cfg, h.tmpl = w, tmpl
+			continue
+		}
+		h := &hook{cfg: w, tmpl: tmpl, queue: make(chan Delivery, queueSize)}
+		d.hooks[w.ID] = h
+		if d.ctx != nil {
+			d.startLocked(h)
+		}
+	}
+	for id, h := range d.hooks {
+		if !keep[id] {
+			if h.cancel != nil {
+				h.cancel()
+			}
+			delete(d.hooks, id)
+		}
+	}
+
+	plugIDs := make(map[string]bool, len(cfg.Plugs))
+	for _, plug := range cfg.Plugs {
+		plugIDs[plug.ID] = true
+	}
+	for id := range d.lastStates {
+		if !plugIDs[id] {
+			delete(d.lastStates, id)
+		}
+	}
+}
+
+// Run sends events to the webhooks until ctx is done. Deliveries still
+// queued or waiting for a retry then go to the dead-letter queue.
+func (d *Dispatcher) Run(ctx context.Context) {
+	d.mu.Lock()
+	d.ctx = ctx
+	for _, h := range d.hooks {
+		d.startLocked(h)
+	}
+	d.mu.Unlock()
+
+	if d.stateSub == nil {
+		<-ctx.Done()
+		return
+	}
+	defer d.stateSub.Close()
+	defer d.commandSub.Close()
+	defer d.errorSub.Close()
+	defer d.connectionSub.Close()
+
+	for {
+		select {
+		case event := <-d.stateSub.Events():
+			d.handleState(event)
+		case event := <-d.commandSub.Events():
+			d.dispatch(Payload{Event: plugs.WebhookCommand, Timestamp: event.Timestamp, PlugID: event.PlugID, Command: &event})
+		case event := <-d.errorSub.Events():
+			msg := "unknown error"
+			if event.Error != nil {
+				msg = event.Error.Error()
+			}
+			d.dispatch(Payload{
+				Event:     plugs.WebhookError,
+				Timestamp: d.now(),
+				PlugID:    event.PlugID,
+				Error:     &ErrorPayload{PlugID: event.PlugID, Message: msg},
+			})
+		case event := <-d.connectionSub.Events():
+			d.dispatch(Payload{Event: plugs.WebhookConnection, Timestamp: event.Timestamp, Connection: &event})
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleState dispatches a plug state update with the fields that changed
+// since the plug's previous one. Updates that change none of the fields
+// webhooks can filter on, like a new LastSeen, are not sent.
+func (d *Dispatcher) handleState(event events.StateUpdateEvent) {
+	d.mu.Lock()
+	prev, seen := d.lastStates[event.PlugID]
+	d.lastStates[event.PlugID] = event
+	d.mu.Unlock()
+
+	var changed []string
+	if seen {
+		changed = changedFields(prev, event)
+		if len(changed) == 0 {
+			return
+		}
+	}
+	d.dispatch(Payload{
+		Event:     plugs.WebhookState,
+		Timestamp: event.Timestamp,
+		PlugID:    event.PlugID,
+		Changed:   changed,
+		State:     &event,
+	})
+}
+
+// stateFields maps the webhook field names to their values in a state.
+var stateFields = map[string]func(events.StateUpdateEvent) any{
+	"on":                func(e events.StateUpdateEvent) any { return e.On },
+	"relays":            func(e events.StateUpdateEvent) any { return e.Relays },
+	"brightness":        func(e events.StateUpdateEvent) any { return e.Brightness },
+	"hue":               func(e events.StateUpdateEvent) any { return e.Hue },
+	"saturation":        func(e events.StateUpdateEvent) any { return e.Saturation },
+	"color_temperature": func(e events.StateUpdateEvent) any { return e.ColorTemperature },
+	"power":             func(e events.StateUpdateEvent) any { return e.Power },
+	"voltage":           func(e events.StateUpdateEvent) any { return e.Voltage },
+	"current":           func(e events.StateUpdateEvent) any { return e.Current },
+	"energy":            func(e events.StateUpdateEvent) any { return e.Energy },
+	"sensors":           func(e events.StateUpdateEvent) any { return e.Sensors },
+	"mqtt_connected":    func(e events.StateUpdateEvent) any { return e.MQTTConnected },
+	"offline":           func(e events.StateUpdateEvent) any { return e.Offline },
+	"connection_state":  func(e events.StateUpdateEvent) any { return e.ConnectionState },
+}
+
+// changedFields returns the webhook fields that differ between two states,
+// in plugs.WebhookFields order.
+func changedFields(prev, cur events.StateUpdateEvent) []string {
+	var changed []string
+	for _, field := range plugs.WebhookFields {
+		value := stateFields[field]
+		if !reflect.DeepEqual(value(prev), value(cur)) {
+			changed = append(changed, field)
+		}
+	}
+	return changed
+}
+
+// dispatch queues a delivery of the payload to every webhook that wants it.
+func (d *Dispatcher) dispatch(payload Payload) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, id := range slices.Sorted(maps.Keys(d.hooks)) {
+		h := d.hooks[id]
+		if !h.cfg.Wants(payload.Event, payload.PlugID) {
+			continue
+		}
+		if payload.Event == plugs.WebhookState && !h.cfg.WantsChanges(payload.Changed) {
+			continue
+		}
+
+		p := payload
+		p.Delivery = newID()
+		p.Webhook = id
+		delivery, err := render(h, p)
+		if err != nil {
+			slog.Warn("Failed to render webhook body", "webhook", id, "event", p.Event, "error", err)
+			d.logLocked(Attempt{Time: d.now(), Delivery: p.Delivery, Webhook: id, Event: p.Event, PlugID: p.PlugID, Error: err.Error()})
+			continue
+		}
+		delivery.Created = d.now()
+
+		select {
+		case h.queue <- delivery:
+		default:
+			delivery.LastError = "queue full"
+			slog.Warn("Webhook queue full, moving delivery to the dead-letter queue", "webhook", id, "delivery", delivery.ID)
+			d.deadLetterLocked(delivery)
+		}
+	}
+}
+
+// render builds the delivery of a payload to a webhook.
+func render(h *hook, p Payload) (Delivery, error) {
+	delivery := Delivery{ID: p.Delivery, Webhook: p.Webhook, Event: p.Event, PlugID: p.PlugID}
+
+	if h.tmpl == nil {
+		body, err := json.Marshal(p)
+		if err != nil {
+			return Delivery{}, err
+		}
+		delivery.Body = body
+		delivery.ContentType = "application/json"
+	} else {
+		var buf bytes.Buffer
+		if err := h.tmpl.Execute(&buf, p); err != nil {
+			return Delivery{}, err
+		}
+		delivery.Body = buf.Bytes()
+		delivery.ContentType = "text/plain; charset=utf-8"
+	}
+	if h.cfg.ContentType != "" {
+		delivery.ContentType = h.cfg.ContentType
+	}
+	return delivery, nil
+}
+
+func (d *Dispatcher) startLocked(h *hook) {
+	ctx, cancel := context.WithCancel(d.ctx)
+	h.cancel = cancel
+	go d.work(ctx, h)
+}
+
+// work sends the deliveries of a webhook one at a time.
+func (d *Dispatcher) work(ctx context.Context, h *hook) {
+	for {
+		select {
+		case delivery := <-h.queue:
+			d.deliver(ctx, h, delivery)
+		case <-ctx.Done():
+			// Keep what is still queued across a restart, but not the
+			// deliveries of a removed webhook
+			if d.ctx.Err() == nil {
+				return
+			}
+			for {
+				select {
+				case delivery := <-h.queue:
+					delivery.LastError = "not sent before shutdown"
+					d.mu.Lock()
+					d.deadLetterLocked(delivery)
+					d.mu.Unlock()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver sends a delivery until it succeeds, fails for good or runs out
+// of attempts.
+func (d *Dispatcher) deliver(ctx context.Context, h *hook, delivery Delivery) {
+	for {
+		d.mu.Lock()
+		cfg := h.cfg
+		d.mu.Unlock()
+
+		delivery.Attempts++
+		start := d.now()
+		status, err := d.send(ctx, cfg, delivery)
+		attempt := Attempt{
+			Time:       start,
+			Delivery:   delivery.ID,
+			Webhook:    delivery.Webhook,
+			Event:      delivery.Event,
+			PlugID:     delivery.PlugID,
+			Attempt:    delivery.Attempts,
+			StatusCode: status,
+			Duration:   d.now().Sub(start),
+		}
+		if err == nil {
+			d.mu.Lock()
+			d.logLocked(attempt)
+			d.mu.Unlock()
+			return
+		}
+
+		delivery.LastError = err.Error()
+		attempt.Error = err.Error()
+		// Client errors other than timeouts and rate limits will not go
+		// away by retrying
+		permanent := status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+		removed := ctx.Err() != nil && d.ctx.Err() == nil
+		if permanent || delivery.Attempts >= maxAttempts || ctx.Err() != nil {
+			attempt.DeadLettered = !removed
+			d.mu.Lock()
+			d.logLocked(attempt)
+			if attempt.DeadLettered {
+				slog.Warn("Giving up on webhook delivery", "webhook", delivery.Webhook, "delivery", delivery.ID, "attempts", delivery.Attempts, "error", err)
+				d.deadLetterLocked(delivery)
+			}
+			d.mu.Unlock()
+			return
+		}
+
+		d.mu.Lock()
+		d.logLocked(attempt)
+		d.mu.Unlock()
+		slog.Debug("Webhook delivery failed, retrying", "webhook", delivery.Webhook, "delivery", delivery.ID, "attempt", delivery.Attempts, "error", err)
+
+		select {
+		case <-time.After(d.backoff(delivery.Attempts)):
+		case <-ctx.Done():
+			if d.ctx.Err() != nil {
+				delivery.LastError = "not sent before shutdown: " + delivery.LastError
+				d.mu.Lock()
+				d.deadLetterLocked(delivery)
+				d.mu.Unlock()
+			}
+			return
+		}
+	}
+}
+
+// send posts a delivery, returning the response status when there was one.
+func (d *Dispatcher) send(ctx context.Context, cfg plugs.Webhook, delivery Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return 0, err
+	}
+	for name, value := range cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", delivery.ContentType)
+	req.Header.Set("User-Agent", "tasmota-homekit")
+	req.Header.Set(HeaderEvent, string(delivery.Event))
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	if cfg.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(cfg.Secret, delivery.Body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature header value of body: "sha256=" and the hex
+// HMAC-SHA256 of the body keyed with secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *Dispatcher) logLocked(attempt Attempt) {
+	d.log = append(d.log, attempt)
+	if len(d.log) > logSize {
+		d.log = slices.Delete(d.log, 0, len(d.log)-logSize)
+	}
+}
+
+func (d *Dispatcher) deadLetterLocked(delivery Delivery) {
+	d.dead = append(d.dead, delivery)
+	if len(d.dead) > maxDeadLetters {
+		dropped := len(d.dead) - maxDeadLetters
+		slog.Warn("Webhook dead-letter queue full, dropping the oldest", "dropped", dropped)
+		d.dead = slices.Delete(d.dead, 0, dropped)
+	}
+	d.saveLocked()
+}
+
+// saveLocked writes the dead letters to disk. They change rarely, so they
+// are saved on every change.
+func (d *Dispatcher) saveLocked() {
+	if d.path == "" {
+		return
+	}
+	data, err := json.Marshal(d.dead)
+	if err != nil {
+		slog.Warn("Failed to encode webhook dead letters", "error", err)
+		return
+	}
+	if err := store.WriteFile(d.path, data); err != nil {
+		slog.Warn("Failed to save webhook dead letters", "path", d.path, "error", err)
+	}
+}
+
+// Webhooks returns the configured webhooks by ID.
+func (d *Dispatcher) Webhooks() []Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	statuses := make([]Status, 0, len(d.hooks))
+	for _, id := range slices.Sorted(maps.Keys(d.hooks)) {
+		h := d.hooks[id]
+		statuses = append(statuses, Status{
+			ID:     id,
+			URL:    h.cfg.URL,
+			Events: h.cfg.Events,
+			Plugs:  h.cfg.Plugs,
+			Fields: h.cfg.Fields,
+			Queued: len(h.queue),
+		})
+	}
+	return statuses
+}
+
+// Deliveries returns the delivery log, newest first.
+func (d *Dispatcher) Deliveries() []Attempt {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	log := slices.Clone(d.log)
+	slices.Reverse(log)
+	return log
+}
+
+// DeadLetters returns the dead-letter queue, oldest first.
+func (d *Dispatcher) DeadLetters() []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return slices.Clone(d.dead)
+}
+
+// Retry queues a dead letter for delivery again, or every dead letter when
+// id is empty, returning how many were queued. Dead letters of webhooks
+// that are no longer configured stay in the queue.
+func (d *Dispatcher) Retry(id string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if id != "" && !slices.ContainsFunc(d.dead, func(del Delivery) bool { return del.ID == id }) {
+		return 0, fmt.Errorf("dead letter %q not found", id)
+	}
+
+	queued := 0
+	d.dead = slices.DeleteFunc(d.dead, func(delivery Delivery) bool {
+		if id != "" && delivery.ID != id {
+			return false
+		}
+		h, ok := d.hooks[delivery.Webhook]
+		if !ok {
+			return false
+		}
+		delivery.Attempts = 0
+		delivery.LastError = ""
+		select {
+		case h.queue <- delivery:
+			queued++
+			return true
+		default:
+			return false
+		}
+	})
+	if queued > 0 {
+		d.saveLocked()
+	}
+	if id != "" && queued == 0 {
+		return 0, fmt.Errorf("dead letter %q cannot be queued, its webhook is gone or its queue is full", id)
+	}
+	return queued, nil
+}
+
+func newID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
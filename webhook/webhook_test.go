@@ -0,0 +1,255 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kradalby/tasmota-homekit/events"
+	"github.com/kradalby/tasmota-homekit/plugs"
+	"github.com/stretchr/testify/require"
+)
+
+type request struct {
+	path    string
+	headers http.Header
+	body    []byte
+}
+
+// receiver records requests and answers with the status codes in
+// statuses, then 200.
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []request
+	statuses map[string][]int // by path
+}
+
+func newReceiver(t *testing.T) *receiver {
+	t.Helper()
+	r := &receiver{statuses: make(map[string][]int)}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.requests = append(r.requests, request{path: req.URL.Path, headers: req.Header.Clone(), body: body})
+		status := http.StatusOK
+		if queued := r.statuses[req.URL.Path]; len(queued) > 0 {
+			status, r.statuses[req.URL.Path] = queued[0], queued[1:]
+		}
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) fail(path string, statuses ...int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses[path] = statuses
+}
+
+func (r *receiver) received(path string) []request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var matching []request
+	for _, req := range r.requests {
+		if req.path == path {
+			matching = append(matching, req)
+		}
+	}
+	return matching
+}
+
+func newTestDispatcher(t *testing.T, webhooks ...plugs.Webhook) (*Dispatcher, context.CancelFunc) {
+	t.Helper()
+	d := New(filepath.Join(t.TempDir(), "webhooks.json"))
+	d.backoff = func(int) time.Duration { return time.Millisecond }
+	d.Update(&plugs.Config{
+		Plugs:    []plugs.Plug{{ID: "desk"}, {ID: "lamp"}},
+		Webhooks: webhooks,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go d.Run(ctx)
+	require.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.ctx != nil
+	}, time.Second, time.Millisecond)
+	return d, cancel
+}
+
+func TestDispatch(t *testing.T) {
+	r := newReceiver(t)
+	d, _ := newTestDispatcher(t,
+		plugs.Webhook{ID: "all", URL: r.URL + "/all", Secret: "s3cret", Headers: map[string]string{"Authorization": "Bearer x"}},
+		plugs.Webhook{ID: "desk-on", URL: r.URL + "/desk-on", Plugs: []string{"desk"}, Fields: []string{"on", "offline"}},
+		plugs.Webhook{
+			ID:       "chat",
+			URL:      r.URL + "/chat",
+			Events:   []plugs.WebhookEvent{plugs.WebhookState},
+			Fields:   []string{"on"},
+			Template: `{{.PlugID}} is {{if .State.On}}on{{else}}off{{end}}`,
+		},
+	)
+
+	d.handleState(events.StateUpdateEvent{PlugID: "desk", Power: 10})
+	d.handleState(events.StateUpdateEvent{PlugID: "desk", Power: 12, LastSeen: time.Now()})
+	// Only LastSeen changed, nothing is sent
+	d.handleState(events.StateUpdateEvent{PlugID: "desk", Power: 12})
+	d.handleState(events.StateUpdateEvent{PlugID: "desk", On: true, Power: 40})
+	d.handleState(events.StateUpdateEvent{PlugID: "lamp", On: true})
+	d.handleState(events.StateUpdateEvent{PlugID: "lamp", On: false})
+	d.dispatch(Payload{Event: plugs.WebhookConnection, Connection: &events.ConnectionStatusEvent{Component: "mqtt", Status: events.ConnectionStatusConnected}})
+
+	require.Eventually(t, func() bool { return len(r.received("/all")) == 6 }, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return len(r.received("/chat")) == 2 }, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return len(r.received("/desk-on")) == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	all := r.received("/all")
+	var payloads []Payload
+	for _, req := range all {
+		require.Equal(t, "application/json", req.headers.Get("Content-Type"))
+		require.Equal(t, "Bearer x", req.headers.Get("Authorization"))
+		require.Equal(t, Sign("s3cret", req.body), req.headers.Get(HeaderSignature))
+		var p Payload
+		require.NoError(t, json.Unmarshal(req.body, &p))
+		require.Equal(t, p.Delivery, req.headers.Get(HeaderDelivery))
+		require.Equal(t, string(p.Event), req.headers.Get(HeaderEvent))
+		payloads = append(payloads, p)
+	}
+	require.Empty(t, payloads[0].Changed, "first state of a plug")
+	require.Equal(t, []string{"power"}, payloads[1].Changed)
+	require.Equal(t, []string{"on", "power"}, payloads[2].Changed)
+	require.True(t, payloads[2].State.On)
+	require.Equal(t, plugs.WebhookConnection, payloads[5].Event)
+	require.Equal(t, "mqtt", payloads[5].Connection.Component)
+
+	// The field filter skips the first desk state, which changed nothing,
+	// and the power change; connection events are not tied to a plug
+	deskOn := r.received("/desk-on")
+	var p Payload
+	require.NoError(t, json.Unmarshal(deskOn[0].body, &p))
+	require.Equal(t, "desk", p.PlugID)
+	require.Equal(t, []string{"on", "power"}, p.Changed)
+	require.Empty(t, deskOn[0].headers.Get(HeaderSignature))
+	require.NoError(t, json.Unmarshal(deskOn[1].body, &p))
+	require.Equal(t, plugs.WebhookConnection, p.Event)
+
+	chat := r.received("/chat")
+	require.Equal(t, "desk is on", string(chat[0].body))
+	require.Equal(t, "lamp is off", string(chat[1].body))
+	require.Equal(t, "text/plain; charset=utf-8", chat[0].headers.Get("Content-Type"))
+}
+
+func TestRetryAndDeadLetters(t *testing.T) {
+	r := newReceiver(t)
+	r.fail("/flaky", http.StatusBadGateway, http.StatusTooManyRequests)
+	r.fail("/down", http.StatusServiceUnavailable, 503, 503, 503, 503)
+	r.fail("/rejecting", http.StatusUnauthorized)
+
+	d, cancel := newTestDispatcher(t,
+		plugs.Webhook{ID: "flaky", URL: r.URL + "/flaky"},
+		plugs.Webhook{ID: "down", URL: r.URL + "/down"},
+		plugs.Webhook{ID: "rejecting", URL: r.URL + "/rejecting"},
+	)
+	d.dispatch(Payload{Event: plugs.WebhookCommand, PlugID: "desk", Command: &events.CommandEvent{PlugID: "desk"}})
+
+	require.Eventually(t, func() bool { return len(d.DeadLetters()) == 2 && len(r.received("/flaky")) == 3 }, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, r.received("/down"), maxAttempts)
+	require.Len(t, r.received("/rejecting"), 1, "client errors are not retried")
+
+	dead := d.DeadLetters()
+	byWebhook := map[string]Delivery{dead[0].Webhook: dead[0], dead[1].Webhook: dead[1]}
+	require.Equal(t, maxAttempts, byWebhook["down"].Attempts)
+	require.Equal(t, "unexpected status 503 Service Unavailable", byWebhook["down"].LastError)
+	require.Equal(t, 1, byWebhook["rejecting"].Attempts)
+
+	log := d.Deliveries()
+	require.Len(t, log, 3+maxAttempts+1)
+	var deadLettered int
+	for _, attempt := range log {
+		if attempt.DeadLettered {
+			deadLettered++
+		}
+	}
+	require.Equal(t, 2, deadLettered)
+
+	// Dead letters survive a restart and can be retried
+	cancel()
+	restarted := New(d.path)
+	restarted.Update(&plugs.Config{Webhooks: []plugs.Webhook{
+		{ID: "down", URL: r.URL + "/down"},
+		{ID: "rejecting", URL: r.URL + "/rejecting"},
+	}})
+	require.NoError(t, restarted.Load())
+	require.Len(t, restarted.DeadLetters(), 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go restarted.Run(ctx)
+
+	_, err := restarted.Retry("missing")
+	require.Error(t, err)
+	queued, err := restarted.Retry(byWebhook["down"].ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, queued)
+	require.Eventually(t, func() bool { return len(r.received("/down")) == maxAttempts+1 }, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, restarted.DeadLetters(), 1)
+
+	queued, err = restarted.Retry("")
+	require.NoError(t, err)
+	require.Equal(t, 1, queued)
+	require.Eventually(t, func() bool { return len(r.received("/rejecting")) == 2 }, 5*time.Second, 10*time.Millisecond)
+	require.Empty(t, restarted.DeadLetters())
+}
+
+func TestEventBus(t *testing.T) {
+	r := newReceiver(t)
+	bus, err := events.New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	t.Cleanup(func() { bus.Close() })
+
+	d := New("")
+	require.NoError(t, d.SetEventBus(bus))
+	d.Update(&plugs.Config{Webhooks: []plugs.Webhook{
+		{ID: "ops", URL: r.URL + "/ops", Events: []plugs.WebhookEvent{plugs.WebhookCommand, plugs.WebhookError}},
+	}})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go d.Run(ctx)
+
+	client, err := bus.Client(events.ClientPlugManager)
+	require.NoError(t, err)
+	on := true
+	bus.PublishCommand(client, events.CommandEvent{PlugID: "desk", Source: "homekit", CommandType: events.CommandTypeSetPower, On: &on})
+	// Not sent, the webhook only takes commands and errors
+	bus.PublishConnectionStatus(client, events.ConnectionStatusEvent{Component: "mqtt"})
+
+	require.Eventually(t, func() bool { return len(r.received("/ops")) == 1 }, 5*time.Second, 10*time.Millisecond)
+	var p Payload
+	require.NoError(t, json.Unmarshal(r.received("/ops")[0].body, &p))
+	require.Equal(t, plugs.WebhookCommand, p.Event)
+	require.Equal(t, "homekit", p.Command.Source)
+	require.Len(t, r.received("/ops"), 1)
+}
+
+func TestChangedFields(t *testing.T) {
+	prev := events.StateUpdateEvent{On: true, Relays: []bool{true, false}, Sensors: map[string]map[string]float64{"AM2301": {"temperature": 21}}}
+	cur := prev
+	cur.Relays = []bool{true, true}
+	cur.Sensors = map[string]map[string]float64{"AM2301": {"temperature": 21.5}}
+	cur.LastSeen = time.Now()
+	require.Equal(t, []string{"relays", "sensors"}, changedFields(prev, cur))
+	require.Empty(t, changedFields(prev, prev))
+}